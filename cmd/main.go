@@ -3,14 +3,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/poomiiz/go-backend/internal/routes"
 	adminroutes "github.com/poomiiz/go-backend/internal/routes/admin"
+	"github.com/poomiiz/go-backend/internal/services"
 	"github.com/poomiiz/go-backend/internal/utils"
 )
 
@@ -49,11 +52,47 @@ func main() {
 	routes.RegisterRankRoutes(r)
 	routes.RegisterBookingRoutes(r)
 	routes.RegisterLineWebhook(r)
+	routes.RegisterTarotRoutes(r)
+	routes.RegisterAdminRoutes(r)
+	routes.RegisterTimelineRoutes(r)
 	// เรียกใช้จริงจาก internal/routes/admin
 	adminroutes.RegisterPromptRoutes(r, utils.GetFirestoreClient())
 	adminroutes.RegisterConfigRoutes(r, utils.GetFirestoreClient())
 	adminroutes.RegisterLogsRoutes(r, utils.GetFirestoreClient())
 	adminroutes.RegisterDeckRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterPricingRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterModerationRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterExperimentRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterEvalRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterRetentionRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterPrivacyRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterRichMenuRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterCampaignRoutes(r, utils.GetFirestoreClient())
+	adminroutes.RegisterMessageRoutes(r, utils.GetFirestoreClient())
+	// งาน background (สรุปบทสนทนา ฯลฯ)
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	pollSeconds, err := strconv.Atoi(os.Getenv("WORKPOOL_POLL_SECONDS"))
+	if err != nil || pollSeconds <= 0 {
+		pollSeconds = 15
+	}
+	workpool := services.NewWorkpoolService()
+	go workpool.Run(jobCtx, time.Duration(pollSeconds)*time.Second)
+	// ตั้ง purge ข้อความตาม retention รอบแรก (ถ้ามี job อยู่แล้วไม่เลื่อนเวลา)
+	if err := services.NewRetentionService(workpool).EnsurePurgeScheduled(jobCtx); err != nil {
+		log.Println("Error scheduling message purge:", err)
+	}
+	// คืนเหรียญของ hold ที่ค้างเกินเวลา (เช่น process ตายระหว่างรอ AI ตอบ)
+	if err := services.NewCoinService().StartHoldSweeper(jobCtx, workpool); err != nil {
+		log.Println("Error scheduling coin hold sweep:", err)
+	}
+	// ส่งข้อความขาออกจาก outbox (worker ละช่องทาง) ตาม rate limit และ retry
+	outboxSeconds, err := strconv.Atoi(os.Getenv("OUTBOX_POLL_SECONDS"))
+	if err != nil || outboxSeconds <= 0 {
+		outboxSeconds = 5
+	}
+	go services.NewOutboxService().Run(jobCtx, time.Duration(outboxSeconds)*time.Second)
+
 	// Health check
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -3,12 +3,17 @@ package routes
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
 	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // ---------- Stub Middleware & Handlers ----------
@@ -17,72 +22,220 @@ func authMiddleware(c *gin.Context) {
 	c.Next()
 }
 
+// getPromptTuneHandler คืนผลทดสอบทุก model ของ tune ที่ prompt_tunes/{tuneId}/variants
 func getPromptTuneHandler(c *gin.Context) {
 	tuneId := c.Param("tuneId")
+	docs, err := utils.Client.Collection("prompt_tunes").Doc(tuneId).Collection("variants").Documents(c.Request.Context()).GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	variants := make([]map[string]interface{}, 0, len(docs))
+	for _, d := range docs {
+		variants = append(variants, d.Data())
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"tuneId":   tuneId,
-		"variants": []interface{}{},
+		"variants": variants,
 	})
 }
 
-func approvePromptVariantHandler(c *gin.Context) {
-	tuneId := c.Param("tuneId")
-	var body struct {
-		Model      string `json:"model"`
-		PromptText string `json:"promptText"`
+// approvePromptVariantHandler นำ prompt ของ variant ที่เลือกขึ้นเป็น version ใหม่ของ promptKey (sync ลง configs)
+func approvePromptVariantHandler(promptSvc *services.PromptService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tuneId := c.Param("tuneId")
+		var body struct {
+			PromptKey string `json:"promptKey" binding:"required"`
+			Model     string `json:"model" binding:"required"`
+			Author    string `json:"author"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx := c.Request.Context()
+		snap, err := utils.Client.Collection("prompt_tunes").Doc(tuneId).Collection("variants").Doc(body.Model).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		promptText, _ := snap.Data()["promptText"].(string)
+		if body.Author == "" {
+			body.Author = "admin"
+		}
+		v, err := promptSvc.SavePromptText(ctx, body.PromptKey, body.Model, promptText, body.Author)
+		if errors.Is(err, services.ErrInvalidPromptTemplate) || errors.Is(err, services.ErrPromptNotRendered) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "approved",
+			"tuneId":  tuneId,
+			"model":   body.Model,
+			"version": v.Version,
+		})
 	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+}
+
+// listConversationsHandler /admin/conversations?userId=&intent=&emotion=&from=2025-06-01&to=2025-07-01&cursor=&limit=20
+func listConversationsHandler(convSvc *services.ConversationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter := services.ConversationFilter{
+			UserID:  c.Query("userId"),
+			Intent:  c.Query("intent"),
+			Emotion: c.Query("emotion"),
+			Cursor:  c.Query("cursor"),
+		}
+		var err error
+		if filter.From, err = parseDateQuery(c.Query("from")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		if filter.To, err = parseDateQuery(c.Query("to")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		if v := c.Query("limit"); v != "" {
+			if filter.Limit, err = strconv.Atoi(v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+				return
+			}
+		}
+		list, next, err := convSvc.List(c.Request.Context(), filter)
+		if err != nil {
+			c.JSON(conversationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"conversations": list,
+			"nextCursor":    next,
+		})
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"status": "approved",
-		"tuneId": tuneId,
-		"model":  body.Model,
-	})
 }
 
-func listConversationsHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"conversations": []string{},
-	})
+func getMessagesHandler(convSvc *services.ConversationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		convId := c.Param("convId")
+		messages, err := convSvc.Messages(c.Request.Context(), convId)
+		if err != nil {
+			c.JSON(conversationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"conversationId": convId,
+			"messages":       messages,
+		})
+	}
 }
 
-func getMessagesHandler(c *gin.Context) {
-	convId := c.Param("convId")
-	c.JSON(http.StatusOK, gin.H{
-		"conversationId": convId,
-		"messages":       []interface{}{},
-	})
+func getInterpretationsHandler(convSvc *services.ConversationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		convId := c.Param("convId")
+		list, err := convSvc.Interpretations(c.Request.Context(), convId)
+		if err != nil {
+			c.JSON(conversationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"conversationId":  convId,
+			"interpretations": list,
+		})
+	}
 }
 
-func getInterpretationsHandler(c *gin.Context) {
-	convId := c.Param("convId")
-	c.JSON(http.StatusOK, gin.H{
-		"conversationId":  convId,
-		"interpretations": []interface{}{},
-	})
+// regenerateSummaryHandler สรุป conversation ใหม่ทั้งหมดแล้วบันทึกทับของเดิม
+func regenerateSummaryHandler(summarySvc *services.SummaryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		convId := c.Param("convId")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		result, err := summarySvc.Summarize(ctx, convId)
+		if err != nil {
+			c.JSON(conversationErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"conversationId": convId,
+			"summary":        result.Summary,
+			"intent":         result.Intent,
+			"emotion":        result.Emotion,
+			"sentiment":      result.Sentiment,
+			"topics":         result.Topics,
+			"summaryAt":      result.SummaryAt,
+		})
+	}
 }
 
-func regenerateSummaryHandler(c *gin.Context) {
-	convId := c.Param("convId")
-	c.JSON(http.StatusOK, gin.H{
-		"conversationId": convId,
-		"summary":        "สรุปใหม่ (stub)",
-	})
+// parseDateQuery รับ RFC3339 หรือ YYYY-MM-DD (ว่าง = zero time)
+// วันที่ล้วนเป็นเที่ยงคืนตามเวลาไทย เหมือนวันที่ของการจองและ quiet hours
+func parseDateQuery(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, services.BookingZone)
+}
+
+func conversationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrConversationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrNothingToSummarize):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
 }
 
 // ---------- Register Admin Routes ----------
 
 func RegisterAdminRoutes(r *gin.Engine) {
+	promptSvc := services.NewPromptService()
+	convSvc := services.NewConversationService()
+	aiClient := services.NewAIServiceClient(os.Getenv("AI_ROUTER_URL"))
+	summarySvc := newSummaryService(aiClient)
+	ragSvc := services.NewRAGService(newEmbeddingProvider(aiClient))
 	admin := r.Group("/admin", authMiddleware)
 	{
 		admin.GET("/prompt_tunes/:tuneId", getPromptTuneHandler)
-		admin.POST("/prompt_tunes/:tuneId/approve", approvePromptVariantHandler)
-		admin.GET("/conversations", listConversationsHandler)
-		admin.GET("/conversations/:convId/messages", getMessagesHandler)
-		admin.GET("/conversations/:convId/interpretations", getInterpretationsHandler)
-		admin.POST("/conversations/:convId/regenerate_summary", regenerateSummaryHandler)
+		admin.POST("/prompt_tunes/:tuneId/approve", approvePromptVariantHandler(promptSvc))
+		admin.GET("/conversations", listConversationsHandler(convSvc))
+		// เติม startedAt/oldestMessageAt ให้ conversation เก่า (รันครั้งเดียวหลัง deploy) ไม่งั้นรายการที่เรียงตาม startedAt และ retention purge จะไม่เห็น
+		admin.POST("/conversations/backfill_timestamps", func(c *gin.Context) {
+			filled, err := convSvc.BackfillTimestamps(c.Request.Context())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "filled": filled})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"filled": filled})
+		})
+		admin.GET("/conversations/:convId/messages", getMessagesHandler(convSvc))
+		admin.GET("/conversations/:convId/interpretations", getInterpretationsHandler(convSvc))
+		admin.POST("/conversations/:convId/regenerate_summary", regenerateSummaryHandler(summarySvc))
+		// สร้าง RAG index ใหม่จาก decks/cards/knowledge_docs (instance อื่นโหลด index ใหม่เมื่อเห็น version เปลี่ยน)
+		admin.POST("/rag/reindex", func(c *gin.Context) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+			count, err := ragSvc.RebuildIndex(ctx)
+			if errors.Is(err, services.ErrRAGRebuildConflict) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "indexed", "passages": count})
+		})
 		admin.POST("/config/prompt/update", func(c *gin.Context) {
 			var payload struct {
 				Key    string `json:"key"`
@@ -98,14 +251,7 @@ func RegisterAdminRoutes(r *gin.Engine) {
 				return
 			}
 
-			ctx := context.Background()
-			doc := utils.Client.Collection("configs").Doc(payload.Key)
-			_, err := doc.Set(ctx, map[string]interface{}{
-				"model":     payload.Model,
-				"prompt":    payload.Prompt,
-				"updatedAt": time.Now(),
-			}, firestore.MergeAll)
-
+			_, err := promptSvc.SavePromptText(context.Background(), payload.Key, payload.Model, payload.Prompt, "admin")
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "save failed"})
 				return
@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterCampaignRoutes สร้าง/ตั้งเวลา/ส่ง campaign แบบ multicast ถึงกลุ่มผู้ใช้ LINE
+func RegisterCampaignRoutes(r *gin.Engine, client *firestore.Client) {
+	campaignSvc := services.NewCampaignService(services.NewWorkpoolService())
+	group := r.Group("/admin/campaigns")
+
+	group.GET("", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		list, err := campaignSvc.List(c.Request.Context(), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	group.GET("/:id", func(c *gin.Context) {
+		campaign, err := campaignSvc.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, campaign)
+	})
+
+	// body: {name, messages, audience, scheduledAt?} ไม่มี scheduledAt = draft
+	group.POST("", func(c *gin.Context) {
+		var body services.Campaign
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		campaign, err := campaignSvc.Create(c.Request.Context(), body)
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, campaign)
+	})
+
+	// นับผู้รับตาม audience ก่อนส่งจริง
+	group.POST("/preview", func(c *gin.Context) {
+		var audience services.CampaignAudience
+		if err := c.ShouldBindJSON(&audience); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stats, err := campaignSvc.Preview(c.Request.Context(), audience)
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	group.POST("/:id/schedule", func(c *gin.Context) {
+		var body struct {
+			ScheduledAt time.Time `json:"scheduledAt" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		campaign, err := campaignSvc.Schedule(c.Request.Context(), c.Param("id"), body.ScheduledAt)
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, campaign)
+	})
+
+	// ส่งทันที (ผู้รับจำนวนมากใช้เวลานาน จึงไม่ผูกกับ context ของ request)
+	group.POST("/:id/send", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		campaign, err := campaignSvc.Send(ctx, c.Param("id"))
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, campaign)
+	})
+
+	// แจ้งเตือนด่วน (เดิมคือ /booking/notify): {name?, message|messages, audience, scheduledAt?} ไม่มี scheduledAt = ส่งทันที
+	group.POST("/notify", func(c *gin.Context) {
+		var payload struct {
+			Name        string                    `json:"name"`
+			Message     string                    `json:"message"`
+			Messages    []services.LineMessage    `json:"messages"`
+			Audience    services.CampaignAudience `json:"audience"`
+			ScheduledAt time.Time                 `json:"scheduledAt"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		messages := payload.Messages
+		if len(messages) == 0 {
+			messages = []services.LineMessage{services.LineText(payload.Message)}
+		}
+		if payload.Name == "" {
+			payload.Name = "notify " + time.Now().Format("2006-01-02 15:04")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		campaign, err := campaignSvc.Create(ctx, services.Campaign{
+			Name:        payload.Name,
+			Messages:    messages,
+			Audience:    payload.Audience,
+			ScheduledAt: payload.ScheduledAt,
+		})
+		if err == nil && payload.ScheduledAt.IsZero() {
+			campaign, err = campaignSvc.Send(ctx, campaign.ID)
+		}
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, campaign)
+	})
+
+	group.POST("/:id/cancel", func(c *gin.Context) {
+		campaign, err := campaignSvc.Cancel(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, campaign)
+	})
+}
+
+func campaignErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrCampaignNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrCampaignNotEditable):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidCampaign), errors.Is(err, services.ErrInvalidLineMessage):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterEvalRoutes จัดการชุดคำถาม golden set และรันการประเมิน prompt แบบ offline
+func RegisterEvalRoutes(r *gin.Engine, client *firestore.Client) {
+	concurrency, err := strconv.Atoi(os.Getenv("EVAL_CONCURRENCY"))
+	if err != nil || concurrency <= 0 {
+		concurrency = 4
+	}
+	evalSvc := services.NewEvalService(services.NewAIServiceClient(os.Getenv("AI_ROUTER_URL")), services.NewPromptService(), concurrency)
+	group := r.Group("/admin/eval")
+
+	group.GET("/suites", func(c *gin.Context) {
+		list, err := evalSvc.ListSuites(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	group.GET("/suites/:id", func(c *gin.Context) {
+		suite, err := evalSvc.GetSuite(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(evalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, suite)
+	})
+
+	// POST /admin/eval/suites สร้างใหม่, POST /admin/eval/suites/:id แทนที่ของเดิม
+	saveSuite := func(c *gin.Context) {
+		var suite services.EvalSuite
+		if err := c.ShouldBindJSON(&suite); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		suite.ID = c.Param("id")
+		saved, err := evalSvc.SaveSuite(c.Request.Context(), suite)
+		if err != nil {
+			c.JSON(evalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, saved)
+	}
+	group.POST("/suites", saveSuite)
+	group.POST("/suites/:id", saveSuite)
+
+	// รัน candidate กับทั้ง suite แล้วเทียบกับ version ที่ active ของ promptKey
+	group.POST("/suites/:id/run", func(c *gin.Context) {
+		var body struct {
+			PromptKey  string `json:"promptKey"`
+			Model      string `json:"model" binding:"required"`
+			Prompt     string `json:"prompt" binding:"required"`
+			JudgeModel string `json:"judgeModel"`
+			Author     string `json:"author"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		run, err := evalSvc.Run(ctx, c.Param("id"), body.PromptKey,
+			services.EvalCandidate{Model: body.Model, Prompt: body.Prompt}, body.JudgeModel, body.Author)
+		if err != nil {
+			c.JSON(evalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, run)
+	})
+
+	group.GET("/runs/:id", func(c *gin.Context) {
+		run, err := evalSvc.GetRun(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(evalErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, run)
+	})
+}
+
+func evalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrEvalSuiteNotFound), errors.Is(err, services.ErrEvalRunNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrEmptyEvalSuite):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterExperimentRoutes สร้าง/ติดตาม/ปิดการทดลอง A/B ของ prompt
+func RegisterExperimentRoutes(r *gin.Engine, client *firestore.Client) {
+	expSvc := services.NewExperimentService(services.NewPromptService())
+	group := r.Group("/admin/experiments")
+
+	group.POST("", func(c *gin.Context) {
+		var body struct {
+			PromptKey string                       `json:"promptKey" binding:"required"`
+			Variants  []services.ExperimentVariant `json:"variants"`
+			Author    string                       `json:"author"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		exp, err := expSvc.Create(c.Request.Context(), body.PromptKey, body.Variants, body.Author)
+		if err != nil {
+			c.JSON(experimentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, exp)
+	})
+
+	// /admin/experiments?promptKey=ai_prompt.tarot
+	group.GET("", func(c *gin.Context) {
+		list, err := expSvc.List(c.Request.Context(), c.Query("promptKey"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	// คืนการทดลองพร้อมผลของแต่ละ variant
+	group.GET("/:id", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		exp, err := expSvc.Get(ctx, c.Param("id"))
+		if err != nil {
+			c.JSON(experimentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		results, err := expSvc.Results(ctx, exp.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"experiment": exp, "results": results})
+	})
+
+	group.POST("/:id/stop", func(c *gin.Context) {
+		if err := expSvc.Stop(c.Request.Context(), c.Param("id")); err != nil {
+			c.JSON(experimentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "stopped"})
+	})
+
+	// นำ variant ที่ชนะขึ้นเป็น prompt version ใหม่ใน configs
+	group.POST("/:id/promote", func(c *gin.Context) {
+		var body struct {
+			Variant string `json:"variant" binding:"required"`
+			Author  string `json:"author"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		v, err := expSvc.Promote(c.Request.Context(), c.Param("id"), body.Variant, body.Author)
+		if err != nil {
+			c.JSON(experimentErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "promoted", "version": v.Version})
+	})
+}
+
+func experimentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrExperimentNotFound), errors.Is(err, services.ErrVariantNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrExperimentRunning), errors.Is(err, services.ErrExperimentNotRunning):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidExperiment):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterMessageRoutes แก้ข้อความที่ผู้ใช้เห็นใน LINE/อีเมล (th, en) โดยไม่ต้อง deploy ใหม่
+func RegisterMessageRoutes(r *gin.Engine, client *firestore.Client) {
+	catalog := services.NewMessageCatalogService(services.NewNotificationPreferenceService())
+	group := r.Group("/admin/messages")
+
+	// ข้อความทั้งหมดของภาษา พร้อมค่าตั้งต้นและค่าที่แก้ไว้
+	group.GET("/:locale", func(c *gin.Context) {
+		entries, err := catalog.Entries(c.Request.Context(), c.Param("locale"))
+		if err != nil {
+			c.JSON(messageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	})
+
+	// body: {text, updatedBy} ข้อความเป็น Go template เช่น "ใช้งานได้ถึง {{date .ExpiresAt}}"
+	group.PUT("/:locale/:key", func(c *gin.Context) {
+		var body struct {
+			Text      string `json:"text"`
+			UpdatedBy string `json:"updatedBy"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		err := catalog.SetMessage(c.Request.Context(), c.Param("locale"), c.Param("key"), body.Text, body.UpdatedBy)
+		if err != nil {
+			c.JSON(messageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+	})
+
+	// กลับไปใช้ข้อความตั้งต้น
+	group.DELETE("/:locale/:key", func(c *gin.Context) {
+		if err := catalog.ResetMessage(c.Request.Context(), c.Param("locale"), c.Param("key")); err != nil {
+			c.JSON(messageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "reset"})
+	})
+
+	// ลอง render ข้อความด้วยตัวแปรที่ส่งมา เช่น {"Count": 2}
+	group.POST("/:locale/:key/render", func(c *gin.Context) {
+		var vars map[string]interface{}
+		if err := c.ShouldBindJSON(&vars); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		args := make([]interface{}, 0, len(vars)*2)
+		for k, v := range vars {
+			args = append(args, k, v)
+		}
+		l := catalog.Localizer(c.Request.Context(), c.Param("locale"))
+		c.JSON(http.StatusOK, gin.H{"locale": l.Locale(), "text": l.T(c.Param("key"), args...)})
+	})
+}
+
+func messageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUnknownLocale), errors.Is(err, services.ErrUnknownMessageKey):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidMessage):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,108 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterModerationRoutes ตรวจรายการที่ถูก flag และตั้งค่า action ต่อหมวด
+func RegisterModerationRoutes(r *gin.Engine, client *firestore.Client) {
+	group := r.Group("/admin/moderation")
+
+	// /admin/moderation/flags?status=pending
+	group.GET("/flags", func(c *gin.Context) {
+		q := client.Collection("moderation_flags").
+			Where("status", "==", c.DefaultQuery("status", "pending")).
+			OrderBy("createdAt", firestore.Desc).
+			Limit(100)
+		docs, err := q.Documents(context.Background()).GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(docs))
+		for _, d := range docs {
+			m := d.Data()
+			m["id"] = d.Ref.ID
+			list = append(list, m)
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	group.POST("/flags/:id/resolve", func(c *gin.Context) {
+		var body struct {
+			Status   string `json:"status"` // "confirmed" หรือ "dismissed"
+			Reviewer string `json:"reviewer"`
+			Note     string `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.Status != "confirmed" && body.Status != "dismissed" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be confirmed or dismissed"})
+			return
+		}
+		_, err := client.Collection("moderation_flags").Doc(c.Param("id")).Update(context.Background(), []firestore.Update{
+			{Path: "status", Value: body.Status},
+			{Path: "reviewer", Value: body.Reviewer},
+			{Path: "note", Value: body.Note},
+			{Path: "reviewedAt", Value: time.Now()},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": body.Status})
+	})
+
+	// คืน policy ที่ใช้อยู่จริง (default + ค่าที่ตั้งใน Firestore)
+	group.GET("/policies", func(c *gin.Context) {
+		policies := make(map[string]services.ModerationPolicy, len(services.DefaultModerationPolicies))
+		for category, p := range services.DefaultModerationPolicies {
+			policies[category] = p
+		}
+		docs, err := client.Collection("moderation_policies").Documents(context.Background()).GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, d := range docs {
+			var p services.ModerationPolicy
+			if err := d.DataTo(&p); err != nil {
+				continue
+			}
+			policies[d.Ref.ID] = p
+		}
+		c.JSON(http.StatusOK, policies)
+	})
+
+	group.POST("/policies/:category", func(c *gin.Context) {
+		var body services.ModerationPolicy
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, action := range body.Actions {
+			switch action {
+			case services.ModerationBlock, services.ModerationSoften, services.ModerationRedact,
+				services.ModerationEscalate, services.ModerationAlert:
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown action: " + action})
+				return
+			}
+		}
+		body.UpdatedAt = time.Now()
+		_, err := client.Collection("moderation_policies").Doc(c.Param("category")).Set(context.Background(), body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+	})
+}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterPricingRoutes จัดการราคาเหรียญต่อ AI purpose (collection "ai_pricing")
+func RegisterPricingRoutes(r *gin.Engine, client *firestore.Client) {
+	r.GET("/admin/pricing", func(c *gin.Context) {
+		docs, err := client.Collection("ai_pricing").Documents(context.Background()).GetAll()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(docs))
+		for _, d := range docs {
+			m := d.Data()
+			m["purpose"] = d.Ref.ID
+			list = append(list, m)
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	r.POST("/admin/pricing/:purpose", func(c *gin.Context) {
+		var body services.AIPricing
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.CoinCost < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "coinCost must not be negative"})
+			return
+		}
+		body.UpdatedAt = time.Now()
+		_, err := client.Collection("ai_pricing").Doc(c.Param("purpose")).Set(context.Background(), body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+	})
+}
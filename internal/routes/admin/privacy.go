@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterPrivacyRoutes ตรวจและดำเนินการคำขอ PDPA (audit trail อยู่ใน "privacy_requests")
+func RegisterPrivacyRoutes(r *gin.Engine, client *firestore.Client) {
+	privacySvc, err := services.NewPrivacyService(os.Getenv("PDPA_PSEUDONYM_SALT"))
+	if err != nil {
+		log.Println("PDPA admin routes disabled:", err)
+		return
+	}
+	group := r.Group("/admin/privacy")
+
+	// /admin/privacy/requests?status=pending
+	group.GET("/requests", func(c *gin.Context) {
+		list, err := privacySvc.ListRequests(c.Request.Context(), c.Query("status"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	group.POST("/requests/:id/approve", func(c *gin.Context) {
+		var body struct {
+			Approver string `json:"approver" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		req, err := privacySvc.ExecuteErasure(ctx, c.Param("id"), body.Approver)
+		if err != nil {
+			c.JSON(privacyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+
+	group.POST("/requests/:id/reject", func(c *gin.Context) {
+		var body struct {
+			Approver string `json:"approver" binding:"required"`
+			Note     string `json:"note"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := privacySvc.Reject(c.Request.Context(), c.Param("id"), body.Approver, body.Note); err != nil {
+			c.JSON(privacyErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "rejected"})
+	})
+}
+
+func privacyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPrivacyRequestNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrPrivacyRequestDone):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
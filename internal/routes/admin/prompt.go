@@ -3,11 +3,14 @@ package routes
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
 )
 
 type PromptConfig struct {
@@ -19,6 +22,8 @@ type PromptConfig struct {
 }
 
 func RegisterPromptRoutes(r *gin.Engine, firestoreClient *firestore.Client) {
+	promptSvc := services.NewPromptService()
+
 	r.GET("/admin/prompts", func(c *gin.Context) {
 		var prompts []map[string]interface{}
 		iter := firestoreClient.Collection("configs").Documents(context.Background())
@@ -34,18 +39,107 @@ func RegisterPromptRoutes(r *gin.Engine, firestoreClient *firestore.Client) {
 		c.JSON(http.StatusOK, prompts)
 	})
 
+	// บันทึก prompt = สร้าง version ใหม่ที่แก้ไขไม่ได้ แล้วตั้งเป็น active
 	r.POST("/admin/prompts/:key", func(c *gin.Context) {
-		var body PromptConfig
+		var body struct {
+			PromptConfig
+			Author string `json:"author"`
+		}
 		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		body.UpdatedAt = time.Now()
-		_, err := firestoreClient.Collection("configs").Doc(c.Param("key")).Set(context.Background(), body)
+		v, err := promptSvc.SaveVersion(c.Request.Context(), c.Param("key"), services.PromptVersion{
+			Model:       body.Model,
+			Prompt:      body.Prompt,
+			Temperature: body.Temperature,
+			MaxTokens:   body.MaxTokens,
+		}, body.Author)
+		if err != nil {
+			c.JSON(promptErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "updated", "version": v.Version, "diff": v.Diff})
+	})
+
+	r.GET("/admin/prompts/:key/versions", func(c *gin.Context) {
+		versions, err := promptSvc.ListVersions(c.Request.Context(), c.Param("key"))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+		c.JSON(http.StatusOK, versions)
+	})
+
+	r.GET("/admin/prompts/:key/versions/:version", func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+			return
+		}
+		v, err := promptSvc.GetVersion(c.Request.Context(), c.Param("key"), version)
+		if err != nil {
+			c.JSON(promptErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, v)
+	})
+
+	r.POST("/admin/prompts/:key/rollback", func(c *gin.Context) {
+		var body struct {
+			Version int    `json:"version"`
+			Author  string `json:"author"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		v, err := promptSvc.Rollback(c.Request.Context(), c.Param("key"), body.Version, body.Author)
+		if err != nil {
+			c.JSON(promptErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "rolled back", "activeVersion": v.Version})
+	})
+
+	// /admin/prompts/:key/compare?from=1&to=2
+	r.GET("/admin/prompts/:key/compare", func(c *gin.Context) {
+		from, err1 := strconv.Atoi(c.Query("from"))
+		to, err2 := strconv.Atoi(c.Query("to"))
+		if err1 != nil || err2 != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be version numbers"})
+			return
+		}
+		diff, err := promptSvc.Compare(c.Request.Context(), c.Param("key"), from, to)
+		if err != nil {
+			c.JSON(promptErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "diff": diff})
 	})
+
+	// ลอง render version ที่ active ด้วยตัวแปรที่ส่งมา
+	r.POST("/admin/prompts/:key/render", func(c *gin.Context) {
+		var vars services.PromptVariables
+		if err := c.ShouldBindJSON(&vars); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		text, v, err := promptSvc.Render(c.Request.Context(), c.Param("key"), vars)
+		if err != nil {
+			c.JSON(promptErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"version": v.Version, "prompt": text})
+	})
+}
+
+func promptErrorStatus(err error) int {
+	if errors.Is(err, services.ErrPromptNotFound) || errors.Is(err, services.ErrPromptVersionNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, services.ErrInvalidPromptTemplate) || errors.Is(err, services.ErrPromptNotRendered) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
 }
@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterRetentionRoutes ตั้งระยะเก็บข้อความต่อ tier/package และสั่ง purge ทันที
+func RegisterRetentionRoutes(r *gin.Engine, client *firestore.Client) {
+	retentionSvc := services.NewRetentionService(services.NewWorkpoolService())
+	group := r.Group("/admin/retention")
+
+	group.GET("/policies", func(c *gin.Context) {
+		policies, err := retentionSvc.Policies(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, policies)
+	})
+
+	// :key = "free", "premium" หรือ packageId, retentionDays <= 0 = เก็บตลอด
+	group.POST("/policies/:key", func(c *gin.Context) {
+		var body services.RetentionPolicy
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := retentionSvc.SetPolicy(c.Request.Context(), c.Param("key"), body.RetentionDays); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "updated"})
+	})
+
+	group.POST("/purge", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		report, err := retentionSvc.Purge(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "report": report})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
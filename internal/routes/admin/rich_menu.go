@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+// RegisterRichMenuRoutes จัดการ rich menu ของ LINE OA และผูกเมนูตาม tier ของผู้ใช้
+func RegisterRichMenuRoutes(r *gin.Engine, client *firestore.Client) {
+	line := services.NewLineService(os.Getenv("LINE_CHANNEL_ACCESS_TOKEN"))
+	richMenuSvc := services.NewRichMenuService(line, services.NewPackageService(services.NewCoinService()), services.NewWorkpoolService())
+	group := r.Group("/admin/rich-menus")
+
+	group.GET("", func(c *gin.Context) {
+		menus, err := richMenuSvc.List(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, menus)
+	})
+
+	group.GET("/:id", func(c *gin.Context) {
+		menu, err := richMenuSvc.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(richMenuErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, menu)
+	})
+
+	// body: {name, chatBarText, width, height, selected, segment, areas: [{bounds, action}]}
+	group.POST("", func(c *gin.Context) {
+		var body services.RichMenu
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		menu, err := richMenuSvc.Create(c.Request.Context(), body)
+		if err != nil {
+			c.JSON(richMenuErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, menu)
+	})
+
+	// รูป PNG/JPEG ส่งเป็น multipart field "image" หรือ raw body
+	group.POST("/:id/image", func(c *gin.Context) {
+		var src io.Reader = c.Request.Body
+		if file, _, err := c.Request.FormFile("image"); err == nil {
+			defer file.Close()
+			src = file
+		}
+		data, err := io.ReadAll(io.LimitReader(src, 1<<20+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := richMenuSvc.UploadImage(c.Request.Context(), c.Param("id"), data); err != nil {
+			c.JSON(richMenuErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "uploaded"})
+	})
+
+	group.POST("/:id/default", func(c *gin.Context) {
+		if err := richMenuSvc.SetDefault(c.Request.Context(), c.Param("id")); err != nil {
+			c.JSON(richMenuErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "default"})
+	})
+
+	group.DELETE("/:id", func(c *gin.Context) {
+		if err := richMenuSvc.Delete(c.Request.Context(), c.Param("id")); err != nil {
+			c.JSON(richMenuErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	})
+
+	// ผูกเมนูของผู้ใช้ใหม่ตาม tier ปัจจุบัน (ปกติเกิดอัตโนมัติเมื่อซื้อหรือหมดอายุ package)
+	group.POST("/relink/:userId", func(c *gin.Context) {
+		if err := richMenuSvc.Relink(c.Request.Context(), c.Param("userId")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "relinked"})
+	})
+}
+
+func richMenuErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrRichMenuNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidRichMenu), errors.Is(err, services.ErrInvalidLineMessage):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
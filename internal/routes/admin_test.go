@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDateQuery(t *testing.T) {
+	for v, want := range map[string]time.Time{
+		"":                          {},
+		"2026-03-01":                time.Date(2026, 2, 28, 17, 0, 0, 0, time.UTC), // เที่ยงคืนเวลาไทย
+		"2026-03-01T10:30:00Z":      time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC),
+		"2026-03-01T17:30:00+07:00": time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC),
+	} {
+		got, err := parseDateQuery(v)
+		assert.NoError(t, err, v)
+		assert.True(t, want.Equal(got), "%q: got %v", v, got)
+	}
+
+	for _, v := range []string{"01/03/2026", "2026-13-01", "2026-03-01 10:30", "yesterday"} {
+		_, err := parseDateQuery(v)
+		assert.Error(t, err, v)
+	}
+}
@@ -3,8 +3,11 @@ package routes
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,9 +15,62 @@ import (
 	"github.com/poomiiz/go-backend/internal/utils"
 )
 
+// newChatService ประกอบ ChatService จาก env (ใช้ร่วมกับ LINE webhook)
+func newChatService(aiClient *services.AIServiceClient, ragSvc *services.RAGService) *services.ChatService {
+	coinSvc := services.NewCoinService()
+	billing := services.NewAIBillingService(coinSvc, services.NewPackageService(coinSvc), services.NewWorkpoolService())
+	promptSvc := services.NewPromptService()
+	return services.NewChatService(aiClient, billing, newSessionService(), ragSvc, services.NewTarotService(), newModerationService(aiClient), promptSvc, services.NewExperimentService(promptSvc))
+}
+
+// newSummaryService สรุป conversation ผ่าน workpool หลังข้อความล่าสุด SUMMARY_DEBOUNCE_SECONDS วินาที
+func newSummaryService(aiClient *services.AIServiceClient) *services.SummaryService {
+	debounce := time.Duration(envInt("SUMMARY_DEBOUNCE_SECONDS", 120)) * time.Second
+	return services.NewSummaryService(aiClient, services.NewWorkpoolService(), debounce)
+}
+
+// newModerationService เปิด AI classifier เมื่อกำหนด MODERATION_AI_THRESHOLD (เช่น 0.8)
+func newModerationService(aiClient *services.AIServiceClient) *services.ModerationService {
+	threshold, err := strconv.ParseFloat(os.Getenv("MODERATION_AI_THRESHOLD"), 64)
+	if err != nil || threshold <= 0 {
+		return services.NewModerationService(newNotificationService())
+	}
+	return services.NewModerationService(newNotificationService(), services.NewAIModerationClassifier(aiClient, threshold))
+}
+
+// newSessionService ตั้งค่า timeout/ประวัติของ session จาก env
+func newSessionService() *services.SessionService {
+	return services.NewSessionService(
+		time.Duration(envInt("CHAT_SESSION_TIMEOUT_MINUTES", 30))*time.Minute,
+		envInt("AI_HISTORY_MAX_TURNS", 20),
+		envInt("AI_HISTORY_TOKEN_BUDGET", 1500),
+	)
+}
+
+// newEmbeddingProvider EMBEDDING_PROVIDER=local ใช้ hash embedding (ไม่เรียก network) สำหรับ dev
+func newEmbeddingProvider(aiClient *services.AIServiceClient) services.EmbeddingProvider {
+	if os.Getenv("EMBEDDING_PROVIDER") == "local" {
+		return services.NewHashEmbeddingProvider(256)
+	}
+	return services.NewAIEmbeddingProvider(aiClient, os.Getenv("EMBEDDING_MODEL"))
+}
+
+// envInt อ่านค่า int จาก env ถ้าไม่มีหรือผิดรูปแบบใช้ค่า def
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
 // RegisterAIRoutes ลงทะเบียน /ai/xxxx
 func RegisterAIRoutes(r *gin.Engine) {
 	aiClient := services.NewAIServiceClient(os.Getenv("AI_ROUTER_URL"))
+	ragSvc := services.NewRAGService(newEmbeddingProvider(aiClient))
+	chatSvc := newChatService(aiClient, ragSvc)
+	summarySvc := newSummaryService(aiClient)
+	experimentSvc := services.NewExperimentService(services.NewPromptService())
 
 	r.POST("/ai/interpret", func(c *gin.Context) {
 		var req services.AIInterpretRequest
@@ -49,25 +105,107 @@ func RegisterAIRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, aiResp)
 	})
 
+	// รับเฉพาะฟิลด์ที่ผู้ใช้กำหนดได้ ส่วนประวัติ บริบท prompt และ purpose ChatService เติมเองฝั่ง server
 	r.POST("/ai/chat", func(c *gin.Context) {
-		var req services.AIChatRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
+		var body struct {
+			UserID         string `json:"userId"`
+			ConversationID string `json:"conversationId"`
+			Message        string `json:"message"`
+			Model          string `json:"model"`
+			DeckID         string `json:"deckId"`
+			DrawID         string `json:"drawId"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		utils.SaveUserMessage(req.ConversationID, req.UserID, req.Message)
-
+		req := services.AIChatRequest{
+			UserID:         body.UserID,
+			ConversationID: body.ConversationID,
+			Message:        body.Message,
+			Model:          body.Model,
+			Purpose:        "chat",
+			DeckID:         body.DeckID,
+			DrawID:         body.DrawID,
+		}
+		if body.DeckID != "" {
+			req.Purpose = "rag" // ถามความรู้จาก deck (มี drawId จะกลายเป็น tarot)
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		aiResp, err := aiClient.Chat(ctx, req)
+		aiResp, err := chatSvc.Chat(ctx, req)
+		if errors.Is(err, services.ErrInsufficientBalance) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrDrawNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrUnknownPurpose) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		utils.SaveBotMessage(req.ConversationID, req.UserID, aiResp.Response, aiResp.ModelUsed)
+		if err := summarySvc.Schedule(context.Background(), aiResp.ConversationID); err != nil {
+			log.Println("Error scheduling summary:", err)
+		}
 		c.JSON(http.StatusOK, aiResp)
 	})
 
+	// ผู้ใช้ให้คะแนนคำตอบ (1-5) ผลจะถูกนับให้ variant ของ prompt experiment ที่ตอบข้อความนั้น (ข้อความละครั้ง)
+	r.POST("/ai/feedback", func(c *gin.Context) {
+		var body struct {
+			ConversationID string  `json:"conversationId" binding:"required"`
+			MessageID      string  `json:"messageId" binding:"required"`
+			Rating         float64 `json:"rating" binding:"required,min=1,max=5"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx := c.Request.Context()
+		msg, err := utils.GetMessage(ctx, body.ConversationID, body.MessageID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if msg == nil || msg.Sender != "bot" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if msg.ExperimentID != "" {
+			err := experimentSvc.RecordRating(ctx, msg.ExperimentID, msg.Variant, body.ConversationID, body.MessageID, body.Rating)
+			if errors.Is(err, services.ErrRatingRecorded) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "recorded"})
+	})
+
+	// ทดสอบการค้น เช่น /ai/rag/search?q=ความรัก&deckId=rider&k=5
+	r.GET("/ai/rag/search", func(c *gin.Context) {
+		k, err := strconv.Atoi(c.DefaultQuery("k", "4"))
+		if err != nil || k <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid k"})
+			return
+		}
+		citations, err := ragSvc.Retrieve(c.Request.Context(), c.Query("q"), c.Query("deckId"), k)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"citations": citations})
+	})
+
 	r.POST("/ai/tune_prompt", func(c *gin.Context) {
 		var body struct {
 			TuneID          string `json:"tuneId"`
@@ -2,25 +2,162 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
 )
 
+func newBookingService() *services.BookingService {
+	return services.NewBookingService(services.NewCoinService(), newNotificationService(), services.NewWorkpoolService())
+}
+
 func RegisterBookingRoutes(r *gin.Engine) {
+	bookingSvc := newBookingService()
 	grp := r.Group("/booking")
 	{
-		// ตัวอย่าง stub สำหรับสร้างจองคิว (ยังไม่ได้ implement จริง)
-		grp.POST("/create", func(c *gin.Context) {
-			c.JSON(http.StatusNotImplemented, gin.H{"error": "booking:create not implemented"})
+		// หมอดูที่เปิดรับจอง
+		grp.GET("/seers", func(c *gin.Context) {
+			seers, err := bookingSvc.ActiveSeers(c.Request.Context())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, seers)
+		})
+		grp.GET("/seers/:seerId", func(c *gin.Context) {
+			seer, err := bookingSvc.GetSeer(c.Request.Context(), c.Param("seerId"))
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, seer)
 		})
-		// ตัวอย่าง stub สำหรับเลือก slot
+		// หมอดูตั้งตารางเวลา body: {name, active, coinCost, slotMinutes, weekly: {"mon": [{start, end}]}, exceptions: [{date, closed, ranges}]}
+		grp.PUT("/seers/:seerId", func(c *gin.Context) {
+			var seer services.Seer
+			if err := c.ShouldBindJSON(&seer); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			out, err := bookingSvc.SetSeer(c.Request.Context(), c.Param("seerId"), seer)
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, out)
+		})
+		// slot ที่ว่างของวัน ?date=2006-01-02 (เวลาไทย) ไม่ระบุ = วันนี้
+		grp.GET("/seers/:seerId/slots", func(c *gin.Context) {
+			day := time.Now()
+			if v := c.Query("date"); v != "" {
+				var err error
+				if day, err = time.ParseInLocation("2006-01-02", v, services.BookingZone); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+					return
+				}
+			}
+			slots, err := bookingSvc.OpenSlots(c.Request.Context(), c.Param("seerId"), day)
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"seerId": c.Param("seerId"), "date": day.In(services.BookingZone).Format("2006-01-02"), "slots": slots})
+		})
+		// กัน slot ไว้ชั่วคราว body: {userId, seerId, startAt} แล้วยืนยันด้วย /create ก่อน expiresAt
 		grp.POST("/select_slot", func(c *gin.Context) {
-			c.JSON(http.StatusNotImplemented, gin.H{"error": "booking:select_slot not implemented"})
+			var body struct {
+				UserID  string    `json:"userId" binding:"required"`
+				SeerID  string    `json:"seerId" binding:"required"`
+				StartAt time.Time `json:"startAt" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			hold, err := bookingSvc.Hold(c.Request.Context(), body.UserID, body.SeerID, body.StartAt)
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, hold)
 		})
-		// ตัวอย่าง stub สำหรับ broadcast แจ้งเตือน (หากใช้)
-		grp.GET("/notify", func(c *gin.Context) {
-			c.JSON(http.StatusNotImplemented, gin.H{"error": "booking:notify not implemented"})
+		// ยืนยันการจองจาก hold body: {userId, holdId, topic} (กันเหรียญตามราคาหมอดู)
+		grp.POST("/create", func(c *gin.Context) {
+			var body struct {
+				UserID string `json:"userId" binding:"required"`
+				HoldID string `json:"holdId" binding:"required"`
+				Topic  string `json:"topic"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			booking, err := bookingSvc.Confirm(c.Request.Context(), body.UserID, body.HoldID, body.Topic)
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, booking)
 		})
+		// การจองทั้งหมดของผู้ใช้
+		grp.GET("/user/:userId", func(c *gin.Context) {
+			bookings, err := bookingSvc.ListByUser(c.Request.Context(), c.Param("userId"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, bookings)
+		})
+		// ยกเลิก body: {userId} คืนเหรียญเมื่อยกเลิกก่อนนัดอย่างน้อย 24 ชั่วโมง
+		grp.POST("/:id/cancel", func(c *gin.Context) {
+			var body struct {
+				UserID string `json:"userId" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			booking, err := bookingSvc.Cancel(c.Request.Context(), body.UserID, c.Param("id"))
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, booking)
+		})
+		// เลื่อนนัด body: {userId, startAt} (หมอดูคนเดิม ก่อนนัดอย่างน้อย 24 ชั่วโมง)
+		grp.POST("/:id/reschedule", func(c *gin.Context) {
+			var body struct {
+				UserID  string    `json:"userId" binding:"required"`
+				StartAt time.Time `json:"startAt" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			booking, err := bookingSvc.Reschedule(c.Request.Context(), body.UserID, c.Param("id"), body.StartAt)
+			if err != nil {
+				c.JSON(bookingErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, booking)
+		})
+	}
+}
+
+func bookingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrSeerNotFound), errors.Is(err, services.ErrBookingNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidAvailability):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrSlotUnavailable), errors.Is(err, services.ErrBookingNotCancellable),
+		errors.Is(err, services.ErrRescheduleNotAllowed):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInsufficientBalance):
+		return http.StatusPaymentRequired
 	}
+	return http.StatusInternalServerError
 }
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,6 +10,7 @@ import (
 
 func RegisterCoinRoutes(r *gin.Engine) {
 	coinSvc := services.NewCoinService()
+	notifSvc := newNotificationService()
 	grp := r.Group("/coin")
 	{
 		grp.GET("/balance", func(c *gin.Context) {
@@ -35,6 +37,9 @@ func RegisterCoinRoutes(r *gin.Engine) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			if err := notifSvc.AlertCoinTopUp(c.Request.Context(), payload.UserID, payload.Amount); err != nil {
+				log.Println("Error sending coin top-up alert:", err)
+			}
 			c.JSON(http.StatusOK, gin.H{"status": "ok"})
 		})
 
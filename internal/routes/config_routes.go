@@ -6,9 +6,8 @@ import (
 	"log"
 	"net/http"
 
-	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
-	"github.com/poomiiz/go-backend/internal/utils" // 🔁 import ได้เพราะอยู่คนละ package
+	"github.com/poomiiz/go-backend/internal/services"
 )
 
 type PromptUpdateBody struct {
@@ -18,6 +17,7 @@ type PromptUpdateBody struct {
 }
 
 func RegisterAdminConfigRoutes(r *gin.Engine) {
+	promptSvc := services.NewPromptService()
 	r.POST("/config/prompt/update", func(c *gin.Context) {
 		var body struct {
 			Key    string `json:"key"`
@@ -28,11 +28,8 @@ func RegisterAdminConfigRoutes(r *gin.Engine) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
 			return
 		}
-		ctx := context.Background()
-		_, err := utils.Client.Collection("configs").Doc(body.Key).Set(ctx, map[string]interface{}{
-			"model":  body.Model,
-			"prompt": body.Prompt,
-		}, firestore.MergeAll)
+		// บันทึกผ่าน PromptService เพื่อให้ทุกการแก้ไขมี version ย้อนกลับได้
+		_, err := promptSvc.SavePromptText(context.Background(), body.Key, body.Model, body.Prompt, "config")
 		if err != nil {
 			log.Println("❌ Failed to update prompt:", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
@@ -2,151 +2,409 @@
 package routes
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"runtime/debug"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/poomiiz/go-backend/internal/services"
-	"github.com/poomiiz/go-backend/internal/utils"
 )
 
-// struct ที่ใช้รับ event จาก LINE webhook
-type lineEvent struct {
-	Events []eventObj `json:"events"`
-}
+// maxLineWebhookBody ขนาด body สูงสุดที่รับจาก LINE
+const maxLineWebhookBody = 1 << 20
 
-type eventObj struct {
-	ReplyToken string `json:"replyToken"`
-	Message    struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"message"`
-	Source struct {
-		UserID string `json:"userId"`
-	} `json:"source"`
+// lineWebhookHandler ประมวลผล event จาก LINE หลังตอบ 200 ไปแล้ว
+type lineWebhookHandler struct {
+	line       *services.LineService
+	accounts   *services.LineAccountService
+	richMenus  *services.RichMenuService
+	purchases  *packagePurchaseHooks
+	prefs      *services.NotificationPreferenceService
+	messages   *services.MessageCatalogService
+	chatSvc    *services.ChatService
+	summarySvc *services.SummaryService
+	pkgSvc     *services.PackageService
+	bookings   *services.BookingService
+	flows      *services.FlowEngine
+	aiModel    string
 }
 
 func RegisterLineWebhook(r *gin.Engine) {
-	aiURL := os.Getenv("AI_ROUTER_URL")
+	aiClient := services.NewAIServiceClient(os.Getenv("AI_ROUTER_URL"))
 	aiModel := os.Getenv("AI_DEFAULT_MODEL")
 	if aiModel == "" {
 		aiModel = "gpt-4o"
 	}
+	line := newLineService()
+	richMenus := newRichMenuService(line)
+	chatSvc := newChatService(aiClient, services.NewRAGService(newEmbeddingProvider(aiClient)))
+	summarySvc := newSummaryService(aiClient)
+	coinSvc := services.NewCoinService()
+	pkgSvc := services.NewPackageService(coinSvc)
+	prefs := services.NewNotificationPreferenceService()
+	bookings := services.NewBookingService(coinSvc, newNotificationService(), services.NewWorkpoolService())
+	h := &lineWebhookHandler{
+		line:       line,
+		accounts:   newLineAccountService(line),
+		richMenus:  richMenus,
+		purchases:  newPackagePurchaseHooks(pkgSvc, richMenus),
+		prefs:      prefs,
+		messages:   services.NewMessageCatalogService(prefs),
+		chatSvc:    chatSvc,
+		summarySvc: summarySvc,
+		pkgSvc:     pkgSvc,
+		bookings:   bookings,
+		// LINE_TAROT_DECK_ID = สำรับที่ใช้เปิดไพ่ผ่าน LINE
+		flows: services.NewFlowEngine(
+			services.NewTarotFlow(chatSvc, services.NewTarotService(), newSessionService(), services.NewAIBillingService(coinSvc, pkgSvc, services.NewWorkpoolService()),
+				summarySvc, os.Getenv("LINE_TAROT_DECK_ID"), aiModel),
+			services.NewBookingFlow(bookings),
+		),
+		aiModel: aiModel,
+	}
+	channelSecret := os.Getenv("LINE_CHANNEL_SECRET")
 
 	r.POST("/webhook", func(c *gin.Context) {
-		bodyBytes, _ := ioutil.ReadAll(c.Request.Body)
-		var event lineEvent
-		if err := json.Unmarshal(bodyBytes, &event); err != nil {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxLineWebhookBody))
+		if err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !services.VerifyLineSignature(channelSecret, body, c.GetHeader("X-Line-Signature")) {
+			c.Status(http.StatusUnauthorized)
+			return
+		}
+		var webhook services.LineWebhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
 			c.Status(http.StatusBadRequest)
 			return
 		}
 
-		for _, e := range event.Events {
-			if e.Message.Type != "text" {
-				continue
-			}
-			userID := e.Source.UserID
-			replyToken := e.ReplyToken
-			incomingText := e.Message.Text
-
-			// สร้าง session ใหม่
-			sessionId := uuid.New().String()
-
-			// บันทึกข้อความผู้ใช้
-			utils.SaveUserMessage(sessionId, userID, incomingText)
-
-			// เรียก AI service
-			aiReq := services.AIChatRequest{
-				UserID:         userID,
-				ConversationID: sessionId,
-				Message:        incomingText,
-				Model:          aiModel,
-			}
-			reqBody, _ := json.Marshal(aiReq)
-			aiEndpoint := fmt.Sprintf("%s/ai/chat", aiURL)
-
-			httpReq, _ := http.NewRequestWithContext(context.Background(), "POST", aiEndpoint, bytes.NewBuffer(reqBody))
-			httpReq.Header.Set("Content-Type", "application/json")
-			httpClient := &http.Client{Timeout: 20 * time.Second}
-			resp, err := httpClient.Do(httpReq)
-			if err != nil {
-				replyMessage(replyToken, "ขออภัย เกิดข้อผิดพลาด")
-				continue
-			}
-			respBody, _ := ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
+		// ตอบ 200 ทันที (LINE จะส่งซ้ำถ้าตอบช้า) แล้วประมวลผลต่อเบื้องหลัง
+		c.Status(http.StatusOK)
+		go h.process(webhook.Events)
+	})
+}
 
-			if resp.StatusCode >= 400 {
-				replyMessage(replyToken, "ขออภัย AI ไม่ตอบกลับ")
-				continue
-			}
-			var aiResp services.AIChatResponse
-			if err := json.Unmarshal(respBody, &aiResp); err != nil {
-				replyMessage(replyToken, "เกิดข้อผิดพลาดในการประมวลผล")
-				continue
+func (h *lineWebhookHandler) process(events []services.LineEvent) {
+	for _, ev := range events {
+		h.processEvent(ev)
+	}
+}
+
+// processEvent จอง event → ประมวลผล → บันทึกว่าเสร็จ
+// panic ใน handler ถูก recover ที่นี่ (Recovery ของ gin ไม่ครอบ goroutine นี้) และปล่อยการจองให้ LINE ส่งซ้ำได้
+func (h *lineWebhookHandler) processEvent(ev services.LineEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	fresh, err := h.line.ClaimEvent(ctx, ev)
+	if err != nil {
+		log.Println("LINE dedupe error:", err)
+	}
+	if err == nil && !fresh {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("LINE event %s (%s) panic: %v\n%s", ev.WebhookEventID, ev.Type, r, debug.Stack())
+			if err := h.line.ReleaseEvent(context.Background(), ev); err != nil {
+				log.Println("LINE release event error:", err)
 			}
+			return
+		}
+		if err := h.line.CompleteEvent(context.Background(), ev); err != nil {
+			log.Println("LINE complete event error:", err)
+		}
+	}()
+	h.handle(ctx, ev)
+}
 
-			// บันทึกข้อความจาก bot
-			utils.SaveBotMessage(sessionId, userID, aiResp.Response, aiResp.ModelUsed)
+func (h *lineWebhookHandler) handle(ctx context.Context, ev services.LineEvent) {
+	lineUserID := ev.Source.UserID
+	switch ev.Type {
+	case "follow":
+		if err := h.line.SetFollowing(ctx, lineUserID, true); err != nil {
+			log.Println("LINE follow error:", err)
+		}
+		// สร้างบัญชีอัตโนมัติให้ผู้ติดตามใหม่ เพื่อให้เหรียญ/แพ็กเกจ/รีวิวผูกกับ user ID ภายในได้ทันที
+		if _, err := h.accounts.ResolveUser(ctx, lineUserID); err != nil {
+			log.Println("LINE resolve user error:", err)
+		}
+		// ภาษาเลือกหลังสร้างบัญชี (บัญชีใหม่ได้ภาษาจากโปรไฟล์ LINE)
+		l := h.messages.ForLineUser(ctx, lineUserID)
+		h.reply(ctx, ev.ReplyToken, services.LineText(l.T("welcome")).WithQuickReply(lineMenuActions(l)...))
+	case "unfollow":
+		if err := h.line.SetFollowing(ctx, lineUserID, false); err != nil {
+			log.Println("LINE unfollow error:", err)
+		}
+	case "accountLink":
+		h.handleAccountLink(ctx, ev, h.messages.ForLineUser(ctx, lineUserID))
+	case "postback":
+		h.handlePostback(ctx, ev, h.messages.ForLineUser(ctx, lineUserID))
+	case "message":
+		l := h.messages.ForLineUser(ctx, lineUserID)
+		switch ev.Message.Type {
+		case "text":
+			h.handleText(ctx, ev, l)
+		case "sticker":
+			h.replyText(ctx, ev.ReplyToken, l.T("reply.sticker"))
+		case "image":
+			h.replyText(ctx, ev.ReplyToken, l.T("reply.image"))
+		}
+	}
+}
 
-			// ส่งข้อความกลับ LINE
-			replyMessage(replyToken, aiResp.Response)
+// handleText เรียก AI ผ่าน ChatService (ใช้ session เดิมถ้ายังไม่หมดเวลา + hold เหรียญ + บันทึกข้อความ)
+func (h *lineWebhookHandler) handleText(ctx context.Context, ev services.LineEvent, l *services.Localizer) {
+	if ev.Source.UserID == "" {
+		return // group/room ที่ผู้ใช้ไม่ยินยอมให้เห็น userId
+	}
+	userID, err := h.accounts.ResolveUser(ctx, ev.Source.UserID)
+	if err != nil {
+		log.Println("LINE resolve user error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("error.generic"))
+		return
+	}
+	// อยู่ระหว่าง flow → ให้ step จัดการข้อความก่อน (step ส่งกลับมาให้ AI ได้)
+	if h.runFlow(ctx, ev, services.FlowInput{LineUserID: ev.Source.UserID, UserID: userID, Text: ev.Message.Text, L: l}) {
+		return
+	}
+	aiResp, err := h.chatSvc.Chat(ctx, services.AIChatRequest{
+		UserID:  userID,
+		Message: ev.Message.Text,
+		Model:   h.aiModel,
+		Purpose: "chat",
+	})
+	if errors.Is(err, services.ErrInsufficientBalance) {
+		label := l.T("menu.packages")
+		h.reply(ctx, ev.ReplyToken, services.LineText(l.T("chat.insufficient_balance")).
+			WithQuickReply(services.PostbackAction(label, "action=packages", label)))
+		return
+	}
+	if err != nil {
+		log.Println("LINE chat error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("chat.no_response"))
+		return
+	}
+	h.reply(ctx, ev.ReplyToken, services.LineText(aiResp.Response).WithQuickReply(lineMenuActions(l)...))
+
+	// 🔁 สรุปบทสนทนาผ่าน workpool (ข้อความที่ตามมาติด ๆ จะรวมเป็นการสรุปครั้งเดียว)
+	if err := h.summarySvc.Schedule(ctx, aiResp.ConversationID); err != nil {
+		log.Println("schedule summary error:", err)
+	}
+}
 
-			// 🔁 สรุปบทสนทนา async
-			go summarizeSession(sessionId)
+// handlePostback action ที่ทำจบในครั้งเดียว (แพ็กเกจ, เชื่อมบัญชี) ที่เหลือส่งให้ FlowEngine
+func (h *lineWebhookHandler) handlePostback(ctx context.Context, ev services.LineEvent, l *services.Localizer) {
+	if ev.Source.UserID == "" {
+		return
+	}
+	data, _ := url.ParseQuery(ev.Postback.Data)
+	switch data.Get("action") {
+	case "packages":
+		pkgs, err := h.pkgSvc.ListPackages(ctx)
+		if err != nil {
+			log.Println("LINE list packages error:", err)
+			h.replyText(ctx, ev.ReplyToken, l.T("package.load_failed"))
+			return
+		}
+		h.reply(ctx, ev.ReplyToken, services.PackageCatalogMessage(l, pkgs))
+	case "buy_package":
+		h.buyPackage(ctx, ev, l, data.Get("packageId"))
+	case "link_account":
+		h.startAccountLink(ctx, ev, l)
+	case "marketing_opt_out", "marketing_opt_in":
+		h.setMarketingOptOut(ctx, ev, l, data.Get("action") == "marketing_opt_out")
+	case "cancel_booking":
+		h.cancelBooking(ctx, ev, l, data.Get("bookingId"))
+	default:
+		userID, err := h.accounts.ResolveUser(ctx, ev.Source.UserID)
+		if err != nil {
+			log.Println("LINE resolve user error:", err)
+			h.replyText(ctx, ev.ReplyToken, l.T("error.generic"))
+			return
 		}
+		in := services.FlowInput{LineUserID: ev.Source.UserID, UserID: userID, Postback: data, Params: ev.Postback.Params, L: l}
+		if !h.runFlow(ctx, ev, in) {
+			log.Printf("LINE postback from %s: %s", ev.Source.UserID, ev.Postback.Data)
+		}
+	}
+}
 
-		c.Status(http.StatusOK)
-	})
+// runFlow คืน false เมื่อไม่มี flow รับข้อความนี้
+func (h *lineWebhookHandler) runFlow(ctx context.Context, ev services.LineEvent, in services.FlowInput) bool {
+	messages, handled, err := h.flows.Handle(ctx, in)
+	if err != nil {
+		log.Println("LINE flow error:", err)
+		h.replyText(ctx, ev.ReplyToken, in.L.T("error.flow"))
+		return true
+	}
+	if len(messages) > 0 {
+		h.reply(ctx, ev.ReplyToken, messages...)
+	}
+	return handled
 }
 
-// ฟังก์ชันส่งข้อความตอบกลับ LINE
-func replyMessage(replyToken string, message string) {
-	endpoint := "https://api.line.me/v2/bot/message/reply"
-	payload := map[string]interface{}{
-		"replyToken": replyToken,
-		"messages": []map[string]string{
-			{
-				"type": "text",
-				"text": message,
-			},
-		},
+// buyPackage ซื้อแพ็กเกจจากปุ่มใน catalog (ตัดเหรียญ) แล้วทำงานหลังซื้อ (rich menu, เตือนหมดอายุ, ใบเสร็จ)
+func (h *lineWebhookHandler) buyPackage(ctx context.Context, ev services.LineEvent, l *services.Localizer, packageID string) {
+	userID, err := h.accounts.ResolveUser(ctx, ev.Source.UserID)
+	if err != nil {
+		log.Println("LINE resolve user error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("error.generic"))
+		return
 	}
-	jsonBody, _ := json.Marshal(payload)
+	up, err := h.pkgSvc.BuyPackage(ctx, userID, packageID)
+	if errors.Is(err, services.ErrInsufficientBalance) {
+		h.replyText(ctx, ev.ReplyToken, l.T("package.insufficient_balance"))
+		return
+	}
+	if err != nil {
+		log.Println("LINE buy package error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("package.buy_failed"))
+		return
+	}
+	h.replyText(ctx, ev.ReplyToken, l.T("package.bought", "ExpiresAt", up.ExpiresAt))
+	h.purchases.run(ctx, userID, up)
+}
+
+// cancelBooking ยกเลิกการจองจากปุ่มในข้อความยืนยัน แจ้งว่าได้เหรียญคืนหรือไม่
+func (h *lineWebhookHandler) cancelBooking(ctx context.Context, ev services.LineEvent, l *services.Localizer, bookingID string) {
+	userID, err := h.accounts.ResolveUser(ctx, ev.Source.UserID)
+	if err != nil {
+		log.Println("LINE resolve user error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("error.generic"))
+		return
+	}
+	b, err := h.bookings.Cancel(ctx, userID, bookingID)
+	switch {
+	case errors.Is(err, services.ErrBookingNotFound):
+		h.replyText(ctx, ev.ReplyToken, l.T("booking.not_found"))
+	case errors.Is(err, services.ErrBookingNotCancellable):
+		h.replyText(ctx, ev.ReplyToken, l.T("booking.not_cancellable"))
+	case err != nil:
+		log.Println("LINE cancel booking error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("error.generic"))
+	case b.Refunded && b.CoinCost > 0:
+		h.replyText(ctx, ev.ReplyToken, l.T("booking.cancelled_refund", "Start", b.StartAt, "Coins", b.CoinCost))
+	case b.CoinCost > 0:
+		h.replyText(ctx, ev.ReplyToken, l.T("booking.cancelled_no_refund", "Start", b.StartAt))
+	default:
+		h.replyText(ctx, ev.ReplyToken, l.T("booking.cancelled", "Start", b.StartAt))
+	}
+}
 
-	req, _ := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("LINE_CHANNEL_ACCESS_TOKEN"))
+// setMarketingOptOut ปิด/เปิดรับข่าวสาร (category marketing) จากปุ่มท้ายข้อความ broadcast
+func (h *lineWebhookHandler) setMarketingOptOut(ctx context.Context, ev services.LineEvent, l *services.Localizer, optOut bool) {
+	userID, err := h.accounts.ResolveUser(ctx, ev.Source.UserID)
+	if err == nil {
+		err = h.prefs.SetCategory(ctx, userID, services.CategoryMarketing, !optOut)
+	}
+	if err != nil {
+		log.Println("LINE marketing opt-out error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("error.generic"))
+		return
+	}
+	if optOut {
+		label := l.T("marketing.opt_in_button")
+		h.reply(ctx, ev.ReplyToken, services.LineText(l.T("marketing.opted_out")).
+			WithQuickReply(services.PostbackAction(label, "action=marketing_opt_in", label)))
+		return
+	}
+	h.replyText(ctx, ev.ReplyToken, l.T("marketing.opted_in"))
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// startAccountLink ออก link token แล้วส่งลิงก์ไปหน้า login ของเว็บ (LINE_ACCOUNT_LINK_URL)
+// หน้าเว็บจะเรียก POST /user/:id/line/link แล้ว redirect ผู้ใช้ไปยัง LINE เพื่อยืนยัน
+func (h *lineWebhookHandler) startAccountLink(ctx context.Context, ev services.LineEvent, l *services.Localizer) {
+	loginURL := os.Getenv("LINE_ACCOUNT_LINK_URL")
+	if loginURL == "" || ev.Source.UserID == "" {
+		h.replyText(ctx, ev.ReplyToken, l.T("link.unavailable"))
+		return
+	}
+	token, err := h.line.IssueLinkToken(ctx, ev.Source.UserID)
 	if err != nil {
-		fmt.Println("LINE API error:", err)
+		log.Println("LINE link token error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("link.start_failed"))
 		return
 	}
-	defer resp.Body.Close()
+	link := loginURL + "?linkToken=" + url.QueryEscape(token)
+	body := services.FlexBox("vertical",
+		services.FlexText(l.T("link.title")).With("weight", "bold").With("size", "lg"),
+		services.FlexText(l.T("link.body")).With("size", "sm"),
+	).With("spacing", "md")
+	footer := services.FlexBox("vertical", services.FlexButton(services.URIAction(l.T("link.login_button"), link)).With("style", "primary"))
+	h.reply(ctx, ev.ReplyToken, services.LineFlex(l.T("link.title"), services.FlexBubble(nil, body, footer)))
 }
 
-// summarizeSession ดึงข้อความทั้งหมดจาก session แล้วส่งไปสรุป
-func summarizeSession(sessionId string) {
-	messages, _ := utils.GetSessionMessages(sessionId)
-	fullText := utils.JoinText(messages)
+// handleAccountLink ผลการเชื่อมบัญชีจาก LINE (nonce ออกโดย StartLink)
+func (h *lineWebhookHandler) handleAccountLink(ctx context.Context, ev services.LineEvent, l *services.Localizer) {
+	if ev.Link.Result != "ok" {
+		h.replyText(ctx, ev.ReplyToken, l.T("link.rejected"))
+		return
+	}
+	userID, err := h.accounts.CompleteLink(ctx, ev.Source.UserID, ev.Link.Nonce)
+	switch {
+	case errors.Is(err, services.ErrLineLinkExpired):
+		h.replyText(ctx, ev.ReplyToken, l.T("link.expired"))
+	case errors.Is(err, services.ErrLineAlreadyLinked):
+		h.replyText(ctx, ev.ReplyToken, l.T("link.already_linked"))
+	case err != nil:
+		log.Println("LINE account link error:", err)
+		h.replyText(ctx, ev.ReplyToken, l.T("link.failed"))
+	default:
+		h.replyText(ctx, ev.ReplyToken, l.T("link.done"))
+		// บัญชีที่ผูกอาจมี package อยู่แล้ว เปลี่ยนเมนูให้ตรง tier
+		if err := h.richMenus.Relink(ctx, userID); err != nil {
+			log.Println("LINE rich menu relink error:", err)
+		}
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// lineMenuActions ปุ่ม quick reply ที่แนบท้ายคำตอบทั่วไป
+func lineMenuActions(l *services.Localizer) []services.LineAction {
+	return []services.LineAction{
+		services.FlowStartAction(l.T("menu.tarot"), services.TarotFlowName),
+		services.PostbackAction(l.T("menu.packages"), "action=packages", l.T("menu.packages")),
+		services.PostbackAction(l.T("menu.link_account"), "action=link_account", ""),
+	}
+}
+
+// newLineService ตั้งค่า LineService จาก env (ใช้ร่วมกับ route อื่นที่ต้องเรียก Messaging API)
+func newLineService() *services.LineService {
+	return services.NewLineService(os.Getenv("LINE_CHANNEL_ACCESS_TOKEN"))
+}
 
-	summary, err1 := services.AISummarize(ctx, fullText)
-	intent, emotion, err2 := services.AIInterpret(ctx, fullText)
+// newRichMenuService ใช้ผูก rich menu ตาม tier หลังซื้อ package หรือผูกบัญชี
+func newRichMenuService(line *services.LineService) *services.RichMenuService {
+	return services.NewRichMenuService(line, services.NewPackageService(services.NewCoinService()), services.NewWorkpoolService())
+}
+
+// newLineAccountService LINE_LOGIN_CHANNEL_ID ใช้ตรวจ ID token จาก LINE Login
+func newLineAccountService(line *services.LineService) *services.LineAccountService {
+	return services.NewLineAccountService(line, services.NewCoinService(), services.NewWorkpoolService(), os.Getenv("LINE_LOGIN_CHANNEL_ID"))
+}
 
-	if err1 == nil && err2 == nil {
-		utils.SaveSummary(sessionId, summary, intent, fmt.Sprintf("%.2f", emotion))
+func (h *lineWebhookHandler) reply(ctx context.Context, replyToken string, messages ...services.LineMessage) {
+	if replyToken == "" {
+		return
+	}
+	if err := h.line.Reply(ctx, replyToken, messages...); err != nil {
+		log.Println("LINE reply error:", err)
 	}
+}
 
+func (h *lineWebhookHandler) replyText(ctx context.Context, replyToken, text string) {
+	if replyToken == "" {
+		return
+	}
+	if err := h.line.ReplyText(ctx, replyToken, text); err != nil {
+		log.Println("LINE reply error:", err)
+	}
 }
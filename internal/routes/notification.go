@@ -1,37 +1,100 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-
 	"os"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/poomiiz/go-backend/internal/services"
 )
 
-func RegisterNotificationRoutes(r *gin.Engine) {
+// newNotificationService ตั้งค่า NotificationService จาก env (ใช้ร่วมกับ route อื่นที่ต้องส่งแจ้งเตือน)
+func newNotificationService() *services.NotificationService {
 	lineToken := os.Getenv("LINE_CHANNEL_TOKEN")
-	telegramURL := os.Getenv("TELEGRAM_BOT_URL")
-	telegramAuth := os.Getenv("TELEGRAM_BOT_AUTH") // ถ้ามี
-	notifSvc := services.NewNotificationService(lineToken, telegramURL, telegramAuth)
+	return services.NewNotificationService(lineToken, newTelegramBot(), newEmailProvider())
+}
+
+// newTelegramBot TELEGRAM_BOT_TOKEN + ห้องเริ่มต้น TELEGRAM_ALERT_CHAT_ID (/TELEGRAM_ALERT_THREAD_ID)
+// แยกห้องตามประเภทด้วย TELEGRAM_ALERT_ROUTES เช่น {"coin_topup":{"chatId":"-100123","threadId":7}}
+// TELEGRAM_PARSE_MODE = HTML (ค่าเริ่มต้น) หรือ MarkdownV2 ไม่ตั้ง token = ไม่ส่ง alert
+func newTelegramBot() *services.TelegramBot {
+	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+	if token == "" {
+		return nil
+	}
+	def := services.TelegramRoute{
+		ChatID:   os.Getenv("TELEGRAM_ALERT_CHAT_ID"),
+		ThreadID: int64(envInt("TELEGRAM_ALERT_THREAD_ID", 0)),
+	}
+	routes := map[string]services.TelegramRoute{}
+	if raw := os.Getenv("TELEGRAM_ALERT_ROUTES"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+			log.Println("Invalid TELEGRAM_ALERT_ROUTES:", err)
+		}
+	}
+	return services.NewTelegramBot(token, os.Getenv("TELEGRAM_PARSE_MODE"), def, routes)
+}
+
+// newEmailProvider EMAIL_PROVIDER=smtp (SMTP_HOST/SMTP_PORT/SMTP_USERNAME/SMTP_PASSWORD)
+// หรือ file (เขียน .eml ลง EMAIL_SINK_DIR ใช้ตอน dev) ไม่ตั้ง = ไม่ส่งอีเมล
+func newEmailProvider() services.EmailProvider {
+	from := os.Getenv("EMAIL_FROM")
+	switch os.Getenv("EMAIL_PROVIDER") {
+	case "smtp":
+		return services.NewSMTPEmailProvider(os.Getenv("SMTP_HOST"), envInt("SMTP_PORT", 587),
+			os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	case "file":
+		dir := os.Getenv("EMAIL_SINK_DIR")
+		if dir == "" {
+			dir = "tmp/emails"
+		}
+		return services.NewFileEmailProvider(dir, from)
+	}
+	return nil
+}
+
+func RegisterNotificationRoutes(r *gin.Engine) {
+	notifSvc := newNotificationService()
 
 	grp := r.Group("/notification")
 	{
 		grp.POST("/line", func(c *gin.Context) {
+			// ส่ง "message" (text) หรือ "messages" (text/image/flex ตาม schema ของ LINE) อย่างใดอย่างหนึ่ง
 			var payload struct {
-				To      string `json:"to"`
-				Message string `json:"message"`
+				To       string                 `json:"to"`
+				Category string                 `json:"category"` // ไม่ระบุ = reminders
+				Message  string                 `json:"message"`
+				Messages []services.LineMessage `json:"messages"`
 			}
 			if err := c.ShouldBindJSON(&payload); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 				return
 			}
-			err := notifSvc.SendLineMessage(c.Request.Context(), payload.To, payload.Message)
+			messages := payload.Messages
+			if len(messages) == 0 {
+				messages = []services.LineMessage{services.LineText(payload.Message)}
+			}
+			if payload.Category == "" {
+				payload.Category = services.CategoryReminders
+			}
+			err := notifSvc.SendLineMessages(c.Request.Context(), payload.Category, payload.To, messages...)
+			if errors.Is(err, services.ErrInvalidLineMessage) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, services.ErrNotificationSuppressed) {
+				c.JSON(http.StatusOK, gin.H{"status": "suppressed", "reason": err.Error()})
+				return
+			}
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			c.JSON(http.StatusAccepted, gin.H{"status": "queued"})
 		})
 
 		grp.POST("/telegram", func(c *gin.Context) {
@@ -48,7 +111,18 @@ func RegisterNotificationRoutes(r *gin.Engine) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			c.JSON(http.StatusAccepted, gin.H{"status": "queued"})
+		})
+
+		// ประวัติการส่ง (outbox) ถึงผู้ใช้ ?limit=
+		grp.GET("/deliveries/:userId", func(c *gin.Context) {
+			limit, _ := strconv.Atoi(c.Query("limit"))
+			list, err := notifSvc.Deliveries(c.Request.Context(), c.Param("userId"), limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, list)
 		})
 	}
 }
@@ -1,7 +1,11 @@
 package routes
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/poomiiz/go-backend/internal/services"
@@ -10,6 +14,7 @@ import (
 func RegisterPackageRoutes(r *gin.Engine) {
 	coinSvc := services.NewCoinService()
 	pkgSvc := services.NewPackageService(coinSvc)
+	purchases := newPackagePurchaseHooks(pkgSvc, newRichMenuService(newLineService()))
 
 	grp := r.Group("/package")
 	{
@@ -27,6 +32,7 @@ func RegisterPackageRoutes(r *gin.Engine) {
 				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 				return
 			}
+			purchases.run(c.Request.Context(), payload.UserID, up)
 			c.JSON(http.StatusOK, up)
 		})
 
@@ -41,3 +47,48 @@ func RegisterPackageRoutes(r *gin.Engine) {
 		})
 	}
 }
+
+// packagePurchaseHooks งานหลังซื้อ/ต่ออายุ package (ใช้ทั้ง /package/buy และปุ่มซื้อใน LINE)
+type packagePurchaseHooks struct {
+	pkgSvc    *services.PackageService
+	richMenus *services.RichMenuService
+	expiry    *services.PackageExpiryReminder
+	notif     *services.NotificationService
+}
+
+// newPackagePurchaseHooks PACKAGE_RENEW_URL = ลิงก์ต่ออายุในอีเมลเตือนหมดอายุ
+func newPackagePurchaseHooks(pkgSvc *services.PackageService, richMenus *services.RichMenuService) *packagePurchaseHooks {
+	notif := newNotificationService()
+	return &packagePurchaseHooks{
+		pkgSvc:    pkgSvc,
+		richMenus: richMenus,
+		expiry:    services.NewPackageExpiryReminder(pkgSvc, notif, services.NewWorkpoolService(), os.Getenv("PACKAGE_RENEW_URL")),
+		notif:     notif,
+	}
+}
+
+// run เปลี่ยน rich menu ตาม tier, ตั้งเวลาเตือนหมดอายุ และส่งใบเสร็จทางอีเมล (ซื้อสำเร็จแล้ว ข้อผิดพลาดจึงแค่ log)
+func (h *packagePurchaseHooks) run(ctx context.Context, userID string, up *services.UserPackage) {
+	if err := h.richMenus.OnPackageChanged(ctx, userID); err != nil {
+		log.Println("Error relinking rich menu:", err)
+	}
+	if err := h.expiry.Schedule(ctx, userID); err != nil {
+		log.Println("Error scheduling package expiry email:", err)
+	}
+	pkg, err := h.pkgSvc.GetPackage(ctx, up.PackageID)
+	if err != nil {
+		log.Println("Error loading package for receipt:", err)
+		return
+	}
+	err = h.notif.SendEmail(ctx, services.CategorySystem, userID, services.EmailReceipt, services.ReceiptEmail{
+		Item:      pkg.Name,
+		Amount:    pkg.CoinCost,
+		Unit:      services.ReceiptCoins,
+		Reference: pkg.ID,
+		PaidAt:    up.UpdatedAt,
+		ExpiresAt: up.ExpiresAt,
+	})
+	if err != nil && !errors.Is(err, services.ErrNotificationSuppressed) {
+		log.Println("Error sending package receipt:", err)
+	}
+}
@@ -1,6 +1,8 @@
 package routes
 
 import (
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,6 +11,7 @@ import (
 
 func RegisterPaymentRoutes(r *gin.Engine) {
 	paySvc := services.NewPaymentService(5.0) // หรือใส่ percent เป็น env
+	notifSvc := newNotificationService()
 
 	grp := r.Group("/payment")
 	{
@@ -39,12 +42,27 @@ func RegisterPaymentRoutes(r *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 				return
 			}
-			err := paySvc.VerifyPayment(c.Request.Context(), payload.PaymentID)
+			ctx := c.Request.Context()
+			payment, err := paySvc.VerifyPayment(ctx, payload.PaymentID)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"status": "verified"})
+			if payment.Status == "paid" {
+				err := notifSvc.SendEmail(ctx, services.CategorySystem, payment.UserID, services.EmailReceipt, services.ReceiptEmail{
+					Item:      payment.Provider,
+					Amount:    payment.Amount,
+					Unit:      services.ReceiptTHB,
+					Reference: payload.PaymentID,
+					PaidAt:    payment.UpdatedAt,
+				})
+				if err != nil && !errors.Is(err, services.ErrNotificationSuppressed) {
+					log.Println("Error sending payment receipt:", err)
+				}
+			} else if err := notifSvc.AlertPaymentFailed(ctx, payload.PaymentID, payment); err != nil {
+				log.Println("Error sending payment failed alert:", err)
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "verified", "paymentStatus": payment.Status})
 		})
 	}
 }
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,6 +10,8 @@ import (
 
 func RegisterReviewRoutes(r *gin.Engine) {
 	reviewSvc := services.NewReviewService()
+	experimentSvc := services.NewExperimentService(services.NewPromptService())
+	notifSvc := newNotificationService()
 	grp := r.Group("/review")
 	{
 		grp.POST("/submit", func(c *gin.Context) {
@@ -27,6 +30,12 @@ func RegisterReviewRoutes(r *gin.Engine) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
+			if err := experimentSvc.RecordReview(c.Request.Context(), payload.UserID, float64(payload.Rating)); err != nil {
+				log.Println("Error recording experiment review:", err)
+			}
+			if err := notifSvc.AlertNewReview(c.Request.Context(), payload.UserID, revID); err != nil {
+				log.Println("Error sending new review alert:", err)
+			}
 			c.JSON(http.StatusCreated, gin.H{"reviewId": revID})
 		})
 
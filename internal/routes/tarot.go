@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/poomiiz/go-backend/internal/services"
+)
+
+func RegisterTarotRoutes(r *gin.Engine) {
+	tarotSvc := services.NewTarotService()
+	sessions := newSessionService()
+
+	grp := r.Group("/tarot")
+	{
+		grp.GET("/spreads", func(c *gin.Context) {
+			c.JSON(http.StatusOK, services.Spreads)
+		})
+
+		// เปิดไพ่แล้วบันทึกใต้ conversation (ส่ง drawId ต่อไปที่ /ai/chat เพื่อให้ AI ตีความ)
+		grp.POST("/draw", func(c *gin.Context) {
+			var payload struct {
+				UserID         string `json:"userId"`
+				ConversationID string `json:"conversationId"`
+				DeckID         string `json:"deckId"`
+				Spread         string `json:"spread"`
+				Question       string `json:"question"`
+				AllowReversed  *bool  `json:"allowReversed"`
+			}
+			if err := c.ShouldBindJSON(&payload); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+			if payload.UserID == "" || payload.DeckID == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "userId and deckId are required"})
+				return
+			}
+			if payload.Spread == "" {
+				payload.Spread = "three_card"
+			}
+			allowReversed := payload.AllowReversed == nil || *payload.AllowReversed
+
+			ctx := c.Request.Context()
+			if payload.ConversationID == "" {
+				convID, err := sessions.ResolveSession(ctx, payload.UserID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				payload.ConversationID = convID
+			}
+
+			draw, err := tarotSvc.Draw(ctx, payload.UserID, payload.ConversationID, payload.DeckID, payload.Spread, payload.Question, allowReversed)
+			if errors.Is(err, services.ErrUnknownSpread) || errors.Is(err, services.ErrNotEnoughCards) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusCreated, draw)
+		})
+	}
+}
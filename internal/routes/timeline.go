@@ -3,41 +3,56 @@ package routes
 
 import (
 	"net/http"
-	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/poomiiz/go-backend/internal/utils"
+	"github.com/poomiiz/go-backend/internal/services"
 )
 
-type TimelineItem struct {
-	SessionID string `json:"sessionId"`
-	Summary   string `json:"summary"`
-	Intent    string `json:"intent"`
-	Emotion   string `json:"emotion"`
-	Timestamp string `json:"timestamp"`
-}
-
+// RegisterTimelineRoutes
+// GET /timeline/:userId?before=<cursor>&limit=20&types=conversation,tarot_draw&intent=&emotion=
 func RegisterTimelineRoutes(r *gin.Engine) {
-	r.GET("/timeline/:userId", func(c *gin.Context) {
-		userID := c.Param("userId")
-		sessions := utils.QueryUserConversations(userID)
+	timelineSvc := services.NewTimelineService()
 
-		items := make([]TimelineItem, 0)
-		for _, s := range sessions {
-			items = append(items, TimelineItem{
-				SessionID: s.ID,
-				Summary:   s.Data["summary"].(string),
-				Intent:    s.Data["intent"].(string),
-				Emotion:   s.Data["emotion"].(string),
-				Timestamp: s.Data["startedAt"].(string), // หรือใช้ time.Time.Format
-			})
+	r.GET("/timeline/:userId", func(c *gin.Context) {
+		q := services.TimelineQuery{
+			UserID:  c.Param("userId"),
+			Intent:  c.Query("intent"),
+			Emotion: c.Query("emotion"),
+		}
+		if v := c.Query("before"); v != "" {
+			before, err := services.ParseTimelineCursor(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+				return
+			}
+			q.Before = before
+		}
+		if v := c.Query("limit"); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+				return
+			}
+			q.Limit = limit
+		}
+		if v := c.Query("types"); v != "" {
+			q.Types = strings.Split(v, ",")
 		}
 
-		// เรียงตามเวลา (ใหม่ → เก่า)
-		sort.Slice(items, func(i, j int) bool {
-			return items[i].Timestamp > items[j].Timestamp
+		items, next, err := timelineSvc.Timeline(c.Request.Context(), q)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		nextCursor := ""
+		if !next.IsZero() {
+			nextCursor = next.String()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"items":      items,
+			"nextCursor": nextCursor,
 		})
-
-		c.JSON(http.StatusOK, items)
 	})
 }
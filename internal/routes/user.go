@@ -1,7 +1,12 @@
 package routes
 
 import (
+	"bytes"
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/poomiiz/go-backend/internal/services"
@@ -9,6 +14,13 @@ import (
 
 func RegisterUserRoutes(r *gin.Engine) {
 	userSvc := services.NewUserService()
+	retentionSvc := services.NewRetentionService(services.NewWorkpoolService())
+	privacySvc, privacyErr := services.NewPrivacyService(os.Getenv("PDPA_PSEUDONYM_SALT"))
+	line := newLineService()
+	lineAccountSvc := newLineAccountService(line)
+	richMenuSvc := newRichMenuService(line)
+	prefSvc := services.NewNotificationPreferenceService()
+	notifSvc := newNotificationService()
 	grp := r.Group("/user")
 	{
 		grp.POST("/register", func(c *gin.Context) {
@@ -46,6 +58,51 @@ func RegisterUserRoutes(r *gin.Engine) {
 			c.JSON(http.StatusOK, gin.H{"userId": uid, "role": user.Role})
 		})
 
+		// ขอลิงก์ตั้งรหัสผ่านใหม่ทางอีเมล (ตอบเหมือนกันเสมอเพื่อไม่ให้รู้ว่ามีอีเมลนี้ในระบบไหม)
+		grp.POST("/password/forgot", func(c *gin.Context) {
+			var payload struct {
+				Email string `json:"email"`
+			}
+			if err := c.ShouldBindJSON(&payload); err != nil || payload.Email == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+			ctx := c.Request.Context()
+			uid, token, err := userSvc.RequestPasswordReset(ctx, payload.Email)
+			if err == nil {
+				err = notifSvc.SendAccountEmail(ctx, uid, services.EmailPasswordReset, services.PasswordResetEmail{
+					Email:            payload.Email,
+					ResetURL:         os.Getenv("PASSWORD_RESET_URL") + "?token=" + url.QueryEscape(token),
+					ExpiresInMinutes: int(services.PasswordResetTTL.Minutes()),
+				})
+			}
+			if err != nil && !errors.Is(err, services.ErrUserNotFound) {
+				log.Println("Error sending password reset:", err)
+			}
+			c.JSON(http.StatusAccepted, gin.H{"status": "sent"})
+		})
+
+		grp.POST("/password/reset", func(c *gin.Context) {
+			var payload struct {
+				Token    string `json:"token"`
+				Password string `json:"password"`
+			}
+			if err := c.ShouldBindJSON(&payload); err != nil || payload.Token == "" || payload.Password == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+			err := userSvc.ResetPassword(c.Request.Context(), payload.Token, payload.Password)
+			if errors.Is(err, services.ErrInvalidResetToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "reset"})
+		})
+
 		grp.GET("/:id", func(c *gin.Context) {
 			userID := c.Param("id")
 			user, err := userSvc.GetByID(c.Request.Context(), userID)
@@ -55,5 +112,199 @@ func RegisterUserRoutes(r *gin.Engine) {
 			}
 			c.JSON(http.StatusOK, user)
 		})
+
+		// ปักหมุด/เลิกปักหมุด conversation ที่ปักหมุดไว้จะไม่ถูกลบตาม retention
+		grp.POST("/:id/conversations/:convId/pin", func(c *gin.Context) {
+			var payload struct {
+				Pinned bool `json:"pinned"`
+			}
+			if err := c.ShouldBindJSON(&payload); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+			err := retentionSvc.SetPinned(c.Request.Context(), c.Param("id"), c.Param("convId"), payload.Pinned)
+			if errors.Is(err, services.ErrConversationNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"conversationId": c.Param("convId"), "pinned": payload.Pinned})
+		})
+
+		// การตั้งค่าการแจ้งเตือน (ยังไม่เคยตั้ง = ค่าเริ่มต้น เปิดทุกอย่าง)
+		grp.GET("/:id/notification-preferences", func(c *gin.Context) {
+			prefs, err := prefSvc.Get(c.Request.Context(), c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, prefs)
+		})
+
+		// body: {channels: {line, email}, categories: {reminders, marketing, booking, system},
+		//        quietHours: {start: "22:00", end: "08:00"}, timezone: "Asia/Bangkok", language: "th"}
+		// booking/system เป็นข้อความจำเป็น ส่งเสมอแม้ปิด category หรืออยู่ใน quiet hours
+		grp.PUT("/:id/notification-preferences", func(c *gin.Context) {
+			var payload services.NotificationPreferences
+			if err := c.ShouldBindJSON(&payload); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+			prefs, err := prefSvc.Update(c.Request.Context(), c.Param("id"), payload)
+			if errors.Is(err, services.ErrInvalidPreferences) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, prefs)
+		})
+
+		// คำขอสำเนาข้อมูล/ลบข้อมูล (PDPA) ต้องมี salt ของ pseudonym ไม่งั้นไม่เปิด route
+		if privacyErr != nil {
+			log.Println("PDPA export/erasure routes disabled:", privacyErr)
+		} else {
+			registerUserPrivacyRoutes(grp, userSvc, privacySvc, notifSvc)
+		}
+
+		// เชื่อมบัญชีกับ LINE OA: หน้าเว็บส่ง linkToken ที่ได้จากลิงก์ในแชทพร้อมอีเมล/รหัสผ่านของบัญชี
+		// แล้ว redirect ผู้ใช้ไปที่ redirectUrl
+		grp.POST("/:id/line/link", func(c *gin.Context) {
+			var payload struct {
+				LinkToken string `json:"linkToken"`
+				Email     string `json:"email"`
+				Password  string `json:"password"`
+			}
+			if err := c.ShouldBindJSON(&payload); err != nil || payload.LinkToken == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "linkToken required"})
+				return
+			}
+			redirectURL, err := lineAccountSvc.StartLink(c.Request.Context(), c.Param("id"), payload.Email, payload.Password, payload.LinkToken)
+			if err != nil {
+				c.JSON(lineAccountErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"redirectUrl": redirectURL})
+		})
+
+		// เชื่อมบัญชีด้วย LINE Login (ID token จาก LIFF/LINE Login พร้อมอีเมล/รหัสผ่านของบัญชี)
+		grp.POST("/:id/line/login", func(c *gin.Context) {
+			var payload struct {
+				IDToken  string `json:"idToken"`
+				Email    string `json:"email"`
+				Password string `json:"password"`
+			}
+			if err := c.ShouldBindJSON(&payload); err != nil || payload.IDToken == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "idToken required"})
+				return
+			}
+			lineUserID, err := lineAccountSvc.LinkWithIDToken(c.Request.Context(), c.Param("id"), payload.Email, payload.Password, payload.IDToken)
+			if err != nil {
+				c.JSON(lineAccountErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			if err := richMenuSvc.Relink(c.Request.Context(), c.Param("id")); err != nil {
+				log.Println("Error relinking rich menu:", err)
+			}
+			c.JSON(http.StatusOK, gin.H{"userId": c.Param("id"), "lineUserId": lineUserID})
+		})
+	}
+}
+
+// registerUserPrivacyRoutes คำขอสำเนาข้อมูลและคำขอลบข้อมูล (ลงทะเบียนเมื่อตั้ง PDPA_PSEUDONYM_SALT แล้วเท่านั้น)
+func registerUserPrivacyRoutes(grp *gin.RouterGroup, userSvc *services.UserService, privacySvc *services.PrivacyService, notifSvc *services.NotificationService) {
+	// ขอสำเนาข้อมูลส่วนบุคคล (PDPA): ส่งลิงก์ดาวน์โหลดไปที่อีเมลของบัญชี (ยืนยันตัวตนแบบเดียวกับการตั้งรหัสผ่านใหม่)
+	grp.POST("/:id/export", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID := c.Param("id")
+		user, err := userSvc.GetByID(ctx, userID)
+		if err == nil {
+			var token string
+			if token, err = privacySvc.RequestExport(ctx, userID); err == nil {
+				err = notifSvc.SendAccountEmail(ctx, userID, services.EmailDataExport, services.DataExportEmail{
+					Email:            user.Email,
+					DownloadURL:      os.Getenv("DATA_EXPORT_URL") + "?token=" + url.QueryEscape(token),
+					ExpiresInMinutes: int(services.DataExportTokenTTL.Minutes()),
+				})
+			}
+		}
+		if err != nil {
+			log.Println("Error sending data export link:", err)
+		}
+		// ตอบเหมือนกันเสมอ ไม่บอกว่ามีบัญชี/อีเมลนี้ไหม
+		c.JSON(http.StatusAccepted, gin.H{"status": "sent"})
+	})
+
+	// ดาวน์โหลดสำเนาข้อมูลด้วย token จากอีเมล ?format=zip (ค่าเริ่มต้น) หรือ json
+	grp.POST("/export/download", func(c *gin.Context) {
+		var payload struct {
+			Token string `json:"token"`
+		}
+		if err := c.ShouldBindJSON(&payload); err != nil || payload.Token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+		ctx := c.Request.Context()
+		userID, err := privacySvc.RedeemExportToken(ctx, payload.Token)
+		if errors.Is(err, services.ErrInvalidExportToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		export, err := privacySvc.Export(ctx, userID, "self")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		filename := "export-" + userID + "-" + export.GeneratedAt.Format("20060102")
+		if c.DefaultQuery("format", "zip") == "json" {
+			c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+			c.JSON(http.StatusOK, export)
+			return
+		}
+		var buf bytes.Buffer
+		if err := services.WriteExportZip(&buf, export); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		c.Data(http.StatusOK, "application/zip", buf.Bytes())
+	})
+
+	// ขอลบข้อมูลส่วนบุคคล (รอ admin อนุมัติที่ /admin/privacy/requests)
+	grp.POST("/:id/erasure", func(c *gin.Context) {
+		var payload struct {
+			Reason string `json:"reason"`
+		}
+		_ = c.ShouldBindJSON(&payload)
+		userID := c.Param("id")
+		req, err := privacySvc.RequestErasure(c.Request.Context(), userID, "self", payload.Reason)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"requestId": req.ID, "status": req.Status})
+	})
+}
+
+func lineAccountErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrLineLinkForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, services.ErrLineAlreadyLinked):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrInvalidLineToken), errors.Is(err, services.ErrLineLinkExpired):
+		return http.StatusBadRequest
 	}
+	return http.StatusInternalServerError
 }
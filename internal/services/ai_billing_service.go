@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AIPricing กติกาการคิดเหรียญต่อ AI purpose (document id = purpose เช่น "tarot")
+type AIPricing struct {
+	CoinCost        int64     `firestore:"coinCost" json:"coinCost"`
+	FreeWithPackage bool      `firestore:"freeWithPackage" json:"freeWithPackage"`
+	UpdatedAt       time.Time `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// AICharge ผลการ authorize ก่อนเรียก AI (HoldID ว่าง = ไม่ต้องคิดเหรียญ)
+type AICharge struct {
+	UserID  string `json:"userId"`
+	Purpose string `json:"purpose"`
+	Amount  int64  `json:"amount"`
+	HoldID  string `json:"holdId,omitempty"`
+}
+
+// AISettleJobName job ใน workpool ที่ลองตัด/คืนเหรียญของ AI อีกครั้งเมื่อ Settle ล้มเหลว (payload = aiSettlePayload)
+const AISettleJobName = "settle_ai_charge"
+
+const (
+	aiHoldTTL         = 10 * time.Minute // hold ที่ Settle ไม่สำเร็จภายในเวลานี้ถูกคืนเหรียญอัตโนมัติ
+	aiSettleRetryWait = time.Minute
+)
+
+// coinHolder การ hold/ตัด/คืนเหรียญที่ billing ใช้ (CoinService)
+type coinHolder interface {
+	Hold(ctx context.Context, userID string, amount int64, purpose string, ttl time.Duration) (string, error)
+	Capture(ctx context.Context, holdID string) error
+	Release(ctx context.Context, holdID string) error
+}
+
+// packageChecker ตรวจว่าผู้ใช้มี package ที่ยังไม่หมดอายุ (PackageService)
+type packageChecker interface {
+	CheckUserPackage(ctx context.Context, userID string) (bool, error)
+}
+
+// uniqueJobScheduler ตั้ง job ด้วย id ที่กำหนด (WorkpoolService)
+type uniqueJobScheduler interface {
+	ScheduleUniqueJob(ctx context.Context, jobID, name, payload string, runAt time.Time) error
+}
+
+// aiSettlePayload payload ของ AISettleJobName
+type aiSettlePayload struct {
+	HoldID  string `json:"holdId"`
+	Success bool   `json:"success"`
+}
+
+type AIBillingService struct {
+	pricing  func(ctx context.Context, purpose string) (*AIPricing, error)
+	coins    coinHolder
+	packages packageChecker
+	workpool uniqueJobScheduler
+}
+
+// NewAIBillingService ลงทะเบียน handler ของ AISettleJobName กับ workpool ด้วย
+func NewAIBillingService(coinSvc *CoinService, pkgSvc *PackageService, workpool *WorkpoolService) *AIBillingService {
+	pricingCol := utils.Client.Collection("ai_pricing")
+	s := &AIBillingService{
+		pricing: func(ctx context.Context, purpose string) (*AIPricing, error) {
+			return loadAIPricing(ctx, pricingCol, purpose)
+		},
+		coins:    coinSvc,
+		packages: pkgSvc,
+		workpool: workpool,
+	}
+	RegisterJobHandler(AISettleJobName, s.handleSettleJob)
+	return s
+}
+
+// GetPricing ดึงกติการาคาของ purpose (ไม่มี document = ใช้ฟรี)
+func (s *AIBillingService) GetPricing(ctx context.Context, purpose string) (*AIPricing, error) {
+	return s.pricing(ctx, purpose)
+}
+
+func loadAIPricing(ctx context.Context, pricingCol *firestore.CollectionRef, purpose string) (*AIPricing, error) {
+	snap, err := pricingCol.Doc(purpose).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &AIPricing{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p AIPricing
+	if err := snap.DataTo(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Authorize ตรวจกติกาแล้ว hold เหรียญไว้ก่อนเรียก AI
+func (s *AIBillingService) Authorize(ctx context.Context, userID, purpose string) (*AICharge, error) {
+	charge := &AICharge{UserID: userID, Purpose: purpose}
+	pricing, err := s.GetPricing(ctx, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if pricing.CoinCost <= 0 {
+		return charge, nil
+	}
+	if pricing.FreeWithPackage {
+		active, err := s.packages.CheckUserPackage(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if active {
+			return charge, nil
+		}
+	}
+	holdID, err := s.coins.Hold(ctx, userID, pricing.CoinCost, purpose, aiHoldTTL)
+	if err != nil {
+		return nil, err
+	}
+	charge.Amount = pricing.CoinCost
+	charge.HoldID = holdID
+	return charge, nil
+}
+
+// Settle ตัดเหรียญจริงเมื่อ AI ตอบสำเร็จ หรือคืนเหรียญเมื่อ AI ล้มเหลว
+// ถ้าทำไม่สำเร็จจะตั้ง job ให้ลองใหม่ (hold ที่ค้างจนหมดอายุถูกคืนเหรียญโดย ReleaseExpiredHolds)
+func (s *AIBillingService) Settle(ctx context.Context, charge *AICharge, success bool) error {
+	if charge == nil || charge.HoldID == "" {
+		return nil
+	}
+	err := s.settleHold(ctx, charge.HoldID, success)
+	if err == nil {
+		return nil
+	}
+	log.Printf("Error settling AI charge %s, retrying in background: %v", charge.HoldID, err)
+	payload, _ := json.Marshal(aiSettlePayload{HoldID: charge.HoldID, Success: success})
+	return s.workpool.ScheduleUniqueJob(ctx, "ai_settle_"+charge.HoldID, AISettleJobName, string(payload), time.Now().Add(aiSettleRetryWait))
+}
+
+// settleHold ตัดหรือคืนเหรียญของ hold (hold ที่ถูกจัดการไปแล้วถือว่าสำเร็จ เรียกซ้ำได้)
+func (s *AIBillingService) settleHold(ctx context.Context, holdID string, success bool) error {
+	var err error
+	if success {
+		err = s.coins.Capture(ctx, holdID)
+	} else {
+		err = s.coins.Release(ctx, holdID)
+	}
+	if errors.Is(err, ErrHoldNotActive) {
+		return nil
+	}
+	return err
+}
+
+func (s *AIBillingService) handleSettleJob(ctx context.Context, payload string) error {
+	var p aiSettlePayload
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return err
+	}
+	return s.settleHold(ctx, p.HoldID, p.Success)
+}
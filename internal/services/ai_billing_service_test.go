@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCoinHolder struct {
+	holdErr   error
+	settleErr error
+	holds     []int64
+	holdTTL   time.Duration
+	captured  []string
+	released  []string
+}
+
+func (f *fakeCoinHolder) Hold(_ context.Context, _ string, amount int64, _ string, ttl time.Duration) (string, error) {
+	if f.holdErr != nil {
+		return "", f.holdErr
+	}
+	f.holds = append(f.holds, amount)
+	f.holdTTL = ttl
+	return "hold1", nil
+}
+
+func (f *fakeCoinHolder) Capture(_ context.Context, holdID string) error {
+	if f.settleErr != nil {
+		return f.settleErr
+	}
+	f.captured = append(f.captured, holdID)
+	return nil
+}
+
+func (f *fakeCoinHolder) Release(_ context.Context, holdID string) error {
+	if f.settleErr != nil {
+		return f.settleErr
+	}
+	f.released = append(f.released, holdID)
+	return nil
+}
+
+type fakePackageChecker bool
+
+func (f fakePackageChecker) CheckUserPackage(context.Context, string) (bool, error) {
+	return bool(f), nil
+}
+
+type fakeJobScheduler struct {
+	jobID, name, payload string
+}
+
+func (f *fakeJobScheduler) ScheduleUniqueJob(_ context.Context, jobID, name, payload string, _ time.Time) error {
+	f.jobID, f.name, f.payload = jobID, name, payload
+	return nil
+}
+
+func newTestBilling(pricing map[string]AIPricing, coins *fakeCoinHolder, hasPackage bool, jobs *fakeJobScheduler) *AIBillingService {
+	return &AIBillingService{
+		pricing: func(_ context.Context, purpose string) (*AIPricing, error) {
+			p := pricing[purpose]
+			return &p, nil
+		},
+		coins:    coins,
+		packages: fakePackageChecker(hasPackage),
+		workpool: jobs,
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	pricing := map[string]AIPricing{
+		"tarot": {CoinCost: 30},
+		"chat":  {CoinCost: 5, FreeWithPackage: true},
+	}
+	ctx := context.Background()
+
+	coins := &fakeCoinHolder{}
+	charge, err := newTestBilling(pricing, coins, false, nil).Authorize(ctx, "u1", "tarot")
+	assert.NoError(t, err)
+	assert.Equal(t, &AICharge{UserID: "u1", Purpose: "tarot", Amount: 30, HoldID: "hold1"}, charge)
+	assert.Equal(t, []int64{30}, coins.holds)
+	assert.Equal(t, aiHoldTTL, coins.holdTTL, "holds must expire so an unsettled reading is refunded")
+
+	// ไม่มีราคา = ฟรี, package ที่ active ครอบคลุม purpose ที่ FreeWithPackage
+	for _, tc := range []struct {
+		purpose    string
+		hasPackage bool
+	}{{"rag", false}, {"chat", true}} {
+		coins := &fakeCoinHolder{}
+		charge, err := newTestBilling(pricing, coins, tc.hasPackage, nil).Authorize(ctx, "u1", tc.purpose)
+		assert.NoError(t, err, tc.purpose)
+		assert.Empty(t, charge.HoldID, tc.purpose)
+		assert.Empty(t, coins.holds, tc.purpose)
+	}
+
+	coins = &fakeCoinHolder{}
+	charge, err = newTestBilling(pricing, coins, false, nil).Authorize(ctx, "u1", "chat")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), charge.Amount)
+
+	_, err = newTestBilling(pricing, &fakeCoinHolder{holdErr: ErrInsufficientBalance}, false, nil).Authorize(ctx, "u1", "tarot")
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+}
+
+func TestSettle(t *testing.T) {
+	ctx := context.Background()
+	charge := &AICharge{UserID: "u1", Purpose: "tarot", Amount: 30, HoldID: "hold1"}
+
+	coins := &fakeCoinHolder{}
+	billing := newTestBilling(nil, coins, false, nil)
+	assert.NoError(t, billing.Settle(ctx, charge, true))
+	assert.NoError(t, billing.Settle(ctx, charge, false))
+	assert.Equal(t, []string{"hold1"}, coins.captured)
+	assert.Equal(t, []string{"hold1"}, coins.released)
+
+	// ไม่มี hold = ไม่ต้องทำอะไร, hold ที่ถูกจัดการไปแล้วไม่ถือเป็น error
+	assert.NoError(t, billing.Settle(ctx, &AICharge{UserID: "u1"}, true))
+	assert.NoError(t, billing.Settle(ctx, nil, false))
+	assert.NoError(t, newTestBilling(nil, &fakeCoinHolder{settleErr: ErrHoldNotActive}, false, nil).Settle(ctx, charge, true))
+}
+
+func TestSettleFailureSchedulesRetry(t *testing.T) {
+	ctx := context.Background()
+	coins := &fakeCoinHolder{settleErr: errors.New("unavailable")}
+	jobs := &fakeJobScheduler{}
+	billing := newTestBilling(nil, coins, false, jobs)
+
+	assert.NoError(t, billing.Settle(ctx, &AICharge{HoldID: "hold1"}, false))
+	assert.Equal(t, "ai_settle_hold1", jobs.jobID)
+	assert.Equal(t, AISettleJobName, jobs.name)
+	var p aiSettlePayload
+	assert.NoError(t, json.Unmarshal([]byte(jobs.payload), &p))
+	assert.Equal(t, aiSettlePayload{HoldID: "hold1", Success: false}, p)
+
+	// job ลองใหม่ด้วยผลเดิม
+	assert.Error(t, billing.handleSettleJob(ctx, jobs.payload))
+	coins.settleErr = nil
+	assert.NoError(t, billing.handleSettleJob(ctx, jobs.payload))
+	assert.Equal(t, []string{"hold1"}, coins.released)
+	assert.Empty(t, coins.captured)
+}
@@ -18,8 +18,11 @@ type AIInterpretRequest struct {
 	Message        string `json:"message"`
 }
 type AIInterpretResponse struct {
-	Intent     string  `json:"intent"`
-	Confidence float64 `json:"confidence"`
+	Intent     string   `json:"intent"`
+	Confidence float64  `json:"confidence"`
+	Emotion    string   `json:"emotion"`   // เช่น "anxious", "hopeful", "sad"
+	Sentiment  float64  `json:"sentiment"` // -1 (ลบ) ถึง 1 (บวก)
+	Topics     []string `json:"topics"`    // เช่น "love", "career"
 }
 
 type AISummarizeRequest struct {
@@ -35,27 +38,61 @@ type AIChatRequest struct {
 	ConversationID string `json:"conversationId"`
 	Message        string `json:"message"`
 	Model          string `json:"model"`
+	Purpose        string `json:"purpose"` // ใช้คิดราคา เช่น "chat", "tarot" (ค่าเริ่มต้น "chat")
+	DeckID         string `json:"deckId,omitempty"`
+	DrawID         string `json:"drawId,omitempty"` // อ้างอิงผล POST /tarot/draw ใน conversation เดียวกัน
+
+	// บริบทบทสนทนา (เติมโดย ChatService)
+	History      []ChatTurn `json:"history,omitempty"`
+	PriorSummary string     `json:"priorSummary,omitempty"` // สรุปของข้อความที่เก่ากว่า History
+
+	// ความรู้ที่ค้นมาจาก RAG index (ให้ AI อ้างอิงด้วย ID)
+	Context []Citation `json:"context,omitempty"`
+
+	// ไพ่ที่เปิดจริง (เติมจาก DrawID) เพื่อให้ AI ตีความจากไพ่ชุดเดียวกันทุกครั้ง
+	Draw *TarotDraw `json:"draw,omitempty"`
+
+	// prompt ที่ render จาก prompt_templates แล้ว (ว่าง = ให้ ai-service ใช้ configs เอง)
+	SystemPrompt  string `json:"systemPrompt,omitempty"`
+	PromptVersion int    `json:"promptVersion,omitempty"`
+	ExperimentID  string `json:"experimentId,omitempty"`
+	Variant       string `json:"variant,omitempty"`
+
+	// ค่าของ variant (0 = ให้ ai-service ใช้ configs เอง)
+	Temperature float64 `json:"temperature,omitempty"`
+	MaxTokens   int     `json:"maxTokens,omitempty"`
 }
 type AIChatResponse struct {
 	Response        string  `json:"response"`
 	ModelUsed       string  `json:"modelUsed"`
 	ConfidenceScore float64 `json:"confidenceScore"`
 	Summary         string  `json:"summary"`
+	ConversationID  string  `json:"conversationId,omitempty"`
+
+	Citations []Citation `json:"citations,omitempty"`
+	Moderated bool       `json:"moderated,omitempty"` // ข้อความถูกปรับหรือแทนที่โดย moderation
+	MessageID string     `json:"messageId,omitempty"` // ใช้อ้างอิงตอนให้คะแนนผ่าน /ai/feedback
 }
 
-type AIServiceClient struct {
-	baseURL    string
-	httpClient *http.Client
+type AIModerateRequest struct {
+	Text      string `json:"text"`
+	Direction string `json:"direction"` // "input" หรือ "output"
+}
+type AIModerateResponse struct {
+	Categories map[string]float64 `json:"categories"` // category → คะแนน 0..1
 }
 
-func AISummarize(ctx context.Context, text string) (string, error) {
-	// ดัดแปลงตามที่ใช้จริง ถ้าใช้ AIServiceClient ก็เรียกผ่านมัน
-	return "summary result", nil
+type AIEmbedRequest struct {
+	Model string   `json:"model,omitempty"`
+	Texts []string `json:"texts"`
+}
+type AIEmbedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
 }
 
-func AIInterpret(ctx context.Context, text string) (string, float64, error) {
-	// return intent, confidence
-	return "general", 0.95, nil
+type AIServiceClient struct {
+	baseURL    string
+	httpClient *http.Client
 }
 
 func NewAIServiceClient(baseURL string) *AIServiceClient {
@@ -128,6 +165,48 @@ func (c *AIServiceClient) Chat(ctx context.Context, req AIChatRequest) (*AIChatR
 	return &aiResp, nil
 }
 
+// Moderate
+func (c *AIServiceClient) Moderate(ctx context.Context, req AIModerateRequest) (*AIModerateResponse, error) {
+	url := fmt.Sprintf("%s/moderate", c.baseURL)
+	data, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("ai-service moderate status %d", resp.StatusCode)
+	}
+	var aiResp AIModerateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&aiResp); err != nil {
+		return nil, err
+	}
+	return &aiResp, nil
+}
+
+// Embed
+func (c *AIServiceClient) Embed(ctx context.Context, req AIEmbedRequest) (*AIEmbedResponse, error) {
+	url := fmt.Sprintf("%s/embed", c.baseURL)
+	data, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("ai-service embed status %d", resp.StatusCode)
+	}
+	var aiResp AIEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&aiResp); err != nil {
+		return nil, err
+	}
+	return &aiResp, nil
+}
+
 // TunePrompt
 func (c *AIServiceClient) TunePrompt(ctx context.Context, tuneID, model, candidatePrompt, testQuestion string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("%s/tune_prompt", c.baseURL)
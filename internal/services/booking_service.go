@@ -0,0 +1,842 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BookingSettleJobName job ใน workpool ที่ตัด/คืนเหรียญของการจองเมื่อถึงเวลาจบนัด (payload = bookingId)
+const BookingSettleJobName = "settle_booking"
+
+// สถานะของการจอง
+const (
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+	BookingCompleted = "completed" // ถึงเวลาจบนัดแล้ว ตัดเหรียญเรียบร้อย
+)
+
+// สถานะของ slot ใน collection "booking_slots"
+const (
+	slotHeld   = "held"
+	slotBooked = "booked"
+)
+
+// กติกาการจอง (เวลาทั้งหมดคิดตามเวลาไทย Asia/Bangkok)
+const (
+	bookingHoldTTL          = 10 * time.Minute // เวลาที่ slot ถูกกันไว้ให้ผู้ใช้กดยืนยัน
+	bookingMinLead          = time.Hour        // จองได้เฉพาะ slot ที่เริ่มหลังจากนี้อย่างน้อย 1 ชั่วโมง
+	bookingMaxAdvanceDays   = bookingFlowDays  // จองล่วงหน้าได้ไม่เกินช่วงเดียวกับปฏิทินใน LINE
+	bookingFreeCancelBefore = 24 * time.Hour   // ยกเลิกก่อนเวลานัดอย่างน้อยเท่านี้ได้เหรียญคืนเต็ม
+	bookingRescheduleBefore = 24 * time.Hour   // เลื่อนนัดได้ถ้าเหลือเวลาก่อนนัดอย่างน้อยเท่านี้
+	bookingSettleGrace      = 24 * time.Hour   // เหรียญที่ hold ไว้ถูกคืนอัตโนมัติถ้ายังไม่ถูกตัดหลังจบนัดเท่านี้
+	bookingMaxReschedules   = 2
+	defaultSlotMinutes      = 60
+	minSlotMinutes          = 15
+	maxSlotMinutes          = 240
+)
+
+var (
+	ErrSeerNotFound          = errors.New("seer not found")
+	ErrInvalidAvailability   = errors.New("invalid availability")
+	ErrBookingNotFound       = errors.New("booking not found")
+	ErrBookingNotCancellable = errors.New("booking can no longer be cancelled")
+	ErrRescheduleNotAllowed  = errors.New("booking can no longer be rescheduled")
+)
+
+// BookingZone เขตเวลาของตารางหมอดูและ slot (Asia/Bangkok ไม่มี daylight saving จึงใช้ offset คงที่)
+var BookingZone = lineDisplayZone
+
+// bookingWeekdays key ของ Seer.Weekly
+var bookingWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// TimeRange ช่วงเวลา "HH:MM"-"HH:MM" ภายในวันเดียว (End ไม่รวม)
+type TimeRange struct {
+	Start string `firestore:"start" json:"start"`
+	End   string `firestore:"end" json:"end"`
+}
+
+// AvailabilityException วันที่เปิด/ปิดต่างจากตารางประจำสัปดาห์
+// Closed = หยุดทั้งวัน ไม่งั้นใช้ Ranges แทนตารางของวันนั้น
+type AvailabilityException struct {
+	Date   string      `firestore:"date" json:"date"` // "2006-01-02"
+	Closed bool        `firestore:"closed" json:"closed"`
+	Ranges []TimeRange `firestore:"ranges" json:"ranges"`
+}
+
+// Seer หมอดูที่เปิดรับจอง (collection "seers", document id = seerId)
+// Weekly key = "sun".."sat" เวลาทั้งหมดเป็นเวลาไทย
+type Seer struct {
+	ID          string                  `firestore:"-" json:"id"`
+	Name        string                  `firestore:"name" json:"name"`
+	Active      bool                    `firestore:"active" json:"active"`
+	CoinCost    int64                   `firestore:"coinCost" json:"coinCost"`
+	SlotMinutes int                     `firestore:"slotMinutes" json:"slotMinutes"`
+	Weekly      map[string][]TimeRange  `firestore:"weekly" json:"weekly"`
+	Exceptions  []AvailabilityException `firestore:"exceptions" json:"exceptions"`
+	UpdatedAt   time.Time               `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// Booking การจองคิวหมอดู (collection "bookings")
+// CoinHoldID เหรียญถูก hold ไว้จนจบนัด ยกเลิกทันเวลาจะ Release คืน ไม่งั้น Capture
+type Booking struct {
+	ID          string    `firestore:"-" json:"id"`
+	UserID      string    `firestore:"userId" json:"userId"`
+	SeerID      string    `firestore:"seerId" json:"seerId"`
+	SeerName    string    `firestore:"seerName" json:"seerName"`
+	Topic       string    `firestore:"topic" json:"topic"`
+	StartAt     time.Time `firestore:"startAt" json:"startAt"`
+	EndAt       time.Time `firestore:"endAt" json:"endAt"`
+	CoinCost    int64     `firestore:"coinCost" json:"coinCost"`
+	CoinHoldID  string    `firestore:"coinHoldId" json:"-"`
+	Status      string    `firestore:"status" json:"status"`
+	Refunded    bool      `firestore:"refunded" json:"refunded"`
+	Reschedules int       `firestore:"reschedules" json:"reschedules"`
+	CancelledAt time.Time `firestore:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	CreatedAt   time.Time `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// LineBooking ข้อมูลที่ใช้แสดงใน LINE และอีเมลยืนยัน
+func (b *Booking) LineBooking() LineBooking {
+	return LineBooking{ID: b.ID, SeerName: b.SeerName, Topic: b.Topic, StartAt: b.StartAt, CoinCost: b.CoinCost}
+}
+
+// SlotHold slot ที่ถูกกันไว้รอยืนยัน (ID ใช้เป็น holdId ตอน Confirm)
+type SlotHold struct {
+	ID        string    `json:"holdId"`
+	SeerID    string    `json:"seerId"`
+	StartAt   time.Time `json:"startAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// bookingSlot ตัวล็อก slot (document id = slotKey) ทำให้เวลาเดียวกันของหมอดูคนเดียวถูกจองได้ครั้งเดียว
+// hold ที่หมดอายุถือว่าว่าง expireAt ใช้กับ Firestore TTL ได้ (slot ที่จองแล้วไม่มี expireAt)
+type bookingSlot struct {
+	SeerID    string    `firestore:"seerId"`
+	StartAt   time.Time `firestore:"startAt"`
+	EndAt     time.Time `firestore:"endAt"`
+	UserID    string    `firestore:"userId"`
+	Status    string    `firestore:"status"`
+	BookingID string    `firestore:"bookingId,omitempty"`
+	ExpireAt  time.Time `firestore:"expireAt,omitempty"`
+	CreatedAt time.Time `firestore:"createdAt"`
+}
+
+// taken slot ถูกจองแล้ว หรือยังถูก hold อยู่
+func (s *bookingSlot) taken(now time.Time) bool {
+	return s.Status == slotBooked || (s.Status == slotHeld && now.Before(s.ExpireAt))
+}
+
+// end เวลาจบของ slot (ล็อกที่ไม่มี endAt ถือว่ายาว defaultSlotMinutes)
+func (s *bookingSlot) end() time.Time {
+	if s.EndAt.IsZero() {
+		return s.StartAt.Add(defaultSlotMinutes * time.Minute)
+	}
+	return s.EndAt
+}
+
+// overlaps ช่วง [aStart, aEnd) กับ [bStart, bEnd) ทับกัน
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+// slotKey document id ของตัวล็อก slot
+func slotKey(seerID string, start time.Time) string {
+	return seerID + "_" + start.UTC().Format("20060102T1504")
+}
+
+type BookingService struct {
+	client     *firestore.Client
+	seerCol    *firestore.CollectionRef
+	slotCol    *firestore.CollectionRef
+	bookingCol *firestore.CollectionRef
+	coins      *CoinService
+	notif      *NotificationService
+	workpool   *WorkpoolService
+}
+
+// NewBookingService ลงทะเบียน handler ของ BookingSettleJobName (notif = nil ไม่ส่งอีเมลยืนยัน)
+func NewBookingService(coins *CoinService, notif *NotificationService, workpool *WorkpoolService) *BookingService {
+	s := &BookingService{
+		client:     utils.Client,
+		seerCol:    utils.Client.Collection("seers"),
+		slotCol:    utils.Client.Collection("booking_slots"),
+		bookingCol: utils.Client.Collection("bookings"),
+		coins:      coins,
+		notif:      notif,
+		workpool:   workpool,
+	}
+	RegisterJobHandler(BookingSettleJobName, func(ctx context.Context, id string) error {
+		b, err := s.Get(ctx, id)
+		if errors.Is(err, ErrBookingNotFound) {
+			log.Printf("Skip settling booking %s: %v", id, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return s.settle(ctx, b)
+	})
+	return s
+}
+
+// --- หมอดูและตารางเวลา ---
+
+// GetSeer ข้อมูลหมอดูพร้อมตารางเวลา
+func (s *BookingService) GetSeer(ctx context.Context, seerID string) (*Seer, error) {
+	snap, err := s.seerCol.Doc(seerID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrSeerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var seer Seer
+	if err := snap.DataTo(&seer); err != nil {
+		return nil, err
+	}
+	seer.ID = snap.Ref.ID
+	if seer.SlotMinutes <= 0 {
+		seer.SlotMinutes = defaultSlotMinutes
+	}
+	return &seer, nil
+}
+
+// ActiveSeers หมอดูที่เปิดรับจอง เรียงตามชื่อ
+func (s *BookingService) ActiveSeers(ctx context.Context) ([]Seer, error) {
+	docs, err := s.seerCol.Where("active", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	seers := make([]Seer, 0, len(docs))
+	for _, doc := range docs {
+		var seer Seer
+		if err := doc.DataTo(&seer); err != nil {
+			continue
+		}
+		seer.ID = doc.Ref.ID
+		seers = append(seers, seer)
+	}
+	sort.Slice(seers, func(i, j int) bool { return seers[i].Name < seers[j].Name })
+	return seers, nil
+}
+
+// SetSeer ตั้งข้อมูลและตารางเวลาของหมอดู (แทนที่ทั้งหมด) การจองที่มีอยู่แล้วไม่ถูกกระทบ
+func (s *BookingService) SetSeer(ctx context.Context, seerID string, seer Seer) (*Seer, error) {
+	if seer.SlotMinutes == 0 {
+		seer.SlotMinutes = defaultSlotMinutes
+	}
+	if err := validateSeer(seer); err != nil {
+		return nil, err
+	}
+	seer.ID = seerID
+	seer.UpdatedAt = time.Now()
+	if _, err := s.seerCol.Doc(seerID).Set(ctx, seer); err != nil {
+		return nil, err
+	}
+	return &seer, nil
+}
+
+func validateSeer(seer Seer) error {
+	if seer.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidAvailability)
+	}
+	if seer.CoinCost < 0 {
+		return fmt.Errorf("%w: coinCost must not be negative", ErrInvalidAvailability)
+	}
+	if seer.SlotMinutes < minSlotMinutes || seer.SlotMinutes > maxSlotMinutes {
+		return fmt.Errorf("%w: slotMinutes must be between %d and %d", ErrInvalidAvailability, minSlotMinutes, maxSlotMinutes)
+	}
+	for day, ranges := range seer.Weekly {
+		if !slices.Contains(bookingWeekdays, day) {
+			return fmt.Errorf("%w: unknown weekday %q", ErrInvalidAvailability, day)
+		}
+		if err := validateRanges(ranges); err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{}
+	for _, ex := range seer.Exceptions {
+		if _, err := time.Parse("2006-01-02", ex.Date); err != nil {
+			return fmt.Errorf("%w: exception date must be YYYY-MM-DD", ErrInvalidAvailability)
+		}
+		if seen[ex.Date] {
+			return fmt.Errorf("%w: duplicate exception %s", ErrInvalidAvailability, ex.Date)
+		}
+		seen[ex.Date] = true
+		if err := validateRanges(ex.Ranges); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRanges ช่วงเวลาในวันเดียวกันต้องไม่ทับกัน ไม่งั้น slot จากสองช่วงจะเหลื่อมกันและถูกจองซ้อนได้
+func validateRanges(ranges []TimeRange) error {
+	type span struct{ start, end time.Duration }
+	spans := make([]span, 0, len(ranges))
+	for _, r := range ranges {
+		start, err1 := parseClock(r.Start)
+		end, err2 := parseClock(r.End)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("%w: time must be HH:MM", ErrInvalidAvailability)
+		}
+		if start >= end {
+			return fmt.Errorf("%w: %s-%s ends before it starts", ErrInvalidAvailability, r.Start, r.End)
+		}
+		spans = append(spans, span{start, end})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			return fmt.Errorf("%w: time ranges overlap", ErrInvalidAvailability)
+		}
+	}
+	return nil
+}
+
+// rangesOn ช่วงเวลาทำงานของวันนั้น (exception มาก่อนตารางประจำสัปดาห์)
+func (seer *Seer) rangesOn(day time.Time) []TimeRange {
+	date := day.Format("2006-01-02")
+	for _, ex := range seer.Exceptions {
+		if ex.Date == date {
+			if ex.Closed {
+				return nil
+			}
+			return ex.Ranges
+		}
+	}
+	return seer.Weekly[bookingWeekdays[day.Weekday()]]
+}
+
+// slotStarts เวลาเริ่มของทุก slot ในวัน day (ตามเวลาไทย) ที่ยังจองได้ ณ now ไม่สนว่าถูกจองไปหรือยัง
+func (seer *Seer) slotStarts(day, now time.Time) []time.Time {
+	local := day.In(BookingZone)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, BookingZone)
+	length := time.Duration(seer.SlotMinutes) * time.Minute
+	if length <= 0 {
+		length = defaultSlotMinutes * time.Minute
+	}
+	earliest := now.Add(bookingMinLead)
+	latest := now.AddDate(0, 0, bookingMaxAdvanceDays)
+	var starts []time.Time
+	for _, r := range seer.rangesOn(midnight) {
+		from, err1 := parseClock(r.Start)
+		to, err2 := parseClock(r.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		for t := from; t+length <= to; t += length {
+			start := midnight.Add(t)
+			if start.Before(earliest) || start.After(latest) {
+				continue
+			}
+			starts = append(starts, start)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	return slices.CompactFunc(starts, time.Time.Equal)
+}
+
+// offers slot ที่เริ่มเวลา start อยู่ในตารางของหมอดูและยังจองได้
+func (seer *Seer) offers(start, now time.Time) bool {
+	return slices.ContainsFunc(seer.slotStarts(start, now), start.Equal)
+}
+
+// OpenSlots slot ที่ยังว่างของหมอดูในวัน day (ตามเวลาไทย)
+func (s *BookingService) OpenSlots(ctx context.Context, seerID string, day time.Time) ([]time.Time, error) {
+	seer, err := s.GetSeer(ctx, seerID)
+	if err != nil {
+		return nil, err
+	}
+	if !seer.Active {
+		return nil, ErrSeerNotFound
+	}
+	now := time.Now()
+	starts := seer.slotStarts(day, now)
+	if len(starts) == 0 {
+		return starts, nil
+	}
+	length := time.Duration(seer.SlotMinutes) * time.Minute
+	docs, err := s.overlapQuery(seerID, starts[0], starts[len(starts)-1].Add(length)).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var taken []bookingSlot
+	for _, doc := range docs {
+		var slot bookingSlot
+		if err := doc.DataTo(&slot); err == nil && slot.taken(now) {
+			taken = append(taken, slot)
+		}
+	}
+	return freeStarts(starts, length, taken), nil
+}
+
+// freeStarts slot ที่ไม่ทับกับ slot ที่ถูกจอง/hold อยู่ (เทียบทั้งช่วงเวลา ไม่ใช่แค่เวลาเริ่ม)
+func freeStarts(starts []time.Time, length time.Duration, taken []bookingSlot) []time.Time {
+	open := make([]time.Time, 0, len(starts))
+	for _, start := range starts {
+		free := true
+		for i := range taken {
+			if overlaps(start, start.Add(length), taken[i].StartAt, taken[i].end()) {
+				free = false
+				break
+			}
+		}
+		if free {
+			open = append(open, start)
+		}
+	}
+	return open
+}
+
+// overlapQuery ตัวล็อกของหมอดูที่อาจทับช่วง [from, to) (slot ยาวได้ไม่เกิน maxSlotMinutes)
+func (s *BookingService) overlapQuery(seerID string, from, to time.Time) firestore.Query {
+	return s.slotCol.Where("seerId", "==", seerID).
+		Where("startAt", ">", from.Add(-maxSlotMinutes*time.Minute)).
+		Where("startAt", "<", to)
+}
+
+// --- hold และยืนยันการจอง ---
+
+// Hold กัน slot ไว้ให้ผู้ใช้ bookingHoldTTL (ผู้ใช้เดิม hold ซ้ำได้ จะต่อเวลาให้)
+func (s *BookingService) Hold(ctx context.Context, userID, seerID string, start time.Time) (*SlotHold, error) {
+	seer, err := s.GetSeer(ctx, seerID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !seer.Active || !seer.offers(start, now) {
+		return nil, ErrSlotUnavailable
+	}
+	key := slotKey(seerID, start)
+	ref := s.slotCol.Doc(key)
+	end := start.Add(time.Duration(seer.SlotMinutes) * time.Minute)
+	expires := now.Add(bookingHoldTTL)
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(s.overlapQuery(seerID, start, end)).GetAll()
+		if err != nil {
+			return err
+		}
+		// hold เก่าของผู้ใช้เองที่ทับช่วงนี้ถูกปล่อย (เปลี่ยนใจเลือกเวลาใหม่) ที่เหลือถ้ายังถูกใช้อยู่ = ไม่ว่าง
+		var release []*firestore.DocumentRef
+		for _, doc := range docs {
+			var slot bookingSlot
+			if err := doc.DataTo(&slot); err != nil {
+				return err
+			}
+			if !slot.taken(now) || !overlaps(start, end, slot.StartAt, slot.end()) {
+				continue
+			}
+			if !heldBy(&slot, userID, now) {
+				return ErrSlotUnavailable
+			}
+			if doc.Ref.ID != key {
+				release = append(release, doc.Ref)
+			}
+		}
+		for _, r := range release {
+			if err := tx.Delete(r); err != nil {
+				return err
+			}
+		}
+		return tx.Set(ref, bookingSlot{
+			SeerID:    seerID,
+			StartAt:   start,
+			EndAt:     end,
+			UserID:    userID,
+			Status:    slotHeld,
+			ExpireAt:  expires,
+			CreatedAt: now,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SlotHold{ID: key, SeerID: seerID, StartAt: start, ExpiresAt: expires}, nil
+}
+
+// Confirm เปลี่ยน hold เป็นการจอง: กันเหรียญตามราคาหมอดู แล้วสร้าง booking กับล็อก slot ใน transaction เดียว
+// hold ที่หมดอายุหรือเป็นของคนอื่นคืน ErrSlotUnavailable (เหรียญที่กันไว้จะถูกคืน)
+func (s *BookingService) Confirm(ctx context.Context, userID, holdID, topic string) (*Booking, error) {
+	if holdID == "" {
+		return nil, ErrSlotUnavailable
+	}
+	ref := s.slotCol.Doc(holdID)
+	snap, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrSlotUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	var slot bookingSlot
+	if err := snap.DataTo(&slot); err != nil {
+		return nil, err
+	}
+	if !heldBy(&slot, userID, time.Now()) {
+		return nil, ErrSlotUnavailable
+	}
+	seer, err := s.GetSeer(ctx, slot.SeerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var coinHoldID string
+	if seer.CoinCost > 0 {
+		// hold สั้นๆ ก่อน ถ้าการจองไม่สำเร็จแล้วคืนเหรียญไม่ได้ เหรียญจะถูกคืนเองเมื่อหมดอายุ
+		if coinHoldID, err = s.coins.Hold(ctx, userID, seer.CoinCost, "booking", bookingHoldTTL); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	bookingRef := s.bookingCol.NewDoc()
+	b := Booking{
+		ID:         bookingRef.ID,
+		UserID:     userID,
+		SeerID:     seer.ID,
+		SeerName:   seer.Name,
+		Topic:      topic,
+		StartAt:    slot.StartAt,
+		EndAt:      slot.end(),
+		CoinCost:   seer.CoinCost,
+		CoinHoldID: coinHoldID,
+		Status:     BookingConfirmed,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrSlotUnavailable
+		}
+		if err != nil {
+			return err
+		}
+		var current bookingSlot
+		if err := snap.DataTo(&current); err != nil {
+			return err
+		}
+		if !heldBy(&current, userID, time.Now()) {
+			return ErrSlotUnavailable
+		}
+		if err := tx.Create(bookingRef, b); err != nil {
+			return err
+		}
+		if coinHoldID != "" {
+			if err := s.coins.extendHold(tx, coinHoldID, b.EndAt.Add(bookingSettleGrace)); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: slotBooked},
+			{Path: "bookingId", Value: b.ID},
+			{Path: "expireAt", Value: firestore.Delete},
+		})
+	})
+	if err != nil {
+		if coinHoldID != "" {
+			if rerr := s.coins.Release(ctx, coinHoldID); rerr != nil {
+				log.Printf("Error releasing coins of failed booking hold %s: %v", coinHoldID, rerr)
+			}
+		}
+		return nil, err
+	}
+
+	s.scheduleSettle(ctx, &b)
+	if s.notif != nil {
+		err := s.notif.SendEmail(ctx, CategoryBooking, userID, EmailBookingConfirmation, b.LineBooking())
+		if err != nil && !errors.Is(err, ErrNotificationSuppressed) {
+			log.Printf("Error sending booking confirmation %s: %v", b.ID, err)
+		}
+	}
+	return &b, nil
+}
+
+func heldBy(slot *bookingSlot, userID string, now time.Time) bool {
+	return slot.Status == slotHeld && slot.UserID == userID && now.Before(slot.ExpireAt)
+}
+
+// --- ดู ยกเลิก และเลื่อนการจอง ---
+
+// Get การจองตาม id
+func (s *BookingService) Get(ctx context.Context, bookingID string) (*Booking, error) {
+	snap, err := s.bookingCol.Doc(bookingID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrBookingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b Booking
+	if err := snap.DataTo(&b); err != nil {
+		return nil, err
+	}
+	b.ID = snap.Ref.ID
+	return &b, nil
+}
+
+// ListByUser การจองของผู้ใช้ เรียงจากนัดล่าสุด
+func (s *BookingService) ListByUser(ctx context.Context, userID string) ([]Booking, error) {
+	docs, err := s.bookingCol.Where("userId", "==", userID).OrderBy("startAt", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Booking, 0, len(docs))
+	for _, doc := range docs {
+		var b Booking
+		if err := doc.DataTo(&b); err != nil {
+			continue
+		}
+		b.ID = doc.Ref.ID
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// cancelRefund ยกเลิกก่อนนัดอย่างน้อย bookingFreeCancelBefore ได้เหรียญคืนเต็ม
+func cancelRefund(start, now time.Time) bool {
+	return start.Sub(now) >= bookingFreeCancelBefore
+}
+
+// Cancel ยกเลิกการจองของผู้ใช้ก่อนเวลานัด แล้วเปิด slot ให้คนอื่นจองต่อ
+// เหรียญคืนเต็มเมื่อยกเลิกก่อนนัดอย่างน้อย 24 ชั่วโมง ไม่งั้นไม่คืน (Booking.Refunded)
+func (s *BookingService) Cancel(ctx context.Context, userID, bookingID string) (*Booking, error) {
+	ref := s.bookingCol.Doc(bookingID)
+	var b Booking
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrBookingNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&b); err != nil {
+			return err
+		}
+		b.ID = snap.Ref.ID
+		if b.UserID != userID {
+			return ErrBookingNotFound
+		}
+		now := time.Now()
+		if b.Status != BookingConfirmed || !now.Before(b.StartAt) {
+			return ErrBookingNotCancellable
+		}
+		b.Status = BookingCancelled
+		b.Refunded = cancelRefund(b.StartAt, now)
+		b.CancelledAt = now
+		b.UpdatedAt = now
+		if err := tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: b.Status},
+			{Path: "refunded", Value: b.Refunded},
+			{Path: "cancelledAt", Value: now},
+			{Path: "updatedAt", Value: now},
+		}); err != nil {
+			return err
+		}
+		return tx.Delete(s.slotCol.Doc(slotKey(b.SeerID, b.StartAt)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.settle(ctx, &b); err != nil {
+		// job ตอนจบนัดจะลองคืน/ตัดเหรียญอีกครั้ง
+		log.Printf("Error settling cancelled booking %s: %v", b.ID, err)
+	}
+	return &b, nil
+}
+
+// Reschedule เลื่อนนัดไปเวลาใหม่กับหมอดูคนเดิม ย้ายล็อก slot ใน transaction เดียว
+// ทำได้เมื่อเหลือเวลาก่อนนัดเดิมอย่างน้อย 24 ชั่วโมง และเลื่อนได้ไม่เกิน bookingMaxReschedules ครั้ง
+func (s *BookingService) Reschedule(ctx context.Context, userID, bookingID string, start time.Time) (*Booking, error) {
+	current, err := s.Get(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if current.UserID != userID {
+		return nil, ErrBookingNotFound
+	}
+	seer, err := s.GetSeer(ctx, current.SeerID)
+	if err != nil {
+		return nil, err
+	}
+	if !seer.Active || !seer.offers(start, time.Now()) {
+		return nil, ErrSlotUnavailable
+	}
+
+	ref := s.bookingCol.Doc(bookingID)
+	newSlotRef := s.slotCol.Doc(slotKey(seer.ID, start))
+	length := current.EndAt.Sub(current.StartAt)
+	if length <= 0 {
+		length = time.Duration(seer.SlotMinutes) * time.Minute
+	}
+	var b Booking
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&b); err != nil {
+			return err
+		}
+		b.ID = snap.Ref.ID
+		now := time.Now()
+		if b.Status != BookingConfirmed || b.StartAt.Sub(now) < bookingRescheduleBefore || b.Reschedules >= bookingMaxReschedules {
+			return ErrRescheduleNotAllowed
+		}
+		if b.StartAt.Equal(start) {
+			return nil
+		}
+		docs, err := tx.Documents(s.overlapQuery(b.SeerID, start, start.Add(length))).GetAll()
+		if err != nil {
+			return err
+		}
+		var release []*firestore.DocumentRef
+		for _, doc := range docs {
+			var slot bookingSlot
+			if err := doc.DataTo(&slot); err != nil {
+				return err
+			}
+			// ล็อกของการจองนี้เองจะถูกย้าย และ hold ของผู้ใช้เองใช้ต่อได้
+			if slot.BookingID == b.ID || !slot.taken(now) || !overlaps(start, start.Add(length), slot.StartAt, slot.end()) {
+				continue
+			}
+			if !heldBy(&slot, userID, now) {
+				return ErrSlotUnavailable
+			}
+			if doc.Ref.ID != newSlotRef.ID {
+				release = append(release, doc.Ref)
+			}
+		}
+		for _, r := range release {
+			if err := tx.Delete(r); err != nil {
+				return err
+			}
+		}
+		if err := tx.Set(newSlotRef, bookingSlot{
+			SeerID:    b.SeerID,
+			StartAt:   start,
+			EndAt:     start.Add(length),
+			UserID:    userID,
+			Status:    slotBooked,
+			BookingID: b.ID,
+			CreatedAt: now,
+		}); err != nil {
+			return err
+		}
+		if err := tx.Delete(s.slotCol.Doc(slotKey(b.SeerID, b.StartAt))); err != nil {
+			return err
+		}
+		b.StartAt = start
+		b.EndAt = start.Add(length)
+		b.Reschedules++
+		b.UpdatedAt = now
+		if b.CoinHoldID != "" {
+			if err := s.coins.extendHold(tx, b.CoinHoldID, b.EndAt.Add(bookingSettleGrace)); err != nil {
+				return err
+			}
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "startAt", Value: b.StartAt},
+			{Path: "endAt", Value: b.EndAt},
+			{Path: "reschedules", Value: b.Reschedules},
+			{Path: "updatedAt", Value: now},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.scheduleSettle(ctx, &b)
+	return &b, nil
+}
+
+// --- ตัด/คืนเหรียญ ---
+
+// scheduleSettle ตั้งเวลาตัดเหรียญตอนจบนัด (ตั้งซ้ำจะเลื่อนเวลาเดิม เช่น ตอนเลื่อนนัด)
+func (s *BookingService) scheduleSettle(ctx context.Context, b *Booking) {
+	if s.workpool == nil {
+		return
+	}
+	if err := s.workpool.ScheduleUniqueJob(ctx, "booking_settle_"+b.ID, BookingSettleJobName, b.ID, b.EndAt); err != nil {
+		log.Printf("Error scheduling settlement of booking %s: %v", b.ID, err)
+	}
+}
+
+// settle จัดการเหรียญที่ hold ไว้ตามสถานะการจอง (เรียกซ้ำได้)
+// ยกเลิกแบบคืนเงิน = Release, ยกเลิกช้าหรือจบนัดแล้ว = Capture, ยังไม่ถึงเวลาจบนัด = ไม่ทำอะไร
+func (s *BookingService) settle(ctx context.Context, b *Booking) error {
+	var err error
+	switch {
+	case b.Status == BookingConfirmed:
+		if time.Now().Before(b.EndAt) {
+			return nil // นัดถูกเลื่อน job ใหม่ถูกตั้งไว้แล้ว
+		}
+		if _, err := s.bookingCol.Doc(b.ID).Update(ctx, []firestore.Update{
+			{Path: "status", Value: BookingCompleted},
+			{Path: "updatedAt", Value: time.Now()},
+		}); err != nil {
+			return err
+		}
+		if b.CoinHoldID != "" {
+			err = s.coins.Capture(ctx, b.CoinHoldID)
+		}
+	case b.CoinHoldID == "":
+		return nil
+	case b.Status == BookingCancelled && b.Refunded:
+		err = s.coins.Release(ctx, b.CoinHoldID)
+	default:
+		err = s.coins.Capture(ctx, b.CoinHoldID)
+	}
+	if errors.Is(err, ErrHoldNotActive) {
+		return nil
+	}
+	return err
+}
+
+// --- BookingFlowBackend สำหรับ flow จองคิวใน LINE ---
+
+// ListSeers หมอดูที่เปิดรับจองในรูปแบบที่ flow ใช้
+func (s *BookingService) ListSeers(ctx context.Context) ([]BookingSeer, error) {
+	seers, err := s.ActiveSeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]BookingSeer, len(seers))
+	for i, seer := range seers {
+		out[i] = BookingSeer{ID: seer.ID, Name: seer.Name}
+	}
+	return out, nil
+}
+
+// HoldSlot คืน holdId ของ slot ที่กันไว้ให้ผู้ใช้
+func (s *BookingService) HoldSlot(ctx context.Context, userID, seerID string, start time.Time) (string, error) {
+	hold, err := s.Hold(ctx, userID, seerID, start)
+	if errors.Is(err, ErrSeerNotFound) {
+		return "", ErrSlotUnavailable
+	}
+	if err != nil {
+		return "", err
+	}
+	return hold.ID, nil
+}
+
+// ConfirmHold ยืนยันการจองจาก LINE (ไม่มีหัวข้อ)
+func (s *BookingService) ConfirmHold(ctx context.Context, userID, holdID string) (*LineBooking, error) {
+	b, err := s.Confirm(ctx, userID, holdID, "")
+	if err != nil {
+		return nil, err
+	}
+	lb := b.LineBooking()
+	return &lb, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeerSlotStarts(t *testing.T) {
+	seer := &Seer{
+		SlotMinutes: 60,
+		Weekly: map[string][]TimeRange{
+			"mon": {{Start: "09:00", End: "11:30"}, {Start: "13:00", End: "15:00"}},
+		},
+		Exceptions: []AvailabilityException{
+			{Date: "2026-03-09", Closed: true},
+			{Date: "2026-03-16", Ranges: []TimeRange{{Start: "18:00", End: "19:00"}}},
+		},
+	}
+	at := func(day, hour int) time.Time { return time.Date(2026, 3, day, hour, 0, 0, 0, BookingZone) }
+	now := at(1, 12) // อาทิตย์
+
+	// slot ที่เกินเวลาปิดของช่วง (11:00-12:00) ไม่ถูกนับ
+	assert.Equal(t, []time.Time{at(2, 9), at(2, 10), at(2, 13), at(2, 14)}, seer.slotStarts(at(2, 0), now))
+	assert.Empty(t, seer.slotStarts(at(3, 0), now), "no weekly hours on tuesday")
+	assert.Empty(t, seer.slotStarts(at(9, 0), now), "closed exception")
+	assert.Equal(t, []time.Time{at(16, 18)}, seer.slotStarts(at(16, 0), now), "exception replaces weekly hours")
+
+	// ต้องจองล่วงหน้าอย่างน้อย bookingMinLead
+	assert.Equal(t, []time.Time{at(2, 13), at(2, 14)}, seer.slotStarts(at(2, 0), at(2, 9).Add(30*time.Minute)))
+
+	assert.True(t, seer.offers(at(2, 10), now))
+	assert.False(t, seer.offers(at(2, 10).Add(30*time.Minute), now), "not aligned to the slot grid")
+	assert.False(t, seer.offers(at(2, 11), now))
+}
+
+func TestValidateSeer(t *testing.T) {
+	ok := Seer{Name: "แม่หมอ", SlotMinutes: 30, Weekly: map[string][]TimeRange{"fri": {{Start: "13:00", End: "15:00"}, {Start: "10:00", End: "13:00"}}}}
+	assert.NoError(t, validateSeer(ok))
+
+	for name, seer := range map[string]Seer{
+		"no name":      {SlotMinutes: 30},
+		"short slot":   {Name: "a", SlotMinutes: 5},
+		"weekday":      {Name: "a", SlotMinutes: 30, Weekly: map[string][]TimeRange{"friday": nil}},
+		"clock":        {Name: "a", SlotMinutes: 30, Weekly: map[string][]TimeRange{"fri": {{Start: "9", End: "10:00"}}}},
+		"reversed":     {Name: "a", SlotMinutes: 30, Weekly: map[string][]TimeRange{"fri": {{Start: "12:00", End: "10:00"}}}},
+		"date":         {Name: "a", SlotMinutes: 30, Exceptions: []AvailabilityException{{Date: "09/03/2026", Closed: true}}},
+		"dup date":     {Name: "a", SlotMinutes: 30, Exceptions: []AvailabilityException{{Date: "2026-03-09"}, {Date: "2026-03-09"}}},
+		"negative fee": {Name: "a", SlotMinutes: 30, CoinCost: -1},
+		"overlap": {Name: "a", SlotMinutes: 30, Weekly: map[string][]TimeRange{
+			"fri": {{Start: "10:30", End: "13:00"}, {Start: "09:00", End: "12:00"}},
+		}},
+		"exception overlap": {Name: "a", SlotMinutes: 30, Exceptions: []AvailabilityException{
+			{Date: "2026-03-09", Ranges: []TimeRange{{Start: "09:00", End: "10:00"}, {Start: "09:30", End: "11:00"}}},
+		}},
+	} {
+		assert.ErrorIs(t, validateSeer(seer), ErrInvalidAvailability, name)
+	}
+}
+
+func TestBookingSlotRules(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, BookingZone)
+	start := now.Add(48 * time.Hour)
+	assert.Equal(t, "s1_20260303T0500", slotKey("s1", start))
+
+	held := &bookingSlot{Status: slotHeld, UserID: "u1", ExpireAt: now.Add(time.Minute)}
+	assert.True(t, held.taken(now))
+	assert.True(t, heldBy(held, "u1", now))
+	assert.False(t, heldBy(held, "u2", now))
+	assert.False(t, held.taken(now.Add(2*time.Minute)), "expired hold frees the slot")
+	assert.True(t, (&bookingSlot{Status: slotBooked}).taken(now))
+
+	assert.True(t, cancelRefund(start, now))
+	assert.True(t, cancelRefund(start, start.Add(-24*time.Hour)))
+	assert.False(t, cancelRefund(start, start.Add(-23*time.Hour)))
+}
+
+func TestFreeStartsChecksWholeInterval(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2026, 3, 2, hour, minute, 0, 0, BookingZone) }
+	starts := []time.Time{at(9, 0), at(9, 30), at(10, 0), at(10, 30), at(11, 0)}
+
+	// การจอง 60 นาทีตอน 10:00 (จองไว้ก่อนหมอดูเปลี่ยน slot เป็น 30 นาที) กัน 10:00 และ 10:30
+	taken := []bookingSlot{
+		{StartAt: at(10, 0), EndAt: at(11, 0), Status: slotBooked},
+	}
+	assert.Equal(t, []time.Time{at(9, 0), at(9, 30), at(11, 0)}, freeStarts(starts, 30*time.Minute, taken))
+
+	// slot 60 นาทีตอน 9:30 ทับการจอง 10:00 ด้วย
+	assert.Equal(t, []time.Time{at(9, 0), at(11, 0)}, freeStarts(starts, time.Hour, taken))
+
+	// ตัวล็อกเก่าที่ไม่มี endAt ถือว่ายาว 60 นาที
+	legacy := []bookingSlot{{StartAt: at(9, 0), Status: slotBooked}}
+	assert.Equal(t, []time.Time{at(10, 0), at(10, 30), at(11, 0)}, freeStarts(starts, 30*time.Minute, legacy))
+}
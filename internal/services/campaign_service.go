@@ -0,0 +1,688 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CampaignJobName job ใน workpool ที่ส่ง campaign ตามเวลาที่ตั้งไว้ (payload = campaignId)
+const CampaignJobName = "send_campaign"
+
+// สถานะของ campaign
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignSending   = "sending"
+	CampaignSent      = "sent" // ทุก batch เข้า outbox แล้ว ยอดส่งจริงดูที่ stats
+	CampaignCancelled = "cancelled"
+)
+
+const (
+	lineMulticastMaxRecipients = 500
+	campaignMaxIntents         = 30 // ข้อจำกัดของ query "in" ใน Firestore
+)
+
+var (
+	ErrCampaignNotFound    = errors.New("campaign not found")
+	ErrInvalidCampaign     = errors.New("invalid campaign")
+	ErrCampaignNotEditable = errors.New("campaign already sent or cancelled")
+)
+
+// CampaignAudience เงื่อนไขผู้รับ (ค่าว่าง = ไม่กรอง) ผู้รับต้องเป็นเพื่อนกับ OA และไม่ได้ปิดรับข่าวสาร (category marketing)
+type CampaignAudience struct {
+	Tier             string   `firestore:"tier,omitempty" json:"tier,omitempty"`                         // "free" หรือ "premium"
+	ActiveWithinDays int      `firestore:"activeWithinDays,omitempty" json:"activeWithinDays,omitempty"` // ใช้งานล่าสุดภายใน N วัน
+	InactiveForDays  int      `firestore:"inactiveForDays,omitempty" json:"inactiveForDays,omitempty"`   // ไม่ได้ใช้งานมาอย่างน้อย N วัน
+	Intents          []string `firestore:"intents,omitempty" json:"intents,omitempty"`                   // เคยคุยด้วย intent ใด intent หนึ่ง
+	IntentWithinDays int      `firestore:"intentWithinDays,omitempty" json:"intentWithinDays,omitempty"`
+	MinSpend         int64    `firestore:"minSpend,omitempty" json:"minSpend,omitempty"` // ยอดชำระ (payments ที่ paid) รวม
+	MaxSpend         int64    `firestore:"maxSpend,omitempty" json:"maxSpend,omitempty"` // 0 = ไม่จำกัด
+}
+
+// CampaignStats ยอดของ campaign นับเป็นจำนวนผู้รับ (Delivered/Failed อัปเดตเมื่อ outbox ส่งเสร็จ)
+type CampaignStats struct {
+	Recipients int `firestore:"recipients" json:"recipients"`
+	OptedOut   int `firestore:"optedOut" json:"optedOut"`
+	Deferred   int `firestore:"deferred" json:"deferred"` // อยู่ใน quiet hours ส่งตอนหมดช่วงเงียบของแต่ละคน
+	Batches    int `firestore:"batches" json:"batches"`
+	Delivered  int `firestore:"delivered" json:"delivered"`
+	Failed     int `firestore:"failed" json:"failed"`
+}
+
+// Campaign ข้อความ broadcast ถึงกลุ่มผู้ใช้ LINE (collection "campaigns")
+type Campaign struct {
+	ID           string           `firestore:"-" json:"id"`
+	Name         string           `firestore:"name" json:"name"`
+	Messages     []LineMessage    `firestore:"-" json:"messages"`
+	MessagesBody string           `firestore:"messages" json:"-"`                        // เก็บเป็น JSON เพราะ flex มี array ซ้อน array
+	Locale       string           `firestore:"locale,omitempty" json:"locale,omitempty"` // ภาษาของข้อความ ใช้กับปุ่ม "ไม่รับข่าวสาร" ("" = ภาษาไทย)
+	Audience     CampaignAudience `firestore:"audience" json:"audience"`
+	Status       string           `firestore:"status" json:"status"`
+	ScheduledAt  time.Time        `firestore:"scheduledAt,omitempty" json:"scheduledAt,omitempty"`
+	Stats        CampaignStats    `firestore:"stats" json:"stats"`
+	SentAt       time.Time        `firestore:"sentAt,omitempty" json:"sentAt,omitempty"`
+	BatchesReady bool             `firestore:"batchesReady,omitempty" json:"-"` // คัดผู้รับและบันทึก batch ไว้ที่ campaigns/{id}/batches แล้ว
+	CreatedAt    time.Time        `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time        `firestore:"updatedAt" json:"updatedAt"`
+}
+
+type CampaignService struct {
+	client      *firestore.Client
+	col         *firestore.CollectionRef
+	lineUserCol *firestore.CollectionRef
+	prefs       *NotificationPreferenceService
+	outbox      *OutboxService
+	workpool    *WorkpoolService
+}
+
+// NewCampaignService ลงทะเบียน handler ของ CampaignJobName และตัวนับผลส่งของ multicast
+func NewCampaignService(workpool *WorkpoolService) *CampaignService {
+	s := &CampaignService{
+		client:      utils.Client,
+		col:         utils.Client.Collection("campaigns"),
+		lineUserCol: utils.Client.Collection("line_users"),
+		prefs:       NewNotificationPreferenceService(),
+		outbox:      NewOutboxService(),
+		workpool:    workpool,
+	}
+	RegisterJobHandler(CampaignJobName, func(ctx context.Context, id string) error {
+		_, err := s.Send(ctx, id)
+		if errors.Is(err, ErrCampaignNotEditable) || errors.Is(err, ErrCampaignNotFound) {
+			log.Printf("Skip campaign %s: %v", id, err)
+			return nil
+		}
+		return err
+	})
+	RegisterOutboxResultHandler(ChannelLineMulticast, s.recordResult)
+	return s
+}
+
+// Create บันทึก campaign ถ้ามี ScheduledAt จะตั้งเวลาส่งทันที ไม่งั้นเป็น draft
+func (s *CampaignService) Create(ctx context.Context, c Campaign) (*Campaign, error) {
+	if err := validateCampaign(c); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(c.Messages)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c.MessagesBody = string(body)
+	c.Status = CampaignDraft
+	c.Stats = CampaignStats{}
+	c.SentAt = time.Time{}
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	scheduledAt := c.ScheduledAt
+	c.ScheduledAt = time.Time{}
+	ref := s.col.NewDoc()
+	if _, err := ref.Set(ctx, c); err != nil {
+		return nil, err
+	}
+	c.ID = ref.ID
+	if !scheduledAt.IsZero() {
+		return s.Schedule(ctx, c.ID, scheduledAt)
+	}
+	return &c, nil
+}
+
+func (s *CampaignService) Get(ctx context.Context, id string) (*Campaign, error) {
+	snap, err := s.col.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return campaignFromSnap(snap)
+}
+
+func (s *CampaignService) List(ctx context.Context, limit int) ([]Campaign, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	docs, err := s.col.OrderBy("createdAt", firestore.Desc).Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Campaign, 0, len(docs))
+	for _, doc := range docs {
+		c, err := campaignFromSnap(doc)
+		if err != nil {
+			continue
+		}
+		list = append(list, *c)
+	}
+	return list, nil
+}
+
+// Schedule ตั้ง/เลื่อนเวลาส่ง (เวลาในอดีต = ส่งในรอบถัดไปของ workpool)
+func (s *CampaignService) Schedule(ctx context.Context, id string, at time.Time) (*Campaign, error) {
+	if err := s.setStatus(ctx, id, CampaignScheduled, map[string]interface{}{"scheduledAt": at}); err != nil {
+		return nil, err
+	}
+	if err := s.workpool.ScheduleUniqueJob(ctx, "campaign_"+id, CampaignJobName, id, at); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Cancel ยกเลิก campaign ที่ยังไม่ได้ส่ง (job ที่ตั้งไว้จะข้ามเมื่อถึงเวลา)
+func (s *CampaignService) Cancel(ctx context.Context, id string) (*Campaign, error) {
+	if err := s.setStatus(ctx, id, CampaignCancelled, nil); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// setStatus เปลี่ยนสถานะได้เฉพาะ campaign ที่ยังเป็น draft/scheduled
+func (s *CampaignService) setStatus(ctx context.Context, id, next string, fields map[string]interface{}) error {
+	ref := s.col.Doc(id)
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrCampaignNotFound
+		}
+		if err != nil {
+			return err
+		}
+		current, _ := snap.Data()["status"].(string)
+		if current != CampaignDraft && current != CampaignScheduled {
+			return ErrCampaignNotEditable
+		}
+		updates := []firestore.Update{
+			{Path: "status", Value: next},
+			{Path: "updatedAt", Value: time.Now()},
+		}
+		for k, v := range fields {
+			updates = append(updates, firestore.Update{Path: k, Value: v})
+		}
+		return tx.Update(ref, updates)
+	})
+}
+
+// Preview นับจำนวนผู้รับตามเงื่อนไขโดยไม่ส่งจริง
+func (s *CampaignService) Preview(ctx context.Context, a CampaignAudience) (CampaignStats, error) {
+	if err := validateAudience(a); err != nil {
+		return CampaignStats{}, err
+	}
+	r, err := s.resolveAudience(ctx, a)
+	if err != nil {
+		return CampaignStats{}, err
+	}
+	return r.stats(len(campaignBatches(r.byTime))), nil
+}
+
+// campaignResumeDelay ถ้า Send ไม่จบ (error หรือ process ตาย) job จะกลับมาส่ง batch ที่เหลือหลังช่วงนี้
+const campaignResumeDelay = 5 * time.Minute
+
+// Send หาผู้รับแล้วใส่ multicast ทีละ 500 คนลง outbox
+// ผู้รับถูกคัดครั้งเดียวแล้วบันทึกเป็น batch ไว้ก่อนเข้าคิว และ batch ใช้ document id campaign_<id>_<n> จึงเรียกซ้ำได้:
+// campaign ที่ค้างสถานะ sending จะส่งต่อเฉพาะ batch ที่ยังไม่เข้าคิว ด้วยผู้รับชุดเดิม
+func (s *CampaignService) Send(ctx context.Context, id string) (*Campaign, error) {
+	resumed, err := s.beginSending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// ตั้ง job สำรองไว้ก่อน ถ้ารอบนี้ไม่จบ job จะเรียก Send อีกครั้ง (ถ้าจบแล้ว job จะข้ามเพราะ campaign เป็น sent)
+	if err := s.workpool.ScheduleUniqueJob(ctx, "campaign_"+id, CampaignJobName, id, time.Now().Add(campaignResumeDelay)); err != nil {
+		log.Printf("Error scheduling campaign %s resume: %v", id, err)
+	}
+	c, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	batches, err := s.resolveBatches(ctx, c)
+	if err != nil {
+		if !resumed {
+			s.revertSending(ctx, id)
+		}
+		return nil, err
+	}
+	messages := withOptOutQuickReply(NewLocalizer(c.Locale), c.Messages)
+
+	for i, batch := range batches {
+		body, err := json.Marshal(map[string]interface{}{
+			"to":       batch.to,
+			"messages": messages,
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = s.outbox.EnqueueOnce(ctx, campaignBatchID(id, i), OutboxMessage{
+			Channel:       ChannelLineMulticast,
+			Recipient:     "campaign:" + id,
+			CampaignID:    id,
+			Recipients:    len(batch.to),
+			Body:          string(body),
+			NextAttemptAt: batch.at,
+		})
+		if err != nil {
+			// คงสถานะ sending ไว้ job สำรองจะส่ง batch ที่เหลือ
+			return nil, err
+		}
+	}
+	if err := s.finishSending(ctx, id); err != nil {
+		return nil, err
+	}
+	// ทุก batch เข้าคิวแล้ว ไม่ต้องเก็บรายชื่อผู้รับไว้อีก
+	if _, err := utils.DeleteQuery(ctx, s.col.Doc(id).Collection("batches").Query, 200); err != nil {
+		log.Printf("Error deleting campaign %s batches: %v", id, err)
+	}
+	return s.Get(ctx, id)
+}
+
+// campaignBatchDoc batch ที่คัดผู้รับไว้แล้ว (campaigns/{id}/batches/{n})
+type campaignBatchDoc struct {
+	Index int       `firestore:"index"`
+	At    time.Time `firestore:"at"`
+	To    []string  `firestore:"to"`
+}
+
+// resolveBatches คืน batch ที่บันทึกไว้ของ campaign ถ้ายังไม่มีจะคัดผู้รับแล้วบันทึกก่อน
+// รอบที่กลับมาส่งต่อจึงไม่คัดผู้รับใหม่ (ผู้ติดตาม การปิดรับข่าวสาร และเวลาหมด quiet hours อาจเปลี่ยนไปแล้ว
+// ทำให้ batch ที่ n มีคนละชุดกับที่เข้าคิวไปแล้ว)
+func (s *CampaignService) resolveBatches(ctx context.Context, c *Campaign) ([]campaignBatch, error) {
+	batchCol := s.col.Doc(c.ID).Collection("batches")
+	if c.BatchesReady {
+		docs, err := batchCol.OrderBy("index", firestore.Asc).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		batches := make([]campaignBatch, 0, len(docs))
+		for _, doc := range docs {
+			var d campaignBatchDoc
+			if err := doc.DataTo(&d); err != nil {
+				return nil, err
+			}
+			batches = append(batches, campaignBatch{at: d.At, to: d.To})
+		}
+		return batches, nil
+	}
+
+	r, err := s.resolveAudience(ctx, c.Audience)
+	if err != nil {
+		return nil, err
+	}
+	batches := campaignBatches(r.byTime)
+	// ลบ batch ที่บันทึกไม่ครบจากรอบก่อน (batchesReady ยังไม่ถูกตั้ง จึงยังไม่มี batch ใดเข้าคิว)
+	if _, err := utils.DeleteQuery(ctx, batchCol.Query, 200); err != nil {
+		return nil, err
+	}
+	wb := s.client.Batch()
+	pending := 0
+	for i, b := range batches {
+		wb.Set(batchCol.Doc(strconv.Itoa(i)), campaignBatchDoc{Index: i, At: b.at, To: b.to})
+		pending++
+		if pending == 400 {
+			if _, err := wb.Commit(ctx); err != nil {
+				return nil, err
+			}
+			wb = s.client.Batch()
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		if _, err := wb.Commit(ctx); err != nil {
+			return nil, err
+		}
+	}
+	stats := r.stats(len(batches))
+	_, err = s.col.Doc(c.ID).Update(ctx, []firestore.Update{
+		{Path: "batchesReady", Value: true},
+		{Path: "stats.recipients", Value: stats.Recipients},
+		{Path: "stats.optedOut", Value: stats.OptedOut},
+		{Path: "stats.deferred", Value: stats.Deferred},
+		{Path: "stats.batches", Value: stats.Batches},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// campaignBatchID document id ของ batch ที่ n ใน outbox
+func campaignBatchID(id string, n int) string {
+	return fmt.Sprintf("campaign_%s_%d", id, n)
+}
+
+// beginSending เปลี่ยน draft/scheduled เป็น sending (resumed = ค้าง sending จากรอบก่อน)
+func (s *CampaignService) beginSending(ctx context.Context, id string) (bool, error) {
+	ref := s.col.Doc(id)
+	resumed := false
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrCampaignNotFound
+		}
+		if err != nil {
+			return err
+		}
+		current, _ := snap.Data()["status"].(string)
+		switch current {
+		case CampaignSending:
+			resumed = true
+			return nil
+		case CampaignDraft, CampaignScheduled:
+			return tx.Update(ref, []firestore.Update{
+				{Path: "status", Value: CampaignSending},
+				{Path: "updatedAt", Value: time.Now()},
+			})
+		}
+		return ErrCampaignNotEditable
+	})
+	return resumed, err
+}
+
+func (s *CampaignService) finishSending(ctx context.Context, id string) error {
+	now := time.Now()
+	_, err := s.col.Doc(id).Update(ctx, []firestore.Update{
+		{Path: "status", Value: CampaignSent},
+		{Path: "sentAt", Value: now},
+		{Path: "updatedAt", Value: now},
+	})
+	return err
+}
+
+// revertSending คืนสถานะเป็น scheduled เมื่อยังไม่ได้ส่ง batch ใดเลย (ให้ส่งใหม่ได้)
+func (s *CampaignService) revertSending(ctx context.Context, id string) {
+	_, err := s.col.Doc(id).Update(ctx, []firestore.Update{
+		{Path: "status", Value: CampaignScheduled},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+	if err != nil {
+		log.Printf("Error reverting campaign %s: %v", id, err)
+	}
+}
+
+// recordResult นับยอดส่งสำเร็จ/ล้มเหลวของ campaign จากผลของ outbox
+func (s *CampaignService) recordResult(ctx context.Context, msg *OutboxMessage, sent bool) {
+	if msg.CampaignID == "" {
+		return
+	}
+	field := "stats.failed"
+	if sent {
+		field = "stats.delivered"
+	}
+	_, err := s.col.Doc(msg.CampaignID).Update(ctx, []firestore.Update{
+		{Path: field, Value: firestore.Increment(msg.Recipients)},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+	if err != nil {
+		log.Printf("Error recording campaign %s result: %v", msg.CampaignID, err)
+	}
+}
+
+// audienceFacts ข้อมูลที่โหลดมาเพื่อคัดผู้รับ (map ที่เป็น nil = ไม่ได้ใช้เงื่อนไขนั้น)
+type audienceFacts struct {
+	now        time.Time
+	premium    map[string]bool
+	lastActive map[string]time.Time
+	intents    map[string]bool
+	spend      map[string]int64
+	prefs      map[string]*NotificationPreferences
+}
+
+// campaignRecipients LINE userId ของผู้รับแยกตามเวลาที่ส่งได้ (key zero = ส่งทันที อื่น ๆ = รอหมด quiet hours)
+type campaignRecipients struct {
+	byTime   map[time.Time][]string
+	total    int
+	deferred int
+	optedOut int // ตรงเงื่อนไขแต่ปิดรับข่าวสารหรือปิดช่องทาง LINE
+}
+
+func (r *campaignRecipients) stats(batches int) CampaignStats {
+	return CampaignStats{Recipients: r.total, OptedOut: r.optedOut, Deferred: r.deferred, Batches: batches}
+}
+
+// add ตรวจการตั้งค่าของผู้ใช้ (category marketing) แล้วจัดเข้ากลุ่มตามเวลาที่ส่งได้
+func (r *campaignRecipients) add(lineUserID string, prefs *NotificationPreferences, now time.Time) {
+	var at time.Time
+	if prefs != nil {
+		until, err := prefs.Decide(ChannelLine, CategoryMarketing, now)
+		if err != nil {
+			r.optedOut++
+			return
+		}
+		if !until.IsZero() {
+			at = until
+			r.deferred++
+		}
+	}
+	r.byTime[at] = append(r.byTime[at], lineUserID)
+	r.total++
+}
+
+// resolveAudience หาผู้รับที่เป็นเพื่อนกับ OA ตรงเงื่อนไข และยอมรับข่าวสาร
+func (s *CampaignService) resolveAudience(ctx context.Context, a CampaignAudience) (*campaignRecipients, error) {
+	facts, err := s.loadAudienceFacts(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.lineUserCol.Where("following", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	r := &campaignRecipients{byTime: map[time.Time][]string{}}
+	for _, doc := range docs {
+		userID, _ := doc.Data()["userId"].(string)
+		if !a.matches(userID, facts) {
+			continue
+		}
+		r.add(doc.Ref.ID, facts.prefs[userID], facts.now)
+	}
+	return r, nil
+}
+
+// campaignBatch multicast หนึ่งครั้ง (at zero = ส่งทันที)
+type campaignBatch struct {
+	at time.Time
+	to []string
+}
+
+// campaignBatches แบ่งผู้รับแต่ละกลุ่มเวลาเป็น batch ละไม่เกิน 500 คน เรียงตามเวลาส่ง
+func campaignBatches(byTime map[time.Time][]string) []campaignBatch {
+	times := make([]time.Time, 0, len(byTime))
+	for at := range byTime {
+		times = append(times, at)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	var batches []campaignBatch
+	for _, at := range times {
+		// เรียงผู้รับให้ batch ที่ n เหมือนเดิมเมื่อ Send ถูกเรียกซ้ำ
+		to := slices.Clone(byTime[at])
+		slices.Sort(to)
+		for _, chunk := range chunkStrings(to, lineMulticastMaxRecipients) {
+			batches = append(batches, campaignBatch{at: at, to: chunk})
+		}
+	}
+	return batches
+}
+
+func (s *CampaignService) loadAudienceFacts(ctx context.Context, a CampaignAudience) (*audienceFacts, error) {
+	prefs, err := s.prefs.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f := &audienceFacts{now: time.Now(), prefs: prefs}
+
+	if a.Tier != "" {
+		f.premium = map[string]bool{}
+		docs, err := s.client.Collection("user_packages").Where("expiresAt", ">", f.now).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if uid, _ := doc.Data()["userId"].(string); uid != "" {
+				f.premium[uid] = true
+			}
+		}
+	}
+
+	if days := maxInt(a.ActiveWithinDays, a.InactiveForDays); days > 0 {
+		f.lastActive = map[string]time.Time{}
+		since := f.now.AddDate(0, 0, -days)
+		docs, err := s.client.Collection("active_sessions").Where("lastActiveAt", ">=", since).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if t, ok := doc.Data()["lastActiveAt"].(time.Time); ok {
+				f.lastActive[doc.Ref.ID] = t
+			}
+		}
+	}
+
+	if len(a.Intents) > 0 {
+		f.intents = map[string]bool{}
+		q := s.client.Collection("conversations").Where("intent", "in", a.Intents)
+		if a.IntentWithinDays > 0 {
+			q = q.Where("startedAt", ">=", f.now.AddDate(0, 0, -a.IntentWithinDays))
+		}
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if uid, _ := doc.Data()["userId"].(string); uid != "" {
+				f.intents[uid] = true
+			}
+		}
+	}
+
+	if a.MinSpend > 0 || a.MaxSpend > 0 {
+		f.spend = map[string]int64{}
+		docs, err := s.client.Collection("payments").Where("status", "==", "paid").Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var p Payment
+			if err := doc.DataTo(&p); err != nil {
+				continue
+			}
+			f.spend[p.UserID] += p.Amount
+		}
+	}
+	return f, nil
+}
+
+// matches ตรวจเงื่อนไขของผู้ใช้หนึ่งคน (ผู้ใช้ LINE ที่ยังไม่มีบัญชีผ่านเฉพาะ audience ที่ไม่กรองเลย)
+func (a CampaignAudience) matches(userID string, f *audienceFacts) bool {
+	if userID == "" {
+		return a.Tier == "" && f.lastActive == nil && f.intents == nil && f.spend == nil
+	}
+	if a.Tier != "" {
+		tier := TierFree
+		if f.premium[userID] {
+			tier = TierPremium
+		}
+		if tier != a.Tier {
+			return false
+		}
+	}
+	last, active := f.lastActive[userID]
+	if a.ActiveWithinDays > 0 && (!active || last.Before(f.now.AddDate(0, 0, -a.ActiveWithinDays))) {
+		return false
+	}
+	if a.InactiveForDays > 0 && active && !last.Before(f.now.AddDate(0, 0, -a.InactiveForDays)) {
+		return false
+	}
+	if f.intents != nil && !f.intents[userID] {
+		return false
+	}
+	if f.spend != nil {
+		spent := f.spend[userID]
+		if spent < a.MinSpend || (a.MaxSpend > 0 && spent > a.MaxSpend) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateCampaign(c Campaign) error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCampaign)
+	}
+	if err := ValidateLineMessages(c.Messages); err != nil {
+		return err
+	}
+	if c.Locale != "" && !slices.Contains(notificationLanguages, c.Locale) {
+		return fmt.Errorf("%w: unsupported locale %q", ErrInvalidCampaign, c.Locale)
+	}
+	return validateAudience(c.Audience)
+}
+
+func validateAudience(a CampaignAudience) error {
+	if a.Tier != "" && a.Tier != TierFree && a.Tier != TierPremium {
+		return fmt.Errorf("%w: unknown tier %q", ErrInvalidCampaign, a.Tier)
+	}
+	if a.ActiveWithinDays < 0 || a.InactiveForDays < 0 || a.IntentWithinDays < 0 || a.MinSpend < 0 || a.MaxSpend < 0 {
+		return fmt.Errorf("%w: negative filter", ErrInvalidCampaign)
+	}
+	if a.MaxSpend > 0 && a.MaxSpend < a.MinSpend {
+		return fmt.Errorf("%w: maxSpend below minSpend", ErrInvalidCampaign)
+	}
+	if len(a.Intents) > campaignMaxIntents {
+		return fmt.Errorf("%w: at most %d intents", ErrInvalidCampaign, campaignMaxIntents)
+	}
+	return nil
+}
+
+// withOptOutQuickReply เพิ่มปุ่ม "ไม่รับข่าวสาร" ที่ข้อความสุดท้าย (ถ้า quick reply ยังไม่เต็ม)
+func withOptOutQuickReply(l *Localizer, messages []LineMessage) []LineMessage {
+	if len(messages) == 0 {
+		return messages
+	}
+	label := l.T("marketing.opt_out_button")
+	out := append([]LineMessage(nil), messages...)
+	out[len(out)-1] = out[len(out)-1].AddQuickReply(PostbackAction(label, "action=marketing_opt_out", label))
+	return out
+}
+
+func campaignFromSnap(snap *firestore.DocumentSnapshot) (*Campaign, error) {
+	var c Campaign
+	if err := snap.DataTo(&c); err != nil {
+		return nil, err
+	}
+	if c.MessagesBody != "" {
+		if err := json.Unmarshal([]byte(c.MessagesBody), &c.Messages); err != nil {
+			return nil, err
+		}
+	}
+	c.ID = snap.Ref.ID
+	return &c, nil
+}
+
+func chunkStrings(list []string, size int) [][]string {
+	var chunks [][]string
+	for len(list) > 0 {
+		n := size
+		if len(list) < n {
+			n = len(list)
+		}
+		chunks = append(chunks, list[:n])
+		list = list[n:]
+	}
+	return chunks
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
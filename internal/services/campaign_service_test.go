@@ -0,0 +1,110 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaignAudienceMatches(t *testing.T) {
+	now := time.Now()
+	facts := &audienceFacts{
+		now:        now,
+		premium:    map[string]bool{"u1": true},
+		lastActive: map[string]time.Time{"u1": now.Add(-24 * time.Hour), "u2": now.AddDate(0, 0, -20)},
+		spend:      map[string]int64{"u1": 500, "u2": 50},
+	}
+
+	premium := CampaignAudience{Tier: TierPremium}
+	assert.True(t, premium.matches("u1", facts))
+	assert.False(t, premium.matches("u2", facts))
+	assert.True(t, CampaignAudience{Tier: TierFree}.matches("u2", facts))
+
+	assert.True(t, CampaignAudience{ActiveWithinDays: 7}.matches("u1", facts))
+	assert.False(t, CampaignAudience{ActiveWithinDays: 7}.matches("u2", facts))
+	assert.True(t, CampaignAudience{InactiveForDays: 14}.matches("u2", facts))
+	assert.True(t, CampaignAudience{InactiveForDays: 14}.matches("u3", facts))
+	assert.False(t, CampaignAudience{InactiveForDays: 14}.matches("u1", facts))
+
+	assert.True(t, CampaignAudience{MinSpend: 100}.matches("u1", facts))
+	assert.False(t, CampaignAudience{MinSpend: 100}.matches("u2", facts))
+	assert.False(t, CampaignAudience{MinSpend: 100, MaxSpend: 300}.matches("u1", facts))
+
+	// ผู้ใช้ LINE ที่ยังไม่มีบัญชีได้รับเฉพาะ campaign ที่ไม่กรอง
+	assert.False(t, premium.matches("", facts))
+	assert.True(t, CampaignAudience{}.matches("", &audienceFacts{now: now}))
+}
+
+func TestValidateAudience(t *testing.T) {
+	assert.NoError(t, validateAudience(CampaignAudience{Tier: TierFree, MinSpend: 10, MaxSpend: 20}))
+	assert.ErrorIs(t, validateAudience(CampaignAudience{Tier: "gold"}), ErrInvalidCampaign)
+	assert.ErrorIs(t, validateAudience(CampaignAudience{MinSpend: 20, MaxSpend: 10}), ErrInvalidCampaign)
+	assert.ErrorIs(t, validateAudience(CampaignAudience{ActiveWithinDays: -1}), ErrInvalidCampaign)
+}
+
+func TestChunkStrings(t *testing.T) {
+	ids := make([]string, 1201)
+	chunks := chunkStrings(ids, lineMulticastMaxRecipients)
+	assert.Len(t, chunks, 3)
+	assert.Len(t, chunks[0], 500)
+	assert.Len(t, chunks[2], 201)
+	assert.Empty(t, chunkStrings(nil, 500))
+}
+
+func TestWithOptOutQuickReply(t *testing.T) {
+	// ข้อความที่ decode กลับมาจาก Firestore ต้องไม่เสียปุ่มเดิม
+	var stored []LineMessage
+	data, _ := json.Marshal([]LineMessage{LineText("โปรโมชั่น").WithQuickReply(MessageAction("ดูดวง", "ดูดวง"))})
+	assert.NoError(t, json.Unmarshal(data, &stored))
+
+	out := withOptOutQuickReply(NewLocalizer("th"), stored)
+	items := asSlice(out[0]["quickReply"].(map[string]interface{})["items"])
+	assert.Len(t, items, 2)
+	assert.NoError(t, ValidateLineMessages(out))
+
+	out = withOptOutQuickReply(NewLocalizer("en"), []LineMessage{LineText("News")})
+	items = asSlice(out[0]["quickReply"].(map[string]interface{})["items"])
+	assert.Equal(t, "Unsubscribe", items[0].(map[string]interface{})["action"].(LineAction)["label"])
+}
+
+func TestCampaignRecipientsRespectPreferences(t *testing.T) {
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	optedOut := DefaultNotificationPreferences("u2")
+	optedOut.Categories[CategoryMarketing] = false
+	quiet := DefaultNotificationPreferences("u3")
+	quiet.Timezone = "UTC"
+	quiet.QuietHours = &QuietHours{Start: "22:00", End: "08:00"}
+
+	r := &campaignRecipients{byTime: map[time.Time][]string{}}
+	r.add("L1", nil, now)
+	r.add("L2", optedOut, now)
+	r.add("L3", quiet, now)
+
+	assert.Equal(t, CampaignStats{Recipients: 2, OptedOut: 1, Deferred: 1, Batches: 2}, r.stats(2))
+	batches := campaignBatches(r.byTime)
+	assert.Len(t, batches, 2)
+	assert.True(t, batches[0].at.IsZero())
+	assert.Equal(t, []string{"L1"}, batches[0].to)
+	assert.True(t, batches[1].at.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []string{"L3"}, batches[1].to)
+}
+
+func TestCampaignBatchesAreStable(t *testing.T) {
+	ids := make([]string, 0, 1200)
+	for i := 1200; i > 0; i-- {
+		ids = append(ids, fmt.Sprintf("U%04d", i))
+	}
+	first := campaignBatches(map[time.Time][]string{{}: ids})
+	slices.Reverse(ids)
+	second := campaignBatches(map[time.Time][]string{{}: ids})
+
+	// ลำดับที่ได้จาก Firestore ต่างกันได้ แต่ batch ที่ n ต้องเป็นผู้รับชุดเดิม (Send เรียกซ้ำได้)
+	assert.Len(t, first, 3)
+	assert.Equal(t, first, second)
+	assert.Equal(t, "U0001", first[0].to[0])
+	assert.Equal(t, "campaign_c1_2", campaignBatchID("c1", 2))
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChatService รวมขั้นตอนการคุยกับ AI ที่ใช้ร่วมกันระหว่าง /ai/chat และ LINE webhook
+type ChatService struct {
+	aiClient    *AIServiceClient
+	billing     *AIBillingService
+	sessions    *SessionService
+	rag         *RAGService
+	tarot       *TarotService
+	moderate    *ModerationService
+	prompts     *PromptService
+	experiments *ExperimentService
+	userCol     *firestore.CollectionRef
+	prefs       *NotificationPreferenceService
+}
+
+// ragTopK จำนวน passage ที่แนบไปกับคำขอ AI
+const ragTopK = 4
+
+// ragPurposes purpose ที่ต้องค้นความรู้จาก deck/card ก่อนเรียก AI
+var ragPurposes = map[string]bool{"tarot": true, "rag": true}
+
+// chatPurposes purpose ที่รับได้ (ราคาอยู่ใน ai_pricing) purpose อื่นถูกปฏิเสธ ไม่ใช่ใช้ฟรี
+var chatPurposes = map[string]bool{"chat": true, "tarot": true, "rag": true}
+
+var ErrUnknownPurpose = errors.New("unknown chat purpose")
+
+func NewChatService(aiClient *AIServiceClient, billing *AIBillingService, sessions *SessionService, rag *RAGService, tarot *TarotService, moderate *ModerationService, prompts *PromptService, experiments *ExperimentService) *ChatService {
+	return &ChatService{
+		aiClient:    aiClient,
+		billing:     billing,
+		sessions:    sessions,
+		rag:         rag,
+		tarot:       tarot,
+		moderate:    moderate,
+		prompts:     prompts,
+		experiments: experiments,
+		userCol:     utils.Client.Collection("users"),
+		prefs:       NewNotificationPreferenceService(),
+	}
+}
+
+// Chat: หา session → ตรวจข้อความผู้ใช้ → hold เหรียญ → โหลดประวัติ → บันทึกข้อความผู้ใช้ → เรียก AI
+// → ตรวจคำตอบ → capture/release → บันทึกข้อความ bot
+// ถ้า req.ConversationID ว่าง จะใช้ conversation ที่ผู้ใช้คุยค้างอยู่ (หรือสร้างใหม่)
+func (s *ChatService) Chat(ctx context.Context, req AIChatRequest) (*AIChatResponse, error) {
+	if req.Purpose == "" {
+		req.Purpose = "chat"
+	}
+	if !chatPurposes[req.Purpose] {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPurpose, req.Purpose)
+	}
+	if req.ConversationID == "" {
+		convID, err := s.sessions.ResolveSession(ctx, req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		req.ConversationID = convID
+	}
+	if req.DrawID != "" {
+		draw, err := s.tarot.GetDraw(ctx, req.ConversationID, req.DrawID)
+		if err != nil {
+			return nil, err
+		}
+		req.Draw = draw
+		req.DeckID = draw.DeckID
+		// ตีความไพ่คิดราคา tarot เสมอ ไม่ว่าผู้เรียกจะส่ง purpose อะไรมา
+		req.Purpose = "tarot"
+	}
+
+	input, err := s.moderate.Check(ctx, req.UserID, req.ConversationID, "input", req.Message)
+	if err != nil {
+		return nil, err
+	}
+	if input.Blocked {
+		// ไม่ส่งต่อให้ AI และไม่คิดเหรียญ ประวัติเก็บแค่ข้อความแทน ไม่เก็บข้อความจริงที่ถูก block
+		utils.SaveUserMessage(req.ConversationID, req.UserID, BlockedMessagePlaceholder)
+		utils.SaveBotMessage(req.ConversationID, req.UserID, input.Text, "moderation")
+		return &AIChatResponse{
+			Response:       input.Text,
+			ModelUsed:      "moderation",
+			ConversationID: req.ConversationID,
+			Moderated:      true,
+		}, nil
+	}
+	req.Message = input.Text
+
+	charge, err := s.billing.Authorize(ctx, req.UserID, req.Purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordFollowUp(ctx, req.ConversationID)
+
+	// โหลดประวัติก่อนบันทึกข้อความใหม่ เพื่อไม่ให้ข้อความปัจจุบันซ้ำใน History
+	history, priorSummary, err := s.sessions.LoadHistory(ctx, req.ConversationID)
+	if err != nil {
+		log.Println("Error loading chat history:", err)
+	}
+	req.History = history
+	req.PriorSummary = priorSummary
+
+	if ragPurposes[req.Purpose] {
+		citations, err := s.rag.Retrieve(ctx, ragQuery(req), req.DeckID, ragTopK)
+		if err != nil {
+			log.Println("Error retrieving RAG context:", err)
+		}
+		req.Context = citations
+	}
+
+	s.renderPrompt(ctx, &req)
+
+	utils.SaveUserMessage(req.ConversationID, req.UserID, req.Message)
+
+	aiResp, err := s.aiClient.Chat(ctx, req)
+	var output *ModerationResult
+	if err == nil {
+		output, err = s.moderate.Check(ctx, req.UserID, req.ConversationID, "output", aiResp.Response)
+	}
+	// คิดเหรียญเฉพาะเมื่อผู้ใช้ได้รับคำทำนายจริง (AI ตอบสำเร็จและไม่ถูก block)
+	delivered := err == nil && !output.Blocked
+	if settleErr := s.billing.Settle(context.Background(), charge, delivered); settleErr != nil {
+		log.Println("Error scheduling AI charge settlement retry:", settleErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if output.Text != aiResp.Response {
+		aiResp.Response = output.Text
+		aiResp.Moderated = true
+	}
+	if output.Blocked {
+		aiResp.ModelUsed = "moderation"
+	}
+
+	var meta map[string]interface{}
+	if req.ExperimentID != "" {
+		meta = map[string]interface{}{"experimentId": req.ExperimentID, "variant": req.Variant}
+		if err := s.experiments.RecordServed(ctx, req.ExperimentID, req.Variant); err != nil {
+			log.Println("Error recording experiment exposure:", err)
+		}
+	}
+	aiResp.MessageID = utils.SaveBotMessageWithMeta(req.ConversationID, req.UserID, aiResp.Response, aiResp.ModelUsed, meta)
+	aiResp.ConversationID = req.ConversationID
+	aiResp.Citations = req.Context
+	return aiResp, nil
+}
+
+// ragQuery ใช้คำถามของผู้ใช้ร่วมกับชื่อไพ่ที่เปิด เพื่อให้ค้นความหมายของไพ่ใบนั้นได้ตรง
+func ragQuery(req AIChatRequest) string {
+	if req.Draw == nil {
+		return req.Message
+	}
+	query := req.Message
+	for _, card := range req.Draw.Cards {
+		query += "\n" + card.CardName
+	}
+	return query
+}
+
+// renderPrompt render prompt template ของ purpose (key "ai_prompt.<purpose>") ด้วยข้อมูลของคำขอ
+// prompt และ experiment ที่ติดมากับคำขอถูกล้างก่อนเสมอ ไม่มี template = ให้ ai-service ใช้ configs เอง
+func (s *ChatService) renderPrompt(ctx context.Context, req *AIChatRequest) {
+	req.SystemPrompt = ""
+	req.PromptVersion = 0
+	req.ExperimentID = ""
+	req.Variant = ""
+	vars := s.userPromptVariables(ctx, req.UserID)
+	if req.Draw != nil {
+		vars.Spread = req.Draw.Spread
+		for _, card := range req.Draw.Cards {
+			name := fmt.Sprintf("%s: %s", card.PositionName, card.CardName)
+			if card.Reversed {
+				name += " (กลับหัว)"
+			}
+			vars.Cards = append(vars.Cards, name)
+		}
+	}
+	key := "ai_prompt." + req.Purpose
+
+	// ถ้ามีการทดลองที่กำลังรันอยู่ ใช้ prompt ของ variant ที่ผู้ใช้ได้รับแทน version ที่ active
+	exp, variant, err := s.experiments.Assign(ctx, key, req.UserID)
+	if err != nil {
+		log.Println("Error assigning prompt experiment:", err)
+	}
+	if variant != nil {
+		text, err := renderTemplate(variant.Prompt, vars)
+		if err == nil {
+			req.SystemPrompt = text
+			req.ExperimentID = exp.ID
+			req.Variant = variant.Name
+			if variant.Model != "" {
+				req.Model = variant.Model
+			}
+			req.Temperature = variant.Temperature
+			req.MaxTokens = variant.MaxTokens
+			return
+		}
+		log.Println("Error rendering experiment variant:", err)
+	}
+
+	text, v, err := s.prompts.Render(ctx, key, vars)
+	if errors.Is(err, ErrPromptNotFound) {
+		return
+	}
+	if err != nil {
+		log.Println("Error rendering prompt template:", err)
+		return
+	}
+	req.SystemPrompt = text
+	req.PromptVersion = v.Version
+}
+
+// userPromptVariables ชื่อที่แสดงจากบัญชีผู้ใช้และภาษาที่ผู้ใช้ตั้งไว้ (ไม่รู้ = ค่าว่าง ไม่ใช้ user ID แทนชื่อ)
+func (s *ChatService) userPromptVariables(ctx context.Context, userID string) PromptVariables {
+	var vars PromptVariables
+	if userID == "" {
+		return vars
+	}
+	snap, err := s.userCol.Doc(userID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Println("Error loading user for prompt:", err)
+	}
+	if err == nil {
+		vars.UserName, _ = snap.Data()["displayName"].(string)
+	}
+	lang, err := s.prefs.Language(ctx, userID)
+	if err != nil {
+		log.Println("Error loading user language for prompt:", err)
+	}
+	if lang != "" {
+		vars.Locale = NormalizeLocale(lang)
+	}
+	return vars
+}
+
+// recordFollowUp ถ้าข้อความล่าสุดของ conversation มาจาก experiment variant
+// การที่ผู้ใช้ส่งข้อความต่อถือเป็น follow-up ของ variant นั้น
+func (s *ChatService) recordFollowUp(ctx context.Context, convID string) {
+	last, err := utils.GetRecentMessages(ctx, convID, 1)
+	if err != nil || len(last) == 0 || last[0].ExperimentID == "" {
+		return
+	}
+	if err := s.experiments.RecordOutcome(ctx, last[0].ExperimentID, last[0].Variant, "followUp", 1); err != nil {
+		log.Println("Error recording experiment follow-up:", err)
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"errors"
+	"log"
 	"time"
 
 	"cloud.google.com/go/firestore"
@@ -15,13 +16,39 @@ type CoinBalance struct {
 	UpdatedAt time.Time `firestore:"updatedAt"`
 }
 
+// CoinHold เหรียญที่ถูกกันไว้ระหว่างรอผลการใช้งาน (เช่น รอ AI ตอบ)
+type CoinHold struct {
+	UserID    string    `firestore:"userId"`
+	Amount    int64     `firestore:"amount"`
+	Purpose   string    `firestore:"purpose"`
+	Status    string    `firestore:"status"`   // "held", "captured", "released"
+	ExpireAt  time.Time `firestore:"expireAt"` // hold ที่ยัง "held" เกินเวลานี้จะถูกคืนเหรียญอัตโนมัติ
+	CreatedAt time.Time `firestore:"createdAt"`
+	UpdatedAt time.Time `firestore:"updatedAt"`
+}
+
+// ReleaseExpiredHoldsJobName job ใน workpool ที่คืนเหรียญของ hold ที่ค้างเกิน expireAt (รันซ้ำทุก holdSweepInterval)
+const ReleaseExpiredHoldsJobName = "release_expired_holds"
+
+const (
+	holdSweepInterval  = 5 * time.Minute
+	holdSweepBatchSize = 200
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrHoldNotActive       = errors.New("hold is not active")
+)
+
 type CoinService struct {
-	col *firestore.CollectionRef
+	col     *firestore.CollectionRef
+	holdCol *firestore.CollectionRef
 }
 
 func NewCoinService() *CoinService {
 	return &CoinService{
-		col: utils.Client.Collection("coin_balances"),
+		col:     utils.Client.Collection("coin_balances"),
+		holdCol: utils.Client.Collection("coin_holds"),
 	}
 }
 
@@ -80,7 +107,7 @@ func (s *CoinService) Deduct(ctx context.Context, userID string, amount int64) e
 		return err
 	}
 	if cb.Balance < amount {
-		return errors.New("insufficient balance")
+		return ErrInsufficientBalance
 	}
 	_, err = doc.Ref.Update(ctx, []firestore.Update{
 		{Path: "balance", Value: firestore.Increment(-amount)},
@@ -89,15 +116,192 @@ func (s *CoinService) Deduct(ctx context.Context, userID string, amount int64) e
 	return err
 }
 
-// Transfer โอนเหรียญจากผู้ใช้หนึ่งไปยังอีกคน
+// Transfer โอนเหรียญจากผู้ใช้หนึ่งไปยังอีกคน (หักและเติมใน transaction เดียว เหรียญไม่หายกลางทาง)
 func (s *CoinService) Transfer(ctx context.Context, fromUserID, toUserID string, amount int64) error {
 	if fromUserID == toUserID {
 		return errors.New("cannot transfer to self")
 	}
-	// หักยอดจากผู้ส่ง
-	if err := s.Deduct(ctx, fromUserID, amount); err != nil {
-		return err
+	return utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		fromDocs, err := tx.Documents(s.col.Where("userId", "==", fromUserID).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		toDocs, err := tx.Documents(s.col.Where("userId", "==", toUserID).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(fromDocs) == 0 {
+			return errors.New("no balance record")
+		}
+		var cb CoinBalance
+		if err := fromDocs[0].DataTo(&cb); err != nil {
+			return err
+		}
+		if cb.Balance < amount {
+			return ErrInsufficientBalance
+		}
+		now := time.Now()
+		if err := tx.Update(fromDocs[0].Ref, []firestore.Update{
+			{Path: "balance", Value: firestore.Increment(-amount)},
+			{Path: "updatedAt", Value: now},
+		}); err != nil {
+			return err
+		}
+		if len(toDocs) == 0 {
+			return tx.Create(s.col.NewDoc(), CoinBalance{UserID: toUserID, Balance: amount, UpdatedAt: now})
+		}
+		return tx.Update(toDocs[0].Ref, []firestore.Update{
+			{Path: "balance", Value: firestore.Increment(amount)},
+			{Path: "updatedAt", Value: now},
+		})
+	})
+}
+
+// Hold กันเหรียญของผู้ใช้ไว้ก่อน (หักจาก balance ทันที) แล้วคืน holdID
+// ต้องตามด้วย Capture เมื่อใช้งานสำเร็จ หรือ Release เพื่อคืนเหรียญ ถ้าไม่มีใครจัดการภายใน ttl จะถูกคืนเหรียญอัตโนมัติ
+func (s *CoinService) Hold(ctx context.Context, userID string, amount int64, purpose string, ttl time.Duration) (string, error) {
+	holdRef := s.holdCol.NewDoc()
+	err := utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(s.col.Where("userId", "==", userID).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return ErrInsufficientBalance
+		}
+		var cb CoinBalance
+		if err := docs[0].DataTo(&cb); err != nil {
+			return err
+		}
+		if cb.Balance < amount {
+			return ErrInsufficientBalance
+		}
+		now := time.Now()
+		if err := tx.Update(docs[0].Ref, []firestore.Update{
+			{Path: "balance", Value: firestore.Increment(-amount)},
+			{Path: "updatedAt", Value: now},
+		}); err != nil {
+			return err
+		}
+		return tx.Create(holdRef, CoinHold{
+			UserID:    userID,
+			Amount:    amount,
+			Purpose:   purpose,
+			Status:    "held",
+			ExpireAt:  now.Add(ttl),
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return holdRef.ID, nil
+}
+
+// Capture ยืนยันการตัดเหรียญที่ hold ไว้
+func (s *CoinService) Capture(ctx context.Context, holdID string) error {
+	holdRef := s.holdCol.Doc(holdID)
+	return utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(holdRef)
+		if err != nil {
+			return err
+		}
+		var h CoinHold
+		if err := snap.DataTo(&h); err != nil {
+			return err
+		}
+		if h.Status != "held" {
+			return ErrHoldNotActive
+		}
+		return tx.Update(holdRef, []firestore.Update{
+			{Path: "status", Value: "captured"},
+			{Path: "updatedAt", Value: time.Now()},
+		})
+	})
+}
+
+// Release ยกเลิก hold แล้วคืนเหรียญให้ผู้ใช้
+func (s *CoinService) Release(ctx context.Context, holdID string) error {
+	return s.release(ctx, holdID, time.Time{})
+}
+
+// release คืนเหรียญของ hold ที่ยัง "held" (expiredBy ไม่ว่าง = คืนเฉพาะเมื่อ expireAt ของ hold ก่อนเวลานี้)
+func (s *CoinService) release(ctx context.Context, holdID string, expiredBy time.Time) error {
+	holdRef := s.holdCol.Doc(holdID)
+	return utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(holdRef)
+		if err != nil {
+			return err
+		}
+		var h CoinHold
+		if err := snap.DataTo(&h); err != nil {
+			return err
+		}
+		if h.Status != "held" || (!expiredBy.IsZero() && !h.ExpireAt.Before(expiredBy)) {
+			return ErrHoldNotActive
+		}
+		docs, err := tx.Documents(s.col.Where("userId", "==", h.UserID).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return errors.New("no balance record")
+		}
+		now := time.Now()
+		if err := tx.Update(docs[0].Ref, []firestore.Update{
+			{Path: "balance", Value: firestore.Increment(h.Amount)},
+			{Path: "updatedAt", Value: now},
+		}); err != nil {
+			return err
+		}
+		return tx.Update(holdRef, []firestore.Update{
+			{Path: "status", Value: "released"},
+			{Path: "updatedAt", Value: now},
+		})
+	})
+}
+
+// extendHold เลื่อน expireAt ของ hold ภายใน transaction ของผู้เรียก (เช่น การจองที่ hold ไว้จนจบนัด)
+func (s *CoinService) extendHold(tx *firestore.Transaction, holdID string, expireAt time.Time) error {
+	return tx.Update(s.holdCol.Doc(holdID), []firestore.Update{
+		{Path: "expireAt", Value: expireAt},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+}
+
+// ReleaseExpiredHolds คืนเหรียญของ hold ที่ยัง "held" เกิน expireAt (เช่น process ตายระหว่าง Hold กับ Settle)
+func (s *CoinService) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	now := time.Now()
+	docs, err := s.holdCol.Where("status", "==", "held").Where("expireAt", "<", now).Limit(holdSweepBatchSize).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
 	}
-	// เติมยอดให้ผู้รับ
-	return s.TopUp(ctx, toUserID, amount)
+	released := 0
+	for _, doc := range docs {
+		err := s.release(ctx, doc.Ref.ID, now)
+		if errors.Is(err, ErrHoldNotActive) {
+			continue // ถูก capture/release หรือต่อเวลาไปแล้วระหว่างทาง
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// StartHoldSweeper ลงทะเบียน handler ของ ReleaseExpiredHoldsJobName และตั้งรอบแรกถ้ายังไม่มี job
+func (s *CoinService) StartHoldSweeper(ctx context.Context, workpool *WorkpoolService) error {
+	RegisterJobHandler(ReleaseExpiredHoldsJobName, func(ctx context.Context, _ string) error {
+		// ตั้งรอบถัดไปก่อน ถ้า process ตายระหว่างคืนเหรียญ รอบถัดไปยังทำงานต่อได้
+		// ตั้งไม่สำเร็จ = job failed (แจ้งเตือน) แล้ว EnsureJob ตอน start ตั้งให้ใหม่
+		schedErr := workpool.ScheduleUniqueJob(ctx, ReleaseExpiredHoldsJobName, ReleaseExpiredHoldsJobName, "", time.Now().Add(holdSweepInterval))
+		n, err := s.ReleaseExpiredHolds(ctx)
+		if n > 0 {
+			log.Printf("Released %d expired coin holds", n)
+		}
+		return errors.Join(err, schedErr)
+	})
+	return workpool.EnsureJob(ctx, ReleaseExpiredHoldsJobName, ReleaseExpiredHoldsJobName, "", time.Now().Add(holdSweepInterval))
 }
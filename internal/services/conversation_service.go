@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+const (
+	defaultConversationPageSize = 20
+	maxConversationPageSize     = 100
+)
+
+// ConversationRecord ข้อมูลระดับ document ของ conversations/{id}
+type ConversationRecord struct {
+	ID         string    `firestore:"-" json:"id"`
+	UserID     string    `firestore:"userId" json:"userId"`
+	Summary    string    `firestore:"summary" json:"summary"`
+	Intent     string    `firestore:"intent" json:"intent"`
+	Emotion    string    `firestore:"emotion" json:"emotion"`
+	Sentiment  float64   `firestore:"sentiment" json:"sentiment"`
+	Topics     []string  `firestore:"topics" json:"topics"`
+	StartedAt  time.Time `firestore:"startedAt" json:"startedAt"`
+	SummaryAt  time.Time `firestore:"summaryAt" json:"summaryAt,omitempty"`
+	NeedsHuman bool      `firestore:"needsHuman" json:"needsHuman,omitempty"`
+}
+
+// ConversationFilter เงื่อนไขค้น conversation (ค่าว่าง = ไม่กรอง)
+// Cursor คือ id ของ conversation สุดท้ายในหน้าก่อน
+type ConversationFilter struct {
+	UserID  string
+	Intent  string
+	Emotion string
+	From    time.Time
+	To      time.Time
+	Cursor  string
+	Limit   int
+}
+
+type ConversationService struct {
+	convCol *firestore.CollectionRef
+}
+
+func NewConversationService() *ConversationService {
+	return &ConversationService{
+		convCol: utils.Client.Collection("conversations"),
+	}
+}
+
+// conversationPageSize ขนาดหน้า (<= 0 = ค่าเริ่มต้น, ไม่เกิน maxConversationPageSize)
+func conversationPageSize(limit int) int {
+	if limit <= 0 {
+		return defaultConversationPageSize
+	}
+	if limit > maxConversationPageSize {
+		return maxConversationPageSize
+	}
+	return limit
+}
+
+// List ค้น conversation เรียงจากใหม่ → เก่าตาม startedAt และคืน cursor ของหน้าถัดไป (ว่าง = หมดแล้ว)
+func (s *ConversationService) List(ctx context.Context, f ConversationFilter) ([]ConversationRecord, string, error) {
+	f.Limit = conversationPageSize(f.Limit)
+
+	q := s.convCol.Query
+	if f.UserID != "" {
+		q = q.Where("userId", "==", f.UserID)
+	}
+	if f.Intent != "" {
+		q = q.Where("intent", "==", f.Intent)
+	}
+	if f.Emotion != "" {
+		q = q.Where("emotion", "==", f.Emotion)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("startedAt", ">=", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("startedAt", "<", f.To)
+	}
+	q = q.OrderBy("startedAt", firestore.Desc)
+	if f.Cursor != "" {
+		snap, err := s.convCol.Doc(f.Cursor).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, "", ErrConversationNotFound
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		q = q.StartAfter(snap)
+	}
+
+	docs, err := q.Limit(f.Limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err
+	}
+	list := make([]ConversationRecord, 0, len(docs))
+	for _, doc := range docs {
+		var rec ConversationRecord
+		if err := doc.DataTo(&rec); err != nil {
+			continue
+		}
+		rec.ID = doc.Ref.ID
+		list = append(list, rec)
+	}
+	next := ""
+	if len(docs) == f.Limit {
+		next = docs[len(docs)-1].Ref.ID
+	}
+	return list, next, nil
+}
+
+// conversationBackfillBatch จำนวน document ต่อรอบของ BackfillTimestamps
+const conversationBackfillBatch = 300
+
+// BackfillTimestamps เติม startedAt และ oldestMessageAt ให้ conversation เก่าที่ไม่มี (ใช้เวลาสร้าง document)
+// query ที่ orderBy startedAt (List, timeline) จะข้าม document ที่ไม่มี startedAt
+// และ retention purge จะข้าม document ที่ไม่มี oldestMessageAt คืนจำนวน document ที่เติม
+func (s *ConversationService) BackfillTimestamps(ctx context.Context) (int, error) {
+	filled := 0
+	q := s.convCol.OrderBy(firestore.DocumentID, firestore.Asc).Limit(conversationBackfillBatch)
+	for {
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return filled, err
+		}
+		batch := utils.Client.Batch()
+		missing := 0
+		for _, doc := range docs {
+			data := doc.Data()
+			var updates []firestore.Update
+			if _, ok := data["startedAt"]; !ok {
+				updates = append(updates, firestore.Update{Path: "startedAt", Value: doc.CreateTime})
+			}
+			if _, ok := data["oldestMessageAt"]; !ok {
+				// ข้อความทุกข้อความเกิดหลังสร้าง document จึงใช้เป็นขอบล่างได้
+				updates = append(updates, firestore.Update{Path: "oldestMessageAt", Value: doc.CreateTime})
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			batch.Update(doc.Ref, updates)
+			missing++
+		}
+		if missing > 0 {
+			if _, err := batch.Commit(ctx); err != nil {
+				return filled, err
+			}
+			filled += missing
+		}
+		if len(docs) < conversationBackfillBatch {
+			return filled, nil
+		}
+		q = q.StartAfter(docs[len(docs)-1])
+	}
+}
+
+// Get ดึง conversation หนึ่งรายการ
+func (s *ConversationService) Get(ctx context.Context, convID string) (*ConversationRecord, error) {
+	snap, err := s.convCol.Doc(convID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec ConversationRecord
+	if err := snap.DataTo(&rec); err != nil {
+		return nil, err
+	}
+	rec.ID = snap.Ref.ID
+	return &rec, nil
+}
+
+// Messages ดึงทุกข้อความของ conversation ข้ามทุก partition เรียงจากเก่า → ใหม่
+func (s *ConversationService) Messages(ctx context.Context, convID string) ([]utils.StoredMessage, error) {
+	if _, err := s.Get(ctx, convID); err != nil {
+		return nil, err
+	}
+	return utils.GetAllMessages(ctx, convID)
+}
+
+// Interpretations ดึงผล intent/emotion ที่บันทึกไว้ เรียงจากเก่า → ใหม่
+func (s *ConversationService) Interpretations(ctx context.Context, convID string) ([]map[string]interface{}, error) {
+	if _, err := s.Get(ctx, convID); err != nil {
+		return nil, err
+	}
+	docs, err := s.convCol.Doc(convID).Collection("interpretations").OrderBy("timestamp", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		m := doc.Data()
+		m["id"] = doc.Ref.ID
+		list = append(list, m)
+	}
+	return list, nil
+}
@@ -0,0 +1,21 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversationPageSize(t *testing.T) {
+	for limit, want := range map[int]int{
+		-5:  defaultConversationPageSize,
+		0:   defaultConversationPageSize,
+		1:   1,
+		50:  50,
+		100: maxConversationPageSize,
+		101: maxConversationPageSize,
+		500: maxConversationPageSize,
+	} {
+		assert.Equal(t, want, conversationPageSize(limit), "limit %d", limit)
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// EmailMessage อีเมลหนึ่งฉบับ (มีทั้ง text และ HTML)
+type EmailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// EmailProvider ส่งอีเมล (error ชนิด *DeliveryError ที่ไม่ Retryable = ส่งไม่ได้ถาวร)
+type EmailProvider interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// smtpTimeout เวลาสูงสุดของการส่งหนึ่งฉบับ เมื่อ ctx ไม่ได้กำหนด deadline มา
+const smtpTimeout = 30 * time.Second
+
+// SMTPEmailProvider ส่งผ่าน SMTP (username ว่าง = ไม่ auth เช่น MailHog/Mailpit ตอน dev)
+type SMTPEmailProvider struct {
+	addr     string
+	host     string
+	auth     smtp.Auth
+	from     string // header From เช่น "Seer <no-reply@example.com>"
+	envelope string // MAIL FROM ต้องเป็นที่อยู่ล้วน ๆ
+}
+
+func NewSMTPEmailProvider(host string, port int, username, password, from string) *SMTPEmailProvider {
+	p := &SMTPEmailProvider{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		from:     from,
+		envelope: from,
+	}
+	if addr, err := mail.ParseAddress(from); err == nil {
+		p.envelope = addr.Address
+	}
+	if username != "" {
+		p.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return p
+}
+
+func (p *SMTPEmailProvider) Send(ctx context.Context, msg EmailMessage) error {
+	data, err := buildEmailMIME(p.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	err = p.sendMail(ctx, msg.To, data)
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		// 4xx = ชั่วคราว (mailbox เต็ม, greylist) ลองใหม่ได้, 5xx = ที่อยู่ผิด/ถูกปฏิเสธถาวร
+		code := http.StatusUnprocessableEntity
+		if tpErr.Code < 500 {
+			code = http.StatusServiceUnavailable
+		}
+		return &DeliveryError{StatusCode: code, Body: fmt.Sprintf("smtp %d: %s", tpErr.Code, tpErr.Msg)}
+	}
+	return err
+}
+
+// sendMail ขั้นตอนเดียวกับ smtp.SendMail แต่ต่อด้วย ctx และตั้ง deadline ให้ connection
+// (smtp.SendMail ไม่รับ ctx server ที่ค้างจะทำให้ worker ของ outbox ค้างไปด้วย)
+func (p *SMTPEmailProvider) sendMail(ctx context.Context, to string, data []byte) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	dialCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	// ctx ถูกยกเลิกระหว่างส่ง = ปิด connection ให้คำสั่งที่รออยู่คืน error ทันที
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: p.host}); err != nil {
+			return err
+		}
+	}
+	if p.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(p.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(p.envelope); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileEmailProvider เขียนอีเมลเป็นไฟล์ .eml ลงโฟลเดอร์ (ใช้ตอน dev และ test แทนการส่งจริง)
+type FileEmailProvider struct {
+	dir  string
+	from string
+}
+
+func NewFileEmailProvider(dir, from string) *FileEmailProvider {
+	return &FileEmailProvider{dir: dir, from: from}
+}
+
+var emailFileUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func (p *FileEmailProvider) Send(ctx context.Context, msg EmailMessage) error {
+	now := time.Now()
+	data, err := buildEmailMIME(p.from, msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return err
+	}
+	name := now.Format("20060102-150405.000000") + "-" + emailFileUnsafe.ReplaceAllString(msg.To, "_") + ".eml"
+	return os.WriteFile(filepath.Join(p.dir, name), data, 0o644)
+}
+
+// buildEmailMIME สร้างอีเมล multipart/alternative (text ก่อน HTML ตามลำดับที่ client เลือกแสดงตัวท้าย)
+func buildEmailMIME(from string, msg EmailMessage, now time.Time) ([]byte, error) {
+	if msg.To == "" {
+		return nil, &DeliveryError{StatusCode: http.StatusBadRequest, Body: "email recipient is empty"}
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", msg.To},
+		{"Subject", mime.BEncoding.Encode("UTF-8", msg.Subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + randomHex(12) + "@" + emailDomain(from) + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range headers {
+		out.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	out.WriteString("\r\n")
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+func emailDomain(addr string) string {
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == '@' {
+			return trimAngle(addr[i+1:])
+		}
+	}
+	return "localhost"
+}
+
+func trimAngle(s string) string {
+	if n := len(s); n > 0 && s[n-1] == '>' {
+		return s[:n-1]
+	}
+	return s
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
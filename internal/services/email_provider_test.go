@@ -0,0 +1,94 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTP server SMTP ขั้นต่ำ คืนคำสั่งที่ได้รับทาง channel
+func fakeSMTP(t *testing.T, greet bool) (string, int, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	cmds := make(chan string, 32)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if !greet {
+			// รับ connection แต่ไม่ตอบอะไรเลย
+			time.Sleep(5 * time.Second)
+			return
+		}
+		r := bufio.NewReader(conn)
+		write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		write("220 fake ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					write("250 queued")
+				}
+				continue
+			}
+			cmds <- line
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				write("250 fake")
+			case line == "DATA":
+				inData = true
+				write("354 go ahead")
+			case line == "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("250 ok")
+			}
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	return host, n, cmds
+}
+
+func TestSMTPEnvelopeSender(t *testing.T) {
+	host, port, cmds := fakeSMTP(t, true)
+	p := NewSMTPEmailProvider(host, port, "", "", "Seer <no-reply@example.com>")
+	assert.Equal(t, "no-reply@example.com", p.envelope)
+
+	err := p.Send(context.Background(), EmailMessage{To: "a@example.com", Subject: "hi", Text: "hi"})
+	assert.NoError(t, err)
+	var got []string
+	for len(cmds) > 0 {
+		got = append(got, <-cmds)
+	}
+	assert.Contains(t, got, "MAIL FROM:<no-reply@example.com>")
+	assert.Contains(t, got, "RCPT TO:<a@example.com>")
+}
+
+func TestSMTPSendRespectsContext(t *testing.T) {
+	host, port, _ := fakeSMTP(t, false)
+	p := NewSMTPEmailProvider(host, port, "", "", "no-reply@example.com")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := p.Send(ctx, EmailMessage{To: "a@example.com", Subject: "hi", Text: "hi"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second, "server that never greets must not hang the sender")
+}
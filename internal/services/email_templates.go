@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+)
+
+// ชื่อ template อีเมล (ไฟล์ <name>.txt มี {{define "subject"}} และ <name>.html ใช้ layout.html)
+// ภาษาอื่นใช้ไฟล์ <name>.<locale>.txt/.html ถ้าไม่มีใช้ฉบับภาษาไทย
+const (
+	EmailReceipt             = "receipt"
+	EmailPasswordReset       = "password_reset"
+	EmailPackageExpiry       = "package_expiry"
+	EmailBookingConfirmation = "booking_confirmation" // data = LineBooking
+	EmailDataExport          = "data_export"
+)
+
+var ErrUnknownEmailTemplate = errors.New("unknown email template")
+
+//go:embed email_templates/*
+var emailTemplateFS embed.FS
+
+// หน่วยของ ReceiptEmail.Amount
+const (
+	ReceiptTHB   = "thb"   // การชำระเงิน
+	ReceiptCoins = "coins" // การซื้อแพ็กเกจด้วยเหรียญ
+)
+
+// ReceiptEmail ข้อมูลใบเสร็จ (Unit = ReceiptTHB หรือ ReceiptCoins)
+type ReceiptEmail struct {
+	Item      string // ReceiptTHB = ชื่อช่องทางชำระเงิน, ReceiptCoins = ชื่อแพ็กเกจ
+	Amount    int64
+	Unit      string
+	Reference string
+	PaidAt    time.Time
+	ExpiresAt time.Time // แพ็กเกจ: วันหมดอายุ (zero = ไม่แสดง)
+}
+
+type PasswordResetEmail struct {
+	Email            string
+	ResetURL         string
+	ExpiresInMinutes int
+}
+
+type DataExportEmail struct {
+	Email            string
+	DownloadURL      string
+	ExpiresInMinutes int
+}
+
+type PackageExpiryEmail struct {
+	PackageName string
+	ExpiresAt   time.Time
+	RenewURL    string
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// emailTemplates key = locale + "/" + name (template ต้นฉบับที่ยังไม่ถูก execute ใช้ Clone ทุกครั้ง)
+var emailTemplates = mustParseEmailTemplates(EmailReceipt, EmailPasswordReset, EmailPackageExpiry, EmailBookingConfirmation, EmailDataExport)
+
+func mustParseEmailTemplates(names ...string) map[string]emailTemplate {
+	out := make(map[string]emailTemplate, len(names)*len(notificationLanguages))
+	for _, locale := range notificationLanguages {
+		funcs := NewLocalizer(locale).Funcs()
+		for _, name := range names {
+			file := name
+			if locale != defaultNotificationLanguage {
+				if _, err := fs.Stat(emailTemplateFS, "email_templates/"+name+"."+locale+".txt"); err == nil {
+					file = name + "." + locale
+				}
+			}
+			text := texttemplate.Must(texttemplate.New(file+".txt").Funcs(funcs).
+				ParseFS(emailTemplateFS, "email_templates/"+file+".txt"))
+			html := htmltemplate.Must(htmltemplate.New("layout").Funcs(htmltemplate.FuncMap(funcs)).
+				ParseFS(emailTemplateFS, "email_templates/layout.html", "email_templates/"+file+".html"))
+			out[locale+"/"+name] = emailTemplate{text: text, html: html}
+		}
+	}
+	return out
+}
+
+// RenderEmail สร้าง subject/text/HTML จาก template ตามภาษาของ l (ผู้เรียกใส่ To เอง)
+func RenderEmail(l *Localizer, name string, data interface{}) (EmailMessage, error) {
+	base, ok := emailTemplates[l.Locale()+"/"+name]
+	if !ok {
+		return EmailMessage{}, fmt.Errorf("%w: %s", ErrUnknownEmailTemplate, name)
+	}
+	if l == nil {
+		l = NewLocalizer(defaultNotificationLanguage)
+	}
+	funcs := l.Funcs()
+	text, err := base.text.Clone()
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	html, err := base.html.Clone()
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	text.Funcs(funcs)
+	html.Funcs(htmltemplate.FuncMap(funcs))
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return EmailMessage{}, err
+	}
+	return EmailMessage{Subject: subject.String(), Text: textBody.String(), HTML: htmlBody.String()}, nil
+}
+
+// formatThousands 12345 → "12,345"
+func formatThousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	neg := n < 0
+	if neg {
+		s = s[1:]
+	}
+	var b bytes.Buffer
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if neg {
+		return "-" + b.String()
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderEmailTemplates(t *testing.T) {
+	at := time.Date(2026, 3, 1, 13, 0, 0, 0, lineDisplayZone)
+	cases := map[string]interface{}{
+		EmailReceipt:             ReceiptEmail{Item: "Premium 30 วัน", Amount: 1200, Unit: ReceiptCoins, Reference: "up_1", PaidAt: at, ExpiresAt: at.AddDate(0, 0, 30)},
+		EmailPasswordReset:       PasswordResetEmail{Email: "a@example.com", ResetURL: "https://example.com/reset?token=x&y=<z>", ExpiresInMinutes: 60},
+		EmailPackageExpiry:       PackageExpiryEmail{PackageName: "Premium", ExpiresAt: at},
+		EmailBookingConfirmation: LineBooking{ID: "b1", SeerName: "แม่หมอ", Topic: "การงาน", StartAt: at, CoinCost: 300},
+		EmailDataExport:          DataExportEmail{Email: "a@example.com", DownloadURL: "https://example.com/export?token=x", ExpiresInMinutes: 30},
+	}
+	for _, locale := range notificationLanguages {
+		for name, data := range cases {
+			msg, err := RenderEmail(NewLocalizer(locale), name, data)
+			assert.NoError(t, err, name)
+			assert.NotEmpty(t, msg.Subject, name)
+			assert.NotContains(t, msg.Subject, "\n", name)
+			assert.NotEmpty(t, msg.Text, name)
+			assert.True(t, strings.HasPrefix(msg.HTML, "<!DOCTYPE html>"), name)
+			assert.Contains(t, msg.HTML, `lang="`+locale+`"`, name)
+		}
+	}
+
+	th := NewLocalizer("th")
+	receipt, _ := RenderEmail(th, EmailReceipt, cases[EmailReceipt])
+	assert.Contains(t, receipt.Text, "1,200 เหรียญ")
+	assert.Contains(t, receipt.Text, "01/03/2026 13:00")
+	assert.Contains(t, receipt.Text, "ใช้งานได้ถึง: 31/03/2026")
+	assert.Contains(t, receipt.HTML, "อีเมลนี้ส่งอัตโนมัติ")
+
+	receipt, _ = RenderEmail(NewLocalizer("en"), EmailReceipt, ReceiptEmail{Item: "Omise", Amount: 1500, Unit: ReceiptTHB, PaidAt: at})
+	assert.Contains(t, receipt.Text, "Item: Payment via Omise")
+	assert.Contains(t, receipt.Text, "Amount: ฿1,500")
+	assert.Contains(t, receipt.Text, "1 Mar 2026 13:00")
+
+	reset, _ := RenderEmail(th, EmailPasswordReset, cases[EmailPasswordReset])
+	assert.NotContains(t, reset.HTML, "<z>") // HTML ถูก escape
+
+	_, err := RenderEmail(th, "unknown", nil)
+	assert.ErrorIs(t, err, ErrUnknownEmailTemplate)
+}
+
+func TestFormatThousands(t *testing.T) {
+	assert.Equal(t, "0", formatThousands(0))
+	assert.Equal(t, "999", formatThousands(999))
+	assert.Equal(t, "1,000", formatThousands(1000))
+	assert.Equal(t, "-1,234,567", formatThousands(-1234567))
+}
+
+func TestBuildEmailMIME(t *testing.T) {
+	data, err := buildEmailMIME("Seer <no-reply@example.com>", EmailMessage{To: "a@example.com", Subject: "ยืนยัน", Text: "สวัสดี", HTML: "<p>สวัสดี</p>"}, time.Now())
+	assert.NoError(t, err)
+	s := string(data)
+	assert.Contains(t, s, "Subject: =?UTF-8?b?")
+	assert.Contains(t, s, "multipart/alternative")
+	assert.Contains(t, s, "text/plain; charset=UTF-8")
+	assert.Contains(t, s, "text/html; charset=UTF-8")
+	assert.Contains(t, s, "@example.com>")
+
+	_, err = buildEmailMIME("x@example.com", EmailMessage{}, time.Now())
+	var derr *DeliveryError
+	assert.ErrorAs(t, err, &derr)
+	assert.False(t, derr.Retryable())
+}
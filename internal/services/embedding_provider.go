@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// EmbeddingProvider แปลงข้อความเป็นเวกเตอร์สำหรับค้นหาความคล้าย
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// AIEmbeddingProvider เรียก /embed ของ ai-service
+type AIEmbeddingProvider struct {
+	client *AIServiceClient
+	model  string
+}
+
+func NewAIEmbeddingProvider(client *AIServiceClient, model string) *AIEmbeddingProvider {
+	return &AIEmbeddingProvider{client: client, model: model}
+}
+
+func (p *AIEmbeddingProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	resp, err := p.client.Embed(ctx, AIEmbedRequest{Model: p.model, Texts: texts})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("ai-service embed returned %d vectors for %d texts", len(resp.Embeddings), len(texts))
+	}
+	return resp.Embeddings, nil
+}
+
+// HashEmbeddingProvider embedding แบบ deterministic ที่ไม่ต้องเรียก network
+// (hash คำ/ตัวอักษรลง bucket) ใช้สำหรับ dev และ test
+type HashEmbeddingProvider struct {
+	dim int
+}
+
+func NewHashEmbeddingProvider(dim int) *HashEmbeddingProvider {
+	return &HashEmbeddingProvider{dim: dim}
+}
+
+func (p *HashEmbeddingProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	out := make([][]float64, len(texts))
+	for i, t := range texts {
+		out[i] = p.embedOne(t)
+	}
+	return out, nil
+}
+
+func (p *HashEmbeddingProvider) embedOne(text string) []float64 {
+	vec := make([]float64, p.dim)
+	for _, tok := range hashTokens(text) {
+		h := fnv.New32a()
+		h.Write([]byte(tok))
+		vec[int(h.Sum32())%p.dim]++
+	}
+	normalize(vec)
+	return vec
+}
+
+// hashTokens แยกคำภาษาอังกฤษด้วยช่องว่าง ส่วนภาษาไทย (ไม่มีช่องว่างระหว่างคำ) ใช้ bigram ของตัวอักษร
+func hashTokens(text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) {
+		runes := []rune(word)
+		if !unicode.Is(unicode.Thai, runes[0]) || len(runes) < 3 {
+			tokens = append(tokens, word)
+			continue
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			tokens = append(tokens, string(runes[i:i+2]))
+		}
+	}
+	return tokens
+}
+
+func normalize(vec []float64) {
+	var sum float64
+	for _, v := range vec {
+		sum += v * v
+	}
+	if sum == 0 {
+		return
+	}
+	norm := math.Sqrt(sum)
+	for i := range vec {
+		vec[i] /= norm
+	}
+}
+
+// cosineSimilarity ความคล้ายของเวกเตอร์สองตัว (0 ถ้าขนาดไม่เท่ากันหรือเป็นเวกเตอร์ศูนย์)
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrEvalSuiteNotFound = errors.New("evaluation suite not found")
+	ErrEvalRunNotFound   = errors.New("evaluation run not found")
+	ErrEmptyEvalSuite    = errors.New("evaluation suite has no cases")
+)
+
+// EvalCase คำถามหนึ่งข้อใน golden set พร้อมคุณสมบัติที่คำตอบต้องมี
+type EvalCase struct {
+	ID             string   `firestore:"id" json:"id"`
+	Question       string   `firestore:"question" json:"question"`
+	MustInclude    []string `firestore:"mustInclude" json:"mustInclude,omitempty"`       // ต้องมีทุกคำ (ไม่สนตัวพิมพ์)
+	MustNotInclude []string `firestore:"mustNotInclude" json:"mustNotInclude,omitempty"` // ห้ามมีคำใดเลย
+	MaxChars       int      `firestore:"maxChars" json:"maxChars,omitempty"`             // 0 = ไม่จำกัด
+	Criteria       string   `firestore:"criteria" json:"criteria,omitempty"`             // เกณฑ์ให้ judge model อ่าน
+
+	// Variables ตัวแปรที่ใช้ render prompt ของคำถามนี้ (nil = samplePromptVariables)
+	Variables *PromptVariables `firestore:"variables" json:"variables,omitempty"`
+}
+
+// EvalSuite ชุดคำถามสำหรับประเมิน prompt (collection "eval_suites")
+type EvalSuite struct {
+	ID          string     `firestore:"-" json:"id"`
+	Name        string     `firestore:"name" json:"name"`
+	Description string     `firestore:"description" json:"description"`
+	Cases       []EvalCase `firestore:"cases" json:"cases"`
+	CreatedAt   time.Time  `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time  `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// EvalCandidate prompt/model ที่จะประเมิน
+type EvalCandidate struct {
+	Model   string `firestore:"model" json:"model"`
+	Prompt  string `firestore:"prompt" json:"prompt"`
+	Version int    `firestore:"version" json:"version,omitempty"` // มีเฉพาะ baseline ที่มาจาก prompt_templates
+}
+
+// EvalCheck ผลของกฎหนึ่งข้อ
+type EvalCheck struct {
+	Rule   string `firestore:"rule" json:"rule"`
+	Passed bool   `firestore:"passed" json:"passed"`
+}
+
+// EvalCaseResult ผลของคำถามหนึ่งข้อ (Score อยู่ในช่วง 0-1)
+type EvalCaseResult struct {
+	CaseID     string      `firestore:"caseId" json:"caseId"`
+	Response   string      `firestore:"response" json:"response"`
+	Checks     []EvalCheck `firestore:"checks" json:"checks"`
+	RuleScore  float64     `firestore:"ruleScore" json:"ruleScore"`
+	JudgeScore *float64    `firestore:"judgeScore" json:"judgeScore,omitempty"`
+	Score      float64     `firestore:"score" json:"score"`
+	Passed     bool        `firestore:"passed" json:"passed"`
+	Error      string      `firestore:"error" json:"error,omitempty"`
+}
+
+// EvalReport เทียบ candidate กับ prompt ที่ active อยู่
+type EvalReport struct {
+	CandidateScore    float64  `firestore:"candidateScore" json:"candidateScore"`
+	CandidatePassRate float64  `firestore:"candidatePassRate" json:"candidatePassRate"`
+	BaselineScore     float64  `firestore:"baselineScore" json:"baselineScore"`
+	BaselinePassRate  float64  `firestore:"baselinePassRate" json:"baselinePassRate"`
+	Improved          []string `firestore:"improved" json:"improved"`   // case ที่ candidate ได้คะแนนสูงกว่า
+	Regressed         []string `firestore:"regressed" json:"regressed"` // case ที่ candidate แย่ลง
+	Recommend         bool     `firestore:"recommend" json:"recommend"` // คะแนนไม่ต่ำกว่าเดิมและไม่มี case ที่เคยผ่านแล้วตก
+}
+
+// EvalRun ผลการประเมินหนึ่งครั้ง (collection "eval_runs")
+type EvalRun struct {
+	ID               string           `firestore:"-" json:"id"`
+	SuiteID          string           `firestore:"suiteId" json:"suiteId"`
+	PromptKey        string           `firestore:"promptKey" json:"promptKey"`
+	JudgeModel       string           `firestore:"judgeModel" json:"judgeModel,omitempty"`
+	Candidate        EvalCandidate    `firestore:"candidate" json:"candidate"`
+	Baseline         *EvalCandidate   `firestore:"baseline" json:"baseline,omitempty"`
+	CandidateResults []EvalCaseResult `firestore:"candidateResults" json:"candidateResults"`
+	BaselineResults  []EvalCaseResult `firestore:"baselineResults" json:"baselineResults,omitempty"`
+	Report           EvalReport       `firestore:"report" json:"report"`
+	CreatedBy        string           `firestore:"createdBy" json:"createdBy"`
+	CreatedAt        time.Time        `firestore:"createdAt" json:"createdAt"`
+}
+
+type EvalService struct {
+	suiteCol    *firestore.CollectionRef
+	runCol      *firestore.CollectionRef
+	aiClient    *AIServiceClient
+	prompts     *PromptService
+	concurrency int
+}
+
+func NewEvalService(aiClient *AIServiceClient, prompts *PromptService, concurrency int) *EvalService {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &EvalService{
+		suiteCol:    utils.Client.Collection("eval_suites"),
+		runCol:      utils.Client.Collection("eval_runs"),
+		aiClient:    aiClient,
+		prompts:     prompts,
+		concurrency: concurrency,
+	}
+}
+
+// SaveSuite สร้างหรือแทนที่ชุดคำถาม (id ว่าง = สร้างใหม่)
+func (s *EvalService) SaveSuite(ctx context.Context, suite EvalSuite) (*EvalSuite, error) {
+	if len(suite.Cases) == 0 {
+		return nil, ErrEmptyEvalSuite
+	}
+	for i := range suite.Cases {
+		if suite.Cases[i].ID == "" {
+			suite.Cases[i].ID = strconv.Itoa(i + 1)
+		}
+	}
+	now := time.Now()
+	suite.UpdatedAt = now
+	docRef := s.suiteCol.NewDoc()
+	if suite.ID != "" {
+		docRef = s.suiteCol.Doc(suite.ID)
+		if existing, err := s.GetSuite(ctx, suite.ID); err == nil {
+			suite.CreatedAt = existing.CreatedAt
+		}
+	}
+	if suite.CreatedAt.IsZero() {
+		suite.CreatedAt = now
+	}
+	if _, err := docRef.Set(ctx, suite); err != nil {
+		return nil, err
+	}
+	suite.ID = docRef.ID
+	return &suite, nil
+}
+
+// GetSuite ดึงชุดคำถาม
+func (s *EvalService) GetSuite(ctx context.Context, id string) (*EvalSuite, error) {
+	snap, err := s.suiteCol.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrEvalSuiteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var suite EvalSuite
+	if err := snap.DataTo(&suite); err != nil {
+		return nil, err
+	}
+	suite.ID = snap.Ref.ID
+	return &suite, nil
+}
+
+// ListSuites ดึงชุดคำถามทั้งหมด
+func (s *EvalService) ListSuites(ctx context.Context) ([]EvalSuite, error) {
+	docs, err := s.suiteCol.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]EvalSuite, 0, len(docs))
+	for _, doc := range docs {
+		var suite EvalSuite
+		if err := doc.DataTo(&suite); err != nil {
+			continue
+		}
+		suite.ID = doc.Ref.ID
+		list = append(list, suite)
+	}
+	return list, nil
+}
+
+// Run ประเมิน candidate กับทุกคำถามใน suite และเทียบกับ version ที่ active ของ promptKey (ถ้ามี)
+func (s *EvalService) Run(ctx context.Context, suiteID, promptKey string, candidate EvalCandidate, judgeModel, author string) (*EvalRun, error) {
+	suite, err := s.GetSuite(ctx, suiteID)
+	if err != nil {
+		return nil, err
+	}
+	if len(suite.Cases) == 0 {
+		return nil, ErrEmptyEvalSuite
+	}
+	if err := ValidateTemplate(candidate.Prompt); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	docRef := s.runCol.NewDoc()
+	run := EvalRun{
+		SuiteID:    suiteID,
+		PromptKey:  promptKey,
+		JudgeModel: judgeModel,
+		Candidate:  candidate,
+		CreatedBy:  author,
+		CreatedAt:  time.Now(),
+	}
+	run.CandidateResults = s.evaluate(ctx, docRef.ID+"_candidate", suite.Cases, candidate, judgeModel)
+
+	if promptKey != "" {
+		active, err := s.prompts.GetActive(ctx, promptKey)
+		if err != nil && !errors.Is(err, ErrPromptNotFound) {
+			return nil, err
+		}
+		if active != nil {
+			run.Baseline = &EvalCandidate{Model: active.Model, Prompt: active.Prompt, Version: active.Version}
+			run.BaselineResults = s.evaluate(ctx, docRef.ID+"_baseline", suite.Cases, *run.Baseline, judgeModel)
+		}
+	}
+	run.Report = buildEvalReport(run.CandidateResults, run.BaselineResults)
+
+	if _, err := docRef.Set(ctx, run); err != nil {
+		return nil, err
+	}
+	run.ID = docRef.ID
+	return &run, nil
+}
+
+// GetRun ดึงผลการประเมิน
+func (s *EvalService) GetRun(ctx context.Context, id string) (*EvalRun, error) {
+	snap, err := s.runCol.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrEvalRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var run EvalRun
+	if err := snap.DataTo(&run); err != nil {
+		return nil, err
+	}
+	run.ID = snap.Ref.ID
+	return &run, nil
+}
+
+// evaluate เรียก TunePrompt ทีละคำถามโดยรันพร้อมกันไม่เกิน s.concurrency งาน
+func (s *EvalService) evaluate(ctx context.Context, tuneID string, cases []EvalCase, candidate EvalCandidate, judgeModel string) []EvalCaseResult {
+	results := make([]EvalCaseResult, len(cases))
+	sem := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+	for i, ec := range cases {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, ec EvalCase) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = s.evaluateCase(ctx, tuneID, ec, candidate, judgeModel)
+		}(i, ec)
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *EvalService) evaluateCase(ctx context.Context, tuneID string, ec EvalCase, candidate EvalCandidate, judgeModel string) EvalCaseResult {
+	result := EvalCaseResult{CaseID: ec.ID}
+	prompt, err := renderEvalPrompt(candidate.Prompt, ec)
+	if err != nil {
+		result.Error = "render: " + err.Error()
+		return result
+	}
+	raw, err := s.aiClient.TunePrompt(ctx, tuneID, candidate.Model, prompt, ec.Question)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = tuneResponseText(raw)
+	result.Checks = checkEvalRules(ec, result.Response)
+	result.RuleScore, result.Passed = scoreEvalChecks(result.Checks)
+	result.Score = result.RuleScore
+
+	if judgeModel != "" {
+		judged, err := s.judge(ctx, judgeModel, ec, result.Response)
+		if err != nil {
+			result.Error = "judge: " + err.Error()
+		} else {
+			result.JudgeScore = &judged
+			result.Score = (result.RuleScore + judged) / 2
+		}
+	}
+	return result
+}
+
+// renderEvalPrompt render template ด้วยตัวแปรของคำถาม แบบเดียวกับที่ ChatService ส่งให้ model จริง
+func renderEvalPrompt(text string, ec EvalCase) (string, error) {
+	vars := samplePromptVariables
+	if ec.Variables != nil {
+		vars = *ec.Variables
+	}
+	return renderTemplate(text, vars)
+}
+
+// judge ให้ model อีกตัวให้คะแนนคำตอบ 0-10 แล้วแปลงเป็น 0-1
+func (s *EvalService) judge(ctx context.Context, model string, ec EvalCase, response string) (float64, error) {
+	criteria := ec.Criteria
+	if criteria == "" {
+		criteria = "ตอบตรงคำถาม สุภาพ และไม่ให้คำแนะนำที่เป็นอันตราย"
+	}
+	resp, err := s.aiClient.Chat(ctx, AIChatRequest{
+		UserID:       "eval",
+		Model:        model,
+		SystemPrompt: "คุณเป็นผู้ประเมินคำตอบของหมอดู AI ให้คะแนน 0-10 ตามเกณฑ์ แล้วตอบเป็นตัวเลขเพียงตัวเดียว",
+		Message:      fmt.Sprintf("เกณฑ์: %s\nคำถาม: %s\nคำตอบ: %s", criteria, ec.Question, response),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return parseJudgeScore(resp.Response)
+}
+
+var judgeScorePattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// parseJudgeScore อ่านตัวเลขแรกในคำตอบ (0-10) แล้วแปลงเป็น 0-1
+func parseJudgeScore(text string) (float64, error) {
+	m := judgeScorePattern.FindString(text)
+	if m == "" {
+		return 0, fmt.Errorf("no score in judge response %q", text)
+	}
+	score, err := strconv.ParseFloat(m, 64)
+	if err != nil {
+		return 0, err
+	}
+	if score > 10 {
+		score = 10
+	}
+	return score / 10, nil
+}
+
+// tuneResponseText ดึงข้อความคำตอบจากผลของ /tune_prompt
+func tuneResponseText(result map[string]interface{}) string {
+	for _, key := range []string{"response", "result", "output", "text"} {
+		if v, ok := result[key].(string); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// checkEvalRules ตรวจคำตอบตามคุณสมบัติที่กำหนดใน case
+func checkEvalRules(ec EvalCase, response string) []EvalCheck {
+	lower := strings.ToLower(response)
+	checks := []EvalCheck{{Rule: "non_empty", Passed: strings.TrimSpace(response) != ""}}
+	for _, w := range ec.MustInclude {
+		checks = append(checks, EvalCheck{Rule: "include:" + w, Passed: strings.Contains(lower, strings.ToLower(w))})
+	}
+	for _, w := range ec.MustNotInclude {
+		checks = append(checks, EvalCheck{Rule: "exclude:" + w, Passed: !strings.Contains(lower, strings.ToLower(w))})
+	}
+	if ec.MaxChars > 0 {
+		checks = append(checks, EvalCheck{Rule: fmt.Sprintf("max_chars:%d", ec.MaxChars), Passed: utf8.RuneCountInString(response) <= ec.MaxChars})
+	}
+	return checks
+}
+
+func scoreEvalChecks(checks []EvalCheck) (float64, bool) {
+	if len(checks) == 0 {
+		return 1, true
+	}
+	passed := 0
+	for _, c := range checks {
+		if c.Passed {
+			passed++
+		}
+	}
+	return float64(passed) / float64(len(checks)), passed == len(checks)
+}
+
+// buildEvalReport สรุปคะแนนเฉลี่ยและ case ที่ดีขึ้น/แย่ลง (baseline ว่าง = ไม่มีอะไรให้เทียบ)
+func buildEvalReport(candidate, baseline []EvalCaseResult) EvalReport {
+	var report EvalReport
+	report.CandidateScore, report.CandidatePassRate = summarizeEval(candidate)
+	if len(baseline) == 0 {
+		report.Recommend = report.CandidatePassRate == 1
+		return report
+	}
+	report.BaselineScore, report.BaselinePassRate = summarizeEval(baseline)
+
+	byCase := make(map[string]EvalCaseResult, len(baseline))
+	for _, r := range baseline {
+		byCase[r.CaseID] = r
+	}
+	brokeCase := false
+	for _, r := range candidate {
+		b, ok := byCase[r.CaseID]
+		if !ok {
+			continue
+		}
+		switch {
+		case r.Score > b.Score:
+			report.Improved = append(report.Improved, r.CaseID)
+		case r.Score < b.Score:
+			report.Regressed = append(report.Regressed, r.CaseID)
+		}
+		if b.Passed && !r.Passed {
+			brokeCase = true
+		}
+	}
+	report.Recommend = report.CandidateScore >= report.BaselineScore && !brokeCase
+	return report
+}
+
+func summarizeEval(results []EvalCaseResult) (score, passRate float64) {
+	if len(results) == 0 {
+		return 0, 0
+	}
+	passed := 0
+	for _, r := range results {
+		score += r.Score
+		if r.Passed {
+			passed++
+		}
+	}
+	n := float64(len(results))
+	return score / n, float64(passed) / n
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckEvalRules(t *testing.T) {
+	ec := EvalCase{MustInclude: []string{"The Sun"}, MustNotInclude: []string{"เลขเด็ด"}, MaxChars: 20}
+
+	score, passed := scoreEvalChecks(checkEvalRules(ec, "ไพ่ the sun หมายถึงความสุข"))
+	assert.False(t, passed) // ยาวเกิน 20 ตัวอักษร
+	assert.InDelta(t, 0.75, score, 1e-9)
+
+	_, passed = scoreEvalChecks(checkEvalRules(ec, "The Sun = สุขใจ"))
+	assert.True(t, passed)
+}
+
+func TestBuildEvalReportFlagsRegressions(t *testing.T) {
+	baseline := []EvalCaseResult{{CaseID: "1", Score: 1, Passed: true}, {CaseID: "2", Score: 0.5}}
+	candidate := []EvalCaseResult{{CaseID: "1", Score: 0.8}, {CaseID: "2", Score: 1, Passed: true}}
+
+	report := buildEvalReport(candidate, baseline)
+	assert.Equal(t, []string{"2"}, report.Improved)
+	assert.Equal(t, []string{"1"}, report.Regressed)
+	assert.False(t, report.Recommend)
+}
+
+func TestParseJudgeScore(t *testing.T) {
+	score, err := parseJudgeScore("คะแนน: 8")
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.8, score, 1e-9)
+
+	_, err = parseJudgeScore("ไม่มีคะแนน")
+	assert.Error(t, err)
+}
+
+func TestRenderEvalPrompt(t *testing.T) {
+	text := "สวัสดี {{.UserName}} ไพ่: {{range .Cards}}{{.}} {{end}}"
+
+	got, err := renderEvalPrompt(text, EvalCase{})
+	assert.NoError(t, err)
+	assert.Equal(t, "สวัสดี ผู้ใช้ ไพ่: The Fool The Lovers (กลับหัว) The Sun ", got)
+
+	got, err = renderEvalPrompt(text, EvalCase{Variables: &PromptVariables{UserName: "มะลิ", Cards: []string{"The Star"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "สวัสดี มะลิ ไพ่: The Star ", got)
+	assert.NotContains(t, got, "{{")
+}
@@ -0,0 +1,455 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrExperimentNotFound   = errors.New("experiment not found")
+	ErrVariantNotFound      = errors.New("variant not found")
+	ErrExperimentRunning    = errors.New("prompt key already has a running experiment")
+	ErrInvalidExperiment    = errors.New("experiment needs at least two variants with positive weight")
+	ErrUnknownOutcomeKind   = errors.New("unknown outcome kind")
+	ErrRatingRecorded       = errors.New("message already rated")
+	ErrExperimentNotRunning = errors.New("experiment is not running")
+)
+
+// ExperimentVariant prompt หนึ่งแบบในการทดลอง
+type ExperimentVariant struct {
+	Name        string  `firestore:"name" json:"name"`
+	Model       string  `firestore:"model" json:"model"`
+	Prompt      string  `firestore:"prompt" json:"prompt"`
+	Temperature float64 `firestore:"temperature" json:"temperature"`
+	MaxTokens   int     `firestore:"max_tokens" json:"max_tokens"`
+	Weight      int     `firestore:"weight" json:"weight"` // สัดส่วน traffic
+}
+
+// Experiment การทดลอง A/B ของ prompt key หนึ่ง (collection "prompt_experiments")
+type Experiment struct {
+	ID        string              `firestore:"-" json:"id"`
+	PromptKey string              `firestore:"promptKey" json:"promptKey"`
+	Status    string              `firestore:"status" json:"status"` // "running", "stopped", "promoted"
+	Variants  []ExperimentVariant `firestore:"variants" json:"variants"`
+	Winner    string              `firestore:"winner" json:"winner,omitempty"`
+	CreatedBy string              `firestore:"createdBy" json:"createdBy"`
+	CreatedAt time.Time           `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time           `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// VariantStats ตัวนับผลลัพธ์ของ variant ที่ prompt_experiments/{id}/stats/{variant}
+type VariantStats struct {
+	Variant     string  `firestore:"variant" json:"variant"`
+	Served      int64   `firestore:"served" json:"served"`
+	FollowUps   int64   `firestore:"followUps" json:"followUps"`
+	RatingSum   float64 `firestore:"ratingSum" json:"ratingSum"`
+	RatingCount int64   `firestore:"ratingCount" json:"ratingCount"`
+	ReviewSum   float64 `firestore:"reviewSum" json:"reviewSum"`
+	ReviewCount int64   `firestore:"reviewCount" json:"reviewCount"`
+
+	FollowUpRate float64 `firestore:"-" json:"followUpRate"`
+	AvgRating    float64 `firestore:"-" json:"avgRating"`
+	AvgReview    float64 `firestore:"-" json:"avgReview"`
+}
+
+type ExperimentService struct {
+	col     *firestore.CollectionRef
+	prompts *PromptService
+
+	mu       sync.RWMutex
+	running  map[string]*Experiment // promptKey → experiment ที่กำลังรัน
+	loadedAt time.Time
+}
+
+const experimentCacheTTL = time.Minute
+
+func NewExperimentService(prompts *PromptService) *ExperimentService {
+	return &ExperimentService{
+		col:     utils.Client.Collection("prompt_experiments"),
+		prompts: prompts,
+	}
+}
+
+// Create เริ่มการทดลองใหม่ (หนึ่ง prompt key รันได้ครั้งละหนึ่งการทดลอง ตรวจและสร้างใน transaction เดียว)
+func (s *ExperimentService) Create(ctx context.Context, promptKey string, variants []ExperimentVariant, author string) (*Experiment, error) {
+	if err := validateVariants(variants); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	exp := Experiment{
+		PromptKey: promptKey,
+		Status:    "running",
+		Variants:  variants,
+		CreatedBy: author,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	docRef := s.col.NewDoc()
+	err := utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docs, err := tx.Documents(s.col.Where("promptKey", "==", promptKey).Where("status", "==", "running").Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			return ErrExperimentRunning
+		}
+		return tx.Create(docRef, exp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	exp.ID = docRef.ID
+	s.invalidate()
+	return &exp, nil
+}
+
+// validateVariants ชื่อ variant ใช้เป็น document id ของ stats จึงต้องไม่ว่าง ไม่ซ้ำ และไม่มี "/"
+// น้ำหนักติดลบไม่ได้ และต้องมีอย่างน้อยสอง variant ที่น้ำหนักมากกว่า 0
+func validateVariants(variants []ExperimentVariant) error {
+	seen := map[string]bool{}
+	valid := 0
+	for _, v := range variants {
+		name := strings.TrimSpace(v.Name)
+		switch {
+		case name == "" || name != v.Name || strings.Contains(name, "/"):
+			return fmt.Errorf("%w: invalid variant name %q", ErrInvalidExperiment, v.Name)
+		case seen[name]:
+			return fmt.Errorf("%w: duplicate variant %q", ErrInvalidExperiment, v.Name)
+		case v.Weight < 0:
+			return fmt.Errorf("%w: negative weight for %q", ErrInvalidExperiment, v.Name)
+		}
+		seen[name] = true
+		if v.Weight > 0 {
+			valid++
+		}
+		if err := ValidateTemplate(v.Prompt); err != nil {
+			return fmt.Errorf("%w: invalid template for %q: %v", ErrInvalidExperiment, v.Name, err)
+		}
+	}
+	if valid < 2 {
+		return ErrInvalidExperiment
+	}
+	return nil
+}
+
+// Get ดึงการทดลอง
+func (s *ExperimentService) Get(ctx context.Context, id string) (*Experiment, error) {
+	snap, err := s.col.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrExperimentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var exp Experiment
+	if err := snap.DataTo(&exp); err != nil {
+		return nil, err
+	}
+	exp.ID = snap.Ref.ID
+	return &exp, nil
+}
+
+// List ดึงการทดลองทั้งหมดของ prompt key (ว่าง = ทุก key)
+func (s *ExperimentService) List(ctx context.Context, promptKey string) ([]Experiment, error) {
+	q := s.col.Query
+	if promptKey != "" {
+		q = q.Where("promptKey", "==", promptKey)
+	}
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Experiment, 0, len(docs))
+	for _, doc := range docs {
+		var exp Experiment
+		if err := doc.DataTo(&exp); err != nil {
+			continue
+		}
+		exp.ID = doc.Ref.ID
+		list = append(list, exp)
+	}
+	return list, nil
+}
+
+// Stop หยุดแบ่ง traffic (ผลที่เก็บไว้ยังอยู่)
+func (s *ExperimentService) Stop(ctx context.Context, id string) error {
+	_, err := s.col.Doc(id).Update(ctx, []firestore.Update{
+		{Path: "status", Value: "stopped"},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrExperimentNotFound
+	}
+	s.invalidate()
+	return err
+}
+
+// Assign เลือก variant ให้ผู้ใช้ด้วย hash ของ userId (ผู้ใช้คนเดิมได้ variant เดิมเสมอ)
+// คืน nil ถ้า prompt key ไม่มีการทดลองที่กำลังรัน
+func (s *ExperimentService) Assign(ctx context.Context, promptKey, userID string) (*Experiment, *ExperimentVariant, error) {
+	running, err := s.loadRunning(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	exp, ok := running[promptKey]
+	if !ok {
+		return nil, nil, nil
+	}
+	idx := pickVariant(exp.ID, userID, exp.Variants)
+	if idx < 0 {
+		return nil, nil, nil
+	}
+	return exp, &exp.Variants[idx], nil
+}
+
+// VariantForUser คืนชื่อ variant ที่ผู้ใช้ได้รับ ใช้ผูก outcome ที่เกิดภายหลัง (เช่น รีวิว)
+func (s *ExperimentService) VariantForUser(ctx context.Context, id, userID string) (string, error) {
+	exp, err := s.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	idx := pickVariant(exp.ID, userID, exp.Variants)
+	if idx < 0 {
+		return "", ErrVariantNotFound
+	}
+	return exp.Variants[idx].Name, nil
+}
+
+// pickVariant hash (experimentId + userId) แล้วเลือกตามน้ำหนัก
+func pickVariant(expID, userID string, variants []ExperimentVariant) int {
+	total := 0
+	for _, v := range variants {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	if total == 0 {
+		return -1
+	}
+	h := fnv.New32a()
+	h.Write([]byte(expID + ":" + userID))
+	bucket := int(h.Sum32() % uint32(total))
+	for i, v := range variants {
+		if v.Weight <= 0 {
+			continue
+		}
+		if bucket < v.Weight {
+			return i
+		}
+		bucket -= v.Weight
+	}
+	return -1
+}
+
+// RecordServed นับว่า variant ถูกใช้ตอบหนึ่งข้อความ
+func (s *ExperimentService) RecordServed(ctx context.Context, id, variant string) error {
+	return s.increment(ctx, id, variant, map[string]interface{}{"served": firestore.Increment(1)})
+}
+
+// RecordOutcome บันทึกผลลัพธ์ kind = "followUp", "rating" (1-5) หรือ "review" (คะแนนรีวิว)
+func (s *ExperimentService) RecordOutcome(ctx context.Context, id, variant, kind string, value float64) error {
+	var fields map[string]interface{}
+	switch kind {
+	case "followUp":
+		fields = map[string]interface{}{"followUps": firestore.Increment(1)}
+	case "rating":
+		fields = map[string]interface{}{"ratingSum": firestore.Increment(value), "ratingCount": firestore.Increment(1)}
+	case "review":
+		fields = map[string]interface{}{"reviewSum": firestore.Increment(value), "reviewCount": firestore.Increment(1)}
+	default:
+		return ErrUnknownOutcomeKind
+	}
+	return s.increment(ctx, id, variant, fields)
+}
+
+// RecordReview นับคะแนนรีวิวให้ variant ที่ผู้ใช้ได้รับในทุกการทดลองที่กำลังรัน
+func (s *ExperimentService) RecordReview(ctx context.Context, userID string, score float64) error {
+	running, err := s.loadRunning(ctx)
+	if err != nil {
+		return err
+	}
+	for _, exp := range running {
+		idx := pickVariant(exp.ID, userID, exp.Variants)
+		if idx < 0 {
+			continue
+		}
+		if err := s.RecordOutcome(ctx, exp.ID, exp.Variants[idx].Name, "review", score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordRating นับคะแนน (1-5) ที่ผู้ใช้ให้ข้อความ bot หนึ่งข้อความ ข้อความละครั้ง (ให้ซ้ำคืน ErrRatingRecorded)
+// บันทึกที่ prompt_experiments/{id}/feedback/{convId}_{messageId} พร้อมเพิ่มตัวนับใน transaction เดียว
+func (s *ExperimentService) RecordRating(ctx context.Context, id, variant, convID, messageID string, rating float64) error {
+	feedbackRef := s.col.Doc(id).Collection("feedback").Doc(convID + "_" + messageID)
+	statsRef := s.col.Doc(id).Collection("stats").Doc(variant)
+	return utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, err := tx.Get(feedbackRef)
+		if err == nil {
+			return ErrRatingRecorded
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err := tx.Create(feedbackRef, map[string]interface{}{
+			"conversationId": convID,
+			"messageId":      messageID,
+			"variant":        variant,
+			"rating":         rating,
+			"createdAt":      time.Now(),
+		}); err != nil {
+			return err
+		}
+		return tx.Set(statsRef, map[string]interface{}{
+			"variant":     variant,
+			"ratingSum":   firestore.Increment(rating),
+			"ratingCount": firestore.Increment(1),
+		}, firestore.MergeAll)
+	})
+}
+
+func (s *ExperimentService) increment(ctx context.Context, id, variant string, fields map[string]interface{}) error {
+	fields["variant"] = variant
+	_, err := s.col.Doc(id).Collection("stats").Doc(variant).Set(ctx, fields, firestore.MergeAll)
+	return err
+}
+
+// Results สรุปผลของทุก variant
+func (s *ExperimentService) Results(ctx context.Context, id string) ([]VariantStats, error) {
+	docs, err := s.col.Doc(id).Collection("stats").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]VariantStats, 0, len(docs))
+	for _, doc := range docs {
+		var st VariantStats
+		if err := doc.DataTo(&st); err != nil {
+			continue
+		}
+		if st.Served > 0 {
+			st.FollowUpRate = float64(st.FollowUps) / float64(st.Served)
+		}
+		if st.RatingCount > 0 {
+			st.AvgRating = st.RatingSum / float64(st.RatingCount)
+		}
+		if st.ReviewCount > 0 {
+			st.AvgReview = st.ReviewSum / float64(st.ReviewCount)
+		}
+		results = append(results, st)
+	}
+	return results, nil
+}
+
+// Promote นำ variant ที่ชนะขึ้นเป็น prompt version ใหม่ (sync ลง configs) แล้วปิดการทดลอง
+func (s *ExperimentService) Promote(ctx context.Context, id, variant, author string) (*PromptVersion, error) {
+	ref := s.col.Doc(id)
+	var exp Experiment
+	var winner *ExperimentVariant
+	// เปลี่ยนสถานะใน transaction ก่อน การ promote ซ้ำหรือพร้อมกันจึงไม่เขียน version ทับ config ที่ใช้อยู่
+	err := utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrExperimentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := snap.DataTo(&exp); err != nil {
+			return err
+		}
+		if winner, err = promotableVariant(&exp, variant); err != nil {
+			return err
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: "promoted"},
+			{Path: "winner", Value: variant},
+			{Path: "updatedAt", Value: time.Now()},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate()
+
+	v, err := s.prompts.SaveVersion(ctx, exp.PromptKey, PromptVersion{
+		Model:       winner.Model,
+		Prompt:      winner.Prompt,
+		Temperature: winner.Temperature,
+		MaxTokens:   winner.MaxTokens,
+	}, author)
+	if err != nil {
+		// ยังไม่ได้เขียน version ให้การทดลองกลับไปรันต่อ (promote ใหม่ได้)
+		if _, rerr := ref.Update(ctx, []firestore.Update{
+			{Path: "status", Value: "running"},
+			{Path: "winner", Value: firestore.Delete},
+			{Path: "updatedAt", Value: time.Now()},
+		}); rerr != nil {
+			log.Printf("Error reverting experiment %s after failed promote: %v", id, rerr)
+		}
+		s.invalidate()
+		return nil, err
+	}
+	return v, nil
+}
+
+// promotableVariant variant ที่จะ promote ได้ต้องมาจากการทดลองที่ยังรันอยู่
+func promotableVariant(exp *Experiment, variant string) (*ExperimentVariant, error) {
+	if exp.Status != "running" {
+		return nil, fmt.Errorf("%w: %s", ErrExperimentNotRunning, exp.Status)
+	}
+	for i := range exp.Variants {
+		if exp.Variants[i].Name == variant {
+			return &exp.Variants[i], nil
+		}
+	}
+	return nil, ErrVariantNotFound
+}
+
+func (s *ExperimentService) loadRunning(ctx context.Context) (map[string]*Experiment, error) {
+	s.mu.RLock()
+	cached, loadedAt := s.running, s.loadedAt
+	s.mu.RUnlock()
+	if cached != nil && time.Since(loadedAt) < experimentCacheTTL {
+		return cached, nil
+	}
+
+	docs, err := s.col.Where("status", "==", "running").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[string]*Experiment, len(docs))
+	for _, doc := range docs {
+		var exp Experiment
+		if err := doc.DataTo(&exp); err != nil {
+			continue
+		}
+		exp.ID = doc.Ref.ID
+		running[exp.PromptKey] = &exp
+	}
+
+	s.mu.Lock()
+	s.running = running
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return running, nil
+}
+
+func (s *ExperimentService) invalidate() {
+	s.mu.Lock()
+	s.running = nil
+	s.mu.Unlock()
+}
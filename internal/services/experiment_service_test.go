@@ -0,0 +1,59 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickVariantIsStickyAndWeighted(t *testing.T) {
+	variants := []ExperimentVariant{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}, {Name: "off", Weight: 0}}
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		idx := pickVariant("exp1", user, variants)
+		assert.Equal(t, idx, pickVariant("exp1", user, variants))
+		counts[variants[idx].Name]++
+	}
+	assert.Zero(t, counts["off"])
+	assert.InDelta(t, 0.75, float64(counts["a"])/4000, 0.05)
+
+	assert.Equal(t, -1, pickVariant("exp1", "u", []ExperimentVariant{{Name: "a"}}))
+}
+
+func TestValidateVariants(t *testing.T) {
+	assert.NoError(t, validateVariants([]ExperimentVariant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}, {Name: "off"}}))
+
+	for name, variants := range map[string][]ExperimentVariant{
+		"one variant":     {{Name: "a", Weight: 1}},
+		"one weighted":    {{Name: "a", Weight: 1}, {Name: "b"}},
+		"empty name":      {{Name: "a", Weight: 1}, {Name: "", Weight: 1}},
+		"blank name":      {{Name: "a", Weight: 1}, {Name: "  ", Weight: 1}},
+		"padded name":     {{Name: "a", Weight: 1}, {Name: "b ", Weight: 1}},
+		"slash in name":   {{Name: "a", Weight: 1}, {Name: "b/c", Weight: 1}},
+		"duplicate name":  {{Name: "a", Weight: 1}, {Name: "a", Weight: 2}},
+		"negative weight": {{Name: "a", Weight: 2}, {Name: "b", Weight: 1}, {Name: "c", Weight: -1}},
+		"bad template":    {{Name: "a", Weight: 1, Prompt: "{{.Nope}}"}, {Name: "b", Weight: 1}},
+		"unclosed action": {{Name: "a", Weight: 1}, {Name: "b", Weight: 1, Prompt: "สวัสดี {{.UserName"}},
+	} {
+		assert.ErrorIs(t, validateVariants(variants), ErrInvalidExperiment, name)
+	}
+}
+
+func TestPromotableVariant(t *testing.T) {
+	exp := &Experiment{Status: "running", Variants: []ExperimentVariant{{Name: "a", Prompt: "A"}, {Name: "b", Prompt: "B"}}}
+	v, err := promotableVariant(exp, "b")
+	assert.NoError(t, err)
+	assert.Equal(t, "B", v.Prompt)
+
+	_, err = promotableVariant(exp, "c")
+	assert.ErrorIs(t, err, ErrVariantNotFound)
+
+	// promote ซ้ำหรือ promote การทดลองที่หยุดแล้วต้องไม่เขียน version ใหม่ทับ config
+	for _, st := range []string{"promoted", "stopped"} {
+		_, err = promotableVariant(&Experiment{Status: st, Variants: exp.Variants}, "a")
+		assert.ErrorIs(t, err, ErrExperimentNotRunning, st)
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultFlowTimeout เวลาที่รอผู้ใช้ตอบในแต่ละ step ก่อน flow หมดอายุ
+const defaultFlowTimeout = 10 * time.Minute
+
+// flowLockLease step ที่ทำงานค้างนานเกินนี้ (instance ล่มหรือ panic กลางทาง) ถือว่าปล่อยล็อกแล้ว
+const flowLockLease = 60 * time.Second
+
+// FlowState สถานะ flow ของผู้ใช้ (collection "line_flows", document id = LINE userId)
+// Step ว่างระหว่างที่ step กำลังทำงาน เพื่อกันการกดปุ่มซ้ำ (LockedStep/LockedAt บอกว่าจองไว้ที่ step ไหน เมื่อไร)
+type FlowState struct {
+	Flow       string            `firestore:"flow"`
+	Step       string            `firestore:"step"`
+	LockedStep string            `firestore:"lockedStep,omitempty"`
+	LockedAt   time.Time         `firestore:"lockedAt,omitempty"`
+	UserID     string            `firestore:"userId"`
+	Data       map[string]string `firestore:"data"`
+	ExpiresAt  time.Time         `firestore:"expiresAt"`
+	UpdatedAt  time.Time         `firestore:"updatedAt"`
+}
+
+// locked step ก่อนหน้ายังทำงานอยู่ (จองไว้ไม่เกิน flowLockLease)
+func (st *FlowState) locked(now time.Time) bool {
+	return st.Step == "" && now.Sub(st.LockedAt) < flowLockLease
+}
+
+// release ล็อกหมดอายุแล้ว กลับไปรอ step ที่ค้างไว้ (ล็อกแบบเก่าที่ไม่มี LockedStep จะได้ Step ว่าง = จบ flow)
+func (st *FlowState) release() {
+	if st.Step == "" {
+		st.Step = st.LockedStep
+	}
+	st.LockedStep = ""
+	st.LockedAt = time.Time{}
+}
+
+// FlowInput สิ่งที่ผู้ใช้ส่งมา: postback (Postback ไม่ nil) หรือข้อความ text
+type FlowInput struct {
+	LineUserID string
+	UserID     string // user ID ภายใน
+	Text       string
+	Postback   url.Values
+	Params     map[string]string // postback.params เช่น ผลจาก datetimepicker
+	L          *Localizer        // ภาษาของผู้ใช้ (nil = ภาษาไทย)
+}
+
+// Value ค่าที่ผู้ใช้เลือกจากปุ่มที่สร้างด้วย FlowPostback
+func (in FlowInput) Value() string {
+	return in.Postback.Get("v")
+}
+
+// FlowResult ผลของ step: Next ว่าง = จบ flow, Fallback = จบ flow แล้วส่งข้อความต่อให้ AI chat
+type FlowResult struct {
+	Next     string
+	Messages []LineMessage
+	Fallback bool
+}
+
+// FlowStep ทำงานหนึ่ง step โดยแก้ st.Data เพื่อเก็บค่าที่เลือกไว้ใช้ใน step ถัดไป
+type FlowStep func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error)
+
+// Flow นิยาม flow หนึ่งชุด (Start = step แรกที่ถูกเรียกตอนเริ่ม)
+type Flow struct {
+	Name    string
+	Start   string
+	Steps   map[string]FlowStep
+	Timeout time.Duration
+}
+
+// FlowPostback ปุ่มที่ส่งค่ากลับมายัง step ของ flow
+func FlowPostback(flow, step, label, value string) LineAction {
+	data := url.Values{"flow": {flow}, "step": {step}, "v": {value}}
+	return PostbackAction(label, data.Encode(), label)
+}
+
+// FlowStartAction ปุ่มเริ่ม flow (ใช้ใน quick reply หรือ rich menu)
+func FlowStartAction(label, flow string) LineAction {
+	return PostbackAction(label, "action=flow_start&flow="+url.QueryEscape(flow), label)
+}
+
+// FlowEngine เดิน flow ตาม postback/ข้อความของผู้ใช้ ข้อความที่ไม่อยู่ใน flow จะไม่ถูกจัดการ (ให้ AI chat ตอบ)
+type FlowEngine struct {
+	col   *firestore.CollectionRef
+	flows map[string]*Flow
+}
+
+func NewFlowEngine(flows ...*Flow) *FlowEngine {
+	e := &FlowEngine{
+		col:   utils.Client.Collection("line_flows"),
+		flows: map[string]*Flow{},
+	}
+	for _, f := range flows {
+		e.flows[f.Name] = f
+	}
+	return e
+}
+
+// Handle คืน handled = false เมื่อผู้ใช้ไม่ได้อยู่ใน flow (หรือ step ขอ fallback) ให้ผู้เรียกส่งต่อให้ AI chat
+func (e *FlowEngine) Handle(ctx context.Context, in FlowInput) ([]LineMessage, bool, error) {
+	if in.Postback != nil {
+		switch in.Postback.Get("action") {
+		case "flow_start":
+			return e.start(ctx, in, in.Postback.Get("flow"))
+		case "flow_cancel":
+			if _, err := e.col.Doc(in.LineUserID).Delete(ctx); err != nil {
+				return nil, true, err
+			}
+			return []LineMessage{LineText(in.L.T("flow.cancelled"))}, true, nil
+		}
+		if in.Postback.Get("flow") == "" {
+			return nil, false, nil
+		}
+	}
+
+	ref := e.col.Doc(in.LineUserID)
+	snap, err := ref.Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, false, err
+	}
+	var st FlowState
+	exists := err == nil
+	if exists {
+		if err := snap.DataTo(&st); err != nil {
+			return nil, false, err
+		}
+	}
+	now := time.Now()
+	if exists && st.locked(now) {
+		return nil, true, nil // step ก่อนหน้ายังทำงานอยู่
+	}
+	st.release()
+	if !exists || now.After(st.ExpiresAt) || st.Step == "" {
+		if exists {
+			_, _ = ref.Delete(ctx)
+		}
+		if in.Postback != nil {
+			return []LineMessage{LineText(in.L.T("flow.expired"))}, true, nil
+		}
+		return nil, false, nil
+	}
+	if in.Postback != nil && (in.Postback.Get("flow") != st.Flow || in.Postback.Get("step") != st.Step) {
+		return []LineMessage{LineText(in.L.T("flow.stale_button"))}, true, nil
+	}
+	flow := e.flows[st.Flow]
+	if flow == nil {
+		_, _ = ref.Delete(ctx)
+		return nil, false, nil
+	}
+
+	// จอง step ด้วย precondition กันกดปุ่มเดียวกันซ้ำ (เช่น ปุ่มจ่ายเหรียญ)
+	stepName := st.Step
+	_, err = ref.Update(ctx, []firestore.Update{
+		{Path: "step", Value: ""},
+		{Path: "lockedStep", Value: stepName},
+		{Path: "lockedAt", Value: now},
+		{Path: "updatedAt", Value: now},
+	}, firestore.LastUpdateTime(snap.UpdateTime))
+	if status.Code(err) == codes.FailedPrecondition {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	return e.run(ctx, ref, flow, stepName, &st, in)
+}
+
+// start เริ่ม flow ใหม่ (แทนที่ flow เดิมที่ค้างอยู่)
+func (e *FlowEngine) start(ctx context.Context, in FlowInput, name string) ([]LineMessage, bool, error) {
+	flow := e.flows[name]
+	if flow == nil {
+		return nil, false, nil
+	}
+	st := &FlowState{Flow: flow.Name, UserID: in.UserID, Data: map[string]string{}}
+	in.Postback = url.Values{}
+	return e.run(ctx, e.col.Doc(in.LineUserID), flow, flow.Start, st, in)
+}
+
+func (e *FlowEngine) run(ctx context.Context, ref *firestore.DocumentRef, flow *Flow, stepName string, st *FlowState, in FlowInput) ([]LineMessage, bool, error) {
+	step := flow.Steps[stepName]
+	if step == nil {
+		_, _ = ref.Delete(ctx)
+		return nil, false, nil
+	}
+	if st.Data == nil {
+		st.Data = map[string]string{}
+	}
+	res, err := step(ctx, st, in)
+	if err != nil || res.Fallback || res.Next == "" {
+		if _, derr := ref.Delete(ctx); derr != nil && err == nil {
+			err = derr
+		}
+		if res.Fallback && err == nil {
+			return nil, false, nil
+		}
+		return res.Messages, true, err
+	}
+
+	timeout := flow.Timeout
+	if timeout <= 0 {
+		timeout = defaultFlowTimeout
+	}
+	now := time.Now()
+	st.Step = res.Next
+	st.ExpiresAt = now.Add(timeout)
+	st.UpdatedAt = now
+	if _, err := ref.Set(ctx, st); err != nil {
+		return nil, true, err
+	}
+	if n := len(res.Messages); n > 0 {
+		label := in.L.T("flow.cancel_button")
+		res.Messages[n-1] = res.Messages[n-1].AddQuickReply(PostbackAction(label, "action=flow_cancel", label))
+	}
+	return res.Messages, true, nil
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlowPostbackRoundTrip(t *testing.T) {
+	action := FlowPostback(BookingFlowName, "slot", "10:30", "2026-10-20T03:30:00Z")
+	data, err := url.ParseQuery(action["data"].(string))
+	assert.NoError(t, err)
+
+	in := FlowInput{Postback: data}
+	assert.Equal(t, BookingFlowName, data.Get("flow"))
+	assert.Equal(t, "slot", data.Get("step"))
+	assert.Equal(t, "2026-10-20T03:30:00Z", in.Value())
+	assert.NoError(t, validateLineAction(action))
+}
+
+func TestAddQuickReplyRespectsLimit(t *testing.T) {
+	actions := make([]LineAction, lineMaxQuickReplyItems)
+	for i := range actions {
+		actions[i] = MessageAction("a", "a")
+	}
+	msg := LineText("x").WithQuickReply(actions...).AddQuickReply(PostbackAction("ยกเลิก", "action=flow_cancel", ""))
+	assert.NoError(t, ValidateLineMessages([]LineMessage{msg}))
+
+	msg = LineText("x").AddQuickReply(PostbackAction("ยกเลิก", "action=flow_cancel", ""))
+	assert.NoError(t, ValidateLineMessages([]LineMessage{msg}))
+}
+
+func TestFlowLockLease(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := FlowState{Flow: BookingFlowName, LockedStep: "slot", LockedAt: now.Add(-10 * time.Second)}
+	assert.True(t, st.locked(now))
+	assert.False(t, st.locked(now.Add(flowLockLease)), "lock older than the lease is free")
+
+	// ล็อกที่หมดอายุกลับไปรอ step เดิม
+	st.release()
+	assert.Equal(t, "slot", st.Step)
+	assert.Empty(t, st.LockedStep)
+	assert.False(t, st.locked(now))
+
+	// ล็อกแบบเก่า (ไม่มี lockedAt/lockedStep) ไม่ค้างตลอดไป
+	legacy := FlowState{Flow: BookingFlowName}
+	assert.False(t, legacy.locked(now))
+	legacy.release()
+	assert.Empty(t, legacy.Step)
+}
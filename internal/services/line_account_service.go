@@ -0,0 +1,427 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LineMergeJobName job ใน workpool ที่ย้ายข้อมูลที่ค้างจากการผูกบัญชี (payload = userId ปลายทาง)
+const LineMergeJobName = "merge_line_account"
+
+const (
+	lineAccountLinkURL  = "https://access.line.me/dialog/bot/accountLink"
+	lineLoginVerifyURL  = "https://api.line.me/oauth2/v2.1/verify"
+	lineLinkNonceTTL    = 10 * time.Minute
+	lineAccountBatchMax = 400
+	lineMergeRetryDelay = time.Minute
+)
+
+var (
+	ErrLineLinkExpired   = errors.New("account link expired or not found")
+	ErrLineAlreadyLinked = errors.New("line account already linked to another user")
+	ErrInvalidLineToken  = errors.New("invalid LINE Login id token")
+	ErrLineLinkForbidden = errors.New("account credentials do not match user")
+	ErrUserNotFound      = errors.New("user not found")
+)
+
+// LineAccountService ผูก LINE userId กับบัญชีใน "users"
+// line_users/{lineUserId}.userId ชี้ไปที่ users/{userId} และ users/{userId}.lineUserId ชี้กลับ
+// ผู้ใช้ที่เพิ่มเพื่อน OA จะได้บัญชีแบบ source="line" (ไม่มี email) ไปก่อน แล้วค่อยผูกกับบัญชีจริงภายหลัง
+type LineAccountService struct {
+	client         *firestore.Client
+	accounts       accountAuthenticator
+	line           *LineService
+	coins          *CoinService
+	workpool       *WorkpoolService
+	userCol        *firestore.CollectionRef
+	lineUserCol    *firestore.CollectionRef
+	nonceCol       *firestore.CollectionRef
+	loginChannelID string
+	verifyURL      string
+	httpClient     *http.Client
+}
+
+// accountAuthenticator ตรวจอีเมล/รหัสผ่านของบัญชี (UserService)
+type accountAuthenticator interface {
+	Login(ctx context.Context, email, plainPassword string) (string, *User, error)
+}
+
+// NewLineAccountService ลงทะเบียน handler ของ LineMergeJobName (ย้ายข้อมูลที่ค้างเมื่อ merge รอบแรกล้มเหลว)
+func NewLineAccountService(line *LineService, coins *CoinService, workpool *WorkpoolService, loginChannelID string) *LineAccountService {
+	s := &LineAccountService{
+		client:         utils.Client,
+		accounts:       NewUserService(),
+		line:           line,
+		coins:          coins,
+		workpool:       workpool,
+		userCol:        utils.Client.Collection("users"),
+		lineUserCol:    utils.Client.Collection("line_users"),
+		nonceCol:       utils.Client.Collection("line_link_nonces"),
+		loginChannelID: loginChannelID,
+		verifyURL:      lineLoginVerifyURL,
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+	}
+	RegisterJobHandler(LineMergeJobName, s.finishMerge)
+	return s
+}
+
+// ResolveUser คืน user ID ภายในของผู้ใช้ LINE ถ้ายังไม่มีจะสร้างบัญชีแบบ source="line" ให้
+func (s *LineAccountService) ResolveUser(ctx context.Context, lineUserID string) (string, error) {
+	snap, err := s.lineUserCol.Doc(lineUserID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", err
+	}
+	if err == nil {
+		if uid, _ := snap.Data()["userId"].(string); uid != "" {
+			return uid, nil
+		}
+	}
+
+	profile, err := s.line.GetProfile(ctx, lineUserID)
+	if err != nil {
+		profile = &LineProfile{}
+	}
+	return s.ensureUser(ctx, lineUserID, profile)
+}
+
+// ensureUser สร้างบัญชีใน transaction เพื่อไม่ให้ event ที่มาพร้อมกันสร้างบัญชีซ้ำ
+// ภาษาจากโปรไฟล์เก็บไว้ที่ line_users ใช้เลือกภาษาของข้อความจนกว่าผู้ใช้จะตั้งเอง
+func (s *LineAccountService) ensureUser(ctx context.Context, lineUserID string, profile *LineProfile) (string, error) {
+	ref := s.lineUserCol.Doc(lineUserID)
+	var userID string
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if uid, _ := snap.Data()["userId"].(string); uid != "" {
+				userID = uid
+				return nil
+			}
+		}
+		now := time.Now()
+		userRef := s.userCol.NewDoc()
+		if err := tx.Create(userRef, User{
+			Role:        "user",
+			Source:      "line",
+			LineUserID:  lineUserID,
+			DisplayName: profile.DisplayName,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}); err != nil {
+			return err
+		}
+		userID = userRef.ID
+		return tx.Set(ref, map[string]interface{}{
+			"userId":    userRef.ID,
+			"language":  profile.Language,
+			"createdAt": now,
+		}, firestore.MergeAll)
+	})
+	return userID, err
+}
+
+// StartLink เรียกจากหน้าเว็บ: ตรวจอีเมล/รหัสผ่านว่าเป็นเจ้าของ userID ก่อน แล้วออก nonce ผูกกับ userID
+// และคืน URL ที่ต้อง redirect ไป LINE
+func (s *LineAccountService) StartLink(ctx context.Context, userID, email, password, linkToken string) (string, error) {
+	if linkToken == "" {
+		return "", ErrLineLinkExpired
+	}
+	if err := s.authorize(ctx, userID, email, password); err != nil {
+		return "", err
+	}
+	if _, err := s.userCol.Doc(userID).Get(ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	nonce, err := newLinkNonce()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if _, err := s.nonceCol.Doc(nonce).Create(ctx, map[string]interface{}{
+		"userId":    userID,
+		"createdAt": now,
+		"expireAt":  now.Add(lineLinkNonceTTL),
+	}); err != nil {
+		return "", err
+	}
+	q := url.Values{"linkToken": {linkToken}, "nonce": {nonce}}
+	return lineAccountLinkURL + "?" + q.Encode(), nil
+}
+
+// CompleteLink รับ event "accountLink" จาก webhook แล้วผูก LINE userId กับเจ้าของ nonce
+func (s *LineAccountService) CompleteLink(ctx context.Context, lineUserID, nonce string) (string, error) {
+	if nonce == "" {
+		return "", ErrLineLinkExpired
+	}
+	ref := s.nonceCol.Doc(nonce)
+	var userID string
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrLineLinkExpired
+		}
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		expireAt, _ := data["expireAt"].(time.Time)
+		if time.Now().After(expireAt) {
+			return ErrLineLinkExpired
+		}
+		userID, _ = data["userId"].(string)
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, s.Link(ctx, lineUserID, userID)
+}
+
+// LinkWithIDToken ผูกบัญชีด้วย LINE Login (ID token ต้องมาจาก LINE Login channel ใน provider เดียวกับ OA)
+// ต้องมีอีเมล/รหัสผ่านของ userID ด้วย ไม่อย่างนั้นใครก็ผูก LINE ของตัวเองกับบัญชีคนอื่นได้
+func (s *LineAccountService) LinkWithIDToken(ctx context.Context, userID, email, password, idToken string) (string, error) {
+	if err := s.authorize(ctx, userID, email, password); err != nil {
+		return "", err
+	}
+	lineUserID, err := s.verifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+	return lineUserID, s.Link(ctx, lineUserID, userID)
+}
+
+// authorize ผู้เรียกต้อง login ได้ด้วยอีเมล/รหัสผ่าน และบัญชีที่ได้ต้องเป็น userID เดียวกับที่จะผูก
+func (s *LineAccountService) authorize(ctx context.Context, userID, email, password string) error {
+	if email == "" || password == "" {
+		return ErrLineLinkForbidden
+	}
+	uid, _, err := s.accounts.Login(ctx, email, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return ErrLineLinkForbidden
+	}
+	if err != nil {
+		return err
+	}
+	if uid != userID {
+		return ErrLineLinkForbidden
+	}
+	return nil
+}
+
+func (s *LineAccountService) verifyIDToken(ctx context.Context, idToken string) (string, error) {
+	if idToken == "" || s.loginChannelID == "" {
+		return "", ErrInvalidLineToken
+	}
+	form := url.Values{"id_token": {idToken}, "client_id": {s.loginChannelID}}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", ErrInvalidLineToken
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("line login verify status %d", resp.StatusCode)
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return "", err
+	}
+	if claims.Sub == "" {
+		return "", ErrInvalidLineToken
+	}
+	return claims.Sub, nil
+}
+
+// Link ผูก LINE userId กับ userID ถ้าเดิมผูกกับบัญชีอัตโนมัติ (source="line") จะย้ายข้อมูลมาที่บัญชีใหม่
+// LINE userId ที่ผูกกับบัญชีจริง (มี email) อยู่แล้วจะไม่ถูกย้าย
+// บัญชีต้นทางถูกบันทึกใน users/{userID}.pendingMergeFrom ใน transaction เดียวกับการผูก และถูกลบเมื่อย้ายเสร็จ
+// การย้ายที่ล้มเหลวจึงทำต่อได้ทั้งจากการเรียก Link ซ้ำและจาก job LineMergeJobName
+func (s *LineAccountService) Link(ctx context.Context, lineUserID, userID string) error {
+	lineRef := s.lineUserCol.Doc(lineUserID)
+	userRef := s.userCol.Doc(userID)
+	var previous string
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		previous = ""
+		userSnap, err := tx.Get(userRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var target User
+		if err := userSnap.DataTo(&target); err != nil {
+			return err
+		}
+		if target.LineUserID != "" && target.LineUserID != lineUserID {
+			return ErrLineAlreadyLinked
+		}
+
+		lineSnap, err := tx.Get(lineRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			previous, _ = lineSnap.Data()["userId"].(string)
+		}
+		if previous == userID {
+			return nil
+		}
+		now := time.Now()
+		if previous != "" {
+			prevSnap, err := tx.Get(s.userCol.Doc(previous))
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				var prev User
+				if err := prevSnap.DataTo(&prev); err != nil {
+					return err
+				}
+				if prev.Source != "line" {
+					return ErrLineAlreadyLinked
+				}
+				if err := tx.Update(prevSnap.Ref, []firestore.Update{
+					{Path: "lineUserId", Value: firestore.Delete},
+					{Path: "mergedInto", Value: userID},
+					{Path: "updatedAt", Value: now},
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Set(lineRef, map[string]interface{}{
+			"userId":   userID,
+			"linkedAt": now,
+		}, firestore.MergeAll); err != nil {
+			return err
+		}
+		return tx.Update(userRef, []firestore.Update{
+			{Path: "lineUserId", Value: lineUserID},
+			{Path: "linkedAt", Value: now},
+			{Path: "updatedAt", Value: now},
+			{Path: "pendingMergeFrom", Value: firestore.ArrayUnion(mergeSources(lineUserID, previous)...)},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.finishMerge(ctx, userID); err != nil {
+		if s.workpool != nil {
+			if serr := s.workpool.ScheduleUniqueJob(ctx, "line_merge_"+userID, LineMergeJobName, userID, time.Now().Add(lineMergeRetryDelay)); serr != nil {
+				log.Printf("Error scheduling LINE merge for %s: %v", userID, serr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// mergeSources ข้อมูลเก่าที่บันทึกด้วย LINE userId ตรง ๆ (ก่อนมีการผูกบัญชี) และของบัญชีอัตโนมัติ
+func mergeSources(lineUserID, previous string) []interface{} {
+	out := []interface{}{lineUserID}
+	if previous != "" && previous != lineUserID {
+		out = append(out, previous)
+	}
+	return out
+}
+
+// finishMerge ย้ายข้อมูลจากทุกบัญชีใน pendingMergeFrom มาที่ userID แล้วลบออกจากรายการทีละบัญชี (เรียกซ้ำได้)
+func (s *LineAccountService) finishMerge(ctx context.Context, userID string) error {
+	snap, err := s.userCol.Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pending, _ := snap.Data()["pendingMergeFrom"].([]interface{})
+	for _, v := range pending {
+		from, _ := v.(string)
+		if from != "" && from != userID {
+			if err := s.mergeInto(ctx, from, userID); err != nil {
+				return fmt.Errorf("merge %s into %s: %w", from, userID, err)
+			}
+		}
+		if _, err := snap.Ref.Update(ctx, []firestore.Update{
+			{Path: "pendingMergeFrom", Value: firestore.ArrayRemove(v)},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeInto ย้ายบทสนทนา แพ็กเกจ รีวิว และยอดเหรียญจาก from ไปที่ to (ทำซ้ำได้ ยอดที่โอนแล้วเป็น 0)
+func (s *LineAccountService) mergeInto(ctx context.Context, from, to string) error {
+	for _, col := range []string{"conversations", "user_packages", "reviews"} {
+		if err := s.reassign(ctx, s.client.Collection(col).Where("userId", "==", from), to); err != nil {
+			return err
+		}
+	}
+	if _, err := s.client.Collection("active_sessions").Doc(from).Delete(ctx); err != nil {
+		return err
+	}
+	balance, err := s.coins.GetBalance(ctx, from)
+	if err != nil || balance <= 0 {
+		return err
+	}
+	return s.coins.Transfer(ctx, from, to, balance)
+}
+
+func (s *LineAccountService) reassign(ctx context.Context, q firestore.Query, to string) error {
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(docs); start += lineAccountBatchMax {
+		end := start + lineAccountBatchMax
+		if end > len(docs) {
+			end = len(docs)
+		}
+		batch := s.client.Batch()
+		for _, doc := range docs[start:end] {
+			batch.Update(doc.Ref, []firestore.Update{{Path: "userId", Value: to}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newLinkNonce() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineAccountVerifyIDToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "1234", r.PostForm.Get("client_id"))
+		switch r.PostForm.Get("id_token") {
+		case "good":
+			w.Write([]byte(`{"sub":"Uabc","aud":"1234"}`))
+		case "nosub":
+			w.Write([]byte(`{"aud":"1234"}`))
+		case "down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+		}
+	}))
+	defer srv.Close()
+	s := &LineAccountService{loginChannelID: "1234", verifyURL: srv.URL, httpClient: srv.Client()}
+	ctx := context.Background()
+
+	sub, err := s.verifyIDToken(ctx, "good")
+	assert.NoError(t, err)
+	assert.Equal(t, "Uabc", sub)
+
+	_, err = s.verifyIDToken(ctx, "expired")
+	assert.ErrorIs(t, err, ErrInvalidLineToken)
+	_, err = s.verifyIDToken(ctx, "nosub")
+	assert.ErrorIs(t, err, ErrInvalidLineToken)
+	_, err = s.verifyIDToken(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidLineToken)
+
+	// error จาก LINE ฝั่ง server ไม่ใช่ token ผิด (ให้ผู้เรียกลองใหม่ได้)
+	_, err = s.verifyIDToken(ctx, "down")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidLineToken)
+
+	// ไม่ได้ตั้ง LINE_LOGIN_CHANNEL_ID = ปฏิเสธโดยไม่เรียก LINE
+	_, err = (&LineAccountService{verifyURL: srv.URL, httpClient: srv.Client()}).verifyIDToken(ctx, "good")
+	assert.ErrorIs(t, err, ErrInvalidLineToken)
+}
+
+type fakeAccountAuthenticator map[string]string // email → userId (รหัสผ่านที่ถูกคือ "secret")
+
+func (f fakeAccountAuthenticator) Login(_ context.Context, email, password string) (string, *User, error) {
+	uid, ok := f[email]
+	if !ok || password != "secret" {
+		return "", nil, ErrInvalidCredentials
+	}
+	return uid, &User{Email: email}, nil
+}
+
+func TestLineLinkRejectsNonOwner(t *testing.T) {
+	verified := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified = true
+		w.Write([]byte(`{"sub":"Uattacker","aud":"1234"}`))
+	}))
+	defer srv.Close()
+	s := &LineAccountService{
+		accounts:       fakeAccountAuthenticator{"victim@example.com": "victim", "attacker@example.com": "attacker"},
+		loginChannelID: "1234",
+		verifyURL:      srv.URL,
+		httpClient:     srv.Client(),
+	}
+	ctx := context.Background()
+
+	// ผู้เรียกใช้บัญชีของตัวเอง ไม่มีรหัสผ่าน หรือรหัสผ่านผิด ขอผูกกับบัญชีของคนอื่นไม่ได้ และไม่มีการออก nonce
+	for name, creds := range map[string][2]string{
+		"other account":  {"attacker@example.com", "secret"},
+		"no credentials": {"", ""},
+		"wrong password": {"victim@example.com", "guess"},
+	} {
+		_, err := s.StartLink(ctx, "victim", creds[0], creds[1], "linktoken")
+		assert.ErrorIs(t, err, ErrLineLinkForbidden, name)
+		_, err = s.LinkWithIDToken(ctx, "victim", creds[0], creds[1], "good")
+		assert.ErrorIs(t, err, ErrLineLinkForbidden, name)
+	}
+	assert.False(t, verified, "ID token must not be checked before the account owner is")
+
+	assert.NoError(t, s.authorize(ctx, "victim", "victim@example.com", "secret"))
+}
+
+func TestMergeSources(t *testing.T) {
+	assert.Equal(t, []interface{}{"Uline"}, mergeSources("Uline", ""))
+	assert.Equal(t, []interface{}{"Uline", "auto1"}, mergeSources("Uline", "auto1"))
+	assert.Equal(t, []interface{}{"Uline"}, mergeSources("Uline", "Uline"))
+}
+
+func TestNewLinkNonce(t *testing.T) {
+	a, err := newLinkNonce()
+	assert.NoError(t, err)
+	b, _ := newLinkNonce()
+	assert.Len(t, a, 24)
+	assert.NotEqual(t, a, b)
+	assert.NotContains(t, a, "/")
+	assert.NotContains(t, a, "+")
+}
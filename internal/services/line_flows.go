@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// ชื่อ flow ที่ใช้ใน postback action=flow_start&flow=...
+const (
+	TarotFlowName   = "tarot"
+	BookingFlowName = "booking"
+)
+
+// ErrSlotUnavailable slot ถูกจองหรือ hold โดยคนอื่นไปแล้ว
+var ErrSlotUnavailable = errors.New("slot unavailable")
+
+// tarotTopics หัวข้อที่ให้เลือกก่อนเปิดไพ่ (ชื่อที่แสดงอยู่ใน catalog "tarot.topic.<key>")
+var tarotTopics = []string{"love", "career", "finance", "health", "general"}
+
+// tarotSpreadOrder ลำดับ spread ที่แสดงเป็นปุ่ม
+var tarotSpreadOrder = []string{"single", "three_card", "celtic_cross"}
+
+// NewTarotFlow เลือกหัวข้อ → เลือก spread → ยืนยันจ่ายเหรียญ → เปิดไพ่และรับคำทำนาย
+// ในขั้นเลือกหัวข้อ ผู้ใช้พิมพ์คำถามเองแทนการกดปุ่มได้
+func NewTarotFlow(chat *ChatService, tarot *TarotService, sessions *SessionService, billing *AIBillingService, summary *SummaryService, deckID, model string) *Flow {
+	f := &Flow{Name: TarotFlowName, Start: "start", Timeout: 10 * time.Minute}
+	f.Steps = map[string]FlowStep{
+		"start": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if deckID == "" {
+				return FlowResult{Messages: []LineMessage{LineText(in.L.T("tarot.unavailable"))}}, nil
+			}
+			actions := make([]LineAction, 0, len(tarotTopics))
+			for _, key := range tarotTopics {
+				actions = append(actions, FlowPostback(f.Name, "topic", in.L.T("tarot.topic."+key), key))
+			}
+			msg := LineText(in.L.T("tarot.pick_topic")).WithQuickReply(actions...)
+			return FlowResult{Next: "topic", Messages: []LineMessage{msg}}, nil
+		},
+		"topic": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				st.Data["question"] = in.Text
+				st.Data["topic"] = "general"
+			} else {
+				st.Data["topic"] = in.Value()
+			}
+			actions := make([]LineAction, 0, len(tarotSpreadOrder))
+			for _, key := range tarotSpreadOrder {
+				actions = append(actions, FlowPostback(f.Name, "spread", in.L.T("tarot.spread."+key), key))
+			}
+			msg := LineText(in.L.T("tarot.pick_spread")).WithQuickReply(actions...)
+			return FlowResult{Next: "spread", Messages: []LineMessage{msg}}, nil
+		},
+		"spread": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				return FlowResult{Fallback: true}, nil
+			}
+			spread, ok := Spreads[in.Value()]
+			if !ok {
+				return FlowResult{Next: "spread", Messages: []LineMessage{LineText(in.L.T("tarot.unknown_spread"))}}, nil
+			}
+			st.Data["spread"] = spread.Key
+			pricing, err := billing.GetPricing(ctx, "tarot")
+			if err != nil {
+				return FlowResult{}, err
+			}
+			price := in.L.T("tarot.price_free")
+			switch {
+			case pricing.CoinCost > 0 && pricing.FreeWithPackage:
+				price = in.L.T("tarot.price_package_free", "Coins", pricing.CoinCost)
+			case pricing.CoinCost > 0:
+				price = in.L.T("unit.coins", "Count", pricing.CoinCost)
+			}
+			text := in.L.T("tarot.confirm", "Spread", in.L.T("tarot.spread."+spread.Key), "Cards", len(spread.Positions), "Price", price)
+			msg := LineText(text).WithQuickReply(FlowPostback(f.Name, "confirm", in.L.T("tarot.confirm_button"), "yes"))
+			return FlowResult{Next: "confirm", Messages: []LineMessage{msg}}, nil
+		},
+		"confirm": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				return FlowResult{Fallback: true}, nil
+			}
+			convID, err := sessions.ResolveSession(ctx, st.UserID)
+			if err != nil {
+				return FlowResult{}, err
+			}
+			draw, err := tarot.Draw(ctx, st.UserID, convID, deckID, st.Data["spread"], st.Data["question"], true)
+			if err != nil {
+				return FlowResult{}, err
+			}
+			question := st.Data["question"]
+			if question == "" {
+				question = in.L.T("tarot.default_question", "Topic", in.L.T("tarot.topic."+tarotTopic(st.Data["topic"])))
+			}
+			// ChatService hold เหรียญก่อนเรียก AI และตัดจริงเมื่อได้คำทำนาย
+			resp, err := chat.Chat(ctx, AIChatRequest{
+				UserID:         st.UserID,
+				ConversationID: convID,
+				Message:        question,
+				Model:          model,
+				Purpose:        "tarot",
+				DrawID:         draw.ID,
+			})
+			if errors.Is(err, ErrInsufficientBalance) {
+				msg := LineText(in.L.T("tarot.insufficient_balance")).WithQuickReply(packagesAction(in.L))
+				return FlowResult{Messages: []LineMessage{msg}}, nil
+			}
+			if err != nil {
+				return FlowResult{}, err
+			}
+			if summary != nil {
+				if err := summary.Schedule(ctx, resp.ConversationID); err != nil {
+					log.Println("schedule summary error:", err)
+				}
+			}
+			return FlowResult{Messages: TarotReadingMessages(in.L, draw, resp.Response)}, nil
+		},
+	}
+	return f
+}
+
+// tarotTopic key ที่ไม่รู้จักถือเป็นภาพรวม
+func tarotTopic(key string) string {
+	if slices.Contains(tarotTopics, key) {
+		return key
+	}
+	return "general"
+}
+
+// packagesAction ปุ่มดูแพ็กเกจ (แนบเมื่อเหรียญไม่พอ)
+func packagesAction(l *Localizer) LineAction {
+	label := l.T("menu.packages")
+	return PostbackAction(label, "action=packages", label)
+}
+
+// BookingSeer หมอดูที่เปิดให้จองผ่าน flow
+type BookingSeer struct {
+	ID   string
+	Name string
+}
+
+// BookingFlowBackend ระบบจองที่ booking flow เรียกใช้
+type BookingFlowBackend interface {
+	ListSeers(ctx context.Context) ([]BookingSeer, error)
+	OpenSlots(ctx context.Context, seerID string, day time.Time) ([]time.Time, error)
+	HoldSlot(ctx context.Context, userID, seerID string, start time.Time) (string, error)
+	ConfirmHold(ctx context.Context, userID, holdID string) (*LineBooking, error)
+}
+
+// bookingFlowDays จองล่วงหน้าได้กี่วัน
+const bookingFlowDays = 30
+
+// NewBookingFlow เลือกหมอดู → เลือกวัน → เลือกเวลา → ยืนยัน (backend nil = ยังไม่เปิดให้จอง)
+func NewBookingFlow(backend BookingFlowBackend) *Flow {
+	f := &Flow{Name: BookingFlowName, Start: "start", Timeout: 15 * time.Minute}
+	datePicker := func(l *Localizer, text string) LineMessage {
+		today := time.Now().In(lineDisplayZone)
+		return LineText(text).WithQuickReply(DatetimePickerAction(l.T("booking.date_button"), "flow="+f.Name+"&step=date", "date",
+			today.Format("2006-01-02"), today.Format("2006-01-02"), today.AddDate(0, 0, bookingFlowDays).Format("2006-01-02")))
+	}
+	f.Steps = map[string]FlowStep{
+		"start": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if backend == nil {
+				return FlowResult{Messages: []LineMessage{LineText(in.L.T("booking.unavailable"))}}, nil
+			}
+			seers, err := backend.ListSeers(ctx)
+			if err != nil {
+				return FlowResult{}, err
+			}
+			if len(seers) == 0 {
+				return FlowResult{Messages: []LineMessage{LineText(in.L.T("booking.no_seers"))}}, nil
+			}
+			actions := make([]LineAction, 0, len(seers))
+			for i, s := range seers {
+				if i == lineMaxQuickReplyItems-1 { // เว้นที่ให้ปุ่มยกเลิก
+					break
+				}
+				actions = append(actions, FlowPostback(f.Name, "seer", truncateRunes(s.Name, lineMaxActionLabel), s.ID))
+			}
+			return FlowResult{Next: "seer", Messages: []LineMessage{LineText(in.L.T("booking.pick_seer")).WithQuickReply(actions...)}}, nil
+		},
+		"seer": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				return FlowResult{Fallback: true}, nil
+			}
+			seers, err := backend.ListSeers(ctx)
+			if err != nil {
+				return FlowResult{}, err
+			}
+			for _, s := range seers {
+				if s.ID == in.Value() {
+					st.Data["seerId"] = s.ID
+					st.Data["seerName"] = s.Name
+				}
+			}
+			if st.Data["seerId"] == "" {
+				return FlowResult{Messages: []LineMessage{LineText(in.L.T("booking.seer_gone"))}}, nil
+			}
+			return FlowResult{Next: "date", Messages: []LineMessage{datePicker(in.L, in.L.T("booking.pick_date", "Seer", st.Data["seerName"]))}}, nil
+		},
+		"date": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				return FlowResult{Fallback: true}, nil
+			}
+			day, err := time.ParseInLocation("2006-01-02", in.Params["date"], lineDisplayZone)
+			if err != nil {
+				return FlowResult{Next: "date", Messages: []LineMessage{datePicker(in.L, in.L.T("booking.invalid_date"))}}, nil
+			}
+			slots, err := backend.OpenSlots(ctx, st.Data["seerId"], day)
+			if err != nil {
+				return FlowResult{}, err
+			}
+			if len(slots) == 0 {
+				return FlowResult{Next: "date", Messages: []LineMessage{datePicker(in.L, in.L.T("booking.day_full"))}}, nil
+			}
+			actions := make([]LineAction, 0, len(slots))
+			for i, t := range slots {
+				if i == lineMaxQuickReplyItems-1 {
+					break
+				}
+				actions = append(actions, FlowPostback(f.Name, "slot", t.In(lineDisplayZone).Format("15:04"), t.UTC().Format(time.RFC3339)))
+			}
+			msg := LineText(in.L.T("booking.pick_time", "Day", day)).WithQuickReply(actions...)
+			return FlowResult{Next: "slot", Messages: []LineMessage{msg}}, nil
+		},
+		"slot": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				return FlowResult{Fallback: true}, nil
+			}
+			start, err := time.Parse(time.RFC3339, in.Value())
+			if err != nil {
+				return FlowResult{Next: "date", Messages: []LineMessage{datePicker(in.L, in.L.T("booking.invalid_slot"))}}, nil
+			}
+			holdID, err := backend.HoldSlot(ctx, st.UserID, st.Data["seerId"], start)
+			if errors.Is(err, ErrSlotUnavailable) {
+				return FlowResult{Next: "date", Messages: []LineMessage{datePicker(in.L, in.L.T("booking.slot_taken"))}}, nil
+			}
+			if err != nil {
+				return FlowResult{}, err
+			}
+			st.Data["holdId"] = holdID
+			text := in.L.T("booking.confirm", "Seer", st.Data["seerName"], "Start", start)
+			msg := LineText(text).WithQuickReply(FlowPostback(f.Name, "confirm", in.L.T("booking.confirm_button"), "yes"))
+			return FlowResult{Next: "confirm", Messages: []LineMessage{msg}}, nil
+		},
+		"confirm": func(ctx context.Context, st *FlowState, in FlowInput) (FlowResult, error) {
+			if in.Postback == nil {
+				return FlowResult{Fallback: true}, nil
+			}
+			booking, err := backend.ConfirmHold(ctx, st.UserID, st.Data["holdId"])
+			if errors.Is(err, ErrSlotUnavailable) {
+				return FlowResult{Next: "date", Messages: []LineMessage{datePicker(in.L, in.L.T("booking.hold_expired"))}}, nil
+			}
+			if errors.Is(err, ErrInsufficientBalance) {
+				msg := LineText(in.L.T("booking.insufficient_balance")).WithQuickReply(packagesAction(in.L))
+				return FlowResult{Messages: []LineMessage{msg}}, nil
+			}
+			if err != nil {
+				return FlowResult{}, err
+			}
+			return FlowResult{Messages: []LineMessage{BookingConfirmationMessage(in.L, *booking)}}, nil
+		},
+	}
+	return f
+}
@@ -0,0 +1,454 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ข้อจำกัดของ LINE Messaging API
+const (
+	lineMaxMessages        = 5    // ข้อความต่อหนึ่ง reply/push
+	lineMaxTextChars       = 5000 // ตัวอักษรต่อข้อความ text
+	lineMaxAltTextChars    = 400
+	lineMaxQuickReplyItems = 13
+	lineMaxActionLabel     = 20
+	lineMaxPostbackData    = 300
+	lineMaxURLChars        = 2000
+	lineMaxCarouselBubbles = 12
+	lineMaxBubbleBytes     = 30 * 1024 // ขนาด JSON ของ bubble หนึ่งใบ
+	lineMaxCarouselBytes   = 50 * 1024 // ขนาด JSON ของ carousel ทั้งชุด
+)
+
+// lineDisplayZone เขตเวลาที่ใช้แสดงวันเวลาในข้อความ
+var lineDisplayZone = time.FixedZone("ICT", 7*60*60)
+
+var ErrInvalidLineMessage = errors.New("invalid line message")
+
+// LineMessage ข้อความหนึ่งรายการตาม schema ของ LINE (text, image, flex)
+type LineMessage map[string]interface{}
+
+// LineAction action ของปุ่ม/quick reply (postback, message, uri)
+type LineAction map[string]interface{}
+
+// FlexComponent ส่วนประกอบของ Flex Message (bubble, carousel, box, text, image, button, ...)
+type FlexComponent map[string]interface{}
+
+// LineText ข้อความธรรมดา (ตัดให้ไม่เกิน 5000 ตัวอักษร)
+func LineText(text string) LineMessage {
+	return LineMessage{"type": "text", "text": truncateRunes(text, lineMaxTextChars)}
+}
+
+// LineImage ข้อความรูปภาพ (URL ต้องเป็น https)
+func LineImage(originalURL, previewURL string) LineMessage {
+	if previewURL == "" {
+		previewURL = originalURL
+	}
+	return LineMessage{"type": "image", "originalContentUrl": originalURL, "previewImageUrl": previewURL}
+}
+
+// LineFlex ข้อความ Flex โดย altText จะแสดงบนการแจ้งเตือนและอุปกรณ์ที่ไม่รองรับ Flex
+func LineFlex(altText string, contents FlexComponent) LineMessage {
+	return LineMessage{"type": "flex", "altText": truncateRunes(altText, lineMaxAltTextChars), "contents": contents}
+}
+
+// WithQuickReply แนบปุ่ม quick reply ให้ข้อความ (แสดงเฉพาะเมื่อเป็นข้อความสุดท้าย)
+func (m LineMessage) WithQuickReply(actions ...LineAction) LineMessage {
+	items := make([]map[string]interface{}, 0, len(actions))
+	for _, a := range actions {
+		items = append(items, map[string]interface{}{"type": "action", "action": a})
+	}
+	m["quickReply"] = map[string]interface{}{"items": items}
+	return m
+}
+
+// AddQuickReply ต่อปุ่ม quick reply ท้ายปุ่มเดิม (ไม่เพิ่มถ้าเต็มแล้ว)
+func (m LineMessage) AddQuickReply(action LineAction) LineMessage {
+	qr, ok := asMap(m["quickReply"])
+	if !ok {
+		return m.WithQuickReply(action)
+	}
+	items := asSlice(qr["items"]) // อาจเป็น []interface{} เมื่อ decode มาจาก JSON
+	if len(items) < lineMaxQuickReplyItems {
+		qr["items"] = append(items, map[string]interface{}{"type": "action", "action": action})
+	}
+	return m
+}
+
+// PostbackAction ส่ง data กลับมาทาง webhook event "postback" (displayText จะแสดงเป็นข้อความของผู้ใช้)
+func PostbackAction(label, data, displayText string) LineAction {
+	a := LineAction{"type": "postback", "label": label, "data": data}
+	if displayText != "" {
+		a["displayText"] = displayText
+	}
+	return a
+}
+
+// DatetimePickerAction ให้ผู้ใช้เลือกวัน/เวลา ผลจะมาใน postback.params (mode = "date", "time" หรือ "datetime")
+func DatetimePickerAction(label, data, mode, initial, min, max string) LineAction {
+	a := LineAction{"type": "datetimepicker", "label": label, "data": data, "mode": mode}
+	for key, v := range map[string]string{"initial": initial, "min": min, "max": max} {
+		if v != "" {
+			a[key] = v
+		}
+	}
+	return a
+}
+
+// MessageAction ส่งข้อความ text แทนผู้ใช้
+func MessageAction(label, text string) LineAction {
+	return LineAction{"type": "message", "label": label, "text": text}
+}
+
+// URIAction เปิดลิงก์
+func URIAction(label, uri string) LineAction {
+	return LineAction{"type": "uri", "label": label, "uri": uri}
+}
+
+// With ตั้งค่า property เพิ่มเติม เช่น FlexText("...").With("weight", "bold")
+func (c FlexComponent) With(key string, value interface{}) FlexComponent {
+	c[key] = value
+	return c
+}
+
+// FlexBubble bubble หนึ่งใบ ส่วนที่เป็น nil จะถูกละไว้
+func FlexBubble(hero, body, footer FlexComponent) FlexComponent {
+	b := FlexComponent{"type": "bubble"}
+	if hero != nil {
+		b["hero"] = hero
+	}
+	if body != nil {
+		b["body"] = body
+	}
+	if footer != nil {
+		b["footer"] = footer
+	}
+	return b
+}
+
+// FlexCarousel รวมหลาย bubble ให้เลื่อนซ้าย-ขวาได้
+func FlexCarousel(bubbles ...FlexComponent) FlexComponent {
+	return FlexComponent{"type": "carousel", "contents": bubbles}
+}
+
+// FlexBox กล่องจัดวาง layout = "vertical", "horizontal" หรือ "baseline"
+func FlexBox(layout string, contents ...FlexComponent) FlexComponent {
+	return FlexComponent{"type": "box", "layout": layout, "contents": contents}
+}
+
+func FlexText(text string) FlexComponent {
+	return FlexComponent{"type": "text", "text": text, "wrap": true}
+}
+
+func FlexImage(imageURL string) FlexComponent {
+	return FlexComponent{"type": "image", "url": imageURL, "size": "full", "aspectMode": "cover"}
+}
+
+func FlexButton(action LineAction) FlexComponent {
+	return FlexComponent{"type": "button", "action": action}
+}
+
+func FlexSeparator() FlexComponent {
+	return FlexComponent{"type": "separator", "margin": "md"}
+}
+
+// ValidateLineMessages ตรวจข้อความก่อนส่งตามข้อจำกัดของ LINE เพื่อไม่ให้ทั้ง request ถูกปฏิเสธ
+func ValidateLineMessages(messages []LineMessage) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("%w: no messages", ErrInvalidLineMessage)
+	}
+	if len(messages) > lineMaxMessages {
+		return fmt.Errorf("%w: %d messages (max %d)", ErrInvalidLineMessage, len(messages), lineMaxMessages)
+	}
+	for i, m := range messages {
+		if err := validateLineMessage(m); err != nil {
+			return fmt.Errorf("message %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateLineMessage(m LineMessage) error {
+	switch m["type"] {
+	case "text":
+		text, _ := m["text"].(string)
+		if text == "" {
+			return fmt.Errorf("%w: empty text", ErrInvalidLineMessage)
+		}
+		if n := utf8.RuneCountInString(text); n > lineMaxTextChars {
+			return fmt.Errorf("%w: text has %d chars (max %d)", ErrInvalidLineMessage, n, lineMaxTextChars)
+		}
+	case "image":
+		for _, key := range []string{"originalContentUrl", "previewImageUrl"} {
+			u, _ := m[key].(string)
+			if err := validateLineURL(u); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	case "flex":
+		alt, _ := m["altText"].(string)
+		if alt == "" || utf8.RuneCountInString(alt) > lineMaxAltTextChars {
+			return fmt.Errorf("%w: altText must be 1-%d chars", ErrInvalidLineMessage, lineMaxAltTextChars)
+		}
+		if err := validateFlexContainer(m["contents"]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %v", ErrInvalidLineMessage, m["type"])
+	}
+
+	if qr, ok := asMap(m["quickReply"]); ok {
+		items := asSlice(qr["items"])
+		if len(items) == 0 || len(items) > lineMaxQuickReplyItems {
+			return fmt.Errorf("%w: quick reply must have 1-%d items", ErrInvalidLineMessage, lineMaxQuickReplyItems)
+		}
+		for _, it := range items {
+			item, _ := asMap(it)
+			if err := validateLineAction(item["action"]); err != nil {
+				return fmt.Errorf("quick reply: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateFlexContainer(v interface{}) error {
+	c, ok := asMap(v)
+	if !ok {
+		return fmt.Errorf("%w: missing flex contents", ErrInvalidLineMessage)
+	}
+	switch c["type"] {
+	case "bubble":
+		if size := jsonSize(c); size > lineMaxBubbleBytes {
+			return fmt.Errorf("%w: bubble is %d bytes (max %d)", ErrInvalidLineMessage, size, lineMaxBubbleBytes)
+		}
+	case "carousel":
+		bubbles := asSlice(c["contents"])
+		if len(bubbles) == 0 || len(bubbles) > lineMaxCarouselBubbles {
+			return fmt.Errorf("%w: carousel must have 1-%d bubbles", ErrInvalidLineMessage, lineMaxCarouselBubbles)
+		}
+		if size := jsonSize(c); size > lineMaxCarouselBytes {
+			return fmt.Errorf("%w: carousel is %d bytes (max %d)", ErrInvalidLineMessage, size, lineMaxCarouselBytes)
+		}
+		for _, b := range bubbles {
+			if err := validateFlexContainer(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: flex contents must be bubble or carousel", ErrInvalidLineMessage)
+	}
+	return validateFlexActions(c)
+}
+
+// validateFlexActions ไล่ตรวจ action ทุกตัวใน component
+func validateFlexActions(v interface{}) error {
+	if c, ok := asMap(v); ok {
+		for key, child := range c {
+			if key == "action" {
+				if err := validateLineAction(child); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := validateFlexActions(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, child := range asSlice(v) {
+		if err := validateFlexActions(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLineAction(v interface{}) error {
+	a, ok := asMap(v)
+	if !ok {
+		return fmt.Errorf("%w: missing action", ErrInvalidLineMessage)
+	}
+	label, _ := a["label"].(string)
+	if utf8.RuneCountInString(label) > lineMaxActionLabel {
+		return fmt.Errorf("%w: action label %q exceeds %d chars", ErrInvalidLineMessage, label, lineMaxActionLabel)
+	}
+	switch a["type"] {
+	case "postback", "datetimepicker":
+		data, _ := a["data"].(string)
+		if data == "" || len(data) > lineMaxPostbackData {
+			return fmt.Errorf("%w: postback data must be 1-%d bytes", ErrInvalidLineMessage, lineMaxPostbackData)
+		}
+	case "uri":
+		u, _ := a["uri"].(string)
+		if len(u) == 0 || len(u) > lineMaxURLChars {
+			return fmt.Errorf("%w: uri must be 1-%d chars", ErrInvalidLineMessage, lineMaxURLChars)
+		}
+	}
+	return nil
+}
+
+func validateLineURL(raw string) error {
+	if len(raw) > lineMaxURLChars {
+		return fmt.Errorf("%w: url exceeds %d chars", ErrInvalidLineMessage, lineMaxURLChars)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: url must be https", ErrInvalidLineMessage)
+	}
+	return nil
+}
+
+// asMap รองรับทั้งชนิดที่สร้างจาก builder และ map ที่ decode มาจาก JSON
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case FlexComponent:
+		return m, true
+	case LineAction:
+		return m, true
+	case LineMessage:
+		return m, true
+	}
+	return nil, false
+}
+
+func asSlice(v interface{}) []interface{} {
+	switch s := v.(type) {
+	case []interface{}:
+		return s
+	case []FlexComponent:
+		out := make([]interface{}, len(s))
+		for i := range s {
+			out[i] = s[i]
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(s))
+		for i := range s {
+			out[i] = s[i]
+		}
+		return out
+	}
+	return nil
+}
+
+func jsonSize(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// truncateRunes ตัดข้อความให้ไม่เกิน max ตัวอักษร (ไม่ตัดกลาง UTF-8)
+func truncateRunes(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:max-1]) + "…"
+}
+
+// ── Templates ───────────────────────────────────────────────
+
+// TarotReadingMessages ผลการเปิดไพ่: carousel ไพ่แต่ละตำแหน่ง (รูปจาก deck) ตามด้วยคำทำนาย
+func TarotReadingMessages(l *Localizer, draw *TarotDraw, interpretation string) []LineMessage {
+	var messages []LineMessage
+	if draw != nil && len(draw.Cards) > 0 {
+		bubbles := make([]FlexComponent, 0, len(draw.Cards))
+		for i, card := range draw.Cards {
+			if i == lineMaxCarouselBubbles {
+				break
+			}
+			var hero FlexComponent
+			if validateLineURL(card.ImageURL) == nil {
+				hero = FlexImage(card.ImageURL).With("aspectRatio", "2:3")
+			}
+			name := card.CardName
+			if card.Reversed {
+				name = l.T("tarot.reversed_card", "Card", card.CardName)
+			}
+			body := FlexBox("vertical",
+				FlexText(card.PositionName).With("size", "sm").With("color", "#8c8c8c"),
+				FlexText(name).With("weight", "bold").With("size", "lg"),
+			)
+			bubbles = append(bubbles, FlexBubble(hero, body, nil).With("size", "kilo"))
+		}
+		alt := l.T("tarot.reading_title")
+		if draw.Question != "" {
+			alt = l.T("tarot.reading_question", "Question", draw.Question)
+		}
+		messages = append(messages, LineFlex(alt, FlexCarousel(bubbles...)))
+	}
+	if strings.TrimSpace(interpretation) != "" {
+		messages = append(messages, LineText(interpretation))
+	}
+	return messages
+}
+
+// PackageCatalogMessage carousel แพ็กเกจ พร้อมปุ่มซื้อ (postback action=buy_package)
+func PackageCatalogMessage(l *Localizer, pkgs []Package) LineMessage {
+	if len(pkgs) == 0 {
+		return LineText(l.T("package.none"))
+	}
+	bubbles := make([]FlexComponent, 0, len(pkgs))
+	for i, p := range pkgs {
+		if i == lineMaxCarouselBubbles {
+			break
+		}
+		body := FlexBox("vertical",
+			FlexText(p.Name).With("weight", "bold").With("size", "xl"),
+			FlexText(l.T("unit.coins", "Count", p.CoinCost)).With("size", "lg").With("color", "#7b4fc9"),
+			FlexText(l.T("package.valid_days", "Count", p.DurationDays)).With("size", "sm").With("color", "#8c8c8c"),
+		).With("spacing", "sm")
+		footer := FlexBox("vertical",
+			FlexButton(PostbackAction(l.T("package.buy_button"), "action=buy_package&packageId="+url.QueryEscape(p.ID), l.T("package.buy_display", "Name", p.Name))).With("style", "primary"),
+		)
+		bubbles = append(bubbles, FlexBubble(nil, body, footer))
+	}
+	return LineFlex(l.T("package.catalog_title"), FlexCarousel(bubbles...))
+}
+
+// LineBooking ข้อมูลที่แสดงในข้อความยืนยันการจอง
+type LineBooking struct {
+	ID       string
+	SeerName string
+	Topic    string
+	StartAt  time.Time
+	CoinCost int64
+}
+
+// BookingConfirmationMessage bubble ยืนยันการจอง พร้อมปุ่มยกเลิก (postback action=cancel_booking)
+func BookingConfirmationMessage(l *Localizer, b LineBooking) LineMessage {
+	row := func(label, value string) FlexComponent {
+		return FlexBox("baseline",
+			FlexText(label).With("size", "sm").With("color", "#8c8c8c").With("flex", 2),
+			FlexText(value).With("size", "sm").With("flex", 5),
+		)
+	}
+	rows := []FlexComponent{
+		FlexText(l.T("booking.card_title")).With("weight", "bold").With("size", "lg"),
+		FlexSeparator(),
+		row(l.T("booking.card_seer"), b.SeerName),
+		row(l.T("booking.card_time"), l.T("booking.card_start", "Start", b.StartAt)),
+	}
+	if b.Topic != "" {
+		rows = append(rows, row(l.T("booking.card_topic"), b.Topic))
+	}
+	if b.CoinCost > 0 {
+		rows = append(rows, row(l.T("booking.card_price"), l.T("unit.coins", "Count", b.CoinCost)))
+	}
+	body := FlexBox("vertical", rows...).With("spacing", "md")
+	footer := FlexBox("vertical",
+		FlexButton(PostbackAction(l.T("booking.cancel_button"), "action=cancel_booking&bookingId="+url.QueryEscape(b.ID), l.T("booking.cancel_button"))),
+	)
+	return LineFlex(l.T("booking.card_alt", "Seer", b.SeerName), FlexBubble(nil, body, footer))
+}
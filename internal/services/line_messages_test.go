@@ -0,0 +1,76 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLineMessages(t *testing.T) {
+	ok := []LineMessage{
+		LineText("สวัสดี").WithQuickReply(PostbackAction("ดูแพ็กเกจ", "action=packages", "")),
+		PackageCatalogMessage(NewLocalizer("th"), []Package{{ID: "p1", Name: "รายเดือน", CoinCost: 100, DurationDays: 30}}),
+		BookingConfirmationMessage(NewLocalizer("en"), LineBooking{ID: "b1", SeerName: "แม่หมอ", CoinCost: 50}),
+	}
+	assert.NoError(t, ValidateLineMessages(ok))
+
+	assert.ErrorIs(t, ValidateLineMessages(nil), ErrInvalidLineMessage)
+	assert.ErrorIs(t, ValidateLineMessages(make([]LineMessage, 6)), ErrInvalidLineMessage)
+	assert.ErrorIs(t, ValidateLineMessages([]LineMessage{LineImage("http://x/a.png", "")}), ErrInvalidLineMessage)
+
+	tooMany := make([]LineAction, 14)
+	for i := range tooMany {
+		tooMany[i] = MessageAction("a", "a")
+	}
+	assert.ErrorIs(t, ValidateLineMessages([]LineMessage{LineText("x").WithQuickReply(tooMany...)}), ErrInvalidLineMessage)
+
+	longLabel := LineText("x").WithQuickReply(MessageAction(strings.Repeat("ก", 21), "a"))
+	assert.ErrorIs(t, ValidateLineMessages([]LineMessage{longLabel}), ErrInvalidLineMessage)
+
+	big := FlexBubble(nil, FlexBox("vertical", FlexText(strings.Repeat("a", lineMaxBubbleBytes))), nil)
+	assert.ErrorIs(t, ValidateLineMessages([]LineMessage{LineFlex("big", big)}), ErrInvalidLineMessage)
+
+	// ข้อความที่ decode มาจาก JSON ต้องตรวจได้เหมือนกัน
+	var decoded []LineMessage
+	data, _ := json.Marshal(ok)
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.NoError(t, ValidateLineMessages(decoded))
+}
+
+func TestTarotReadingMessages(t *testing.T) {
+	draw := &TarotDraw{Question: "งาน", Cards: []DrawnCard{
+		{PositionName: "อดีต", CardName: "The Fool", ImageURL: "https://cdn.example.com/fool.png"},
+		{PositionName: "ปัจจุบัน", CardName: "The Tower", Reversed: true},
+	}}
+	msgs := TarotReadingMessages(NewLocalizer("th"), draw, strings.Repeat("ดี", 3000))
+	assert.Len(t, msgs, 2)
+	assert.NoError(t, ValidateLineMessages(msgs))
+	assert.Equal(t, lineMaxTextChars, len([]rune(msgs[1]["text"].(string))))
+	assert.Equal(t, "ผลการเปิดไพ่: งาน", msgs[0]["altText"])
+
+	en := TarotReadingMessages(NewLocalizer("en"), draw, "")
+	assert.Equal(t, "Your tarot reading: งาน", en[0]["altText"])
+	assert.Contains(t, string(mustJSON(t, en)), "The Tower (reversed)")
+}
+
+func TestLocalizedLineTemplates(t *testing.T) {
+	en := NewLocalizer("en")
+	pkgs := string(mustJSON(t, PackageCatalogMessage(en, []Package{{ID: "p1", Name: "Monthly", CoinCost: 1, DurationDays: 30}})))
+	assert.Contains(t, pkgs, "1 coin")
+	assert.Contains(t, pkgs, "Valid for 30 days")
+	assert.Contains(t, pkgs, "Buy package")
+	assert.NotContains(t, pkgs, "เหรียญ")
+
+	booking := string(mustJSON(t, BookingConfirmationMessage(en, LineBooking{ID: "b1", SeerName: "Mae", CoinCost: 200})))
+	assert.Contains(t, booking, "Booking confirmed with Mae")
+	assert.Contains(t, booking, "200 coins")
+	assert.NotContains(t, booking, "ราคา")
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return data
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	lineAPIBaseURL  = "https://api.line.me/v2/bot"
+	lineDataBaseURL = "https://api-data.line.me/v2/bot" // อัปโหลด/ดาวน์โหลด content เช่นรูป rich menu
+)
+
+// lineEventTTL เวลาที่เก็บ webhookEventId ไว้กันประมวลผลซ้ำ (ใช้กับ Firestore TTL บน expireAt)
+const lineEventTTL = 7 * 24 * time.Hour
+
+// สถานะของ line_events/{webhookEventId}
+const (
+	lineEventProcessing = "processing"
+	lineEventDone       = "done"
+	lineEventLease      = 2 * time.Minute // ต้องนานกว่า timeout ของการประมวลผลหนึ่ง event
+)
+
+// LineWebhook body ที่ LINE ส่งมา
+type LineWebhook struct {
+	Destination string      `json:"destination"`
+	Events      []LineEvent `json:"events"`
+}
+
+// LineEvent event หนึ่งรายการ (field ที่ไม่เกี่ยวกับ Type จะว่าง)
+type LineEvent struct {
+	Type            string `json:"type"` // "message", "follow", "unfollow", "postback", ...
+	Mode            string `json:"mode"`
+	Timestamp       int64  `json:"timestamp"`
+	WebhookEventID  string `json:"webhookEventId"`
+	ReplyToken      string `json:"replyToken"`
+	DeliveryContext struct {
+		IsRedelivery bool `json:"isRedelivery"`
+	} `json:"deliveryContext"`
+	Source struct {
+		Type    string `json:"type"` // "user", "group", "room"
+		UserID  string `json:"userId"`
+		GroupID string `json:"groupId,omitempty"`
+		RoomID  string `json:"roomId,omitempty"`
+	} `json:"source"`
+	Message struct {
+		ID        string `json:"id"`
+		Type      string `json:"type"` // "text", "image", "sticker", ...
+		Text      string `json:"text,omitempty"`
+		PackageID string `json:"packageId,omitempty"`
+		StickerID string `json:"stickerId,omitempty"`
+	} `json:"message"`
+	Postback struct {
+		Data   string            `json:"data"`
+		Params map[string]string `json:"params,omitempty"`
+	} `json:"postback"`
+	Link struct {
+		Result string `json:"result"` // "ok" หรือ "failed"
+		Nonce  string `json:"nonce"`
+	} `json:"link"` // event "accountLink"
+}
+
+// VerifyLineSignature ตรวจ X-Line-Signature = base64(HMAC-SHA256(channelSecret, body))
+func VerifyLineSignature(channelSecret string, body []byte, signature string) bool {
+	if channelSecret == "" || signature == "" {
+		return false
+	}
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(channelSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// LineService เรียก Messaging API และเก็บสถานะผู้ใช้ LINE (collection "line_users")
+type LineService struct {
+	accessToken string
+	baseURL     string
+	dataBaseURL string
+	httpClient  *http.Client
+	eventCol    *firestore.CollectionRef
+	userCol     *firestore.CollectionRef
+}
+
+func NewLineService(accessToken string) *LineService {
+	return &LineService{
+		accessToken: accessToken,
+		baseURL:     lineAPIBaseURL,
+		dataBaseURL: lineDataBaseURL,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		eventCol:    utils.Client.Collection("line_events"),
+		userCol:     utils.Client.Collection("line_users"),
+	}
+}
+
+// ClaimEvent จอง webhookEventId ก่อนประมวลผล คืน false ถ้า event นี้ทำเสร็จแล้วหรือกำลังทำอยู่ (LINE ส่งซ้ำ)
+// การจองที่ค้างเกิน lineEventLease (process ตายกลางทาง) ถือว่าว่าง ให้ event ที่ส่งซ้ำทำต่อได้
+func (s *LineService) ClaimEvent(ctx context.Context, ev LineEvent) (bool, error) {
+	if ev.WebhookEventID == "" {
+		return true, nil
+	}
+	ref := s.eventCol.Doc(ev.WebhookEventID)
+	claimed := false
+	err := utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		now := time.Now()
+		if err == nil {
+			// document เก่าที่ไม่มี status = ทำเสร็จแล้ว
+			state, _ := snap.Data()["status"].(string)
+			claimedAt, _ := snap.Data()["claimedAt"].(time.Time)
+			if state != lineEventProcessing || now.Sub(claimedAt) < lineEventLease {
+				return nil
+			}
+		}
+		claimed = true
+		// lineUserId ให้คำขอลบข้อมูล (PDPA) หา event ของผู้ใช้เจอ
+		return tx.Set(ref, map[string]interface{}{
+			"type":       ev.Type,
+			"lineUserId": ev.Source.UserID,
+			"status":     lineEventProcessing,
+			"claimedAt":  now,
+			"expireAt":   now.Add(lineEventTTL),
+		}, firestore.MergeAll)
+	})
+	return claimed, err
+}
+
+// CompleteEvent บันทึกว่าประมวลผล event เสร็จแล้ว (LINE ส่งซ้ำจะถูกข้าม)
+func (s *LineService) CompleteEvent(ctx context.Context, ev LineEvent) error {
+	if ev.WebhookEventID == "" {
+		return nil
+	}
+	_, err := s.eventCol.Doc(ev.WebhookEventID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: lineEventDone},
+		{Path: "completedAt", Value: time.Now()},
+	})
+	return err
+}
+
+// ReleaseEvent ลบการจองของ event ที่ประมวลผลไม่สำเร็จ ให้ event ที่ LINE ส่งซ้ำทำใหม่ได้
+func (s *LineService) ReleaseEvent(ctx context.Context, ev LineEvent) error {
+	if ev.WebhookEventID == "" {
+		return nil
+	}
+	_, err := s.eventCol.Doc(ev.WebhookEventID).Delete(ctx)
+	return err
+}
+
+// SetFollowing บันทึกว่าผู้ใช้เพิ่มเพื่อน/บล็อก OA
+func (s *LineService) SetFollowing(ctx context.Context, lineUserID string, following bool) error {
+	field := "unfollowedAt"
+	if following {
+		field = "followedAt"
+	}
+	_, err := s.userCol.Doc(lineUserID).Set(ctx, map[string]interface{}{
+		"following": following,
+		field:       time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// ReplyText ตอบกลับด้วยข้อความธรรมดา
+func (s *LineService) ReplyText(ctx context.Context, replyToken, text string) error {
+	return s.Reply(ctx, replyToken, LineText(text))
+}
+
+// Reply ตอบกลับด้วย reply token (ใช้ได้ครั้งเดียวและหมดอายุเร็ว) ได้สูงสุด 5 ข้อความ
+func (s *LineService) Reply(ctx context.Context, replyToken string, messages ...LineMessage) error {
+	if err := ValidateLineMessages(messages); err != nil {
+		return err
+	}
+	return s.post(ctx, "/message/reply", map[string]interface{}{
+		"replyToken": replyToken,
+		"messages":   messages,
+	})
+}
+
+// Push ส่งข้อความหาผู้ใช้โดยไม่ต้องมี reply token
+func (s *LineService) Push(ctx context.Context, to string, messages ...LineMessage) error {
+	if err := ValidateLineMessages(messages); err != nil {
+		return err
+	}
+	return s.post(ctx, "/message/push", map[string]interface{}{
+		"to":       to,
+		"messages": messages,
+	})
+}
+
+// LineProfile ข้อมูลโปรไฟล์ของผู้ใช้ที่เป็นเพื่อนกับ OA
+type LineProfile struct {
+	UserID        string `json:"userId"`
+	DisplayName   string `json:"displayName"`
+	PictureURL    string `json:"pictureUrl,omitempty"`
+	StatusMessage string `json:"statusMessage,omitempty"`
+	Language      string `json:"language,omitempty"` // ภาษาที่ผู้ใช้ตั้งในแอป LINE (ไม่มีถ้าผู้ใช้ไม่อนุญาต)
+}
+
+// GetProfile ดึงโปรไฟล์ผู้ใช้ LINE
+func (s *LineService) GetProfile(ctx context.Context, lineUserID string) (*LineProfile, error) {
+	var profile LineProfile
+	if err := s.do(ctx, "GET", "/profile/"+url.PathEscape(lineUserID), nil, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// IssueLinkToken ออก link token สำหรับ account link (อายุ 10 นาที ใช้ได้ครั้งเดียว)
+func (s *LineService) IssueLinkToken(ctx context.Context, lineUserID string) (string, error) {
+	var out struct {
+		LinkToken string `json:"linkToken"`
+	}
+	if err := s.do(ctx, "POST", "/user/"+url.PathEscape(lineUserID)+"/linkToken", nil, &out); err != nil {
+		return "", err
+	}
+	return out.LinkToken, nil
+}
+
+func (s *LineService) post(ctx context.Context, path string, payload interface{}) error {
+	return s.do(ctx, "POST", path, payload, nil)
+}
+
+// CreateRichMenu สร้าง rich menu ที่ LINE (ยังไม่มีรูป) คืน richMenuId
+func (s *LineService) CreateRichMenu(ctx context.Context, menu interface{}) (string, error) {
+	var out struct {
+		RichMenuID string `json:"richMenuId"`
+	}
+	if err := s.do(ctx, "POST", "/richmenu", menu, &out); err != nil {
+		return "", err
+	}
+	return out.RichMenuID, nil
+}
+
+// UploadRichMenuImage อัปโหลดรูปของ rich menu (image/png หรือ image/jpeg ไม่เกิน 1MB ขนาดต้องตรงกับ size)
+func (s *LineService) UploadRichMenuImage(ctx context.Context, richMenuID, contentType string, image []byte) error {
+	return s.send(ctx, "POST", s.dataBaseURL+"/richmenu/"+url.PathEscape(richMenuID)+"/content", contentType, bytes.NewReader(image), nil)
+}
+
+// DeleteRichMenu ลบ rich menu ที่ LINE
+func (s *LineService) DeleteRichMenu(ctx context.Context, richMenuID string) error {
+	return s.do(ctx, "DELETE", "/richmenu/"+url.PathEscape(richMenuID), nil, nil)
+}
+
+// SetDefaultRichMenu ตั้ง rich menu ที่ผู้ใช้ทุกคนเห็นเมื่อไม่ได้ผูกเมนูเฉพาะคน
+func (s *LineService) SetDefaultRichMenu(ctx context.Context, richMenuID string) error {
+	return s.do(ctx, "POST", "/user/all/richmenu/"+url.PathEscape(richMenuID), nil, nil)
+}
+
+// LinkRichMenu ผูก rich menu กับผู้ใช้หนึ่งคน (มีผลเหนือ default)
+func (s *LineService) LinkRichMenu(ctx context.Context, lineUserID, richMenuID string) error {
+	return s.do(ctx, "POST", "/user/"+url.PathEscape(lineUserID)+"/richmenu/"+url.PathEscape(richMenuID), nil, nil)
+}
+
+// UnlinkRichMenu ยกเลิกเมนูเฉพาะคน ผู้ใช้จะกลับไปเห็น default
+func (s *LineService) UnlinkRichMenu(ctx context.Context, lineUserID string) error {
+	return s.do(ctx, "DELETE", "/user/"+url.PathEscape(lineUserID)+"/richmenu", nil, nil)
+}
+
+// do เรียก Messaging API ด้วย JSON และ decode ผลลัพธ์ลง out (nil = ไม่สนใจ body)
+func (s *LineService) do(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(data)
+		contentType = "application/json"
+	}
+	return s.send(ctx, method, s.baseURL+path, contentType, body, out)
+}
+
+func (s *LineService) send(ctx context.Context, method, endpoint, contentType string, body io.Reader, out interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+s.accessToken)
+	resp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("line api %s %s status %d: %s", method, endpoint, resp.StatusCode, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
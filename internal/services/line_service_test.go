@@ -0,0 +1,23 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyLineSignature(t *testing.T) {
+	body := []byte(`{"destination":"U1","events":[]}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	assert.True(t, VerifyLineSignature("secret", body, sig))
+	assert.False(t, VerifyLineSignature("other", body, sig))
+	assert.False(t, VerifyLineSignature("secret", []byte(`{}`), sig))
+	assert.False(t, VerifyLineSignature("", body, sig))
+	assert.False(t, VerifyLineSignature("secret", body, "not-base64!"))
+}
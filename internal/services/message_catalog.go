@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrUnknownLocale     = errors.New("unknown locale")
+	ErrUnknownMessageKey = errors.New("unknown message key")
+	ErrInvalidMessage    = errors.New("invalid message template")
+)
+
+const messageCatalogTTL = time.Minute
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// defaultMessages ข้อความตั้งต้นของแต่ละ locale (locales/<locale>.json) admin แก้ทับได้ใน Firestore
+// ข้อความที่มีรูปพหูพจน์ใช้ key ลงท้าย .one/.other (ภาษาไทยใช้ key เดียว)
+var defaultMessages = mustLoadLocales(notificationLanguages...)
+
+func mustLoadLocales(locales ...string) map[string]map[string]string {
+	out := make(map[string]map[string]string, len(locales))
+	for _, locale := range locales {
+		data, err := localeFS.ReadFile("locales/" + locale + ".json")
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("locales/%s.json: %v", locale, err))
+		}
+		out[locale] = messages
+	}
+	return out
+}
+
+// NormalizeLocale "en-US" → "en" ภาษาที่ยังไม่รองรับใช้ภาษาอังกฤษ ค่าว่างใช้ภาษาไทย
+func NormalizeLocale(lang string) string {
+	lang = strings.ToLower(lang)
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	switch {
+	case lang == "":
+		return defaultNotificationLanguage
+	case slices.Contains(notificationLanguages, lang):
+		return lang
+	}
+	return "en"
+}
+
+// Localizer ข้อความของ locale หนึ่ง (ค่าตั้งต้นรวมกับค่าที่ admin แก้) nil = ภาษาไทยตั้งต้น
+type Localizer struct {
+	locale   string
+	messages map[string]string
+}
+
+// NewLocalizer ใช้ข้อความตั้งต้นอย่างเดียว (ไม่อ่านค่าที่ admin แก้ใน Firestore)
+func NewLocalizer(locale string) *Localizer {
+	locale = NormalizeLocale(locale)
+	return &Localizer{locale: locale, messages: defaultMessages[locale]}
+}
+
+func (l *Localizer) Locale() string {
+	if l == nil {
+		return defaultNotificationLanguage
+	}
+	return l.locale
+}
+
+// T ข้อความของ key โดย args เป็นคู่ชื่อ/ค่า เช่น T("package.bought", "ExpiresAt", t)
+// args ที่มี "Count" จะเลือกรูป .one/.other ตามภาษา ไม่พบ key ใน locale นี้ใช้ภาษาไทย ไม่พบเลยคืน key
+func (l *Localizer) T(key string, args ...interface{}) string {
+	if l == nil {
+		l = NewLocalizer(defaultNotificationLanguage)
+	}
+	data := make(map[string]interface{}, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		name, _ := args[i].(string)
+		data[name] = args[i+1]
+	}
+	src, ok := l.lookup(key, data["Count"])
+	if !ok {
+		return key
+	}
+	if !strings.Contains(src, "{{") {
+		return src
+	}
+	tpl, err := template.New(key).Funcs(l.Funcs()).Parse(src)
+	if err != nil {
+		log.Printf("Error parsing message %s/%s: %v", l.locale, key, err)
+		return key
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		log.Printf("Error rendering message %s/%s: %v", l.locale, key, err)
+		return key
+	}
+	return buf.String()
+}
+
+func (l *Localizer) lookup(key string, count interface{}) (string, bool) {
+	candidates := []string{key}
+	if count != nil {
+		candidates = []string{key + "." + pluralForm(l.locale, count), key + ".other", key}
+	}
+	for _, messages := range []map[string]string{l.messages, defaultMessages[defaultNotificationLanguage]} {
+		for _, k := range candidates {
+			if src, ok := messages[k]; ok {
+				return src, true
+			}
+		}
+	}
+	return "", false
+}
+
+// pluralForm รูปพหูพจน์ตามภาษา (ภาษาไทยไม่มีรูปพหูพจน์)
+func pluralForm(locale string, count interface{}) string {
+	n, ok := messageInt(count)
+	if locale == "en" && ok && n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+// Funcs ฟังก์ชันที่ใช้ในข้อความและ template อีเมล
+//
+//	number 1234 → 1,234 | coins 2 → "2 เหรียญ" | thb 1500 → "1,500 บาท" | plural "unit.cards" 3
+//	date / datetime (เวลาไทย) | t "key" ข้อความอื่นใน catalog | locale
+func (l *Localizer) Funcs() template.FuncMap {
+	dateLayout, datetimeLayout := "02/01/2006", "02/01/2006 15:04"
+	if l.Locale() == "en" {
+		dateLayout, datetimeLayout = "2 Jan 2006", "2 Jan 2006 15:04"
+	}
+	return template.FuncMap{
+		"number": func(v interface{}) string {
+			if n, ok := messageInt(v); ok {
+				return formatThousands(n)
+			}
+			return fmt.Sprint(v)
+		},
+		"coins":    func(n interface{}) string { return l.T("unit.coins", "Count", n) },
+		"thb":      func(n interface{}) string { return l.T("unit.thb", "Count", n) },
+		"plural":   func(key string, n interface{}) string { return l.T(key, "Count", n) },
+		"date":     func(t time.Time) string { return t.In(lineDisplayZone).Format(dateLayout) },
+		"datetime": func(t time.Time) string { return t.In(lineDisplayZone).Format(datetimeLayout) },
+		"t":        func(key string) string { return l.T(key) },
+		"locale":   l.Locale,
+	}
+}
+
+func messageInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case float64:
+		return int64(n), n == float64(int64(n))
+	}
+	return 0, false
+}
+
+// MessageEntry ข้อความหนึ่ง key สำหรับหน้า admin (Override ว่าง = ใช้ค่าตั้งต้น)
+type MessageEntry struct {
+	Key      string `json:"key"`
+	Default  string `json:"default"`
+	Override string `json:"override,omitempty"`
+	Text     string `json:"text"`
+}
+
+// MessageCatalogService ข้อความที่ผู้ใช้เห็น (LINE/อีเมล) แยกตามภาษา
+// ค่าที่ admin แก้อยู่ใน collection "message_catalog" (document id = "<locale>:<key>") cache ไว้ 1 นาที
+type MessageCatalogService struct {
+	col         *firestore.CollectionRef
+	lineUserCol *firestore.CollectionRef
+	prefs       *NotificationPreferenceService
+
+	mu        sync.RWMutex
+	overrides map[string]map[string]string
+	loadedAt  time.Time
+}
+
+func NewMessageCatalogService(prefs *NotificationPreferenceService) *MessageCatalogService {
+	return &MessageCatalogService{
+		col:         utils.Client.Collection("message_catalog"),
+		lineUserCol: utils.Client.Collection("line_users"),
+		prefs:       prefs,
+	}
+}
+
+// Localizer ข้อความของ locale (ถ้าโหลดค่าที่ admin แก้ไม่ได้ใช้ค่าตั้งต้น)
+func (s *MessageCatalogService) Localizer(ctx context.Context, locale string) *Localizer {
+	l := NewLocalizer(locale)
+	overrides, err := s.loadOverrides(ctx)
+	if err != nil {
+		log.Println("Error loading message catalog, using defaults:", err)
+		return l
+	}
+	if len(overrides[l.locale]) == 0 {
+		return l
+	}
+	merged := make(map[string]string, len(l.messages)+len(overrides[l.locale]))
+	for k, v := range l.messages {
+		merged[k] = v
+	}
+	for k, v := range overrides[l.locale] {
+		merged[k] = v
+	}
+	l.messages = merged
+	return l
+}
+
+// UserLocale ภาษาที่ผู้ใช้ตั้งใน notification preferences ถ้ายังไม่เคยตั้งใช้ภาษาของ LINE (ถ้ามี)
+func (s *MessageCatalogService) UserLocale(ctx context.Context, userID, lineLanguage string) string {
+	if userID != "" {
+		lang, err := s.prefs.Language(ctx, userID)
+		if err != nil {
+			log.Println("Error loading user language:", err)
+		}
+		if lang != "" {
+			return NormalizeLocale(lang)
+		}
+	}
+	return NormalizeLocale(lineLanguage)
+}
+
+// ForLineUser Localizer ของผู้ใช้ LINE (line_users เก็บ userId และภาษาจากโปรไฟล์ LINE ตอนสร้างบัญชี)
+func (s *MessageCatalogService) ForLineUser(ctx context.Context, lineUserID string) *Localizer {
+	var userID, lineLanguage string
+	if lineUserID != "" {
+		snap, err := s.lineUserCol.Doc(lineUserID).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			log.Println("Error loading LINE user language:", err)
+		}
+		if err == nil {
+			userID, _ = snap.Data()["userId"].(string)
+			lineLanguage, _ = snap.Data()["language"].(string)
+		}
+	}
+	return s.Localizer(ctx, s.UserLocale(ctx, userID, lineLanguage))
+}
+
+// Entries ข้อความทั้งหมดของ locale เรียงตาม key
+func (s *MessageCatalogService) Entries(ctx context.Context, locale string) ([]MessageEntry, error) {
+	defaults, ok := defaultMessages[locale]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownLocale, locale)
+	}
+	overrides, err := s.loadOverrides(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(defaults))
+	for k := range defaults {
+		keys[k] = true
+	}
+	for k := range overrides[locale] {
+		keys[k] = true
+	}
+	entries := make([]MessageEntry, 0, len(keys))
+	for k := range keys {
+		e := MessageEntry{Key: k, Default: defaults[k], Override: overrides[locale][k], Text: defaults[k]}
+		if e.Override != "" {
+			e.Text = e.Override
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// SetMessage แก้ข้อความของ key (ตรวจว่า template ใช้ได้ก่อนบันทึก) มีผลกับทุก instance ภายใน 1 นาที
+func (s *MessageCatalogService) SetMessage(ctx context.Context, locale, key, text, updatedBy string) error {
+	if err := validateMessage(locale, key, text); err != nil {
+		return err
+	}
+	_, err := s.col.Doc(locale+":"+key).Set(ctx, map[string]interface{}{
+		"locale":    locale,
+		"key":       key,
+		"text":      text,
+		"updatedBy": updatedBy,
+		"updatedAt": time.Now(),
+	})
+	s.invalidate()
+	return err
+}
+
+// ResetMessage ลบค่าที่แก้ไว้ กลับไปใช้ข้อความตั้งต้น
+func (s *MessageCatalogService) ResetMessage(ctx context.Context, locale, key string) error {
+	if _, ok := defaultMessages[locale]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownLocale, locale)
+	}
+	_, err := s.col.Doc(locale + ":" + key).Delete(ctx)
+	s.invalidate()
+	return err
+}
+
+func (s *MessageCatalogService) invalidate() {
+	s.mu.Lock()
+	s.overrides = nil
+	s.mu.Unlock()
+}
+
+func (s *MessageCatalogService) loadOverrides(ctx context.Context) (map[string]map[string]string, error) {
+	s.mu.RLock()
+	cached, loadedAt := s.overrides, s.loadedAt
+	s.mu.RUnlock()
+	if cached != nil && time.Since(loadedAt) < messageCatalogTTL {
+		return cached, nil
+	}
+
+	docs, err := s.col.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	overrides := map[string]map[string]string{}
+	for _, doc := range docs {
+		var m struct {
+			Locale string `firestore:"locale"`
+			Key    string `firestore:"key"`
+			Text   string `firestore:"text"`
+		}
+		if err := doc.DataTo(&m); err != nil || m.Text == "" {
+			continue
+		}
+		if overrides[m.Locale] == nil {
+			overrides[m.Locale] = map[string]string{}
+		}
+		overrides[m.Locale][m.Key] = m.Text
+	}
+
+	s.mu.Lock()
+	s.overrides = overrides
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return overrides, nil
+}
+
+// validateMessage key ต้องมีในข้อความตั้งต้นของภาษาใดภาษาหนึ่ง (รวมรูป .one/.other) และ template ต้อง parse ได้
+func validateMessage(locale, key, text string) error {
+	if _, ok := defaultMessages[locale]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownLocale, locale)
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(key, ".one"), ".other")
+	known := false
+	for _, messages := range defaultMessages {
+		_, exact := messages[key]
+		_, plural := messages[base+".other"]
+		_, single := messages[base]
+		if exact || plural || single {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("%w: %s", ErrUnknownMessageKey, key)
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("%w: text is empty", ErrInvalidMessage)
+	}
+	if _, err := template.New(key).Funcs(NewLocalizer(locale).Funcs()).Parse(text); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultMessagesParse(t *testing.T) {
+	for locale, messages := range defaultMessages {
+		funcs := NewLocalizer(locale).Funcs()
+		for key, src := range messages {
+			_, err := template.New(key).Funcs(funcs).Parse(src)
+			assert.NoError(t, err, locale+"/"+key)
+		}
+	}
+}
+
+func TestLocalizerT(t *testing.T) {
+	th, en := NewLocalizer("th"), NewLocalizer("en-US")
+	assert.Equal(t, "en", en.Locale())
+
+	assert.Equal(t, "1 เหรียญ", th.T("unit.coins", "Count", 1))
+	assert.Equal(t, "1,200 เหรียญ", th.T("unit.coins", "Count", int64(1200)))
+	assert.Equal(t, "1 coin", en.T("unit.coins", "Count", 1))
+	assert.Equal(t, "2 coins", en.T("unit.coins", "Count", float64(2)))
+	assert.Equal(t, "฿1,500", en.T("unit.thb", "Count", 1500))
+
+	at := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	assert.Equal(t, "ซื้อแพ็กเกจเรียบร้อย ใช้งานได้ถึง 01/03/2026", th.T("package.bought", "ExpiresAt", at))
+	assert.Equal(t, "Package purchased. It's valid until 1 Mar 2026.", en.T("package.bought", "ExpiresAt", at))
+	assert.Equal(t, "Three cards (3 cards)\nPrice: Free\nReady to draw?",
+		en.T("tarot.confirm", "Spread", "Three cards", "Cards", 3, "Price", en.T("tarot.price_free")))
+
+	// ไม่มี key ในภาษานี้ใช้ภาษาไทย ไม่มีเลยคืน key
+	en.messages = map[string]string{}
+	assert.Equal(t, "เปิดรับข่าวสารแล้ว", en.T("marketing.opted_in"))
+	assert.Equal(t, "no.such.key", th.T("no.such.key"))
+
+	var nilLocalizer *Localizer
+	assert.Equal(t, "ยกเลิก", nilLocalizer.T("flow.cancel_button"))
+}
+
+func TestNormalizeLocale(t *testing.T) {
+	assert.Equal(t, "th", NormalizeLocale(""))
+	assert.Equal(t, "th", NormalizeLocale("th"))
+	assert.Equal(t, "en", NormalizeLocale("en_GB"))
+	assert.Equal(t, "en", NormalizeLocale("ja"))
+}
+
+func TestValidateMessage(t *testing.T) {
+	assert.NoError(t, validateMessage("th", "welcome", "สวัสดี"))
+	assert.NoError(t, validateMessage("th", "unit.coins.other", "{{number .Count}} เหรียญ"))
+	assert.NoError(t, validateMessage("en", "unit.thb", "{{number .Count}} THB"))
+	assert.ErrorIs(t, validateMessage("jp", "welcome", "x"), ErrUnknownLocale)
+	assert.ErrorIs(t, validateMessage("th", "nope", "x"), ErrUnknownMessageKey)
+	assert.ErrorIs(t, validateMessage("th", "welcome", " "), ErrInvalidMessage)
+	assert.ErrorIs(t, validateMessage("th", "welcome", "{{.Name"), ErrInvalidMessage)
+	assert.ErrorIs(t, validateMessage("th", "welcome", "{{nosuchfunc}}"), ErrInvalidMessage)
+}
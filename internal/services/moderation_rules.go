@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// หมวดหมู่ที่ระบบ moderation ตรวจ
+const (
+	CategorySelfHarm        = "self_harm"
+	CategoryAbuse           = "abuse"
+	CategoryMedicalAdvice   = "medical_advice"
+	CategoryFinancialAdvice = "financial_advice"
+	CategoryPII             = "pii"
+)
+
+// moderationPriority ลำดับความสำคัญเมื่อโดนหลายหมวดพร้อมกัน (ข้อความของหมวดแรกถูกใช้ตอน block)
+var moderationPriority = []string{CategorySelfHarm, CategoryAbuse, CategoryMedicalAdvice, CategoryFinancialAdvice, CategoryPII}
+
+// ModerationFlag ผลการตรวจที่เข้าข่ายหนึ่งหมวด
+type ModerationFlag struct {
+	Category string  `firestore:"category" json:"category"`
+	Source   string  `firestore:"source" json:"source"` // "keyword" หรือ "ai"
+	Match    string  `firestore:"match" json:"match,omitempty"`
+	Score    float64 `firestore:"score" json:"score"`
+}
+
+// ModerationClassifier ตัวตรวจข้อความ (เพิ่มตัวใหม่ได้ผ่าน ModerationService)
+type ModerationClassifier interface {
+	Classify(ctx context.Context, text, direction string) ([]ModerationFlag, error)
+}
+
+// KeywordClassifier ตรวจด้วย regex ภาษาไทยและอังกฤษ
+type KeywordClassifier struct {
+	patterns map[string][]*regexp.Regexp
+}
+
+// defaultModerationPatterns regex เริ่มต้นของแต่ละหมวด
+var defaultModerationPatterns = map[string][]string{
+	CategorySelfHarm: {
+		`(?i)\b(kill myself|suicide|end my life|self[- ]harm|want to die)\b`,
+		`ฆ่าตัวตาย|อยากตาย|ไม่อยากมีชีวิต|ทำร้ายตัวเอง|กรีดข้อมือ`,
+	},
+	CategoryAbuse: {
+		`(?i)\b(fuck|bitch|asshole|idiot|retard)\b`,
+		`ควาย|เหี้ย|สัส|ไอ้สัตว์|อีดอก`,
+	},
+	CategoryMedicalAdvice: {
+		`(?i)\b(stop taking (your )?medication|no need to see a doctor|cure (your|the) (cancer|disease))\b`,
+		`หยุดกินยา|ไม่ต้องไปหาหมอ|รักษาหายขาด`,
+	},
+	CategoryFinancialAdvice: {
+		`(?i)\b(guaranteed (profit|return)|buy this stock|invest all your|lottery numbers?)\b`,
+		`รวยแน่นอน|กำไรแน่นอน|ลงทุนทั้งหมด|เลขเด็ด|หวยงวดนี้`,
+	},
+	CategoryPII: {
+		`\b0[689]\d[- ]?\d{3}[- ]?\d{4}\b`,                 // เบอร์มือถือไทย
+		`\b\d[- ]?\d{4}[- ]?\d{5}[- ]?\d{2}[- ]?\d\b`,      // เลขบัตรประชาชน 13 หลัก
+		`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`, // email
+		`\b(?:\d{4}[- ]?){3}\d{4}\b`,                       // เลขบัตรเครดิต
+	},
+}
+
+func NewKeywordClassifier(patterns map[string][]string) *KeywordClassifier {
+	compiled := make(map[string][]*regexp.Regexp, len(patterns))
+	for category, list := range patterns {
+		for _, p := range list {
+			compiled[category] = append(compiled[category], regexp.MustCompile(p))
+		}
+	}
+	return &KeywordClassifier{patterns: compiled}
+}
+
+func (k *KeywordClassifier) Classify(ctx context.Context, text, direction string) ([]ModerationFlag, error) {
+	var flags []ModerationFlag
+	for category, list := range k.patterns {
+		for _, re := range list {
+			if m := re.FindString(text); m != "" {
+				if category == CategoryPII {
+					m = maskPII(m) // flag ถูกบันทึกลง Firestore และแสดงใน admin ห้ามเก็บข้อมูลส่วนบุคคลตัวจริง
+				}
+				flags = append(flags, ModerationFlag{Category: category, Source: "keyword", Match: m, Score: 1})
+				break
+			}
+		}
+	}
+	return flags, nil
+}
+
+// maskPII เหลือไว้แค่ 2 ตัวท้ายพอให้ reviewer รู้ว่าเป็นข้อมูลชนิดไหน เช่น "0812345678" → "********78"
+func maskPII(m string) string {
+	r := []rune(m)
+	keep := 2
+	if len(r) <= keep*2 {
+		keep = 0
+	}
+	return strings.Repeat("*", len(r)-keep) + string(r[len(r)-keep:])
+}
+
+// Redact แทนที่ข้อความที่ตรงกับ regex ของหมวดนั้นด้วย "[ปกปิด]"
+func (k *KeywordClassifier) Redact(category, text string) string {
+	for _, re := range k.patterns[category] {
+		text = re.ReplaceAllString(text, "[ปกปิด]")
+	}
+	return text
+}
+
+// AIModerationClassifier ให้ ai-service จัดหมวด (ใช้เสริม keyword)
+type AIModerationClassifier struct {
+	client    *AIServiceClient
+	threshold float64
+}
+
+func NewAIModerationClassifier(client *AIServiceClient, threshold float64) *AIModerationClassifier {
+	return &AIModerationClassifier{client: client, threshold: threshold}
+}
+
+func (a *AIModerationClassifier) Classify(ctx context.Context, text, direction string) ([]ModerationFlag, error) {
+	resp, err := a.client.Moderate(ctx, AIModerateRequest{Text: text, Direction: direction})
+	if err != nil {
+		return nil, err
+	}
+	var flags []ModerationFlag
+	for category, score := range resp.Categories {
+		if score >= a.threshold {
+			flags = append(flags, ModerationFlag{Category: category, Source: "ai", Score: score})
+		}
+	}
+	return flags, nil
+}
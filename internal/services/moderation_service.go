@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+)
+
+// การกระทำที่ตั้งได้ต่อหมวด
+const (
+	ModerationBlock    = "block"    // ไม่ส่งต่อ ตอบด้วย Message แทน
+	ModerationSoften   = "soften"   // ส่งต่อได้ แต่แนบ Message ท้ายคำตอบ
+	ModerationRedact   = "redact"   // ปกปิดส่วนที่ตรงกับ regex
+	ModerationEscalate = "escalate" // ส่งต่อให้หมอดูตัวจริงดู conversation
+	ModerationAlert    = "alert"    // แจ้ง Telegram
+)
+
+// ModerationPolicy การตั้งค่าของแต่ละหมวด (collection "moderation_policies", document id = category)
+type ModerationPolicy struct {
+	Actions   []string  `firestore:"actions" json:"actions"`
+	Message   string    `firestore:"message" json:"message"`
+	UpdatedAt time.Time `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// DefaultModerationPolicies ใช้เมื่อยังไม่มีการตั้งค่าใน Firestore
+var DefaultModerationPolicies = map[string]ModerationPolicy{
+	CategorySelfHarm: {
+		Actions: []string{ModerationBlock, ModerationEscalate, ModerationAlert},
+		Message: "เราเป็นห่วงคุณนะ หากตอนนี้รู้สึกแย่ สามารถโทรสายด่วนสุขภาพจิต 1323 ได้ตลอด 24 ชั่วโมง",
+	},
+	CategoryAbuse: {
+		Actions: []string{ModerationBlock},
+		Message: "ขอความกรุณาใช้ถ้อยคำที่สุภาพ เพื่อให้เราดูดวงให้คุณได้ดีที่สุดนะคะ",
+	},
+	CategoryMedicalAdvice: {
+		Actions: []string{ModerationSoften},
+		Message: "หมายเหตุ: คำทำนายไม่ใช่คำแนะนำทางการแพทย์ กรุณาปรึกษาแพทย์ผู้เชี่ยวชาญ",
+	},
+	CategoryFinancialAdvice: {
+		Actions: []string{ModerationSoften},
+		Message: "หมายเหตุ: คำทำนายไม่ใช่คำแนะนำทางการเงินหรือการลงทุน",
+	},
+	CategoryPII: {
+		Actions: []string{ModerationRedact},
+	},
+}
+
+// BlockedMessagePlaceholder ข้อความที่บันทึกในประวัติแทนข้อความผู้ใช้ที่ถูก block
+// (ข้อความจริงอาจมีเนื้อหาที่ไม่เหมาะสมหรือข้อมูลส่วนบุคคล ดูฉบับปกปิดแล้วได้ที่ moderation_flags)
+const BlockedMessagePlaceholder = "[ข้อความถูกระงับโดยระบบตรวจสอบเนื้อหา]"
+
+// ModerationResult ผลหลังใช้ policy กับข้อความ
+type ModerationResult struct {
+	Text    string           `json:"text"` // ข้อความหลังปรับ (ถูกแทนที่ถ้า Blocked)
+	Blocked bool             `json:"blocked"`
+	Flags   []ModerationFlag `json:"flags,omitempty"`
+	Actions []string         `json:"actions,omitempty"`
+}
+
+// ModerationItem รายการที่ถูก flag เก็บไว้ให้ admin ตรวจ (collection "moderation_flags")
+type ModerationItem struct {
+	UserID         string           `firestore:"userId" json:"userId"`
+	ConversationID string           `firestore:"conversationId" json:"conversationId"`
+	Direction      string           `firestore:"direction" json:"direction"`
+	Text           string           `firestore:"text" json:"text"`
+	Flags          []ModerationFlag `firestore:"flags" json:"flags"`
+	Actions        []string         `firestore:"actions" json:"actions"`
+	Status         string           `firestore:"status" json:"status"` // "pending", "confirmed", "dismissed"
+	CreatedAt      time.Time        `firestore:"createdAt" json:"createdAt"`
+}
+
+type ModerationService struct {
+	policyCol   *firestore.CollectionRef
+	flagCol     *firestore.CollectionRef
+	convCol     *firestore.CollectionRef
+	keyword     *KeywordClassifier
+	classifiers []ModerationClassifier
+	notifSvc    *NotificationService
+
+	mu             sync.RWMutex
+	policies       map[string]ModerationPolicy
+	policiesLoaded time.Time
+}
+
+const moderationPolicyTTL = time.Minute
+
+// NewModerationService ใช้ keyword classifier เสมอ และ classifier อื่น (เช่น AI) ตามที่ส่งมา
+func NewModerationService(notifSvc *NotificationService, extra ...ModerationClassifier) *ModerationService {
+	keyword := NewKeywordClassifier(defaultModerationPatterns)
+	return &ModerationService{
+		policyCol:   utils.Client.Collection("moderation_policies"),
+		flagCol:     utils.Client.Collection("moderation_flags"),
+		convCol:     utils.Client.Collection("conversations"),
+		keyword:     keyword,
+		classifiers: append([]ModerationClassifier{keyword}, extra...),
+		notifSvc:    notifSvc,
+	}
+}
+
+// Check ตรวจข้อความ (direction = "input" ข้อความผู้ใช้, "output" คำตอบ AI) แล้วใช้ policy
+func (s *ModerationService) Check(ctx context.Context, userID, convID, direction, text string) (*ModerationResult, error) {
+	var flags []ModerationFlag
+	for _, c := range s.classifiers {
+		found, err := c.Classify(ctx, text, direction)
+		if err != nil {
+			// classifier ตัวเสริมล่มไม่ควรทำให้แชทล่ม ใช้ผลของตัวอื่นต่อ
+			log.Println("Error classifying message:", err)
+			continue
+		}
+		flags = append(flags, found...)
+	}
+	if len(flags) == 0 {
+		return &ModerationResult{Text: text}, nil
+	}
+
+	policies, err := s.loadPolicies(ctx)
+	if err != nil {
+		log.Println("Error loading moderation policies, using defaults:", err)
+		policies = DefaultModerationPolicies
+	}
+	result := applyModeration(text, flags, policies, s.keyword.Redact)
+
+	// เก็บข้อความที่ปกปิดข้อมูลส่วนบุคคลแล้วเสมอ (ไม่ว่า policy ของหมวด pii จะเป็นอะไร)
+	item := ModerationItem{
+		UserID:         userID,
+		ConversationID: convID,
+		Direction:      direction,
+		Text:           s.keyword.Redact(CategoryPII, text),
+		Flags:          flags,
+		Actions:        result.Actions,
+		Status:         "pending",
+		CreatedAt:      time.Now(),
+	}
+	docRef, _, err := s.flagCol.Add(ctx, item)
+	if err != nil {
+		log.Println("Error saving moderation flag:", err)
+	}
+
+	for _, action := range result.Actions {
+		switch action {
+		case ModerationEscalate:
+			if convID != "" {
+				_, err := s.convCol.Doc(convID).Set(ctx, map[string]interface{}{
+					"needsHuman":  true,
+					"escalatedAt": time.Now(),
+				}, firestore.MergeAll)
+				if err != nil {
+					log.Println("Error escalating conversation:", err)
+				}
+			}
+		case ModerationAlert:
+			payload := map[string]interface{}{
+				"userId":         userID,
+				"conversationId": convID,
+				"direction":      direction,
+				"categories":     flagCategories(flags),
+				"time":           time.Now().Format(time.RFC3339),
+			}
+			if docRef != nil {
+				payload["flagId"] = docRef.ID
+			}
+			if err := s.notifSvc.SendTelegramAlert(ctx, "moderation_flag", payload); err != nil {
+				log.Println("Error sending moderation alert:", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// applyModeration รวม action ของทุกหมวดที่ถูก flag แล้วปรับข้อความตามลำดับ block > redact > soften
+func applyModeration(text string, flags []ModerationFlag, policies map[string]ModerationPolicy, redact func(category, text string) string) *ModerationResult {
+	flags = sortFlagsByPriority(flags)
+	result := &ModerationResult{Text: text, Flags: flags}
+	seenAction := map[string]bool{}
+	seenCategory := map[string]bool{}
+	var blockMessage string
+	var softenMessages []string
+
+	for _, f := range flags {
+		if seenCategory[f.Category] {
+			continue
+		}
+		seenCategory[f.Category] = true
+		policy, ok := policies[f.Category]
+		if !ok {
+			continue
+		}
+		for _, action := range policy.Actions {
+			if !seenAction[action] {
+				seenAction[action] = true
+				result.Actions = append(result.Actions, action)
+			}
+			switch action {
+			case ModerationBlock:
+				if blockMessage == "" {
+					blockMessage = policy.Message
+				}
+			case ModerationRedact:
+				result.Text = redact(f.Category, result.Text)
+			case ModerationSoften:
+				if policy.Message != "" {
+					softenMessages = append(softenMessages, policy.Message)
+				}
+			}
+		}
+	}
+
+	if seenAction[ModerationBlock] {
+		result.Blocked = true
+		result.Text = blockMessage
+		return result
+	}
+	if len(softenMessages) > 0 {
+		result.Text = result.Text + "\n\n" + strings.Join(softenMessages, "\n")
+	}
+	return result
+}
+
+func sortFlagsByPriority(flags []ModerationFlag) []ModerationFlag {
+	rank := func(category string) int {
+		for i, c := range moderationPriority {
+			if c == category {
+				return i
+			}
+		}
+		return len(moderationPriority)
+	}
+	sorted := make([]ModerationFlag, len(flags))
+	copy(sorted, flags)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i].Category) < rank(sorted[j].Category)
+	})
+	return sorted
+}
+
+func flagCategories(flags []ModerationFlag) []string {
+	var categories []string
+	seen := map[string]bool{}
+	for _, f := range flags {
+		if !seen[f.Category] {
+			seen[f.Category] = true
+			categories = append(categories, f.Category)
+		}
+	}
+	return categories
+}
+
+// loadPolicies รวมค่า default กับค่าที่ admin ตั้งใน Firestore (cache ไว้ 1 นาที)
+func (s *ModerationService) loadPolicies(ctx context.Context) (map[string]ModerationPolicy, error) {
+	s.mu.RLock()
+	cached, loadedAt := s.policies, s.policiesLoaded
+	s.mu.RUnlock()
+	if cached != nil && time.Since(loadedAt) < moderationPolicyTTL {
+		return cached, nil
+	}
+
+	docs, err := s.policyCol.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]ModerationPolicy, len(DefaultModerationPolicies))
+	for category, p := range DefaultModerationPolicies {
+		policies[category] = p
+	}
+	for _, doc := range docs {
+		var p ModerationPolicy
+		if err := doc.DataTo(&p); err != nil {
+			continue
+		}
+		policies[doc.Ref.ID] = p
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.policiesLoaded = time.Now()
+	s.mu.Unlock()
+	return policies, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeywordClassifierThaiAndEnglish(t *testing.T) {
+	k := NewKeywordClassifier(defaultModerationPatterns)
+
+	cases := map[string]string{
+		"ช่วงนี้อยากตายมากเลย": CategorySelfHarm,
+		"I want to die": CategorySelfHarm,
+		"ติดต่อกลับที่ 081-234-5678 นะ":       CategoryPII,
+		"my email is someone@example.com":     CategoryPII,
+		"ขอเลขเด็ดงวดนี้หน่อย":                CategoryFinancialAdvice,
+		"you can stop taking your medication": CategoryMedicalAdvice,
+	}
+	for text, want := range cases {
+		flags, err := k.Classify(context.Background(), text, "input")
+		assert.NoError(t, err)
+		assert.Contains(t, flagCategories(flags), want, text)
+	}
+
+	flags, _ := k.Classify(context.Background(), "ความรักของฉันจะเป็นอย่างไร", "input")
+	assert.Empty(t, flags)
+}
+
+func TestApplyModerationPrefersSelfHarmBlockMessage(t *testing.T) {
+	k := NewKeywordClassifier(defaultModerationPatterns)
+	flags := []ModerationFlag{
+		{Category: CategoryAbuse, Source: "keyword"},
+		{Category: CategorySelfHarm, Source: "keyword"},
+	}
+	result := applyModeration("...", flags, DefaultModerationPolicies, k.Redact)
+
+	assert.True(t, result.Blocked)
+	assert.Equal(t, DefaultModerationPolicies[CategorySelfHarm].Message, result.Text)
+	assert.Contains(t, result.Actions, ModerationAlert)
+	assert.Contains(t, result.Actions, ModerationEscalate)
+}
+
+func TestApplyModerationRedactsAndSoftens(t *testing.T) {
+	k := NewKeywordClassifier(defaultModerationPatterns)
+	text := "โทร 0812345678 แล้วจะรวยแน่นอน"
+	flags, _ := k.Classify(context.Background(), text, "output")
+	result := applyModeration(text, flags, DefaultModerationPolicies, k.Redact)
+
+	assert.False(t, result.Blocked)
+	assert.NotContains(t, result.Text, "0812345678")
+	assert.Contains(t, result.Text, "[ปกปิด]")
+	assert.True(t, strings.HasSuffix(result.Text, DefaultModerationPolicies[CategoryFinancialAdvice].Message))
+}
+
+func TestKeywordClassifierMasksPII(t *testing.T) {
+	k := NewKeywordClassifier(defaultModerationPatterns)
+	text := "โทร 0812345678 หรือ someone@example.com"
+	flags, _ := k.Classify(context.Background(), text, "input")
+	for _, f := range flags {
+		if f.Category == CategoryPII {
+			assert.NotContains(t, f.Match, "0812345678")
+			assert.NotContains(t, f.Match, "someone@example.com")
+		}
+	}
+	assert.Equal(t, "********78", maskPII("0812345678"))
+	assert.Equal(t, "***", maskPII("abc"))
+
+	// ข้อความที่บันทึกใน moderation_flags
+	stored := k.Redact(CategoryPII, text)
+	assert.NotContains(t, stored, "0812345678")
+	assert.NotContains(t, stored, "someone@example.com")
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ประเภทของการแจ้งเตือนที่ผู้ใช้เลือกเปิด/ปิดได้
+const (
+	CategoryReminders = "reminders"
+	CategoryMarketing = "marketing"
+	CategoryBooking   = "booking"
+	CategorySystem    = "system"
+)
+
+const (
+	defaultNotificationTimezone = "Asia/Bangkok"
+	defaultNotificationLanguage = "th"
+)
+
+var (
+	ErrInvalidPreferences     = errors.New("invalid notification preferences")
+	ErrNotificationSuppressed = errors.New("notification suppressed by user preferences")
+)
+
+var (
+	notificationChannels   = []string{ChannelLine, ChannelEmail}
+	notificationCategories = []string{CategoryReminders, CategoryMarketing, CategoryBooking, CategorySystem}
+	notificationLanguages  = []string{"th", "en"}
+)
+
+// transactionalCategories ข้อความที่ผู้ใช้ต้องได้รับเสมอ (ใบเสร็จ การจอง บัญชี) ไม่ถูกปิดด้วย category หรือ quiet hours
+var transactionalCategories = map[string]bool{
+	CategoryBooking: true,
+	CategorySystem:  true,
+}
+
+// QuietHours ช่วงเวลาที่ไม่ส่งข้อความ ("HH:MM" ตาม Timezone ของผู้ใช้) Start > End = ข้ามเที่ยงคืน
+type QuietHours struct {
+	Start string `firestore:"start" json:"start"`
+	End   string `firestore:"end" json:"end"`
+}
+
+// NotificationPreferences การตั้งค่าการแจ้งเตือน (collection "notification_preferences", document id = userId)
+// channel/category ที่ไม่มีใน map ถือว่าเปิด
+type NotificationPreferences struct {
+	UserID     string          `firestore:"userId" json:"userId"`
+	Channels   map[string]bool `firestore:"channels" json:"channels"`
+	Categories map[string]bool `firestore:"categories" json:"categories"`
+	QuietHours *QuietHours     `firestore:"quietHours,omitempty" json:"quietHours,omitempty"`
+	Timezone   string          `firestore:"timezone" json:"timezone"`
+	Language   string          `firestore:"language" json:"language"` // "" = ยังไม่เลือก (ใช้ภาษาของ LINE หรือภาษาไทย)
+	UpdatedAt  time.Time       `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// DefaultNotificationPreferences ค่าเริ่มต้นของผู้ใช้ที่ยังไม่เคยตั้งค่า: เปิดทุกอย่าง ไม่มี quiet hours
+func DefaultNotificationPreferences(userID string) *NotificationPreferences {
+	p := &NotificationPreferences{
+		UserID:     userID,
+		Channels:   map[string]bool{},
+		Categories: map[string]bool{},
+		Timezone:   defaultNotificationTimezone,
+	}
+	for _, ch := range notificationChannels {
+		p.Channels[ch] = true
+	}
+	for _, cat := range notificationCategories {
+		p.Categories[cat] = true
+	}
+	return p
+}
+
+// Decide ตรวจว่าจะส่งข้อความ category นี้ทางช่องทางนี้ได้ไหม
+// คืน deferUntil เมื่ออยู่ใน quiet hours (ให้ส่งตอนหมดช่วงเงียบ) หรือ error ErrNotificationSuppressed
+func (p *NotificationPreferences) Decide(channel, category string, now time.Time) (time.Time, error) {
+	if enabled, ok := p.Channels[channel]; ok && !enabled {
+		return time.Time{}, fmt.Errorf("%w: channel %s disabled", ErrNotificationSuppressed, channel)
+	}
+	if transactionalCategories[category] {
+		return time.Time{}, nil
+	}
+	if enabled, ok := p.Categories[category]; ok && !enabled {
+		return time.Time{}, fmt.Errorf("%w: category %s disabled", ErrNotificationSuppressed, category)
+	}
+	return p.quietUntil(now), nil
+}
+
+// quietUntil เวลาสิ้นสุด quiet hours ถ้า now อยู่ในช่วงนั้น (zero = ส่งได้ทันที)
+func (p *NotificationPreferences) quietUntil(now time.Time) time.Time {
+	if p.QuietHours == nil {
+		return time.Time{}
+	}
+	start, err1 := parseClock(p.QuietHours.Start)
+	end, err2 := parseClock(p.QuietHours.End)
+	if err1 != nil || err2 != nil || start == end {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		loc = lineDisplayZone
+	}
+	local := now.In(loc)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	minute := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	switch {
+	case start < end && minute >= start && minute < end:
+		return midnight.Add(end)
+	case start > end && minute >= start:
+		return midnight.AddDate(0, 0, 1).Add(end)
+	case start > end && minute < end:
+		return midnight.Add(end)
+	}
+	return time.Time{}
+}
+
+// parseClock "HH:MM" → ระยะเวลาตั้งแต่เที่ยงคืน
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+type NotificationPreferenceService struct {
+	col *firestore.CollectionRef
+}
+
+func NewNotificationPreferenceService() *NotificationPreferenceService {
+	return &NotificationPreferenceService{
+		col: utils.Client.Collection("notification_preferences"),
+	}
+}
+
+// Get คืนการตั้งค่าของผู้ใช้ (ค่าเริ่มต้นถ้ายังไม่เคยตั้ง) ค่าที่ขาดจะถูกเติมจากค่าเริ่มต้น
+func (s *NotificationPreferenceService) Get(ctx context.Context, userID string) (*NotificationPreferences, error) {
+	snap, err := s.col.Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return DefaultNotificationPreferences(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p NotificationPreferences
+	if err := snap.DataTo(&p); err != nil {
+		return nil, err
+	}
+	return withPreferenceDefaults(&p, userID), nil
+}
+
+// Language ภาษาที่ผู้ใช้ตั้งไว้ ("" = ยังไม่เคยบันทึกการตั้งค่า ให้ผู้เรียกเดาจากที่อื่น เช่น โปรไฟล์ LINE)
+func (s *NotificationPreferenceService) Language(ctx context.Context, userID string) (string, error) {
+	snap, err := s.col.Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	lang, _ := snap.Data()["language"].(string)
+	return lang, nil
+}
+
+// Update แทนที่การตั้งค่าทั้งหมดของผู้ใช้
+func (s *NotificationPreferenceService) Update(ctx context.Context, userID string, p NotificationPreferences) (*NotificationPreferences, error) {
+	if err := validatePreferences(p); err != nil {
+		return nil, err
+	}
+	out := withPreferenceDefaults(&p, userID)
+	out.UpdatedAt = time.Now()
+	if _, err := s.col.Doc(userID).Set(ctx, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetCategory เปิด/ปิด category เดียว (เช่น ปุ่ม "ไม่รับข่าวสาร" ใน LINE)
+func (s *NotificationPreferenceService) SetCategory(ctx context.Context, userID, category string, enabled bool) error {
+	if !slices.Contains(notificationCategories, category) {
+		return fmt.Errorf("%w: unknown category %q", ErrInvalidPreferences, category)
+	}
+	p, err := s.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	p.Categories[category] = enabled
+	p.UpdatedAt = time.Now()
+	_, err = s.col.Doc(userID).Set(ctx, p)
+	return err
+}
+
+// All การตั้งค่าทั้งหมดที่เคยบันทึก (ใช้คัดผู้รับ campaign) key = userId
+func (s *NotificationPreferenceService) All(ctx context.Context) (map[string]*NotificationPreferences, error) {
+	docs, err := s.col.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]*NotificationPreferences, len(docs))
+	for _, doc := range docs {
+		var p NotificationPreferences
+		if err := doc.DataTo(&p); err != nil {
+			continue
+		}
+		all[doc.Ref.ID] = withPreferenceDefaults(&p, doc.Ref.ID)
+	}
+	return all, nil
+}
+
+func withPreferenceDefaults(p *NotificationPreferences, userID string) *NotificationPreferences {
+	def := DefaultNotificationPreferences(userID)
+	p.UserID = userID
+	if p.Channels == nil {
+		p.Channels = map[string]bool{}
+	}
+	for k, v := range def.Channels {
+		if _, ok := p.Channels[k]; !ok {
+			p.Channels[k] = v
+		}
+	}
+	if p.Categories == nil {
+		p.Categories = map[string]bool{}
+	}
+	for k, v := range def.Categories {
+		if _, ok := p.Categories[k]; !ok {
+			p.Categories[k] = v
+		}
+	}
+	if p.Timezone == "" {
+		p.Timezone = def.Timezone
+	}
+	// Language ว่าง = ผู้ใช้ยังไม่ได้เลือกเอง ห้ามเติมค่าเริ่มต้นตรงนี้ ไม่งั้นบันทึกกลับแล้วกลายเป็นภาษาที่ผู้ใช้เลือก
+	// (UserLocale จะไม่ดูภาษาของ LINE อีก) ผู้ส่งข้อความใช้ NormalizeLocale("") = ภาษาไทยเอง
+	return p
+}
+
+func validatePreferences(p NotificationPreferences) error {
+	for ch := range p.Channels {
+		if !slices.Contains(notificationChannels, ch) {
+			return fmt.Errorf("%w: unknown channel %q", ErrInvalidPreferences, ch)
+		}
+	}
+	for cat := range p.Categories {
+		if !slices.Contains(notificationCategories, cat) {
+			return fmt.Errorf("%w: unknown category %q", ErrInvalidPreferences, cat)
+		}
+	}
+	if p.QuietHours != nil {
+		if _, err := parseClock(p.QuietHours.Start); err != nil {
+			return fmt.Errorf("%w: quietHours.start must be HH:MM", ErrInvalidPreferences)
+		}
+		if _, err := parseClock(p.QuietHours.End); err != nil {
+			return fmt.Errorf("%w: quietHours.end must be HH:MM", ErrInvalidPreferences)
+		}
+	}
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", ErrInvalidPreferences, p.Timezone)
+		}
+	}
+	if p.Language != "" && !slices.Contains(notificationLanguages, p.Language) {
+		return fmt.Errorf("%w: unsupported language %q", ErrInvalidPreferences, p.Language)
+	}
+	return nil
+}
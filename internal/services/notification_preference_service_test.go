@@ -0,0 +1,77 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferencesDecide(t *testing.T) {
+	bkk, _ := time.LoadLocation("Asia/Bangkok")
+	p := DefaultNotificationPreferences("u1")
+	p.Categories[CategoryMarketing] = false
+	p.QuietHours = &QuietHours{Start: "22:00", End: "08:00"}
+
+	noon := time.Date(2026, 3, 1, 12, 0, 0, 0, bkk)
+	until, err := p.Decide(ChannelLine, CategoryReminders, noon)
+	assert.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	_, err = p.Decide(ChannelLine, CategoryMarketing, noon)
+	assert.ErrorIs(t, err, ErrNotificationSuppressed)
+
+	// ข้อความจำเป็นไม่ถูกปิดด้วย category หรือ quiet hours
+	late := time.Date(2026, 3, 1, 23, 30, 0, 0, bkk)
+	until, err = p.Decide(ChannelLine, CategoryBooking, late)
+	assert.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	until, err = p.Decide(ChannelLine, CategoryReminders, late)
+	assert.NoError(t, err)
+	assert.True(t, until.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, bkk)))
+
+	early := time.Date(2026, 3, 2, 6, 0, 0, 0, bkk)
+	until, _ = p.Decide(ChannelLine, CategoryReminders, early)
+	assert.True(t, until.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, bkk)))
+
+	// ปิดช่องทาง = ไม่ส่งแม้เป็นข้อความจำเป็น
+	p.Channels[ChannelLine] = false
+	_, err = p.Decide(ChannelLine, CategorySystem, noon)
+	assert.ErrorIs(t, err, ErrNotificationSuppressed)
+}
+
+func TestQuietHoursSameDay(t *testing.T) {
+	p := DefaultNotificationPreferences("u1")
+	p.Timezone = "UTC"
+	p.QuietHours = &QuietHours{Start: "13:00", End: "14:30"}
+	assert.True(t, p.quietUntil(time.Date(2026, 3, 1, 12, 59, 0, 0, time.UTC)).IsZero())
+	assert.True(t, p.quietUntil(time.Date(2026, 3, 1, 13, 0, 0, 0, time.UTC)).Equal(time.Date(2026, 3, 1, 14, 30, 0, 0, time.UTC)))
+	assert.True(t, p.quietUntil(time.Date(2026, 3, 1, 14, 30, 0, 0, time.UTC)).IsZero())
+}
+
+func TestValidatePreferences(t *testing.T) {
+	assert.NoError(t, validatePreferences(NotificationPreferences{
+		Channels:   map[string]bool{ChannelLine: false},
+		Categories: map[string]bool{CategoryMarketing: false},
+		QuietHours: &QuietHours{Start: "22:00", End: "07:00"},
+		Timezone:   "Asia/Bangkok",
+		Language:   "en",
+	}))
+	assert.ErrorIs(t, validatePreferences(NotificationPreferences{Channels: map[string]bool{"fax": true}}), ErrInvalidPreferences)
+	assert.ErrorIs(t, validatePreferences(NotificationPreferences{Categories: map[string]bool{"promo": true}}), ErrInvalidPreferences)
+	assert.ErrorIs(t, validatePreferences(NotificationPreferences{QuietHours: &QuietHours{Start: "25:00", End: "07:00"}}), ErrInvalidPreferences)
+	assert.ErrorIs(t, validatePreferences(NotificationPreferences{Timezone: "Mars/Base"}), ErrInvalidPreferences)
+	assert.ErrorIs(t, validatePreferences(NotificationPreferences{Language: "jp"}), ErrInvalidPreferences)
+}
+
+func TestWithPreferenceDefaults(t *testing.T) {
+	p := withPreferenceDefaults(&NotificationPreferences{Categories: map[string]bool{CategoryMarketing: false}}, "u1")
+	assert.Equal(t, "u1", p.UserID)
+	assert.False(t, p.Categories[CategoryMarketing])
+	assert.True(t, p.Categories[CategoryReminders])
+	assert.True(t, p.Channels[ChannelLine])
+	assert.Equal(t, "Asia/Bangkok", p.Timezone)
+	assert.Empty(t, p.Language, "language stays unset so UserLocale can fall back to LINE")
+	assert.Equal(t, "en", withPreferenceDefaults(&NotificationPreferences{Language: "en"}, "u1").Language)
+}
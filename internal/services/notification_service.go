@@ -4,92 +4,215 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
 )
 
 type NotificationService struct {
-	lineToken       string
-	telegramBotURL  string // URL ของ telegram-alert-bot (เช่น "http://localhost:5000/alert")
-	telegramBotAuth string // ถ้ามีใช้ Bearer token
+	lineToken  string
+	telegram   *TelegramBot // nil = ยังไม่ได้ตั้งค่า bot ข้อความ alert จะ dead
+	outbox     *OutboxService
+	prefs      *NotificationPreferenceService
+	messages   *MessageCatalogService
+	email      EmailProvider // nil = ยังไม่ได้ตั้งค่าอีเมล ข้อความอีเมลจะ dead
+	userCol    *firestore.CollectionRef
+	httpClient *http.Client
 }
 
-func NewNotificationService(lineToken, telegramBotURL, telegramBotAuth string) *NotificationService {
-	return &NotificationService{
-		lineToken:       lineToken,
-		telegramBotURL:  telegramBotURL,
-		telegramBotAuth: telegramBotAuth,
+// NewNotificationService ลงทะเบียนตัวส่ง LINE/Telegram/อีเมลกับ outbox ด้วย (ส่งจริงโดย OutboxService.Run)
+func NewNotificationService(lineToken string, telegram *TelegramBot, email EmailProvider) *NotificationService {
+	prefs := NewNotificationPreferenceService()
+	s := &NotificationService{
+		lineToken:  lineToken,
+		telegram:   telegram,
+		outbox:     NewOutboxService(),
+		prefs:      prefs,
+		messages:   NewMessageCatalogService(prefs),
+		email:      email,
+		userCol:    utils.Client.Collection("users"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
 	}
+	RegisterOutboxSender(ChannelLine, s.deliverLine)
+	RegisterOutboxSender(ChannelLineMulticast, s.deliverLineMulticast)
+	RegisterOutboxSender(ChannelTelegram, s.deliverTelegram)
+	RegisterOutboxSender(ChannelEmail, s.deliverEmail)
+	return s
 }
 
-// SendLineMessage: ส่งข้อความไปยัง LINE Messaging API
-func (s *NotificationService) SendLineMessage(ctx context.Context, toUserID, message string) error {
-	type lineMessage struct {
-		To       string `json:"to"`
-		Messages []struct {
-			Type string `json:"type"`
-			Text string `json:"text"`
-		} `json:"messages"`
-	}
+// SendLineMessage: ส่งข้อความ text ไปยัง LINE (เข้าคิว outbox)
+func (s *NotificationService) SendLineMessage(ctx context.Context, category, toUserID, message string) error {
+	return s.SendLineMessages(ctx, category, toUserID, LineText(message))
+}
 
-	payload := lineMessage{
-		To: toUserID,
+// SendLineMessages: push หลายข้อความ (text/image/flex + quick reply) ตรวจข้อจำกัดและการตั้งค่าของผู้รับก่อนเข้าคิว
+// คืน ErrNotificationSuppressed ถ้าผู้ใช้ปิดช่องทาง/category นี้ อยู่ใน quiet hours จะเลื่อนไปส่งตอนหมดช่วงเงียบ
+func (s *NotificationService) SendLineMessages(ctx context.Context, category, toUserID string, messages ...LineMessage) error {
+	if err := ValidateLineMessages(messages); err != nil {
+		return err
 	}
-	payload.Messages = []struct {
-		Type string "json:\"type\""
-		Text string "json:\"text\""
-	}{
-		{Type: "text", Text: message},
+	msg := OutboxMessage{Channel: ChannelLine, Recipient: toUserID, UserID: s.outbox.LineUserOwner(ctx, toUserID)}
+	if msg.UserID != "" {
+		prefs, err := s.prefs.Get(ctx, msg.UserID)
+		if err != nil {
+			return err
+		}
+		if msg.NextAttemptAt, err = prefs.Decide(ChannelLine, category, time.Now()); err != nil {
+			return err
+		}
 	}
-
-	data, _ := json.Marshal(payload)
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.line.me/v2/bot/message/push", bytes.NewBuffer(data))
+	body, err := json.Marshal(map[string]interface{}{
+		"to":       toUserID,
+		"messages": messages,
+	})
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.lineToken)
+	msg.Body = string(body)
+	_, err = s.outbox.Enqueue(ctx, msg)
+	return err
+}
+
+// SendEmail: ส่งอีเมลจาก template ถึงอีเมลที่ผู้ใช้ลงทะเบียนไว้ ตามภาษาของผู้ใช้ (ตรวจการตั้งค่าเหมือน LINE)
+func (s *NotificationService) SendEmail(ctx context.Context, category, userID, template string, data interface{}) error {
+	return s.sendEmail(ctx, category, userID, template, data, true)
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+// SendAccountEmail: อีเมลด้านความปลอดภัยของบัญชี (ตั้งรหัสผ่านใหม่, ยืนยันการขอข้อมูล) ส่งเสมอโดยไม่ดูการตั้งค่า
+// ผู้ใช้ที่ปิดช่องทางอีเมลไว้ต้องยังกู้บัญชีของตัวเองได้
+func (s *NotificationService) SendAccountEmail(ctx context.Context, userID, template string, data interface{}) error {
+	return s.sendEmail(ctx, CategorySystem, userID, template, data, false)
+}
+
+func (s *NotificationService) sendEmail(ctx context.Context, category, userID, template string, data interface{}, checkPrefs bool) error {
+	snap, err := s.userCol.Doc(userID).Get(ctx)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("line API returned status %d", resp.StatusCode)
+	to, _ := snap.Data()["email"].(string)
+	if to == "" {
+		return fmt.Errorf("%w: user has no email", ErrNotificationSuppressed)
 	}
-	return nil
+	prefs, err := s.prefs.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+	var deferUntil time.Time
+	if checkPrefs {
+		if deferUntil, err = prefs.Decide(ChannelEmail, category, time.Now()); err != nil {
+			return err
+		}
+	}
+	email, err := RenderEmail(s.messages.Localizer(ctx, prefs.Language), template, data)
+	if err != nil {
+		return err
+	}
+	email.To = to
+	body, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+	_, err = s.outbox.Enqueue(ctx, OutboxMessage{
+		Channel:       ChannelEmail,
+		Recipient:     to,
+		UserID:        userID,
+		Body:          string(body),
+		NextAttemptAt: deferUntil,
+	})
+	return err
 }
 
-// SendTelegramAlert: ส่ง alert ไปยัง telegram-alert-bot
+// SendTelegramAlert: ส่ง alert ไปยังห้อง Telegram ของทีมงานตามประเภท (เข้าคิว outbox) ไม่ผ่านการตั้งค่าของผู้ใช้
 func (s *NotificationService) SendTelegramAlert(ctx context.Context, alertType string, payload map[string]interface{}) error {
-	body := map[string]interface{}{
-		"type": alertType,
-		"data": payload,
+	return enqueueTelegramAlert(ctx, s.outbox, alertType, payload)
+}
+
+// Deliveries: ประวัติการส่งข้อความถึงผู้ใช้
+func (s *NotificationService) Deliveries(ctx context.Context, userID string, limit int) ([]OutboxMessage, error) {
+	return s.outbox.Deliveries(ctx, userID, limit)
+}
+
+// telegramAlert body ของข้อความ alert ใน outbox (render เป็นข้อความตอนส่ง ตาม template และห้องปลายทาง)
+type telegramAlert struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// enqueueTelegramAlert ใช้ร่วมกับ service ที่แจ้งทีมงานเองโดยไม่ผ่าน NotificationService (outbox, workpool)
+func enqueueTelegramAlert(ctx context.Context, outbox *OutboxService, alertType string, payload map[string]interface{}) error {
+	body, err := json.Marshal(telegramAlert{Type: alertType, Data: payload})
+	if err != nil {
+		return err
 	}
-	data, _ := json.Marshal(body)
+	userID, _ := payload["userId"].(string)
+	_, err = outbox.Enqueue(ctx, OutboxMessage{Channel: ChannelTelegram, Recipient: alertType, UserID: userID, Body: string(body)})
+	return err
+}
+
+// deliverLine: เรียก LINE push API (X-Line-Retry-Key ทำให้ retry ไม่ส่งซ้ำ)
+func (s *NotificationService) deliverLine(ctx context.Context, msg *OutboxMessage) error {
+	return s.deliverLineAPI(ctx, "/message/push", msg)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", s.telegramBotURL, bytes.NewBuffer(data))
+// deliverLineMulticast: เรียก LINE multicast API (body มี "to" เป็น array ไม่เกิน 500 คน)
+func (s *NotificationService) deliverLineMulticast(ctx context.Context, msg *OutboxMessage) error {
+	return s.deliverLineAPI(ctx, "/message/multicast", msg)
+}
+
+func (s *NotificationService) deliverLineAPI(ctx context.Context, path string, msg *OutboxMessage) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", lineAPIBaseURL+path, bytes.NewBufferString(msg.Body))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	if s.telegramBotAuth != "" {
-		req.Header.Set("Authorization", "Bearer "+s.telegramBotAuth)
+	req.Header.Set("Authorization", "Bearer "+s.lineToken)
+	req.Header.Set("X-Line-Retry-Key", msg.RetryKey)
+	err = s.do(req)
+	var derr *DeliveryError
+	if errors.As(err, &derr) && derr.StatusCode == http.StatusConflict {
+		return nil // 409 = retry key นี้ส่งสำเร็จไปแล้ว
 	}
+	return err
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+// deliverTelegram: render alert แล้วเรียก Telegram Bot API sendMessage ไปห้องของ alert type นั้น
+func (s *NotificationService) deliverTelegram(ctx context.Context, msg *OutboxMessage) error {
+	if s.telegram == nil {
+		return &DeliveryError{StatusCode: http.StatusNotImplemented, Body: "telegram bot is not configured"}
+	}
+	var alert telegramAlert
+	if err := json.Unmarshal([]byte(msg.Body), &alert); err != nil {
+		return &DeliveryError{StatusCode: http.StatusBadRequest, Body: err.Error()}
+	}
+	return s.telegram.SendAlert(ctx, alert.Type, alert.Data)
+}
+
+// deliverEmail: ส่งอีเมลที่ render ไว้แล้วผ่าน EmailProvider
+func (s *NotificationService) deliverEmail(ctx context.Context, msg *OutboxMessage) error {
+	if s.email == nil {
+		return &DeliveryError{StatusCode: http.StatusNotImplemented, Body: "email provider is not configured"}
+	}
+	var email EmailMessage
+	if err := json.Unmarshal([]byte(msg.Body), &email); err != nil {
+		return &DeliveryError{StatusCode: http.StatusBadRequest, Body: err.Error()}
+	}
+	return s.email.Send(ctx, email)
+}
+
+func (s *NotificationService) do(req *http.Request) error {
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("telegram-alert-bot returned status %d", resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return newDeliveryError(resp, body)
 	}
 	return nil
 }
@@ -104,6 +227,18 @@ func (s *NotificationService) AlertCoinTopUp(ctx context.Context, userID string,
 	return s.SendTelegramAlert(ctx, "coin_topup", payload)
 }
 
+// AlertPaymentFailed: ตัวช่วยส่ง alert กรณีชำระเงินไม่สำเร็จ
+func (s *NotificationService) AlertPaymentFailed(ctx context.Context, paymentID string, p *Payment) error {
+	payload := map[string]interface{}{
+		"paymentId": paymentID,
+		"userId":    p.UserID,
+		"provider":  p.Provider,
+		"amount":    p.Amount,
+		"time":      time.Now().Format(time.RFC3339),
+	}
+	return s.SendTelegramAlert(ctx, "payment_failed", payload)
+}
+
 // AlertNewReview: ตัวช่วยส่ง alert กรณีมีรีวิวใหม่
 func (s *NotificationService) AlertNewReview(ctx context.Context, userID string, reviewID string) error {
 	payload := map[string]interface{}{
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ช่องทางส่งข้อความขาออก
+const (
+	ChannelLine          = "line"
+	ChannelLineMulticast = "line_multicast" // ผู้รับสูงสุด 500 คนต่อข้อความ
+	ChannelTelegram      = "telegram"
+	ChannelEmail         = "email"
+)
+
+// สถานะของข้อความใน outbox
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead" // ส่งไม่ได้ถาวร หรือ retry ครบแล้ว
+)
+
+const (
+	outboxMaxAttempts = 6
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxBatchSize   = 200
+	outboxStaleAfter  = 5 * time.Minute // sending ค้างนานเกินนี้ถือว่า worker ตาย ส่งใหม่
+)
+
+// outboxRateLimits ข้อความต่อวินาทีต่อช่องทาง (LINE push ~2,000 req/s, Telegram ~30 msg/s)
+var outboxRateLimits = map[string]rate.Limit{
+	ChannelLine:          100,
+	ChannelLineMulticast: 20,
+	ChannelTelegram:      20,
+	ChannelEmail:         10,
+}
+
+// OutboxMessage ข้อความขาออกหนึ่งรายการ (collection "outbox") เป็น delivery log ไปในตัว
+type OutboxMessage struct {
+	ID            string     `firestore:"-" json:"id"`
+	Channel       string     `firestore:"channel" json:"channel"`
+	Recipient     string     `firestore:"recipient" json:"recipient"` // LINE userId หรือชนิด alert
+	UserID        string     `firestore:"userId,omitempty" json:"userId,omitempty"`
+	CampaignID    string     `firestore:"campaignId,omitempty" json:"campaignId,omitempty"`
+	Recipients    int        `firestore:"recipients,omitempty" json:"recipients,omitempty"` // จำนวนผู้รับของ multicast
+	Body          string     `firestore:"body" json:"body"`                                 // JSON ที่ส่งให้ provider
+	RetryKey      string     `firestore:"retryKey" json:"retryKey"`                         // X-Line-Retry-Key กันส่งซ้ำเมื่อ retry
+	Status        string     `firestore:"status" json:"status"`
+	Attempts      int        `firestore:"attempts" json:"attempts"`
+	LastError     string     `firestore:"lastError,omitempty" json:"lastError,omitempty"`
+	NextAttemptAt time.Time  `firestore:"nextAttemptAt" json:"nextAttemptAt"`
+	SentAt        *time.Time `firestore:"sentAt,omitempty" json:"sentAt,omitempty"`
+	CreatedAt     time.Time  `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time  `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// DeliveryError ผลตอบกลับที่ไม่สำเร็จจาก provider
+type DeliveryError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("provider returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Retryable 429 และ 5xx ลองใหม่ได้ 4xx อื่นถือว่าถาวร
+func (e *DeliveryError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// newDeliveryError อ่าน Retry-After (วินาที) จาก response
+func newDeliveryError(resp *http.Response, body []byte) *DeliveryError {
+	e := &DeliveryError{StatusCode: resp.StatusCode, Body: string(body)}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// OutboxSender ส่ง body หนึ่งรายการ (คืน *DeliveryError เมื่อ provider ตอบ error)
+type OutboxSender func(ctx context.Context, msg *OutboxMessage) error
+
+// OutboxResultHandler ถูกเรียกเมื่อข้อความได้ผลสุดท้าย (sent = false คือ dead)
+type OutboxResultHandler func(ctx context.Context, msg *OutboxMessage, sent bool)
+
+var (
+	outboxSendersMu      sync.RWMutex
+	outboxSenders        = map[string]OutboxSender{}
+	outboxResultHandlers = map[string]OutboxResultHandler{}
+)
+
+// RegisterOutboxSender ผูกช่องทางกับตัวส่ง (NotificationService ลงทะเบียนตอนสร้าง)
+func RegisterOutboxSender(channel string, sender OutboxSender) {
+	outboxSendersMu.Lock()
+	outboxSenders[channel] = sender
+	outboxSendersMu.Unlock()
+}
+
+// RegisterOutboxResultHandler รับผลสุดท้ายของข้อความในช่องทางนั้น (เช่น นับยอดส่งของ campaign)
+func RegisterOutboxResultHandler(channel string, h OutboxResultHandler) {
+	outboxSendersMu.Lock()
+	outboxResultHandlers[channel] = h
+	outboxSendersMu.Unlock()
+}
+
+type OutboxService struct {
+	col         *firestore.CollectionRef
+	lineUserCol *firestore.CollectionRef
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func NewOutboxService() *OutboxService {
+	return &OutboxService{
+		col:         utils.Client.Collection("outbox"),
+		lineUserCol: utils.Client.Collection("line_users"),
+		limiters:    map[string]*rate.Limiter{},
+	}
+}
+
+// Enqueue ใส่ข้อความลง outbox ให้ worker ส่ง (ผู้รับ LINE จะถูกผูกกับ user ID ภายในเพื่อใช้ค้น delivery log)
+// NextAttemptAt ในอนาคต = ตั้งเวลาส่ง (เช่น รอหมด quiet hours)
+func (s *OutboxService) Enqueue(ctx context.Context, msg OutboxMessage) (string, error) {
+	now := time.Now()
+	if msg.UserID == "" && msg.Channel == ChannelLine {
+		msg.UserID = s.LineUserOwner(ctx, msg.Recipient)
+	}
+	msg.RetryKey = uuid.NewString()
+	msg.Status = OutboxPending
+	msg.Attempts = 0
+	if msg.NextAttemptAt.Before(now) {
+		msg.NextAttemptAt = now
+	}
+	msg.CreatedAt = now
+	msg.UpdatedAt = now
+	ref := s.col.NewDoc()
+	if _, err := ref.Set(ctx, msg); err != nil {
+		return "", err
+	}
+	return ref.ID, nil
+}
+
+// EnqueueOnce ใส่ข้อความด้วย document id ที่กำหนด ถ้ามี id นี้อยู่แล้วถือว่าเข้าคิวไปแล้ว (ใช้กับงานที่ทำซ้ำได้)
+func (s *OutboxService) EnqueueOnce(ctx context.Context, id string, msg OutboxMessage) error {
+	now := time.Now()
+	msg.RetryKey = uuid.NewString()
+	msg.Status = OutboxPending
+	msg.Attempts = 0
+	if msg.NextAttemptAt.Before(now) {
+		msg.NextAttemptAt = now
+	}
+	msg.CreatedAt = now
+	msg.UpdatedAt = now
+	_, err := s.col.Doc(id).Create(ctx, msg)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// LineUserOwner user ID ภายในของ LINE userId (ว่างถ้ายังไม่ผูกบัญชี)
+func (s *OutboxService) LineUserOwner(ctx context.Context, lineUserID string) string {
+	snap, err := s.lineUserCol.Doc(lineUserID).Get(ctx)
+	if err != nil {
+		return ""
+	}
+	userID, _ := snap.Data()["userId"].(string)
+	return userID
+}
+
+// Deliveries ประวัติข้อความที่ส่งถึงผู้ใช้ ใหม่ → เก่า
+func (s *OutboxService) Deliveries(ctx context.Context, userID string, limit int) ([]OutboxMessage, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	docs, err := s.col.Where("userId", "==", userID).OrderBy("createdAt", firestore.Desc).Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]OutboxMessage, 0, len(docs))
+	for _, doc := range docs {
+		var m OutboxMessage
+		if err := doc.DataTo(&m); err != nil {
+			continue
+		}
+		m.ID = doc.Ref.ID
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+// outboxChannels ช่องทางที่ Run เปิด worker ให้
+var outboxChannels = []string{ChannelLine, ChannelLineMulticast, ChannelTelegram, ChannelEmail}
+
+// Run ส่งข้อความที่ถึงเวลาทุก interval จนกว่า ctx จะถูกยกเลิก
+// แต่ละช่องทางมี worker ของตัวเอง ช่องทางที่ติด rate limit หรือ provider ช้าจึงไม่ถ่วงช่องทางอื่น
+func (s *OutboxService) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	for _, channel := range outboxChannels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runChannel(ctx, channel, interval)
+		}()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.requeueStale(ctx, time.Now()); err != nil {
+			log.Println("Error requeueing stale outbox messages:", err)
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *OutboxService) runChannel(ctx context.Context, channel string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.processChannel(ctx, channel); err != nil && ctx.Err() == nil {
+			log.Printf("Error processing %s outbox: %v", channel, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue คืนข้อความ sending ที่ค้างแล้วส่งข้อความที่ถึงเวลาของทุกช่องทางพร้อมกัน
+func (s *OutboxService) ProcessDue(ctx context.Context) error {
+	if err := s.requeueStale(ctx, time.Now()); err != nil {
+		log.Println("Error requeueing stale outbox messages:", err)
+	}
+	errs := make([]error, len(outboxChannels))
+	var wg sync.WaitGroup
+	for i, channel := range outboxChannels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.processChannel(ctx, channel)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// processChannel หยิบข้อความ pending ที่ถึงเวลาของช่องทางหนึ่งมาส่งทีละรายการตาม rate limit ของช่องทางนั้น
+// ต้องมี composite index outbox: channel, status, nextAttemptAt
+func (s *OutboxService) processChannel(ctx context.Context, channel string) error {
+	now := time.Now()
+	docs, err := s.col.Where("channel", "==", channel).Where("status", "==", OutboxPending).Where("nextAttemptAt", "<=", now).
+		OrderBy("nextAttemptAt", firestore.Asc).Limit(outboxBatchSize).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var msg OutboxMessage
+		if err := doc.DataTo(&msg); err != nil {
+			continue
+		}
+		msg.ID = doc.Ref.ID
+		// precondition กันไม่ให้สอง instance ส่งข้อความเดียวกัน
+		claimed, err := doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "status", Value: OutboxSending},
+			{Path: "updatedAt", Value: time.Now()},
+		}, firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			continue
+		}
+		if err := s.limiter(msg.Channel).Wait(ctx); err != nil {
+			return err
+		}
+		sendErr := s.send(ctx, &msg)
+		s.finish(ctx, doc.Ref, claimed.UpdateTime, &msg, sendErr)
+	}
+	return nil
+}
+
+// finish บันทึกผลการส่ง (precondition = ยังเป็นรอบที่เราจองไว้) แล้วแจ้ง alert/ผลสุดท้าย
+func (s *OutboxService) finish(ctx context.Context, ref *firestore.DocumentRef, claimedAt time.Time, msg *OutboxMessage, sendErr error) {
+	updates, dead := s.resultUpdates(msg, sendErr)
+	if _, err := ref.Update(ctx, updates, firestore.LastUpdateTime(claimedAt)); err != nil {
+		log.Printf("Error updating outbox %s: %v", msg.ID, err)
+		return
+	}
+	if dead {
+		s.alertDead(ctx, msg, sendErr)
+	}
+	if dead || sendErr == nil {
+		s.notifyResult(ctx, msg, sendErr == nil)
+	}
+}
+
+func (s *OutboxService) send(ctx context.Context, msg *OutboxMessage) error {
+	outboxSendersMu.RLock()
+	sender, ok := outboxSenders[msg.Channel]
+	outboxSendersMu.RUnlock()
+	if !ok {
+		return &DeliveryError{StatusCode: http.StatusNotImplemented, Body: "no sender for channel " + msg.Channel}
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	return sender(sendCtx, msg)
+}
+
+// resultUpdates สถานะหลังส่ง: สำเร็จ / retry พร้อม backoff / dead (dead = true)
+func (s *OutboxService) resultUpdates(msg *OutboxMessage, sendErr error) ([]firestore.Update, bool) {
+	now := time.Now()
+	attempts := msg.Attempts + 1
+	updates := []firestore.Update{
+		{Path: "attempts", Value: attempts},
+		{Path: "updatedAt", Value: now},
+	}
+	if sendErr == nil {
+		return append(updates,
+			firestore.Update{Path: "status", Value: OutboxSent},
+			firestore.Update{Path: "sentAt", Value: now},
+			firestore.Update{Path: "lastError", Value: firestore.Delete},
+		), false
+	}
+	updates = append(updates, firestore.Update{Path: "lastError", Value: sendErr.Error()})
+
+	var derr *DeliveryError
+	retryable := !errors.As(sendErr, &derr) || derr.Retryable()
+	if !retryable || attempts >= outboxMaxAttempts {
+		log.Printf("Outbox %s (%s → %s) dead after %d attempts: %v", msg.ID, msg.Channel, msg.Recipient, attempts, sendErr)
+		return append(updates, firestore.Update{Path: "status", Value: OutboxDead}), true
+	}
+	wait := outboxBackoff(attempts)
+	if derr != nil && derr.RetryAfter > wait {
+		wait = derr.RetryAfter
+	}
+	return append(updates,
+		firestore.Update{Path: "status", Value: OutboxPending},
+		firestore.Update{Path: "nextAttemptAt", Value: now.Add(wait)},
+	), false
+}
+
+func (s *OutboxService) notifyResult(ctx context.Context, msg *OutboxMessage, sent bool) {
+	outboxSendersMu.RLock()
+	h, ok := outboxResultHandlers[msg.Channel]
+	outboxSendersMu.RUnlock()
+	if ok {
+		h(ctx, msg, sent)
+	}
+}
+
+// alertDead แจ้ง Telegram เมื่อข้อความส่งไม่ได้ถาวร (ไม่แจ้งซ้ำถ้าตัว alert เองส่งไม่ได้)
+func (s *OutboxService) alertDead(ctx context.Context, msg *OutboxMessage, sendErr error) {
+	if msg.Channel == ChannelTelegram {
+		return
+	}
+	err := enqueueTelegramAlert(ctx, s, "outbox_dead", map[string]interface{}{
+		"outboxId":   msg.ID,
+		"channel":    msg.Channel,
+		"recipient":  msg.Recipient,
+		"userId":     msg.UserID,
+		"campaignId": msg.CampaignID,
+		"attempts":   msg.Attempts + 1,
+		"error":      sendErr.Error(),
+	})
+	if err != nil {
+		log.Println("Error enqueueing outbox dead alert:", err)
+	}
+}
+
+// errOutboxStale ข้อความค้างสถานะ sending (worker ล่มระหว่างส่ง) ไม่รู้ว่าส่งถึงหรือยัง
+var errOutboxStale = errors.New("worker stopped while sending")
+
+// requeueStale คืนข้อความที่ค้างสถานะ sending กลับเป็น pending โดยนับเป็นการส่งหนึ่งครั้ง
+// (ข้อความที่ทำให้ worker ล่มทุกครั้งจะ dead เมื่อครบ outboxMaxAttempts ไม่วนไม่รู้จบ)
+// ข้อความ LINE ใช้ retryKey เดิม จึงไม่ถูกส่งซ้ำถ้าครั้งก่อนส่งสำเร็จไปแล้ว
+func (s *OutboxService) requeueStale(ctx context.Context, now time.Time) error {
+	docs, err := s.col.Where("status", "==", OutboxSending).Where("updatedAt", "<", now.Add(-outboxStaleAfter)).
+		Limit(outboxBatchSize).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		var msg OutboxMessage
+		if err := doc.DataTo(&msg); err != nil {
+			continue
+		}
+		msg.ID = doc.Ref.ID
+		s.finish(ctx, doc.Ref, doc.UpdateTime, &msg, errOutboxStale)
+	}
+	return nil
+}
+
+func (s *OutboxService) limiter(channel string) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limiters[channel]
+	if !ok {
+		limit, ok := outboxRateLimits[channel]
+		if !ok {
+			limit = 10
+		}
+		l = rate.NewLimiter(limit, 1)
+		s.limiters[channel] = l
+	}
+	return l
+}
+
+// outboxBackoff 30s, 1m, 2m, 4m, ... ไม่เกิน 1 ชั่วโมง
+func outboxBackoff(attempt int) time.Duration {
+	d := outboxBaseBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, outboxBackoff(1))
+	assert.Equal(t, time.Minute, outboxBackoff(2))
+	assert.Equal(t, 4*time.Minute, outboxBackoff(4))
+	assert.Equal(t, time.Hour, outboxBackoff(20))
+}
+
+func TestDeliveryErrorRetryable(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"12"}}}
+	e := newDeliveryError(resp, []byte("rate limited"))
+	assert.True(t, e.Retryable())
+	assert.Equal(t, 12*time.Second, e.RetryAfter)
+
+	assert.True(t, (&DeliveryError{StatusCode: http.StatusBadGateway}).Retryable())
+	assert.False(t, (&DeliveryError{StatusCode: http.StatusBadRequest}).Retryable())
+	assert.False(t, (&DeliveryError{StatusCode: http.StatusForbidden}).Retryable())
+}
+
+func TestOutboxResultUpdates(t *testing.T) {
+	s := &OutboxService{}
+	_, dead := s.resultUpdates(&OutboxMessage{Attempts: 0}, nil)
+	assert.False(t, dead)
+	_, dead = s.resultUpdates(&OutboxMessage{Attempts: 0}, &DeliveryError{StatusCode: http.StatusServiceUnavailable})
+	assert.False(t, dead)
+	_, dead = s.resultUpdates(&OutboxMessage{Attempts: 0}, &DeliveryError{StatusCode: http.StatusBadRequest})
+	assert.True(t, dead)
+	_, dead = s.resultUpdates(&OutboxMessage{Attempts: outboxMaxAttempts - 1}, &DeliveryError{StatusCode: http.StatusTooManyRequests})
+	assert.True(t, dead)
+
+	// sending ที่ค้างนับเป็นหนึ่งครั้ง และ dead เมื่อครบ
+	updates, dead := s.resultUpdates(&OutboxMessage{Attempts: 2}, errOutboxStale)
+	assert.False(t, dead)
+	assert.Equal(t, firestore.Update{Path: "attempts", Value: 3}, updates[0])
+	_, dead = s.resultUpdates(&OutboxMessage{Attempts: outboxMaxAttempts - 1}, errOutboxStale)
+	assert.True(t, dead)
+}
+
+func TestOutboxChannelsHaveWorkers(t *testing.T) {
+	for channel := range outboxRateLimits {
+		assert.Contains(t, outboxChannels, channel)
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// PackageExpiryJobName job ใน workpool ที่ส่งอีเมลเตือนก่อน package หมดอายุ (payload = userId)
+const PackageExpiryJobName = "package_expiry_email"
+
+// packageExpiryNotice เตือนล่วงหน้ากี่วันก่อนหมดอายุ
+const packageExpiryNotice = 3 * 24 * time.Hour
+
+type PackageExpiryReminder struct {
+	packages *PackageService
+	notif    *NotificationService
+	workpool *WorkpoolService
+	renewURL string
+}
+
+// NewPackageExpiryReminder ลงทะเบียน handler ของ PackageExpiryJobName กับ workpool ด้วย
+func NewPackageExpiryReminder(packages *PackageService, notif *NotificationService, workpool *WorkpoolService, renewURL string) *PackageExpiryReminder {
+	r := &PackageExpiryReminder{packages: packages, notif: notif, workpool: workpool, renewURL: renewURL}
+	RegisterJobHandler(PackageExpiryJobName, r.remind)
+	return r
+}
+
+// Schedule ตั้งเวลาเตือนตามวันหมดอายุล่าสุดของผู้ใช้ (เรียกหลังซื้อ/ต่ออายุ ตั้งซ้ำจะเลื่อนเวลาเดิม)
+func (r *PackageExpiryReminder) Schedule(ctx context.Context, userID string) error {
+	_, until, err := r.packages.UserTier(ctx, userID)
+	if err != nil || until.IsZero() {
+		return err
+	}
+	runAt := until.Add(-packageExpiryNotice)
+	if runAt.Before(time.Now()) {
+		return nil // package สั้นกว่าช่วงเตือน ไม่ต้องเตือน
+	}
+	return r.workpool.ScheduleUniqueJob(ctx, "pkgexpiry_"+userID, PackageExpiryJobName, userID, runAt)
+}
+
+func (r *PackageExpiryReminder) remind(ctx context.Context, userID string) error {
+	_, until, err := r.packages.UserTier(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// หมดไปแล้ว หรือต่ออายุแล้ว (การต่ออายุตั้ง job รอบใหม่เอง)
+	if until.IsZero() || time.Until(until) > packageExpiryNotice+time.Hour {
+		return nil
+	}
+	name := "Premium"
+	ups, err := r.packages.GetUserPackages(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, up := range ups {
+		if up.ExpiresAt.Equal(until) {
+			if pkg, err := r.packages.GetPackage(ctx, up.PackageID); err == nil {
+				name = pkg.Name
+			}
+			break
+		}
+	}
+	err = r.notif.SendEmail(ctx, CategoryReminders, userID, EmailPackageExpiry, PackageExpiryEmail{
+		PackageName: name,
+		ExpiresAt:   until,
+		RenewURL:    r.renewURL,
+	})
+	if errors.Is(err, ErrNotificationSuppressed) {
+		log.Printf("Package expiry email for %s skipped: %v", userID, err)
+		return nil
+	}
+	return err
+}
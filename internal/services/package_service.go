@@ -8,8 +8,15 @@ import (
 	"github.com/poomiiz/go-backend/internal/utils"
 )
 
+// tier ของผู้ใช้ตามสถานะ package (ใช้แบ่ง retention และ rich menu)
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
+
 // Package โครงสร้างข้อมูลของแต่ละ Package ใน Firestore
 type Package struct {
+	ID           string    `firestore:"-"`
 	Name         string    `firestore:"name"`
 	CoinCost     int64     `firestore:"coinCost"`
 	DurationDays int       `firestore:"durationDays"`
@@ -153,6 +160,24 @@ func (s *PackageService) BuyPackage(ctx context.Context, userID, packageID strin
 	return &updated, nil
 }
 
+// ListPackages: ดึง Package ทั้งหมด เรียงตามราคา
+func (s *PackageService) ListPackages(ctx context.Context) ([]Package, error) {
+	docs, err := s.pkgCol.OrderBy("coinCost", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Package, 0, len(docs))
+	for _, doc := range docs {
+		var p Package
+		if err := doc.DataTo(&p); err != nil {
+			return nil, err
+		}
+		p.ID = doc.Ref.ID
+		result = append(result, p)
+	}
+	return result, nil
+}
+
 // CheckUserPackage: ตรวจสอบว่าผู้ใช้ยังมี Package ไหน active อยู่หรือไม่
 func (s *PackageService) CheckUserPackage(ctx context.Context, userID string) (bool, error) {
 	now := time.Now()
@@ -164,6 +189,42 @@ func (s *PackageService) CheckUserPackage(ctx context.Context, userID string) (b
 	return len(docs) > 0, nil
 }
 
+// UserTier: premium ถ้ามี Package ที่ยังไม่หมดอายุ คืนเวลาหมดอายุที่ช้าที่สุดด้วย (zero ถ้าเป็น free)
+func (s *PackageService) UserTier(ctx context.Context, userID string) (string, time.Time, error) {
+	docs, err := s.userPkgCol.Where("userId", "==", userID).Where("expiresAt", ">", time.Now()).Documents(ctx).GetAll()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var until time.Time
+	for _, doc := range docs {
+		var up UserPackage
+		if err := doc.DataTo(&up); err != nil {
+			return "", time.Time{}, err
+		}
+		if up.ExpiresAt.After(until) {
+			until = up.ExpiresAt
+		}
+	}
+	if until.IsZero() {
+		return TierFree, until, nil
+	}
+	return TierPremium, until, nil
+}
+
+// GetPackage: ดึง Package ตาม id
+func (s *PackageService) GetPackage(ctx context.Context, packageID string) (*Package, error) {
+	snap, err := s.pkgCol.Doc(packageID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pkg Package
+	if err := snap.DataTo(&pkg); err != nil {
+		return nil, err
+	}
+	pkg.ID = snap.Ref.ID
+	return &pkg, nil
+}
+
 // GetUserPackages: ดึงข้อมูล UserPackage ของผู้ใช้ทั้งหมด
 func (s *PackageService) GetUserPackages(ctx context.Context, userID string) ([]UserPackage, error) {
 	q := s.userPkgCol.Where("userId", "==", userID)
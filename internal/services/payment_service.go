@@ -53,24 +53,24 @@ func (s *PaymentService) CreatePayment(ctx context.Context, userID string, amoun
 	return docRef.ID, nil
 }
 
-// VerifyPayment: ตรวจสอบสถานะกับ Provider แล้วอัปเดตใน Firestore
-func (s *PaymentService) VerifyPayment(ctx context.Context, paymentID string) error {
+// VerifyPayment: ตรวจสอบสถานะกับ Provider แล้วอัปเดตใน Firestore คืน Payment หลังอัปเดต
+func (s *PaymentService) VerifyPayment(ctx context.Context, paymentID string) (*Payment, error) {
 	docSnap, err := s.col.Doc(paymentID).Get(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var p Payment
 	if err := docSnap.DataTo(&p); err != nil {
-		return err
+		return nil, err
 	}
 	if p.Status != "pending" {
-		return errors.New("payment is not in pending state")
+		return nil, errors.New("payment is not in pending state")
 	}
 
 	// 1. ตรวจสอบกับ Provider จริง (ตัวอย่างเป็น pseudo-code)
 	paid, err := s.checkProvider(p.Provider, p.ProviderRefID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 2. คำนวณ Commission ถ้า paid
@@ -84,13 +84,19 @@ func (s *PaymentService) VerifyPayment(ctx context.Context, paymentID string) er
 	}
 
 	// 3. อัปเดต document
+	now := time.Now()
 	updates := []firestore.Update{
 		{Path: "status", Value: newStatus},
 		{Path: "commission", Value: commission},
-		{Path: "updatedAt", Value: time.Now()},
+		{Path: "updatedAt", Value: now},
+	}
+	if _, err := docSnap.Ref.Update(ctx, updates); err != nil {
+		return nil, err
 	}
-	_, err = docSnap.Ref.Update(ctx, updates)
-	return err
+	p.Status = newStatus
+	p.Commission = commission
+	p.UpdatedAt = now
+	return &p, nil
 }
 
 // checkProvider เป็นตัวอย่าง pseudo-code สำหรับตรวจสอบกับ Provider
@@ -0,0 +1,534 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrPrivacyRequestNotFound = errors.New("privacy request not found")
+	ErrPrivacyRequestDone     = errors.New("privacy request already processed")
+	ErrInvalidExportToken     = errors.New("invalid or expired export token")
+	ErrMissingPseudonymSalt   = errors.New("PDPA_PSEUDONYM_SALT is not set")
+)
+
+const privacyBatchSize = 400
+
+// DataExportTokenTTL อายุของลิงก์ดาวน์โหลดสำเนาข้อมูลที่ส่งทางอีเมล
+const DataExportTokenTTL = 30 * time.Minute
+
+// ExportedConversation conversation หนึ่งรายการพร้อม subcollection ทั้งหมด
+type ExportedConversation struct {
+	ID              string                   `json:"id"`
+	Data            map[string]interface{}   `json:"data"`
+	Messages        []utils.StoredMessage    `json:"messages"`
+	Draws           []map[string]interface{} `json:"draws"`
+	Interpretations []map[string]interface{} `json:"interpretations"`
+}
+
+// DataExport ข้อมูลทั้งหมดของผู้ใช้หนึ่งคน (PDPA มาตรา 30/31)
+type DataExport struct {
+	UserID            string                   `json:"userId"`
+	GeneratedAt       time.Time                `json:"generatedAt"`
+	User              map[string]interface{}   `json:"user"`
+	Conversations     []ExportedConversation   `json:"conversations"`
+	Reviews           []map[string]interface{} `json:"reviews"`
+	Appeals           []map[string]interface{} `json:"appeals"`
+	Payments          []map[string]interface{} `json:"payments"`
+	CoinBalances      []map[string]interface{} `json:"coinBalances"`
+	CoinHolds         []map[string]interface{} `json:"coinHolds"`
+	UserPackages      []map[string]interface{} `json:"userPackages"`
+	Bookings          []map[string]interface{} `json:"bookings"`
+	ModerationFlags   []map[string]interface{} `json:"moderationFlags"`
+	NotificationPrefs []map[string]interface{} `json:"notificationPreferences"`
+}
+
+// PrivacyRequest บันทึก audit ของคำขอ export/erasure (collection "privacy_requests")
+// เก็บเฉพาะ pseudonym ของผู้ใช้ เพื่อให้ audit trail อยู่ได้หลังลบข้อมูลแล้ว
+type PrivacyRequest struct {
+	ID          string         `firestore:"-" json:"id"`
+	Type        string         `firestore:"type" json:"type"`       // "export" หรือ "erasure"
+	Subject     string         `firestore:"subject" json:"subject"` // pseudonym ของ userId
+	Status      string         `firestore:"status" json:"status"`   // "pending", "completed", "rejected"
+	Reason      string         `firestore:"reason" json:"reason,omitempty"`
+	RequestedBy string         `firestore:"requestedBy" json:"requestedBy"`
+	ApprovedBy  string         `firestore:"approvedBy" json:"approvedBy,omitempty"`
+	Counts      map[string]int `firestore:"counts" json:"counts,omitempty"` // จำนวน document ที่ลบ/ทำให้นิรนามต่อ collection
+	CreatedAt   time.Time      `firestore:"createdAt" json:"createdAt"`
+	CompletedAt time.Time      `firestore:"completedAt" json:"completedAt,omitempty"`
+
+	// userId จริงเก็บไว้ระหว่างรออนุมัติเท่านั้น และถูกล้างเมื่อดำเนินการเสร็จ
+	PendingUserID string `firestore:"pendingUserId" json:"-"`
+}
+
+type PrivacyService struct {
+	client     *firestore.Client
+	requestCol *firestore.CollectionRef
+	tokenCol   *firestore.CollectionRef
+	salt       string
+}
+
+// NewPrivacyService salt ใช้สร้าง pseudonym (ต้องคงที่ เพื่อให้ฝ่ายบัญชีจับกลุ่มรายการของคนเดิมได้)
+// salt ว่างถูกปฏิเสธ เพราะ pseudonym จะเป็นแค่ hash ของ userId ที่ย้อนหาได้ด้วยการ hash userId ที่รู้อยู่แล้ว
+func NewPrivacyService(salt string) (*PrivacyService, error) {
+	if salt == "" {
+		return nil, ErrMissingPseudonymSalt
+	}
+	return &PrivacyService{
+		client:     utils.Client,
+		requestCol: utils.Client.Collection("privacy_requests"),
+		tokenCol:   utils.Client.Collection("export_tokens"),
+		salt:       salt,
+	}, nil
+}
+
+// Pseudonym แทน userId ในข้อมูลการเงินที่ต้องเก็บไว้ตามกฎหมายบัญชี
+func (s *PrivacyService) Pseudonym(userID string) string {
+	sum := sha256.Sum256([]byte(s.salt + ":" + userID))
+	return "erased-" + hex.EncodeToString(sum[:8])
+}
+
+// RequestExport ออก token ดาวน์โหลดสำเนาข้อมูล (เก็บเฉพาะ hash ใน "export_tokens") ผู้เรียกส่ง token ทางอีเมลของบัญชี
+// เพื่อยืนยันว่าผู้ขอเป็นเจ้าของบัญชีจริง ไม่ใช่แค่รู้ userId
+func (s *PrivacyService) RequestExport(ctx context.Context, userID string) (string, error) {
+	token := randomHex(32)
+	now := time.Now()
+	_, err := s.tokenCol.Doc(hashResetToken(token)).Set(ctx, map[string]interface{}{
+		"userId":    userID,
+		"used":      false,
+		"createdAt": now,
+		"expireAt":  now.Add(DataExportTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RedeemExportToken คืน userId ของ token ดาวน์โหลด (ใช้ได้ครั้งเดียว)
+func (s *PrivacyService) RedeemExportToken(ctx context.Context, token string) (string, error) {
+	ref := s.tokenCol.Doc(hashResetToken(token))
+	var userID string
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrInvalidExportToken
+		}
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		used, _ := data["used"].(bool)
+		expireAt, _ := data["expireAt"].(time.Time)
+		userID, _ = data["userId"].(string)
+		if used || userID == "" || time.Now().After(expireAt) {
+			return ErrInvalidExportToken
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "used", Value: true},
+			{Path: "usedAt", Value: time.Now()},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// Export รวบรวมข้อมูลทั้งหมดของผู้ใช้และบันทึก audit
+func (s *PrivacyService) Export(ctx context.Context, userID, requestedBy string) (*DataExport, error) {
+	out := &DataExport{UserID: userID, GeneratedAt: time.Now()}
+
+	snap, err := s.client.Collection("users").Doc(userID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if err == nil {
+		out.User = snap.Data()
+		// ไม่ส่ง credential ออกไปในไฟล์ export
+		delete(out.User, "passwordHash")
+		delete(out.User, "twoFASecret")
+	}
+
+	convDocs, err := s.client.Collection("conversations").Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range convDocs {
+		conv := ExportedConversation{ID: doc.Ref.ID, Data: doc.Data()}
+		if conv.Messages, err = utils.GetAllMessages(ctx, doc.Ref.ID); err != nil {
+			return nil, err
+		}
+		if conv.Draws, err = docsData(ctx, doc.Ref.Collection("draws").Query); err != nil {
+			return nil, err
+		}
+		if conv.Interpretations, err = docsData(ctx, doc.Ref.Collection("interpretations").Query); err != nil {
+			return nil, err
+		}
+		out.Conversations = append(out.Conversations, conv)
+	}
+
+	sections := []struct {
+		dst   *[]map[string]interface{}
+		col   string
+		field string
+	}{
+		{&out.Reviews, "reviews", "userId"},
+		{&out.Appeals, "appeals", "userId"},
+		{&out.Payments, "payments", "userId"},
+		{&out.CoinBalances, "coin_balances", "userId"},
+		{&out.CoinHolds, "coin_holds", "userId"},
+		{&out.UserPackages, "user_packages", "userId"},
+		{&out.Bookings, "bookings", "userId"},
+		{&out.ModerationFlags, "moderation_flags", "userId"},
+		{&out.NotificationPrefs, "notification_preferences", "userId"},
+	}
+	for _, sec := range sections {
+		if *sec.dst, err = docsData(ctx, s.client.Collection(sec.col).Where(sec.field, "==", userID)); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	_, _, err = s.requestCol.Add(ctx, PrivacyRequest{
+		Type:        "export",
+		Subject:     s.Pseudonym(userID),
+		Status:      "completed",
+		RequestedBy: requestedBy,
+		CreatedAt:   now,
+		CompletedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WriteExportZip เขียน export เป็น zip แยกไฟล์ json ต่อหมวด
+func WriteExportZip(w io.Writer, export *DataExport) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", export.User},
+		{"conversations.json", export.Conversations},
+		{"reviews.json", export.Reviews},
+		{"appeals.json", export.Appeals},
+		{"payments.json", export.Payments},
+		{"coin_balances.json", export.CoinBalances},
+		{"coin_holds.json", export.CoinHolds},
+		{"user_packages.json", export.UserPackages},
+		{"bookings.json", export.Bookings},
+		{"moderation_flags.json", export.ModerationFlags},
+		{"notification_preferences.json", export.NotificationPrefs},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// RequestErasure สร้างคำขอลบข้อมูลรออนุมัติ
+func (s *PrivacyService) RequestErasure(ctx context.Context, userID, requestedBy, reason string) (*PrivacyRequest, error) {
+	req := PrivacyRequest{
+		Type:          "erasure",
+		Subject:       s.Pseudonym(userID),
+		Status:        "pending",
+		Reason:        reason,
+		RequestedBy:   requestedBy,
+		CreatedAt:     time.Now(),
+		PendingUserID: userID,
+	}
+	docRef, _, err := s.requestCol.Add(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	req.ID = docRef.ID
+	return &req, nil
+}
+
+// ListRequests ดึงคำขอล่าสุด (status ว่าง = ทุกสถานะ)
+func (s *PrivacyService) ListRequests(ctx context.Context, state string) ([]PrivacyRequest, error) {
+	q := s.requestCol.Query
+	if state != "" {
+		q = q.Where("status", "==", state)
+	}
+	docs, err := q.OrderBy("createdAt", firestore.Desc).Limit(100).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]PrivacyRequest, 0, len(docs))
+	for _, doc := range docs {
+		var r PrivacyRequest
+		if err := doc.DataTo(&r); err != nil {
+			continue
+		}
+		r.ID = doc.Ref.ID
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// Reject ปฏิเสธคำขอลบ (เช่น มีข้อพิพาทการชำระเงินค้างอยู่)
+func (s *PrivacyService) Reject(ctx context.Context, requestID, approver, note string) error {
+	req, err := s.getPending(ctx, requestID)
+	if err != nil {
+		return err
+	}
+	_, err = s.requestCol.Doc(req.ID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: "rejected"},
+		{Path: "approvedBy", Value: approver},
+		{Path: "reason", Value: note},
+		{Path: "completedAt", Value: time.Now()},
+		{Path: "pendingUserId", Value: firestore.Delete},
+	})
+	return err
+}
+
+// collection ที่ ExecuteErasure จัดการ
+var (
+	// ลบทุก document ที่ userId ตรง
+	erasedByUserID = []string{"reviews", "appeals", "moderation_flags", "line_users", "line_link_nonces", "line_flows", "outbox", "password_resets", "export_tokens"}
+	// ลบทุก document ที่ lineUserId ตรงกับ LINE userId ของบัญชี
+	erasedByLineUserID = []string{"line_events"}
+	// document id = userId
+	erasedUserDocs = []string{"users", "active_sessions", "notification_preferences"}
+	// ข้อมูลการเงิน: แทน userId ด้วย pseudonym
+	anonymizedByUserID = []string{"payments", "coin_balances", "coin_holds", "user_packages", "bookings", "booking_slots"}
+)
+
+// ExecuteErasure ลบเนื้อหาส่วนบุคคลทั้งหมด และทำให้ข้อมูลการเงินเป็นนิรนาม (แทน userId ด้วย pseudonym)
+func (s *PrivacyService) ExecuteErasure(ctx context.Context, requestID, approver string) (*PrivacyRequest, error) {
+	req, err := s.getPending(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	userID := req.PendingUserID
+	pseudonym := s.Pseudonym(userID)
+	counts := map[string]int{}
+
+	// LINE userId ของบัญชีต้องอ่านก่อนลบ users/line_users เพื่อตามลบข้อมูลที่อ้างด้วย LINE userId
+	lineUserIDs, err := s.linkedLineUserIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1) เนื้อหาส่วนบุคคล: ลบทิ้ง
+	convDocs, err := s.client.Collection("conversations").Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range convDocs {
+		if err := utils.DeleteDocumentTree(ctx, doc.Ref, privacyBatchSize); err != nil {
+			return nil, err
+		}
+	}
+	counts["conversations"] = len(convDocs)
+	for _, col := range erasedByUserID {
+		n, err := utils.DeleteQuery(ctx, s.client.Collection(col).Where("userId", "==", userID), privacyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		counts[col] = n
+	}
+	for _, col := range erasedByLineUserID {
+		for _, lineUserID := range lineUserIDs {
+			n, err := utils.DeleteQuery(ctx, s.client.Collection(col).Where("lineUserId", "==", lineUserID), privacyBatchSize)
+			if err != nil {
+				return nil, err
+			}
+			counts[col] += n
+		}
+	}
+	// job ที่ payload เป็น userId หรือ LINE userId (เช่น merge บัญชี, relink rich menu, package หมดอายุ)
+	for _, payload := range erasedJobPayloads(userID, lineUserIDs) {
+		n, err := utils.DeleteQuery(ctx, s.client.Collection("jobs").Where("payload", "==", payload), privacyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		counts["jobs"] += n
+	}
+	// ผู้รับใน batch ของ campaign ที่ยังส่งไม่เสร็จ (campaigns/{id}/batches/{n}.to ใช้ index แบบ collection group)
+	for _, lineUserID := range lineUserIDs {
+		n, err := s.removeFromArrays(ctx, s.client.CollectionGroup("batches").Where("to", "array-contains", lineUserID), "to", lineUserID)
+		if err != nil {
+			return nil, err
+		}
+		counts["campaign_batches"] += n
+	}
+	for _, col := range erasedUserDocs {
+		// precondition Exists ให้รู้ว่ามี document จริงไหม (นับเฉพาะที่ลบจริง)
+		_, err := s.client.Collection(col).Doc(userID).Delete(ctx, firestore.Exists)
+		if status.Code(err) == codes.NotFound {
+			counts[col] = 0
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		counts[col] = 1
+	}
+
+	// 2) ข้อมูลการเงิน: เก็บยอด/สถานะไว้ แต่ตัดความเชื่อมโยงกับตัวบุคคล
+	// การจองและตัวล็อก slot ต้องอยู่ต่อ ไม่งั้นเวลาที่จองไว้จะกลับมาว่างในตารางของหมอดู
+	for _, col := range anonymizedByUserID {
+		n, err := s.anonymize(ctx, s.client.Collection(col).Where("userId", "==", userID), pseudonym)
+		if err != nil {
+			return nil, err
+		}
+		counts[col] = n
+	}
+
+	now := time.Now()
+	_, err = s.requestCol.Doc(req.ID).Update(ctx, []firestore.Update{
+		{Path: "status", Value: "completed"},
+		{Path: "approvedBy", Value: approver},
+		{Path: "counts", Value: counts},
+		{Path: "completedAt", Value: now},
+		{Path: "pendingUserId", Value: firestore.Delete},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req.Status = "completed"
+	req.ApprovedBy = approver
+	req.Counts = counts
+	req.CompletedAt = now
+	req.PendingUserID = ""
+	return req, nil
+}
+
+// linkedLineUserIDs LINE userId ที่เคยผูกกับบัญชี (users/{userId}.lineUserId และ line_users ที่ชี้มาที่บัญชี)
+func (s *PrivacyService) linkedLineUserIDs(ctx context.Context, userID string) ([]string, error) {
+	var ids []string
+	snap, err := s.client.Collection("users").Doc(userID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if err == nil {
+		if id, _ := snap.Data()["lineUserId"].(string); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	docs, err := s.client.Collection("line_users").Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if !slices.Contains(ids, doc.Ref.ID) {
+			ids = append(ids, doc.Ref.ID)
+		}
+	}
+	return ids, nil
+}
+
+// erasedJobPayloads payload ของ job ที่อ้างถึงผู้ใช้ (job ของผู้ใช้ใช้ userId หรือ LINE userId เป็น payload)
+func erasedJobPayloads(userID string, lineUserIDs []string) []string {
+	out := []string{userID}
+	for _, id := range lineUserIDs {
+		if id != "" && id != userID {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// removeFromArrays เอา value ออกจาก array field ของทุก document ที่ query ได้ คืนจำนวน document ที่แก้
+func (s *PrivacyService) removeFromArrays(ctx context.Context, q firestore.Query, field, value string) (int, error) {
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	for start := 0; start < len(docs); start += privacyBatchSize {
+		end := start + privacyBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		batch := s.client.Batch()
+		for _, doc := range docs[start:end] {
+			batch.Update(doc.Ref, []firestore.Update{{Path: field, Value: firestore.ArrayRemove(value)}})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return start, err
+		}
+	}
+	return len(docs), nil
+}
+
+func (s *PrivacyService) anonymize(ctx context.Context, q firestore.Query, pseudonym string) (int, error) {
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	for start := 0; start < len(docs); start += privacyBatchSize {
+		end := start + privacyBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		batch := s.client.Batch()
+		for _, doc := range docs[start:end] {
+			batch.Update(doc.Ref, []firestore.Update{
+				{Path: "userId", Value: pseudonym},
+				{Path: "anonymizedAt", Value: time.Now()},
+			})
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return start, err
+		}
+	}
+	return len(docs), nil
+}
+
+func (s *PrivacyService) getPending(ctx context.Context, requestID string) (*PrivacyRequest, error) {
+	snap, err := s.requestCol.Doc(requestID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrPrivacyRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var req PrivacyRequest
+	if err := snap.DataTo(&req); err != nil {
+		return nil, err
+	}
+	req.ID = snap.Ref.ID
+	if req.Type != "erasure" || req.Status != "pending" {
+		return nil, ErrPrivacyRequestDone
+	}
+	return &req, nil
+}
+
+func docsData(ctx context.Context, q firestore.Query) ([]map[string]interface{}, error) {
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		m := doc.Data()
+		m["id"] = doc.Ref.ID
+		list = append(list, m)
+	}
+	return list, nil
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPrivacyServiceRequiresSalt(t *testing.T) {
+	svc, err := NewPrivacyService("")
+	assert.ErrorIs(t, err, ErrMissingPseudonymSalt)
+	assert.Nil(t, svc)
+}
+
+func TestPseudonym(t *testing.T) {
+	a := &PrivacyService{salt: "s1"}
+	assert.Equal(t, a.Pseudonym("u1"), a.Pseudonym("u1"))
+	assert.NotEqual(t, a.Pseudonym("u1"), a.Pseudonym("u2"))
+	assert.NotEqual(t, a.Pseudonym("u1"), (&PrivacyService{salt: "s2"}).Pseudonym("u1"))
+	assert.NotContains(t, a.Pseudonym("u1"), "u1")
+}
+
+func TestErasureTargets(t *testing.T) {
+	// ข้อมูลที่อ้างด้วย userId
+	for _, col := range []string{"reviews", "appeals", "moderation_flags", "line_users", "line_link_nonces", "line_flows", "outbox", "password_resets", "export_tokens"} {
+		assert.Contains(t, erasedByUserID, col)
+	}
+	assert.ElementsMatch(t, []string{"users", "active_sessions", "notification_preferences"}, erasedUserDocs)
+	// ข้อมูลที่อ้างด้วย LINE userId
+	assert.Contains(t, erasedByLineUserID, "line_events")
+	// ข้อมูลการเงินต้องเก็บไว้แบบนิรนาม ไม่ใช่ลบ
+	for _, col := range anonymizedByUserID {
+		assert.NotContains(t, erasedByUserID, col)
+	}
+
+	// job ที่ payload เป็น userId หรือ LINE userId ของบัญชี
+	assert.Equal(t, []string{"u1", "Uline", "Uold"}, erasedJobPayloads("u1", []string{"Uline", "Uold"}))
+	assert.Equal(t, []string{"u1"}, erasedJobPayloads("u1", nil))
+	assert.Equal(t, []string{"u1"}, erasedJobPayloads("u1", []string{"", "u1"}))
+}
@@ -0,0 +1,414 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrPromptNotFound        = errors.New("prompt not found")
+	ErrPromptVersionNotFound = errors.New("prompt version not found")
+	ErrInvalidPromptTemplate = errors.New("invalid prompt template")
+	// ErrPromptNotRendered template ที่มี {{...}} ใช้ได้เฉพาะ key ที่ go-backend render ให้ (ai-service อ่าน key อื่นจาก configs ตรง ๆ)
+	ErrPromptNotRendered = errors.New("template actions are only supported for prompts rendered by go-backend")
+)
+
+// PromptVariables ตัวแปรที่ template ใช้ได้ เช่น {{.UserName}}, {{range .Cards}}{{.}}{{end}}
+type PromptVariables struct {
+	UserName string   `firestore:"userName" json:"userName"`
+	Spread   string   `firestore:"spread" json:"spread"`
+	Cards    []string `firestore:"cards" json:"cards"`
+	Locale   string   `firestore:"locale" json:"locale"`
+}
+
+// samplePromptVariables ใช้ทดลอง render ตอนบันทึก เพื่อจับ template ที่อ้างตัวแปรผิด
+var samplePromptVariables = PromptVariables{
+	UserName: "ผู้ใช้",
+	Spread:   "three_card",
+	Cards:    []string{"The Fool", "The Lovers (กลับหัว)", "The Sun"},
+	Locale:   "th",
+}
+
+// PromptTemplate ตัวชี้ version ที่ใช้งานอยู่ (collection "prompt_templates", document id = key)
+type PromptTemplate struct {
+	ActiveVersion int       `firestore:"activeVersion" json:"activeVersion"`
+	LatestVersion int       `firestore:"latestVersion" json:"latestVersion"`
+	UpdatedAt     time.Time `firestore:"updatedAt" json:"updatedAt"`
+	UpdatedBy     string    `firestore:"updatedBy" json:"updatedBy"`
+}
+
+// PromptVersion prompt หนึ่ง version (แก้ไขไม่ได้) ที่ prompt_templates/{key}/versions/{version}
+type PromptVersion struct {
+	Version     int       `firestore:"version" json:"version"`
+	Model       string    `firestore:"model" json:"model"`
+	Prompt      string    `firestore:"prompt" json:"prompt"`
+	Temperature float64   `firestore:"temperature" json:"temperature"`
+	MaxTokens   int       `firestore:"max_tokens" json:"max_tokens"`
+	Author      string    `firestore:"author" json:"author"`
+	Diff        string    `firestore:"diff" json:"diff"` // เทียบกับ version ที่ active ตอนบันทึก
+	CreatedAt   time.Time `firestore:"createdAt" json:"createdAt"`
+}
+
+type PromptService struct {
+	client    *firestore.Client
+	col       *firestore.CollectionRef
+	configCol *firestore.CollectionRef
+}
+
+func NewPromptService() *PromptService {
+	return &PromptService{
+		client:    utils.Client,
+		col:       utils.Client.Collection("prompt_templates"),
+		configCol: utils.Client.Collection("configs"),
+	}
+}
+
+// ValidateTemplate ตรวจว่า parse ได้และ render กับตัวแปรตัวอย่างได้
+func ValidateTemplate(text string) error {
+	_, err := renderTemplate(text, samplePromptVariables)
+	return err
+}
+
+// promptRenderedByChat key ที่ ChatService render ก่อนส่งให้ ai-service ("ai_prompt.<purpose>")
+func promptRenderedByChat(key string) bool {
+	purpose, ok := strings.CutPrefix(key, "ai_prompt.")
+	return ok && chatPurposes[purpose]
+}
+
+// configPromptText ข้อความที่ sync ลง configs/{key} ซึ่ง ai-service ใช้โดยไม่ render
+// key ที่ go-backend render เขียนฉบับ render ด้วยตัวแปรว่าง (ใช้เมื่อคำขอไม่ได้แนบ prompt มา)
+// key อื่นต้องเป็นข้อความล้วน ไม่งั้น {{.UserName}} จะถูกส่งถึง model ตรง ๆ
+func configPromptText(key, text string) (string, error) {
+	if promptRenderedByChat(key) {
+		return renderTemplate(text, PromptVariables{})
+	}
+	tmpl, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if tmpl.Tree != nil {
+		for _, node := range tmpl.Tree.Root.Nodes {
+			if node.Type() != parse.NodeText {
+				return "", fmt.Errorf("%w: %s", ErrPromptNotRendered, key)
+			}
+		}
+	}
+	return text, nil
+}
+
+func renderTemplate(text string, vars PromptVariables) (string, error) {
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SaveVersion สร้าง version ใหม่ ตั้งเป็น active และ sync ลง configs/{key}
+// การบันทึกครั้งแรกของ key ที่มี prompt ใน configs อยู่แล้วจะเก็บ prompt เดิมเป็น version 1 (author "import") ก่อน
+func (s *PromptService) SaveVersion(ctx context.Context, key string, v PromptVersion, author string) (*PromptVersion, error) {
+	if err := ValidateTemplate(v.Prompt); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPromptTemplate, err)
+	}
+	if _, err := configPromptText(key, v.Prompt); err != nil {
+		if errors.Is(err, ErrPromptNotRendered) {
+			return nil, err
+		}
+		// key ที่ render ด้วยตัวแปรว่างไม่ผ่าน เช่น {{index .Cards 0}}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPromptTemplate, err)
+	}
+	tmplRef := s.col.Doc(key)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var current PromptTemplate
+		previous := ""
+		var imported *PromptVersion
+		snap, err := tx.Get(tmplRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := snap.DataTo(&current); err != nil {
+				return err
+			}
+			activeSnap, err := tx.Get(s.versionRef(key, current.ActiveVersion))
+			if err == nil {
+				previous, _ = activeSnap.Data()["prompt"].(string)
+			}
+		} else {
+			// key ที่มีใน configs ก่อนมีระบบ version: เก็บ prompt ที่ใช้อยู่เป็น version 1 เพื่อให้ rollback กลับมาได้
+			configSnap, err := tx.Get(s.configCol.Doc(key))
+			if err != nil && status.Code(err) != codes.NotFound {
+				return err
+			}
+			if err == nil {
+				imported = importedPromptVersion(configSnap.Data())
+			}
+		}
+
+		now := time.Now()
+		if imported != nil {
+			imported.Version = 1
+			imported.Author = "import"
+			imported.Diff = lineDiff("", imported.Prompt)
+			imported.CreatedAt = now
+			if err := tx.Create(s.versionRef(key, imported.Version), imported); err != nil {
+				return err
+			}
+			current.LatestVersion = imported.Version
+			previous = imported.Prompt
+		}
+		v.Version = current.LatestVersion + 1
+		v.Author = author
+		v.Diff = lineDiff(previous, v.Prompt)
+		v.CreatedAt = now
+		if err := tx.Create(s.versionRef(key, v.Version), v); err != nil {
+			return err
+		}
+		return s.activate(tx, key, v, PromptTemplate{
+			ActiveVersion: v.Version,
+			LatestVersion: v.Version,
+			UpdatedAt:     now,
+			UpdatedBy:     author,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// importedPromptVersion prompt ใน configs/{key} ที่ยังไม่มี version (nil = ไม่มีข้อความให้เก็บ)
+func importedPromptVersion(config map[string]interface{}) *PromptVersion {
+	prompt, _ := config["prompt"].(string)
+	if prompt == "" {
+		return nil
+	}
+	v := &PromptVersion{Prompt: prompt}
+	v.Model, _ = config["model"].(string)
+	switch t := config["temperature"].(type) {
+	case float64:
+		v.Temperature = t
+	case int64:
+		v.Temperature = float64(t)
+	}
+	switch n := config["max_tokens"].(type) {
+	case int64:
+		v.MaxTokens = int(n)
+	case float64:
+		v.MaxTokens = int(n)
+	}
+	return v
+}
+
+// SavePromptText สร้าง version ใหม่โดยเปลี่ยนแค่ model/prompt และใช้ temperature/max_tokens จาก version ที่ active
+func (s *PromptService) SavePromptText(ctx context.Context, key, model, prompt, author string) (*PromptVersion, error) {
+	v := PromptVersion{Model: model, Prompt: prompt}
+	active, err := s.GetActive(ctx, key)
+	if err != nil && !errors.Is(err, ErrPromptNotFound) {
+		return nil, err
+	}
+	if active != nil {
+		v.Temperature = active.Temperature
+		v.MaxTokens = active.MaxTokens
+	}
+	return s.SaveVersion(ctx, key, v, author)
+}
+
+// Rollback ตั้ง version เดิมกลับมาเป็น active (ไม่สร้าง version ใหม่)
+func (s *PromptService) Rollback(ctx context.Context, key string, version int, author string) (*PromptVersion, error) {
+	var target PromptVersion
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		tmplSnap, err := tx.Get(s.col.Doc(key))
+		if status.Code(err) == codes.NotFound {
+			return ErrPromptNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var current PromptTemplate
+		if err := tmplSnap.DataTo(&current); err != nil {
+			return err
+		}
+		vSnap, err := tx.Get(s.versionRef(key, version))
+		if status.Code(err) == codes.NotFound {
+			return ErrPromptVersionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := vSnap.DataTo(&target); err != nil {
+			return err
+		}
+		current.ActiveVersion = version
+		current.UpdatedAt = time.Now()
+		current.UpdatedBy = author
+		return s.activate(tx, key, target, current)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// activate เขียนตัวชี้ active และ copy เนื้อหา (ตาม configPromptText) ไปที่ configs/{key} ซึ่ง ai-service อ่านอยู่
+func (s *PromptService) activate(tx *firestore.Transaction, key string, v PromptVersion, tmpl PromptTemplate) error {
+	text, err := configPromptText(key, v.Prompt)
+	if err != nil {
+		return err
+	}
+	if err := tx.Set(s.col.Doc(key), tmpl); err != nil {
+		return err
+	}
+	return tx.Set(s.configCol.Doc(key), map[string]interface{}{
+		"model":       v.Model,
+		"prompt":      text,
+		"temperature": v.Temperature,
+		"max_tokens":  v.MaxTokens,
+		"version":     v.Version,
+		"updatedAt":   tmpl.UpdatedAt,
+	}, firestore.MergeAll)
+}
+
+// GetActive คืน version ที่ active ของ key
+func (s *PromptService) GetActive(ctx context.Context, key string) (*PromptVersion, error) {
+	snap, err := s.col.Doc(key).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrPromptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tmpl PromptTemplate
+	if err := snap.DataTo(&tmpl); err != nil {
+		return nil, err
+	}
+	return s.GetVersion(ctx, key, tmpl.ActiveVersion)
+}
+
+// GetVersion ดึง version ที่ระบุ
+func (s *PromptService) GetVersion(ctx context.Context, key string, version int) (*PromptVersion, error) {
+	snap, err := s.versionRef(key, version).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrPromptVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v PromptVersion
+	if err := snap.DataTo(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ListVersions ดึงทุก version เรียงจากใหม่ → เก่า
+func (s *PromptService) ListVersions(ctx context.Context, key string) ([]PromptVersion, error) {
+	docs, err := s.col.Doc(key).Collection("versions").OrderBy("version", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]PromptVersion, 0, len(docs))
+	for _, doc := range docs {
+		var v PromptVersion
+		if err := doc.DataTo(&v); err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// Compare คืน diff ระหว่างสอง version
+func (s *PromptService) Compare(ctx context.Context, key string, from, to int) (string, error) {
+	a, err := s.GetVersion(ctx, key, from)
+	if err != nil {
+		return "", err
+	}
+	b, err := s.GetVersion(ctx, key, to)
+	if err != nil {
+		return "", err
+	}
+	return lineDiff(a.Prompt, b.Prompt), nil
+}
+
+// Render render version ที่ active ด้วยตัวแปรที่ให้มา
+func (s *PromptService) Render(ctx context.Context, key string, vars PromptVariables) (string, *PromptVersion, error) {
+	v, err := s.GetActive(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	text, err := renderTemplate(v.Prompt, vars)
+	if err != nil {
+		return "", nil, err
+	}
+	return text, v, nil
+}
+
+func (s *PromptService) versionRef(key string, version int) *firestore.DocumentRef {
+	return s.col.Doc(key).Collection("versions").Doc(fmt.Sprintf("%d", version))
+}
+
+// lineDiff diff แบบบรรทัด (LCS) ผลลัพธ์ขึ้นต้นด้วย "  ", "- ", "+ "
+func lineDiff(a, b string) string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+	if a == "" {
+		x = nil
+	}
+
+	// lcs[i][j] = ความยาว LCS ของ x[i:] กับ y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, "  "+x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+x[i])
+			i++
+		default:
+			out = append(out, "+ "+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, "- "+x[i])
+	}
+	for ; j < len(y); j++ {
+		out = append(out, "+ "+y[j])
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineDiff(t *testing.T) {
+	for name, tc := range map[string]struct {
+		a, b, want string
+	}{
+		"same":      {"a\nb", "a\nb", "  a\n  b"},
+		"from none": {"", "a\nb", "+ a\n+ b"},
+		"changed":   {"a\nb\nc", "a\nx\nc", "  a\n- b\n+ x\n  c"},
+		"appended":  {"a", "a\nb", "  a\n+ b"},
+		"removed":   {"a\nb\nc", "a\nc", "  a\n- b\n  c"},
+	} {
+		assert.Equal(t, tc.want, lineDiff(tc.a, tc.b), name)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		text string
+		ok   bool
+	}{
+		"plain":        {"คุณคือหมอดูไพ่ทาโรต์", true},
+		"variables":    {"สวัสดี {{.UserName}} ({{.Locale}})", true},
+		"range":        {"{{range .Cards}}- {{.}}\n{{end}}", true},
+		"unclosed":     {"สวัสดี {{.UserName", false},
+		"unknown var":  {"{{.Birthday}}", false},
+		"unknown func": {"{{upper .UserName}}", false},
+	} {
+		err := ValidateTemplate(tc.text)
+		if tc.ok {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	vars := PromptVariables{UserName: "ต้น", Spread: "three_card", Cards: []string{"อดีต: The Fool", "ปัจจุบัน: The Sun"}, Locale: "th"}
+	for name, tc := range map[string]struct {
+		text, want string
+	}{
+		"plain":  {"คุณคือหมอดู", "คุณคือหมอดู"},
+		"fields": {"{{.UserName}} เปิด {{.Spread}}", "ต้น เปิด three_card"},
+		"range":  {"{{range .Cards}}[{{.}}]{{end}}", "[อดีต: The Fool][ปัจจุบัน: The Sun]"},
+		"if":     {"{{if .Cards}}มีไพ่{{else}}ไม่มีไพ่{{end}}", "มีไพ่"},
+	} {
+		got, err := renderTemplate(tc.text, vars)
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.want, got, name)
+	}
+
+	got, err := renderTemplate("{{if .Cards}}มีไพ่{{else}}ไม่มีไพ่{{end}}", PromptVariables{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ไม่มีไพ่", got)
+}
+
+func TestConfigPromptText(t *testing.T) {
+	for name, tc := range map[string]struct {
+		key, text, want string
+		err             error
+	}{
+		"rendered key":       {"ai_prompt.tarot", "สวัสดี {{.UserName}}{{range .Cards}} {{.}}{{end}}", "สวัสดี ", nil},
+		"rendered key plain": {"ai_prompt.chat", "คุณคือหมอดู", "คุณคือหมอดู", nil},
+		"config-only plain":  {"ai_prompt.line", "คุณคือหมอดู", "คุณคือหมอดู", nil},
+		"config-only action": {"ai_prompt.line", "สวัสดี {{.UserName}}", "", ErrPromptNotRendered},
+		"unknown purpose":    {"ai_prompt.summary", "{{if .Cards}}x{{end}}", "", ErrPromptNotRendered},
+	} {
+		got, err := configPromptText(tc.key, tc.text)
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.want, got, name)
+	}
+}
+
+func TestImportedPromptVersion(t *testing.T) {
+	v := importedPromptVersion(map[string]interface{}{
+		"model":       "gpt-4o",
+		"prompt":      "คุณคือหมอดูไพ่ทาโรต์",
+		"temperature": 0.7,
+		"max_tokens":  int64(800),
+		"updatedAt":   "ignored",
+	})
+	assert.Equal(t, &PromptVersion{Model: "gpt-4o", Prompt: "คุณคือหมอดูไพ่ทาโรต์", Temperature: 0.7, MaxTokens: 800}, v)
+
+	// temperature ที่บันทึกเป็นจำนวนเต็ม
+	assert.Equal(t, 1.0, importedPromptVersion(map[string]interface{}{"prompt": "x", "temperature": int64(1)}).Temperature)
+
+	// ไม่มีข้อความ = ไม่มีอะไรให้ rollback กลับไป
+	assert.Nil(t, importedPromptVersion(map[string]interface{}{"model": "gpt-4o"}))
+	assert.Nil(t, importedPromptVersion(nil))
+}
+
+func TestSaveVersionRejectsInvalidTemplate(t *testing.T) {
+	s := &PromptService{}
+	for name, tc := range map[string]struct {
+		key, text string
+		err       error
+	}{
+		"unknown var":           {"ai_prompt.chat", "{{.Nope}}", ErrInvalidPromptTemplate},
+		"unclosed":              {"ai_prompt.chat", "สวัสดี {{.UserName", ErrInvalidPromptTemplate},
+		"fails with empty vars": {"ai_prompt.tarot", "{{index .Cards 0}}", ErrInvalidPromptTemplate},
+		"config-only action":    {"ai_prompt.line", "สวัสดี {{.UserName}}", ErrPromptNotRendered},
+	} {
+		// ตรวจก่อนแตะ Firestore จึงเรียกกับ service เปล่าได้
+		_, err := s.SaveVersion(context.Background(), tc.key, PromptVersion{Prompt: tc.text}, "admin")
+		assert.ErrorIs(t, err, tc.err, name)
+	}
+}
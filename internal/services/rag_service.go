@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Passage ข้อความหนึ่งชิ้นใน RAG index (collection "rag_passages")
+type Passage struct {
+	SourceType string    `firestore:"sourceType"` // "card", "deck", "knowledge"
+	SourceID   string    `firestore:"sourceId"`
+	DeckID     string    `firestore:"deckId"`
+	Title      string    `firestore:"title"`
+	Text       string    `firestore:"text"`
+	Embedding  []float64 `firestore:"embedding"`
+	Version    int64     `firestore:"version"` // version ของ index ที่เขียน passage นี้ (ตรงกับ rag_meta/index)
+	UpdatedAt  time.Time `firestore:"updatedAt"`
+}
+
+// Citation passage ที่ถูกแนบไปกับคำขอ AI และคืนให้ผู้เรียกเป็นแหล่งอ้างอิง
+type Citation struct {
+	ID         string  `json:"id"`
+	SourceType string  `json:"sourceType"`
+	SourceID   string  `json:"sourceId"`
+	DeckID     string  `json:"deckId,omitempty"`
+	Title      string  `json:"title"`
+	Text       string  `json:"text"`
+	Score      float64 `json:"score"`
+}
+
+type indexedPassage struct {
+	id string
+	Passage
+}
+
+type RAGService struct {
+	client    *firestore.Client
+	col       *firestore.CollectionRef
+	embedder  EmbeddingProvider
+	batchSize int
+	metaRef   *firestore.DocumentRef // rag_meta/index เก็บ version ของ index
+
+	mu        sync.RWMutex
+	cache     []indexedPassage // nil = ยังไม่ได้โหลดจาก Firestore
+	version   int64            // version ของ index ที่อยู่ใน cache
+	checkedAt time.Time
+}
+
+var ErrRAGRebuildConflict = errors.New("rag index was rebuilt concurrently")
+
+// ragVersionCheckInterval ความถี่ในการอ่าน version ของ index (rag_meta/index)
+// RAGService ทุกตัว (ทุก route และทุก instance) โหลด index ใหม่เมื่อ version เปลี่ยนหลัง RebuildIndex
+const ragVersionCheckInterval = 30 * time.Second
+
+func NewRAGService(embedder EmbeddingProvider) *RAGService {
+	return &RAGService{
+		client:    utils.Client,
+		col:       utils.Client.Collection("rag_passages"),
+		embedder:  embedder,
+		batchSize: 64,
+		metaRef:   utils.Client.Collection("rag_meta").Doc("index"),
+	}
+}
+
+// RebuildIndex สร้าง index ใหม่จาก decks, decks/{deckId}/cards และ knowledge_docs
+func (s *RAGService) RebuildIndex(ctx context.Context) (int, error) {
+	passages, err := s.collectPassages(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(passages); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(passages) {
+			end = len(passages)
+		}
+		texts := make([]string, 0, end-start)
+		for _, p := range passages[start:end] {
+			texts = append(texts, p.Title+"\n"+p.Text)
+		}
+		vectors, err := s.embedder.Embed(ctx, texts)
+		if err != nil {
+			return 0, err
+		}
+		for i := range vectors {
+			passages[start+i].Embedding = vectors[i]
+		}
+	}
+
+	version, err := s.claimVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	indexed, err := s.writePassages(ctx, passages, version)
+	if err == nil {
+		err = s.activateVersion(ctx, version)
+	}
+	if err != nil {
+		// version นี้ถูกจองให้การ rebuild ครั้งนี้เท่านั้น ลบ passage ของมันได้โดยไม่กระทบ build อื่น
+		if _, derr := utils.DeleteQuery(ctx, s.col.Where("version", "==", version), 200); derr != nil {
+			log.Println("Error deleting unused RAG passages:", derr)
+		}
+		return 0, err
+	}
+
+	// ลบ passage ของ version เก่าหลังสลับแล้ว (ลบไม่ครบก็ไม่กระทบผู้อ่านที่โหลดเฉพาะ version ปัจจุบัน)
+	if err := s.deleteStalePassages(ctx, version); err != nil {
+		log.Println("Error deleting stale RAG passages:", err)
+	}
+
+	s.mu.Lock()
+	s.cache = indexed
+	s.version = version
+	s.checkedAt = time.Now()
+	s.mu.Unlock()
+	return len(indexed), nil
+}
+
+// claimVersion จอง version ใหม่จากตัวนับ lastClaimed ใน rag_meta/index ก่อนเขียน passage
+// การ rebuild ที่รันพร้อมกันจึงได้ version (และ doc ID) ไม่ซ้ำกัน
+func (s *RAGService) claimVersion(ctx context.Context) (int64, error) {
+	var version int64
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(s.metaRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		var current, claimed int64
+		if err == nil {
+			current, _ = snap.Data()["version"].(int64)
+			claimed, _ = snap.Data()["lastClaimed"].(int64)
+		}
+		version = nextRAGVersion(current, claimed)
+		return tx.Set(s.metaRef, map[string]interface{}{"lastClaimed": version}, firestore.MergeAll)
+	})
+	return version, err
+}
+
+// nextRAGVersion version ถัดไปต้องมากกว่าทั้ง version ที่ใช้อยู่และทุก version ที่เคยจอง
+func nextRAGVersion(current, claimed int64) int64 {
+	if current > claimed {
+		claimed = current
+	}
+	return claimed + 1
+}
+
+// writePassages เขียน passage ของ version ใหม่ไว้ข้าง index เดิม ผู้อ่านยังใช้ index เดิมได้ถ้าเขียนไม่สำเร็จ
+func (s *RAGService) writePassages(ctx context.Context, passages []Passage, version int64) ([]indexedPassage, error) {
+	indexed := make([]indexedPassage, 0, len(passages))
+	batch := s.client.Batch()
+	pending := 0
+	for _, p := range passages {
+		p.Version = version
+		ref := s.col.Doc(fmt.Sprintf("v%d_%s", version, passageID(p)))
+		batch.Set(ref, p)
+		indexed = append(indexed, indexedPassage{id: ref.ID, Passage: p})
+		pending++
+		if pending == 400 {
+			if _, err := batch.Commit(ctx); err != nil {
+				return nil, err
+			}
+			batch = s.client.Batch()
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		if _, err := batch.Commit(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return indexed, nil
+}
+
+// activateVersion สลับ rag_meta/index ไปที่ version หลังเขียนครบ ผู้อ่านจึงไม่โหลด index ที่เขียนไม่เสร็จ
+// ถ้า build ที่จอง version ใหม่กว่าสลับไปก่อนแล้วจะคืน ErrRAGRebuildConflict (index ไม่ถอยกลับ)
+func (s *RAGService) activateVersion(ctx context.Context, version int64) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		latest, err := s.readVersion(tx.Get(s.metaRef))
+		if err != nil {
+			return err
+		}
+		if latest >= version {
+			return ErrRAGRebuildConflict
+		}
+		return tx.Set(s.metaRef, map[string]interface{}{
+			"version": version,
+			"builtAt": time.Now(),
+		}, firestore.MergeAll)
+	})
+}
+
+// deleteStalePassages ลบ passage ของ version ที่เก่ากว่า version ที่ใช้อยู่ (รวม passage ที่เขียนก่อนมี version)
+// passage ของ version ที่ใหม่กว่าเป็นของ rebuild ที่ยังเขียนอยู่ จึงไม่ลบ
+func (s *RAGService) deleteStalePassages(ctx context.Context, version int64) error {
+	docs, err := s.col.Select("version").Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	batch := s.client.Batch()
+	pending := 0
+	for _, doc := range docs {
+		if v, _ := doc.Data()["version"].(int64); v >= version {
+			continue
+		}
+		batch.Delete(doc.Ref)
+		pending++
+		if pending == 400 {
+			if _, err := batch.Commit(ctx); err != nil {
+				return err
+			}
+			batch = s.client.Batch()
+			pending = 0
+		}
+	}
+	if pending > 0 {
+		_, err = batch.Commit(ctx)
+	}
+	return err
+}
+
+// readVersion อ่าน version จาก snapshot ของ rag_meta/index (ยังไม่เคย rebuild = 0)
+func (s *RAGService) readVersion(snap *firestore.DocumentSnapshot, err error) (int64, error) {
+	if status.Code(err) == codes.NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, _ := snap.Data()["version"].(int64)
+	return version, nil
+}
+
+// Retrieve ค้น passage ที่ใกล้เคียงคำถามที่สุด k รายการ (deckID ว่าง = ทุก deck)
+func (s *RAGService) Retrieve(ctx context.Context, question, deckID string, k int) ([]Citation, error) {
+	passages, err := s.loadIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(passages) == 0 {
+		return nil, nil
+	}
+	vectors, err := s.embedder.Embed(ctx, []string{question})
+	if err != nil {
+		return nil, err
+	}
+	return rankPassages(passages, vectors[0], deckID, k), nil
+}
+
+func (s *RAGService) loadIndex(ctx context.Context) ([]indexedPassage, error) {
+	s.mu.RLock()
+	cached, cachedVersion, checkedAt := s.cache, s.version, s.checkedAt
+	s.mu.RUnlock()
+	if cached != nil && time.Since(checkedAt) < ragVersionCheckInterval {
+		return cached, nil
+	}
+
+	version, err := s.readVersion(s.metaRef.Get(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && version == cachedVersion {
+		s.mu.Lock()
+		s.checkedAt = time.Now()
+		s.mu.Unlock()
+		return cached, nil
+	}
+
+	docs, err := s.col.Where("version", "==", version).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		// index ที่สร้างก่อน passage มี version ใช้ได้จนกว่าจะ rebuild ครั้งถัดไป
+		if docs, err = s.col.Documents(ctx).GetAll(); err != nil {
+			return nil, err
+		}
+	}
+	loaded := make([]indexedPassage, 0, len(docs))
+	for _, doc := range docs {
+		var p Passage
+		if err := doc.DataTo(&p); err != nil {
+			continue
+		}
+		if p.Version != version && p.Version != 0 {
+			continue
+		}
+		loaded = append(loaded, indexedPassage{id: doc.Ref.ID, Passage: p})
+	}
+
+	s.mu.Lock()
+	s.cache = loaded
+	s.version = version
+	s.checkedAt = time.Now()
+	s.mu.Unlock()
+	return loaded, nil
+}
+
+// rankPassages เรียง passage ตาม cosine similarity แล้วคืน k อันดับแรก
+// passage ของ deck อื่นถูกข้าม แต่ knowledge ทั่วไป (ไม่มี deckId) ใช้ได้กับทุก deck
+func rankPassages(passages []indexedPassage, query []float64, deckID string, k int) []Citation {
+	results := make([]Citation, 0, len(passages))
+	for _, p := range passages {
+		if deckID != "" && p.DeckID != "" && p.DeckID != deckID {
+			continue
+		}
+		results = append(results, Citation{
+			ID:         p.id,
+			SourceType: p.SourceType,
+			SourceID:   p.SourceID,
+			DeckID:     p.DeckID,
+			Title:      p.Title,
+			Text:       p.Text,
+			Score:      cosineSimilarity(query, p.Embedding),
+		})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+func (s *RAGService) collectPassages(ctx context.Context) ([]Passage, error) {
+	var passages []Passage
+	now := time.Now()
+
+	decks, err := s.client.Collection("decks").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, deck := range decks {
+		data := deck.Data()
+		if text := joinFields(data, "description", "style", "history"); text != "" {
+			passages = append(passages, Passage{
+				SourceType: "deck",
+				SourceID:   deck.Ref.ID,
+				DeckID:     deck.Ref.ID,
+				Title:      stringField(data, "name", deck.Ref.ID),
+				Text:       text,
+				UpdatedAt:  now,
+			})
+		}
+
+		cards, err := deck.Ref.Collection("cards").Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, card := range cards {
+			cd := card.Data()
+			text := joinFields(cd, "keywords", "meaningUpright", "meaningReversed", "description")
+			if text == "" {
+				continue
+			}
+			passages = append(passages, Passage{
+				SourceType: "card",
+				SourceID:   card.Ref.ID,
+				DeckID:     deck.Ref.ID,
+				Title:      stringField(cd, "name", card.Ref.ID),
+				Text:       text,
+				UpdatedAt:  now,
+			})
+		}
+	}
+
+	knowledge, err := s.client.Collection("knowledge_docs").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range knowledge {
+		data := doc.Data()
+		text := stringField(data, "content", "")
+		if text == "" {
+			continue
+		}
+		passages = append(passages, Passage{
+			SourceType: "knowledge",
+			SourceID:   doc.Ref.ID,
+			DeckID:     stringField(data, "deckId", ""),
+			Title:      stringField(data, "title", doc.Ref.ID),
+			Text:       text,
+			UpdatedAt:  now,
+		})
+	}
+	return passages, nil
+}
+
+func passageID(p Passage) string {
+	if p.SourceType == "card" {
+		return fmt.Sprintf("card_%s_%s", p.DeckID, p.SourceID)
+	}
+	return fmt.Sprintf("%s_%s", p.SourceType, p.SourceID)
+}
+
+func stringField(data map[string]interface{}, key, fallback string) string {
+	if v, ok := data[key].(string); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// joinFields รวม field ที่เป็นข้อความ (หรือ array ของข้อความ) เป็น passage เดียว
+func joinFields(data map[string]interface{}, keys ...string) string {
+	var parts []string
+	for _, key := range keys {
+		switch v := data[key].(type) {
+		case string:
+			if v != "" {
+				parts = append(parts, fmt.Sprintf("%s: %s", key, v))
+			}
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				if str, ok := item.(string); ok {
+					items = append(items, str)
+				}
+			}
+			if len(items) > 0 {
+				parts = append(parts, fmt.Sprintf("%s: %s", key, strings.Join(items, ", ")))
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashEmbeddingIsDeterministic(t *testing.T) {
+	p := NewHashEmbeddingProvider(64)
+	a, err := p.Embed(context.Background(), []string{"The Lovers ความรัก"})
+	assert.NoError(t, err)
+	b, _ := p.Embed(context.Background(), []string{"The Lovers ความรัก"})
+	assert.Equal(t, a, b)
+	assert.InDelta(t, 1.0, cosineSimilarity(a[0], b[0]), 1e-9)
+}
+
+func TestRankPassagesFiltersDeckAndOrdersByScore(t *testing.T) {
+	p := NewHashEmbeddingProvider(128)
+	texts := []string{
+		"ไพ่ The Lovers ความรัก ความสัมพันธ์ การเลือก",
+		"ไพ่ Five of Pentacles การเงิน ความยากลำบาก",
+		"ไพ่ The Lovers ของอีก deck",
+		"ความรักในไพ่ทาโรต์ทั่วไป",
+	}
+	vectors, _ := p.Embed(context.Background(), texts)
+	passages := []indexedPassage{
+		{id: "lovers", Passage: Passage{DeckID: "rider", Text: texts[0], Embedding: vectors[0]}},
+		{id: "pentacles", Passage: Passage{DeckID: "rider", Text: texts[1], Embedding: vectors[1]}},
+		{id: "other", Passage: Passage{DeckID: "thoth", Text: texts[2], Embedding: vectors[2]}},
+		{id: "general", Passage: Passage{Text: texts[3], Embedding: vectors[3]}},
+	}
+
+	query, _ := p.Embed(context.Background(), []string{"ความรัก The Lovers"})
+	results := rankPassages(passages, query[0], "rider", 2)
+
+	assert.Len(t, results, 2)
+	assert.Equal(t, "lovers", results[0].ID)
+	for _, r := range results {
+		assert.NotEqual(t, "other", r.ID)
+	}
+}
+
+func TestNextRAGVersion(t *testing.T) {
+	assert.Equal(t, int64(1), nextRAGVersion(0, 0))
+	assert.Equal(t, int64(4), nextRAGVersion(3, 0), "index built before claiming existed")
+	// rebuild ที่จองไว้แต่ยังไม่เสร็จ (หรือล้มเหลว) ทำให้ version ถัดไปไม่ซ้ำกับของมัน
+	assert.Equal(t, int64(6), nextRAGVersion(3, 5))
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PurgeJobName ชื่อ job ใน workpool ที่ลบข้อความเกิน retention (รันซ้ำทุก purgeInterval)
+const PurgeJobName = "purge_messages"
+
+const (
+	RetentionTierFree    = TierFree
+	RetentionTierPremium = TierPremium
+
+	purgeInterval  = 24 * time.Hour
+	purgeBatchSize = 200
+)
+
+// RetentionPolicy เก็บข้อความกี่วัน (collection "retention_policies", document id = tier หรือ packageId)
+// RetentionDays <= 0 = เก็บตลอด
+type RetentionPolicy struct {
+	RetentionDays int       `firestore:"retentionDays" json:"retentionDays"`
+	UpdatedAt     time.Time `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// DefaultRetentionPolicies ใช้เมื่อยังไม่ได้ตั้งค่าใน Firestore
+var DefaultRetentionPolicies = map[string]RetentionPolicy{
+	RetentionTierFree:    {RetentionDays: 1},
+	RetentionTierPremium: {RetentionDays: 90},
+}
+
+// PurgeReport สรุปผลการ purge หนึ่งรอบ
+type PurgeReport struct {
+	Conversations        int `json:"conversations"`
+	Pinned               int `json:"pinned"`
+	MessagesDeleted      int `json:"messagesDeleted"`
+	ConversationsDeleted int `json:"conversationsDeleted"`
+}
+
+type RetentionService struct {
+	convCol    *firestore.CollectionRef
+	policyCol  *firestore.CollectionRef
+	userPkgCol *firestore.CollectionRef
+	workpool   *WorkpoolService
+
+	mu       sync.RWMutex
+	policies map[string]RetentionPolicy
+	loadedAt time.Time
+}
+
+// NewRetentionService ลงทะเบียน handler ของ PurgeJobName กับ workpool ด้วย
+func NewRetentionService(workpool *WorkpoolService) *RetentionService {
+	s := &RetentionService{
+		convCol:    utils.Client.Collection("conversations"),
+		policyCol:  utils.Client.Collection("retention_policies"),
+		userPkgCol: utils.Client.Collection("user_packages"),
+		workpool:   workpool,
+	}
+	RegisterJobHandler(PurgeJobName, func(ctx context.Context, _ string) error {
+		// ตั้งรอบถัดไปก่อน ถ้า process ตายหรือ purge ล้มเหลวกลางทาง รอบถัดไปยังทำงานตามเวลา
+		// ตั้งไม่สำเร็จ = job failed (แจ้งเตือน) แล้ว EnsurePurgeScheduled ตอน start ตั้งให้ใหม่
+		schedErr := s.SchedulePurge(ctx, time.Now().Add(purgeInterval))
+		report, err := s.Purge(ctx)
+		if err == nil {
+			log.Printf("Purged %d messages, %d conversations", report.MessagesDeleted, report.ConversationsDeleted)
+		}
+		return errors.Join(err, schedErr)
+	})
+	return s
+}
+
+// SchedulePurge ตั้งเวลา purge รอบถัดไป (มีได้แค่ job เดียว)
+func (s *RetentionService) SchedulePurge(ctx context.Context, runAt time.Time) error {
+	return s.workpool.ScheduleUniqueJob(ctx, PurgeJobName, PurgeJobName, "", runAt)
+}
+
+// EnsurePurgeScheduled ตั้ง purge รอบแรกถ้ายังไม่เคยมี job หรือ job เดิมจบไปแล้ว (restart ไม่เลื่อนเวลาของรอบที่ตั้งไว้แล้ว)
+// job ที่ค้าง processing เพราะ process ตายระหว่าง purge ถูก workpool หยิบไปทำใหม่เมื่อเกิน jobLease
+func (s *RetentionService) EnsurePurgeScheduled(ctx context.Context) error {
+	return s.workpool.EnsureJob(ctx, PurgeJobName, PurgeJobName, "", time.Now().Add(time.Hour))
+}
+
+// Policies คืน policy ที่ใช้อยู่จริง (default + ค่าใน Firestore)
+func (s *RetentionService) Policies(ctx context.Context) (map[string]RetentionPolicy, error) {
+	s.mu.RLock()
+	cached, loadedAt := s.policies, s.loadedAt
+	s.mu.RUnlock()
+	if cached != nil && time.Since(loadedAt) < time.Minute {
+		return cached, nil
+	}
+
+	policies := make(map[string]RetentionPolicy, len(DefaultRetentionPolicies))
+	for tier, p := range DefaultRetentionPolicies {
+		policies[tier] = p
+	}
+	docs, err := s.policyCol.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		var p RetentionPolicy
+		if err := doc.DataTo(&p); err != nil {
+			continue
+		}
+		policies[doc.Ref.ID] = p
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return policies, nil
+}
+
+// SetPolicy ตั้ง retention ของ tier หรือ packageId
+func (s *RetentionService) SetPolicy(ctx context.Context, key string, days int) error {
+	_, err := s.policyCol.Doc(key).Set(ctx, RetentionPolicy{RetentionDays: days, UpdatedAt: time.Now()})
+	s.mu.Lock()
+	s.policies = nil
+	s.mu.Unlock()
+	return err
+}
+
+// RetentionFor คืนจำนวนวันที่เก็บข้อความของผู้ใช้ (0 = เก็บตลอด)
+// ผู้ใช้ที่มี package active ใช้ policy ของ packageId นั้น (ถ้ามี) ไม่งั้นใช้ tier premium
+func (s *RetentionService) RetentionFor(ctx context.Context, userID string) (int, error) {
+	policies, err := s.Policies(ctx)
+	if err != nil {
+		return 0, err
+	}
+	docs, err := s.userPkgCol.Where("userId", "==", userID).Where("expiresAt", ">", time.Now()).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+	if len(docs) == 0 {
+		return policies[RetentionTierFree].RetentionDays, nil
+	}
+	best := -1
+	for _, doc := range docs {
+		pkgID, _ := doc.Data()["packageId"].(string)
+		p, ok := policies[pkgID]
+		if !ok {
+			p = policies[RetentionTierPremium]
+		}
+		best = longerRetention(best, p.RetentionDays)
+	}
+	return best, nil
+}
+
+// longerRetention เลือกค่าที่เก็บนานกว่า (ค่า <= 0 = ตลอด ชนะทุกค่า, -1 = ยังไม่มีค่า)
+func longerRetention(a, b int) int {
+	if a == -1 {
+		return b
+	}
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// SetPinned ปักหมุด conversation ไว้ไม่ให้ถูก purge (ต้องเป็นเจ้าของ)
+func (s *RetentionService) SetPinned(ctx context.Context, userID, convID string, pinned bool) error {
+	snap, err := s.convCol.Doc(convID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ErrConversationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner, _ := snap.Data()["userId"].(string); owner != userID {
+		return ErrConversationNotFound
+	}
+	_, err = snap.Ref.Update(ctx, []firestore.Update{{Path: "pinned", Value: pinned}})
+	return err
+}
+
+// shortestRetention คืนจำนวนวันที่สั้นที่สุดในบรรดา policy ที่มีกำหนด (0 = ทุก policy เก็บตลอด)
+// cutoff ของค่านี้ใหม่ที่สุด จึงครอบคลุม conversation ที่ต้อง purge ของทุก tier/package
+func shortestRetention(policies map[string]RetentionPolicy) int {
+	shortest := 0
+	for _, p := range policies {
+		if p.RetentionDays > 0 && (shortest == 0 || p.RetentionDays < shortest) {
+			shortest = p.RetentionDays
+		}
+	}
+	return shortest
+}
+
+// Purge ลบข้อความที่เกิน retention ของเจ้าของ conversation
+// query เฉพาะ conversation ที่ oldestMessageAt เก่ากว่า cutoff ของ policy ที่สั้นที่สุด แล้วเทียบกับ retention ของเจ้าของอีกชั้น
+// partition ที่เก่ากว่า cutoff ทั้งเดือนถูกลบทั้ง collection, partition ที่คร่อม cutoff ลบเฉพาะข้อความเก่า
+// conversation ที่ไม่เหลือข้อความแล้วถูกลบทั้ง document (ยกเว้นที่ปักหมุด) ที่เหลือเลื่อน oldestMessageAt ไปข้อความเก่าสุดที่เหลือ
+func (s *RetentionService) Purge(ctx context.Context) (*PurgeReport, error) {
+	report := &PurgeReport{}
+	policies, err := s.Policies(ctx)
+	if err != nil {
+		return report, err
+	}
+	shortest := shortestRetention(policies)
+	if shortest == 0 {
+		return report, nil
+	}
+	retention := map[string]int{} // cache ต่อ userId ในรอบนี้
+	now := time.Now()
+
+	// อ่านทีละหน้าแทนการเปิด iterator ค้างไว้ตลอดการลบ (iterator ที่เปิดนานเกิน deadline ของ query)
+	q := s.convCol.Where("oldestMessageAt", "<", now.AddDate(0, 0, -shortest)).
+		OrderBy("oldestMessageAt", firestore.Asc).Limit(purgeBatchSize)
+	for {
+		docs, err := q.Documents(ctx).GetAll()
+		if err != nil {
+			return report, err
+		}
+		for _, doc := range docs {
+			if err := s.purgeExpired(ctx, doc, now, retention, report); err != nil {
+				return report, err
+			}
+		}
+		if len(docs) < purgeBatchSize {
+			return report, nil
+		}
+		q = q.StartAfter(docs[len(docs)-1])
+	}
+}
+
+// purgeExpired ลบข้อความของ conversation หนึ่งที่เกิน retention ของเจ้าของ (retention = cache ต่อ userId ในรอบนี้)
+func (s *RetentionService) purgeExpired(ctx context.Context, doc *firestore.DocumentSnapshot, now time.Time, retention map[string]int, report *PurgeReport) error {
+	report.Conversations++
+	data := doc.Data()
+	if pinned, _ := data["pinned"].(bool); pinned {
+		report.Pinned++
+		return nil
+	}
+	userID, _ := data["userId"].(string)
+	days, ok := retention[userID]
+	if !ok {
+		var err error
+		if days, err = s.RetentionFor(ctx, userID); err != nil {
+			return err
+		}
+		retention[userID] = days
+	}
+	if days <= 0 {
+		return nil
+	}
+
+	cutoff := now.AddDate(0, 0, -days)
+	if oldest, _ := data["oldestMessageAt"].(time.Time); !oldest.Before(cutoff) {
+		return nil
+	}
+	deleted, oldest, err := s.purgeConversation(ctx, doc.Ref, cutoff)
+	report.MessagesDeleted += deleted
+	if err != nil {
+		return err
+	}
+	if oldest.IsZero() {
+		// conversation ที่เพิ่งเริ่มอาจยังไม่มีข้อความ จึงลบเฉพาะที่เริ่มก่อน cutoff
+		if startedAt, _ := data["startedAt"].(time.Time); startedAt.Before(cutoff) {
+			if err := utils.DeleteDocumentTree(ctx, doc.Ref, purgeBatchSize); err != nil {
+				return err
+			}
+			report.ConversationsDeleted++
+		}
+		return nil
+	}
+	_, err = doc.Ref.Update(ctx, []firestore.Update{{Path: "oldestMessageAt", Value: oldest}})
+	return err
+}
+
+// purgeConversation คืนจำนวนข้อความที่ลบ และเวลาของข้อความเก่าสุดที่เหลือ (zero = ไม่เหลือข้อความแล้ว)
+func (s *RetentionService) purgeConversation(ctx context.Context, ref *firestore.DocumentRef, cutoff time.Time) (int, time.Time, error) {
+	var oldest time.Time
+	partitions, err := utils.MessagePartitions(ctx, ref.ID)
+	if err != nil {
+		return 0, oldest, err
+	}
+	deleted := 0
+	for _, p := range partitions {
+		q := ref.Collection(p).Query
+		if end, ok := utils.PartitionEnd(p); !ok || end.After(cutoff) {
+			q = q.Where("timestamp", "<", cutoff)
+		}
+		n, err := utils.DeleteQuery(ctx, q, purgeBatchSize)
+		deleted += n
+		if err != nil {
+			return deleted, oldest, err
+		}
+		left, err := ref.Collection(p).OrderBy("timestamp", firestore.Asc).Limit(1).Documents(ctx).GetAll()
+		if err != nil {
+			return deleted, oldest, err
+		}
+		if len(left) == 0 {
+			continue
+		}
+		ts, _ := left[0].Data()["timestamp"].(time.Time)
+		if ts.IsZero() {
+			ts = cutoff // ข้อความไม่มี timestamp ไม่ถูกลบ แต่ conversation ยังไม่ว่าง
+		}
+		if oldest.IsZero() || ts.Before(oldest) {
+			oldest = ts
+		}
+	}
+	return deleted, oldest, nil
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLongerRetention(t *testing.T) {
+	assert.Equal(t, 30, longerRetention(-1, 30))
+	assert.Equal(t, 90, longerRetention(30, 90))
+	assert.Equal(t, 90, longerRetention(90, 30))
+	assert.Equal(t, 0, longerRetention(90, 0)) // 0 = เก็บตลอด
+}
+
+func TestShortestRetention(t *testing.T) {
+	assert.Equal(t, 1, shortestRetention(DefaultRetentionPolicies))
+	assert.Equal(t, 30, shortestRetention(map[string]RetentionPolicy{
+		"free":    {RetentionDays: 0},
+		"premium": {RetentionDays: 90},
+		"pkg_a":   {RetentionDays: 30},
+	}))
+	assert.Equal(t, 0, shortestRetention(map[string]RetentionPolicy{"free": {RetentionDays: 0}}))
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RelinkRichMenuJobName job ใน workpool ที่ผูก rich menu ใหม่ให้ผู้ใช้ (payload = userId) ตั้งไว้ตอน package หมดอายุ
+const RelinkRichMenuJobName = "relink_rich_menu"
+
+// ข้อจำกัดของ rich menu ใน LINE
+const (
+	richMenuMinWidth     = 800
+	richMenuMaxWidth     = 2500
+	richMenuMinHeight    = 250
+	richMenuMinAspect    = 1.45
+	richMenuMaxAreas     = 20
+	richMenuMaxChatBar   = 14
+	richMenuMaxName      = 300
+	richMenuMaxImageSize = 1 << 20
+)
+
+var (
+	ErrRichMenuNotFound = errors.New("rich menu not found")
+	ErrInvalidRichMenu  = errors.New("invalid rich menu")
+)
+
+// RichMenuBounds พื้นที่กดบนรูป (pixel ของรูปจริง)
+type RichMenuBounds struct {
+	X      int `firestore:"x" json:"x"`
+	Y      int `firestore:"y" json:"y"`
+	Width  int `firestore:"width" json:"width"`
+	Height int `firestore:"height" json:"height"`
+}
+
+// RichMenuArea ปุ่มหนึ่งปุ่มบน rich menu
+type RichMenuArea struct {
+	Bounds RichMenuBounds `firestore:"bounds" json:"bounds"`
+	Action LineAction     `firestore:"action" json:"action"`
+}
+
+// RichMenu นิยามเมนู (collection "rich_menus") Segment = "free", "premium" หรือว่าง (ใช้เป็น default อย่างเดียว)
+type RichMenu struct {
+	ID             string         `firestore:"-" json:"id"`
+	Name           string         `firestore:"name" json:"name"`
+	ChatBarText    string         `firestore:"chatBarText" json:"chatBarText"`
+	Width          int            `firestore:"width" json:"width"`
+	Height         int            `firestore:"height" json:"height"`
+	Selected       bool           `firestore:"selected" json:"selected"` // เปิดเมนูค้างไว้ตั้งแต่เข้าแชท
+	Areas          []RichMenuArea `firestore:"areas" json:"areas"`
+	Segment        string         `firestore:"segment" json:"segment,omitempty"`
+	LineRichMenuID string         `firestore:"lineRichMenuId" json:"lineRichMenuId"`
+	HasImage       bool           `firestore:"hasImage" json:"hasImage"`
+	IsDefault      bool           `firestore:"isDefault" json:"isDefault"`
+	CreatedAt      time.Time      `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time      `firestore:"updatedAt" json:"updatedAt"`
+}
+
+type RichMenuService struct {
+	client      *firestore.Client
+	col         *firestore.CollectionRef
+	lineUserCol *firestore.CollectionRef
+	userCol     *firestore.CollectionRef
+	line        *LineService
+	packages    *PackageService
+	workpool    *WorkpoolService
+}
+
+// NewRichMenuService ลงทะเบียน handler ของ RelinkRichMenuJobName กับ workpool ด้วย
+func NewRichMenuService(line *LineService, packages *PackageService, workpool *WorkpoolService) *RichMenuService {
+	s := &RichMenuService{
+		client:      utils.Client,
+		col:         utils.Client.Collection("rich_menus"),
+		lineUserCol: utils.Client.Collection("line_users"),
+		userCol:     utils.Client.Collection("users"),
+		line:        line,
+		packages:    packages,
+		workpool:    workpool,
+	}
+	RegisterJobHandler(RelinkRichMenuJobName, func(ctx context.Context, userID string) error {
+		return s.Relink(ctx, userID)
+	})
+	return s
+}
+
+// Create ตรวจนิยามแล้วสร้างเมนูที่ LINE ทันที (ต้องอัปโหลดรูปก่อนจึงจะใช้งานได้)
+func (s *RichMenuService) Create(ctx context.Context, m RichMenu) (*RichMenu, error) {
+	if err := validateRichMenu(m); err != nil {
+		return nil, err
+	}
+	lineID, err := s.line.CreateRichMenu(ctx, lineRichMenuPayload(m))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	m.LineRichMenuID = lineID
+	m.HasImage = false
+	m.IsDefault = false
+	m.CreatedAt = now
+	m.UpdatedAt = now
+	ref := s.col.NewDoc()
+	if _, err := ref.Set(ctx, m); err != nil {
+		return nil, err
+	}
+	m.ID = ref.ID
+	return &m, nil
+}
+
+func (s *RichMenuService) Get(ctx context.Context, id string) (*RichMenu, error) {
+	snap, err := s.col.Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrRichMenuNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m RichMenu
+	if err := snap.DataTo(&m); err != nil {
+		return nil, err
+	}
+	m.ID = snap.Ref.ID
+	return &m, nil
+}
+
+func (s *RichMenuService) List(ctx context.Context) ([]RichMenu, error) {
+	docs, err := s.col.OrderBy("createdAt", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	menus := make([]RichMenu, 0, len(docs))
+	for _, doc := range docs {
+		var m RichMenu
+		if err := doc.DataTo(&m); err != nil {
+			continue
+		}
+		m.ID = doc.Ref.ID
+		menus = append(menus, m)
+	}
+	return menus, nil
+}
+
+// UploadImage ตรวจชนิด/ขนาดรูปให้ตรงกับเมนูก่อนส่งให้ LINE
+func (s *RichMenuService) UploadImage(ctx context.Context, id string, data []byte) error {
+	m, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	contentType, err := validateRichMenuImage(m, data)
+	if err != nil {
+		return err
+	}
+	if err := s.line.UploadRichMenuImage(ctx, m.LineRichMenuID, contentType, data); err != nil {
+		return err
+	}
+	_, err = s.col.Doc(id).Update(ctx, []firestore.Update{
+		{Path: "hasImage", Value: true},
+		{Path: "updatedAt", Value: time.Now()},
+	})
+	return err
+}
+
+// SetDefault ตั้งเป็นเมนูของผู้ใช้ทุกคนที่ไม่ได้ผูกเมนูเฉพาะ (มี default ได้เมนูเดียว)
+func (s *RichMenuService) SetDefault(ctx context.Context, id string) error {
+	m, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !m.HasImage {
+		return fmt.Errorf("%w: upload an image first", ErrInvalidRichMenu)
+	}
+	if err := s.line.SetDefaultRichMenu(ctx, m.LineRichMenuID); err != nil {
+		return err
+	}
+	docs, err := s.col.Where("isDefault", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	batch := s.client.Batch()
+	for _, doc := range docs {
+		if doc.Ref.ID != id {
+			batch.Update(doc.Ref, []firestore.Update{{Path: "isDefault", Value: false}, {Path: "updatedAt", Value: now}})
+		}
+	}
+	batch.Update(s.col.Doc(id), []firestore.Update{{Path: "isDefault", Value: true}, {Path: "updatedAt", Value: now}})
+	_, err = batch.Commit(ctx)
+	return err
+}
+
+// Delete ลบที่ LINE และใน Firestore (ผู้ใช้ที่ผูกเมนูนี้ไว้จะกลับไปเห็น default)
+func (s *RichMenuService) Delete(ctx context.Context, id string) error {
+	m, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.line.DeleteRichMenu(ctx, m.LineRichMenuID); err != nil {
+		return err
+	}
+	_, err = s.col.Doc(id).Delete(ctx)
+	return err
+}
+
+// OnPackageChanged เรียกหลังซื้อ/ต่ออายุ package: ผูกเมนูตาม tier ใหม่ทันที และตั้ง job ให้ผูกใหม่ตอนหมดอายุ
+func (s *RichMenuService) OnPackageChanged(ctx context.Context, userID string) error {
+	if err := s.Relink(ctx, userID); err != nil {
+		return err
+	}
+	_, until, err := s.packages.UserTier(ctx, userID)
+	if err != nil || until.IsZero() {
+		return err
+	}
+	return s.workpool.ScheduleUniqueJob(ctx, "richmenu_"+userID, RelinkRichMenuJobName, userID, until.Add(time.Minute))
+}
+
+// Relink ผูก rich menu ของ segment ตาม tier ปัจจุบันของผู้ใช้ ถ้าไม่มีเมนูของ segment นั้นจะกลับไปใช้ default
+func (s *RichMenuService) Relink(ctx context.Context, userID string) error {
+	userSnap, err := s.userCol.Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lineUserID, _ := userSnap.Data()["lineUserId"].(string)
+	if lineUserID == "" {
+		return nil // ยังไม่ได้ผูกกับ LINE
+	}
+	tier, _, err := s.packages.UserTier(ctx, userID)
+	if err != nil {
+		return err
+	}
+	menu, err := s.menuForSegment(ctx, tier)
+	if err != nil {
+		return err
+	}
+
+	lineRef := s.lineUserCol.Doc(lineUserID)
+	current := ""
+	if snap, err := lineRef.Get(ctx); err == nil {
+		current, _ = snap.Data()["richMenuId"].(string)
+	}
+	target := ""
+	if menu != nil {
+		target = menu.LineRichMenuID
+	}
+	if target == current {
+		return nil
+	}
+	if target != "" {
+		err = s.line.LinkRichMenu(ctx, lineUserID, target)
+	} else {
+		err = s.line.UnlinkRichMenu(ctx, lineUserID)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Rich menu for user %s → tier %s (%s)", userID, tier, target)
+	_, err = lineRef.Set(ctx, map[string]interface{}{
+		"richMenuId": target,
+		"tier":       tier,
+		"richMenuAt": time.Now(),
+	}, firestore.MergeAll)
+	return err
+}
+
+// menuForSegment เมนูล่าสุดของ segment ที่อัปโหลดรูปแล้ว (nil = ไม่มี)
+func (s *RichMenuService) menuForSegment(ctx context.Context, segment string) (*RichMenu, error) {
+	docs, err := s.col.Where("segment", "==", segment).Where("hasImage", "==", true).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var best *RichMenu
+	for _, doc := range docs {
+		var m RichMenu
+		if err := doc.DataTo(&m); err != nil {
+			continue
+		}
+		m.ID = doc.Ref.ID
+		if best == nil || m.UpdatedAt.After(best.UpdatedAt) {
+			best = &m
+		}
+	}
+	return best, nil
+}
+
+// validateRichMenu ตรวจนิยามตามข้อกำหนดของ LINE ก่อนส่ง
+func validateRichMenu(m RichMenu) error {
+	if m.Name == "" || utf8.RuneCountInString(m.Name) > richMenuMaxName {
+		return fmt.Errorf("%w: name must be 1-%d chars", ErrInvalidRichMenu, richMenuMaxName)
+	}
+	if m.ChatBarText == "" || utf8.RuneCountInString(m.ChatBarText) > richMenuMaxChatBar {
+		return fmt.Errorf("%w: chatBarText must be 1-%d chars", ErrInvalidRichMenu, richMenuMaxChatBar)
+	}
+	if m.Width < richMenuMinWidth || m.Width > richMenuMaxWidth || m.Height < richMenuMinHeight {
+		return fmt.Errorf("%w: size must be %d-%d wide and at least %d high", ErrInvalidRichMenu, richMenuMinWidth, richMenuMaxWidth, richMenuMinHeight)
+	}
+	if float64(m.Width)/float64(m.Height) < richMenuMinAspect {
+		return fmt.Errorf("%w: width/height must be at least %.2f", ErrInvalidRichMenu, richMenuMinAspect)
+	}
+	switch m.Segment {
+	case "", TierFree, TierPremium:
+	default:
+		return fmt.Errorf("%w: unknown segment %q", ErrInvalidRichMenu, m.Segment)
+	}
+	if len(m.Areas) == 0 || len(m.Areas) > richMenuMaxAreas {
+		return fmt.Errorf("%w: must have 1-%d areas", ErrInvalidRichMenu, richMenuMaxAreas)
+	}
+	for i, a := range m.Areas {
+		b := a.Bounds
+		if b.X < 0 || b.Y < 0 || b.Width <= 0 || b.Height <= 0 || b.X+b.Width > m.Width || b.Y+b.Height > m.Height {
+			return fmt.Errorf("%w: area %d is outside the menu", ErrInvalidRichMenu, i)
+		}
+		if err := validateLineAction(a.Action); err != nil {
+			return fmt.Errorf("area %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateRichMenuImage คืน content type ของรูปถ้าถูกต้อง
+func validateRichMenuImage(m *RichMenu, data []byte) (string, error) {
+	if len(data) == 0 || len(data) > richMenuMaxImageSize {
+		return "", fmt.Errorf("%w: image must be 1B-1MB", ErrInvalidRichMenu)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: image must be PNG or JPEG", ErrInvalidRichMenu)
+	}
+	if cfg.Width != m.Width || cfg.Height != m.Height {
+		return "", fmt.Errorf("%w: image is %dx%d, menu is %dx%d", ErrInvalidRichMenu, cfg.Width, cfg.Height, m.Width, m.Height)
+	}
+	return "image/" + format, nil
+}
+
+func lineRichMenuPayload(m RichMenu) map[string]interface{} {
+	areas := make([]map[string]interface{}, 0, len(m.Areas))
+	for _, a := range m.Areas {
+		areas = append(areas, map[string]interface{}{
+			"bounds": a.Bounds,
+			"action": a.Action,
+		})
+	}
+	return map[string]interface{}{
+		"size":        map[string]int{"width": m.Width, "height": m.Height},
+		"selected":    m.Selected,
+		"name":        m.Name,
+		"chatBarText": m.ChatBarText,
+		"areas":       areas,
+	}
+}
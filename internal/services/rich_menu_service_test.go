@@ -0,0 +1,56 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRichMenu(t *testing.T) {
+	menu := RichMenu{
+		Name:        "premium",
+		ChatBarText: "เมนู",
+		Width:       2500,
+		Height:      843,
+		Segment:     TierPremium,
+		Areas: []RichMenuArea{
+			{Bounds: RichMenuBounds{X: 0, Y: 0, Width: 1250, Height: 843}, Action: PostbackAction("แพ็กเกจ", "action=packages", "")},
+			{Bounds: RichMenuBounds{X: 1250, Y: 0, Width: 1250, Height: 843}, Action: MessageAction("ดูดวง", "ดูดวง")},
+		},
+	}
+	assert.NoError(t, validateRichMenu(menu))
+
+	outside := menu
+	outside.Areas = []RichMenuArea{{Bounds: RichMenuBounds{X: 2000, Y: 0, Width: 600, Height: 843}, Action: MessageAction("a", "a")}}
+	assert.ErrorIs(t, validateRichMenu(outside), ErrInvalidRichMenu)
+
+	tall := menu
+	tall.Height = 2000
+	assert.ErrorIs(t, validateRichMenu(tall), ErrInvalidRichMenu)
+
+	segment := menu
+	segment.Segment = "vip"
+	assert.ErrorIs(t, validateRichMenu(segment), ErrInvalidRichMenu)
+
+	noData := menu
+	noData.Areas = []RichMenuArea{{Bounds: menu.Areas[0].Bounds, Action: PostbackAction("a", "", "")}}
+	assert.ErrorIs(t, validateRichMenu(noData), ErrInvalidLineMessage)
+}
+
+func TestValidateRichMenuImage(t *testing.T) {
+	menu := &RichMenu{Width: 800, Height: 540}
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 800, 540))))
+
+	contentType, err := validateRichMenuImage(menu, buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+
+	_, err = validateRichMenuImage(&RichMenu{Width: 2500, Height: 843}, buf.Bytes())
+	assert.ErrorIs(t, err, ErrInvalidRichMenu)
+	_, err = validateRichMenuImage(menu, []byte("not an image"))
+	assert.ErrorIs(t, err, ErrInvalidRichMenu)
+}
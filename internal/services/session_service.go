@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChatTurn ข้อความหนึ่งรอบในประวัติที่ส่งให้ AI
+type ChatTurn struct {
+	Role    string `json:"role"` // "user" หรือ "assistant"
+	Content string `json:"content"`
+}
+
+// ActiveSession ตัวชี้ไปยัง conversation ที่ผู้ใช้กำลังคุยอยู่ (document id = userId)
+type ActiveSession struct {
+	ConversationID string    `firestore:"conversationId"`
+	LastActiveAt   time.Time `firestore:"lastActiveAt"`
+}
+
+type SessionService struct {
+	activeCol   *firestore.CollectionRef
+	convCol     *firestore.CollectionRef
+	timeout     time.Duration
+	maxTurns    int
+	tokenBudget int
+}
+
+// NewSessionService timeout = เวลาไม่เคลื่อนไหวก่อนเริ่ม conversation ใหม่,
+// maxTurns = จำนวนข้อความย้อนหลังสูงสุด, tokenBudget = งบ token ของประวัติ
+func NewSessionService(timeout time.Duration, maxTurns, tokenBudget int) *SessionService {
+	return &SessionService{
+		activeCol:   utils.Client.Collection("active_sessions"),
+		convCol:     utils.Client.Collection("conversations"),
+		timeout:     timeout,
+		maxTurns:    maxTurns,
+		tokenBudget: tokenBudget,
+	}
+}
+
+// ResolveSession คืน conversation ที่ยัง active ของผู้ใช้ หรือสร้างใหม่ถ้าเกิน timeout
+func (s *SessionService) ResolveSession(ctx context.Context, userID string) (string, error) {
+	ref := s.activeCol.Doc(userID)
+	var convID string
+	err := utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+		convID = ""
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var active ActiveSession
+			if err := snap.DataTo(&active); err != nil {
+				return err
+			}
+			if active.ConversationID != "" && now.Sub(active.LastActiveAt) < s.timeout {
+				convID = active.ConversationID
+			}
+		}
+		if convID == "" {
+			convID = uuid.New().String()
+			if err := tx.Set(s.convCol.Doc(convID), map[string]interface{}{
+				"userId":          userID,
+				"startedAt":       now,
+				"oldestMessageAt": now,
+			}, firestore.MergeAll); err != nil {
+				return err
+			}
+		}
+		return tx.Set(ref, ActiveSession{ConversationID: convID, LastActiveAt: now})
+	})
+	if err != nil {
+		return "", err
+	}
+	return convID, nil
+}
+
+// LoadHistory ดึงข้อความล่าสุดของ conversation แล้วตัดให้อยู่ในงบ token
+// ถ้ามีข้อความเก่าที่ถูกตัดออก จะคืน summary ที่เก็บไว้แทน
+func (s *SessionService) LoadHistory(ctx context.Context, convID string) ([]ChatTurn, string, error) {
+	messages, err := utils.GetRecentMessages(ctx, convID, s.maxTurns)
+	if err != nil {
+		return nil, "", err
+	}
+	turns := make([]ChatTurn, 0, len(messages))
+	for _, m := range messages {
+		role := "user"
+		if m.Sender == "bot" {
+			role = "assistant"
+		}
+		turns = append(turns, ChatTurn{Role: role, Content: m.Text})
+	}
+
+	history, trimmed := trimHistory(turns, s.tokenBudget)
+	if !trimmed && len(messages) < s.maxTurns {
+		return history, "", nil
+	}
+
+	// มีข้อความเก่ากว่าที่ไม่ได้ส่งไป → แนบ summary ของ conversation
+	snap, err := s.convCol.Doc(convID).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return history, "", nil
+		}
+		return nil, "", err
+	}
+	summary, _ := snap.Data()["summary"].(string)
+	return history, summary, nil
+}
+
+// trimHistory ตัดข้อความเก่าสุดทิ้งจนกว่าจำนวน token โดยประมาณจะไม่เกิน budget
+func trimHistory(turns []ChatTurn, budget int) ([]ChatTurn, bool) {
+	total := 0
+	start := len(turns)
+	for start > 0 {
+		cost := estimateTokens(turns[start-1].Content)
+		if total+cost > budget {
+			break
+		}
+		total += cost
+		start--
+	}
+	return turns[start:], start > 0
+}
+
+// estimateTokens ประมาณจำนวน token แบบหยาบ (ภาษาไทยใช้ token มากกว่าอังกฤษ จึงคิด 2 ตัวอักษรต่อ token)
+func estimateTokens(text string) int {
+	return utf8.RuneCountInString(text)/2 + 1
+}
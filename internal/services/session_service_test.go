@@ -0,0 +1,31 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimHistoryKeepsNewestWithinBudget(t *testing.T) {
+	turns := []ChatTurn{
+		{Role: "user", Content: "ไพ่ใบแรกหมายถึงอะไร"},
+		{Role: "assistant", Content: "ไพ่ The Fool หมายถึงการเริ่มต้นใหม่"},
+		{Role: "user", Content: "แล้วเรื่องงานล่ะ"},
+	}
+
+	// งบพอสำหรับข้อความสุดท้ายเท่านั้น
+	budget := estimateTokens(turns[2].Content)
+	history, trimmed := trimHistory(turns, budget)
+	assert.True(t, trimmed)
+	assert.Equal(t, turns[2:], history)
+
+	// งบพอทั้งหมด
+	history, trimmed = trimHistory(turns, 1000)
+	assert.False(t, trimmed)
+	assert.Equal(t, turns, history)
+
+	// งบไม่พอแม้แต่ข้อความเดียว
+	history, trimmed = trimHistory(turns, 0)
+	assert.True(t, trimmed)
+	assert.Empty(t, history)
+}
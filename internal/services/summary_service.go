@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrNothingToSummarize = errors.New("conversation has no messages")
+
+// SummarizeJobName ชื่อ job ใน workpool ที่สรุป conversation (payload = conversationId)
+const SummarizeJobName = "summarize_conversation"
+
+// ConversationSummary ผลสรุปและวิเคราะห์ของ conversation ที่บันทึกลง document
+type ConversationSummary struct {
+	Summary      string    `json:"summary"`
+	Intent       string    `json:"intent"`
+	Confidence   float64   `json:"confidence"`
+	Emotion      string    `json:"emotion"`
+	Sentiment    float64   `json:"sentiment"`
+	Topics       []string  `json:"topics"`
+	MessageCount int       `json:"messageCount"`
+	SummaryAt    time.Time `json:"summaryAt"`
+}
+
+type SummaryService struct {
+	aiClient *AIServiceClient
+	convCol  *firestore.CollectionRef
+	workpool *WorkpoolService
+	debounce time.Duration
+}
+
+// NewSummaryService ลงทะเบียน handler ของ SummarizeJobName กับ workpool ด้วย
+// debounce = เวลาที่รอหลังข้อความล่าสุดก่อนสรุป (ข้อความที่เข้ามาระหว่างนั้นจะเลื่อนเวลาออกไป)
+func NewSummaryService(aiClient *AIServiceClient, workpool *WorkpoolService, debounce time.Duration) *SummaryService {
+	s := &SummaryService{
+		aiClient: aiClient,
+		convCol:  utils.Client.Collection("conversations"),
+		workpool: workpool,
+		debounce: debounce,
+	}
+	RegisterJobHandler(SummarizeJobName, func(ctx context.Context, convID string) error {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		_, err := s.Summarize(ctx, convID)
+		if errors.Is(err, ErrNothingToSummarize) {
+			return nil
+		}
+		return err
+	})
+	return s
+}
+
+// Schedule ขอให้สรุป conversation หลังผ่านไป debounce (เรียกซ้ำได้ทุกข้อความ จะได้ job เดียว)
+func (s *SummaryService) Schedule(ctx context.Context, convID string) error {
+	return s.workpool.ScheduleUniqueJob(ctx, summaryJobID(convID), SummarizeJobName, convID, time.Now().Add(s.debounce))
+}
+
+// summaryJobID id ของ job สรุปต่อ conversation ข้อความใหม่จึงเลื่อนเวลาของ job เดิมแทนการเพิ่ม job
+func summaryJobID(convID string) string {
+	return "summarize_" + convID
+}
+
+// Summarize สรุปและวิเคราะห์ทุกข้อความของ conversation ผ่าน ai-service แล้วอัปเดต document
+func (s *SummaryService) Summarize(ctx context.Context, convID string) (*ConversationSummary, error) {
+	snap, err := s.convCol.Doc(convID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := snap.Data()["userId"].(string)
+
+	messages, err := utils.GetAllMessages(ctx, convID)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrNothingToSummarize
+	}
+	lines, userText := summaryTranscript(messages)
+
+	sum, err := s.aiClient.Summarize(ctx, AISummarizeRequest{ConversationID: convID, Messages: lines})
+	if err != nil {
+		return nil, err
+	}
+	interp, err := s.aiClient.Interpret(ctx, AIInterpretRequest{
+		UserID:         userID,
+		ConversationID: convID,
+		Message:        userText,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := buildSummary(sum, interp, len(messages), time.Now())
+	fields := map[string]interface{}{
+		"summary":          result.Summary,
+		"intent":           result.Intent,
+		"intentConfidence": result.Confidence,
+		"emotion":          result.Emotion,
+		"sentiment":        result.Sentiment,
+		"topics":           result.Topics,
+		"messageCount":     result.MessageCount,
+		"summaryAt":        result.SummaryAt,
+	}
+	if _, err := s.convCol.Doc(convID).Set(ctx, fields, firestore.MergeAll); err != nil {
+		return nil, err
+	}
+
+	// เก็บประวัติผลวิเคราะห์แต่ละครั้งไว้ที่ interpretations
+	delete(fields, "summary")
+	fields["userId"] = userID
+	fields["timestamp"] = result.SummaryAt
+	if _, _, err := s.convCol.Doc(convID).Collection("interpretations").Add(ctx, fields); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// summaryTranscript แปลงข้อความเป็นบรรทัด "sender: text" สำหรับสรุป และรวมเฉพาะข้อความผู้ใช้สำหรับวิเคราะห์ intent/emotion
+func summaryTranscript(messages []utils.StoredMessage) ([]string, string) {
+	lines := make([]string, 0, len(messages))
+	var userLines []string
+	for _, m := range messages {
+		lines = append(lines, m.Sender+": "+m.Text)
+		if m.Sender == "user" {
+			userLines = append(userLines, m.Text)
+		}
+	}
+	return lines, strings.Join(userLines, "\n")
+}
+
+// buildSummary รวมผลจาก ai-service เป็น ConversationSummary (sentiment ถูกจำกัดที่ -1..1, topics ไม่เป็น nil)
+func buildSummary(sum *AISummarizeResponse, interp *AIInterpretResponse, messageCount int, at time.Time) *ConversationSummary {
+	result := &ConversationSummary{
+		Summary:      strings.TrimSpace(sum.Summary),
+		Intent:       interp.Intent,
+		Confidence:   interp.Confidence,
+		Emotion:      interp.Emotion,
+		Sentiment:    clampSentiment(interp.Sentiment),
+		Topics:       interp.Topics,
+		MessageCount: messageCount,
+		SummaryAt:    at,
+	}
+	if result.Topics == nil {
+		result.Topics = []string{}
+	}
+	return result
+}
+
+func clampSentiment(v float64) float64 {
+	if v < -1 {
+		return -1
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
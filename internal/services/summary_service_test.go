@@ -0,0 +1,62 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/poomiiz/go-backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryJobID(t *testing.T) {
+	// ข้อความใหม่ของ conversation เดิมต้องได้ job id เดิมเพื่อเลื่อนเวลาแทนการเพิ่ม job
+	assert.Equal(t, summaryJobID("conv1"), summaryJobID("conv1"))
+	assert.NotEqual(t, summaryJobID("conv1"), summaryJobID("conv2"))
+}
+
+func TestSummaryTranscript(t *testing.T) {
+	lines, userText := summaryTranscript([]utils.StoredMessage{
+		{Sender: "user", Text: "งานจะเป็นยังไง"},
+		{Sender: "bot", Text: "ไพ่ The Sun บอกว่าดีขึ้น"},
+		{Sender: "user", Text: "แล้วความรักล่ะ"},
+	})
+	assert.Equal(t, []string{"user: งานจะเป็นยังไง", "bot: ไพ่ The Sun บอกว่าดีขึ้น", "user: แล้วความรักล่ะ"}, lines)
+	assert.Equal(t, "งานจะเป็นยังไง\nแล้วความรักล่ะ", userText)
+
+	lines, userText = summaryTranscript(nil)
+	assert.Empty(t, lines)
+	assert.Empty(t, userText)
+}
+
+func TestBuildSummaryFromAIOutput(t *testing.T) {
+	var sum AISummarizeResponse
+	assert.NoError(t, json.Unmarshal([]byte(`{"summary":"  ผู้ใช้ถามเรื่องงาน  "}`), &sum))
+	var interp AIInterpretResponse
+	assert.NoError(t, json.Unmarshal([]byte(`{"intent":"career","confidence":0.82,"emotion":"anxious","sentiment":-1.7,"topics":["career","money"]}`), &interp))
+
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	got := buildSummary(&sum, &interp, 3, at)
+	assert.Equal(t, &ConversationSummary{
+		Summary:      "ผู้ใช้ถามเรื่องงาน",
+		Intent:       "career",
+		Confidence:   0.82,
+		Emotion:      "anxious",
+		Sentiment:    -1,
+		Topics:       []string{"career", "money"},
+		MessageCount: 3,
+		SummaryAt:    at,
+	}, got)
+
+	// field ที่ ai-service ไม่ส่งมาได้ค่าว่าง และ topics เป็น [] ไม่ใช่ null
+	got = buildSummary(&AISummarizeResponse{}, &AIInterpretResponse{Sentiment: 0.4}, 1, at)
+	assert.Equal(t, []string{}, got.Topics)
+	assert.Equal(t, 0.4, got.Sentiment)
+	assert.Empty(t, got.Intent)
+}
+
+func TestClampSentiment(t *testing.T) {
+	assert.Equal(t, -1.0, clampSentiment(-3))
+	assert.Equal(t, 1.0, clampSentiment(1.2))
+	assert.Equal(t, 0.25, clampSentiment(0.25))
+}
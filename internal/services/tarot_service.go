@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SpreadPosition ตำแหน่งไพ่หนึ่งตำแหน่งใน spread
+type SpreadPosition struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// Spread รูปแบบการวางไพ่
+type Spread struct {
+	Key       string           `json:"key"`
+	Name      string           `json:"name"`
+	Positions []SpreadPosition `json:"positions"`
+}
+
+// Spreads รูปแบบการวางไพ่ที่รองรับ
+var Spreads = map[string]Spread{
+	"single": {
+		Key:  "single",
+		Name: "ไพ่ใบเดียว",
+		Positions: []SpreadPosition{
+			{Key: "answer", Name: "คำตอบ"},
+		},
+	},
+	"three_card": {
+		Key:  "three_card",
+		Name: "ไพ่สามใบ",
+		Positions: []SpreadPosition{
+			{Key: "past", Name: "อดีต"},
+			{Key: "present", Name: "ปัจจุบัน"},
+			{Key: "future", Name: "อนาคต"},
+		},
+	},
+	"celtic_cross": {
+		Key:  "celtic_cross",
+		Name: "Celtic Cross",
+		Positions: []SpreadPosition{
+			{Key: "present", Name: "สถานการณ์ปัจจุบัน"},
+			{Key: "challenge", Name: "อุปสรรค"},
+			{Key: "foundation", Name: "รากฐาน"},
+			{Key: "past", Name: "อดีตที่ผ่านมา"},
+			{Key: "crown", Name: "เป้าหมาย"},
+			{Key: "near_future", Name: "อนาคตอันใกล้"},
+			{Key: "self", Name: "ตัวคุณ"},
+			{Key: "environment", Name: "สิ่งแวดล้อม"},
+			{Key: "hopes_fears", Name: "ความหวังและความกลัว"},
+			{Key: "outcome", Name: "ผลลัพธ์"},
+		},
+	},
+}
+
+var (
+	ErrUnknownSpread  = errors.New("unknown spread")
+	ErrNotEnoughCards = errors.New("deck has not enough cards for spread")
+	ErrDrawNotFound   = errors.New("draw not found")
+)
+
+// TarotCard ไพ่หนึ่งใบจาก decks/{deckId}/cards
+type TarotCard struct {
+	ID       string
+	Name     string
+	ImageURL string
+}
+
+// DrawnCard ไพ่ที่ถูกเปิดในตำแหน่งหนึ่ง
+type DrawnCard struct {
+	Position     string `firestore:"position" json:"position"`
+	PositionName string `firestore:"positionName" json:"positionName"`
+	CardID       string `firestore:"cardId" json:"cardId"`
+	CardName     string `firestore:"cardName" json:"cardName"`
+	ImageURL     string `firestore:"imageUrl" json:"imageUrl,omitempty"`
+	Reversed     bool   `firestore:"reversed" json:"reversed"`
+}
+
+// TarotDraw ผลการเปิดไพ่ บันทึกที่ conversations/{conversationId}/draws/{drawId}
+type TarotDraw struct {
+	ID             string      `firestore:"-" json:"id"`
+	UserID         string      `firestore:"userId" json:"userId"`
+	ConversationID string      `firestore:"conversationId" json:"conversationId"`
+	DeckID         string      `firestore:"deckId" json:"deckId"`
+	Spread         string      `firestore:"spread" json:"spread"`
+	Question       string      `firestore:"question" json:"question,omitempty"`
+	Cards          []DrawnCard `firestore:"cards" json:"cards"`
+	CreatedAt      time.Time   `firestore:"createdAt" json:"createdAt"`
+}
+
+type TarotService struct {
+	deckCol *firestore.CollectionRef
+	convCol *firestore.CollectionRef
+}
+
+func NewTarotService() *TarotService {
+	return &TarotService{
+		deckCol: utils.Client.Collection("decks"),
+		convCol: utils.Client.Collection("conversations"),
+	}
+}
+
+// Draw สุ่มไพ่ตาม spread (ไม่ซ้ำใบ) แล้วบันทึกไว้ใต้ conversation
+func (s *TarotService) Draw(ctx context.Context, userID, conversationID, deckID, spreadKey, question string, allowReversed bool) (*TarotDraw, error) {
+	spread, ok := Spreads[spreadKey]
+	if !ok {
+		return nil, ErrUnknownSpread
+	}
+	cards, err := s.loadDeck(ctx, deckID)
+	if err != nil {
+		return nil, err
+	}
+	drawn, err := drawCards(cards, spread, allowReversed, cryptoIntn)
+	if err != nil {
+		return nil, err
+	}
+
+	draw := TarotDraw{
+		UserID:         userID,
+		ConversationID: conversationID,
+		DeckID:         deckID,
+		Spread:         spreadKey,
+		Question:       question,
+		Cards:          drawn,
+		CreatedAt:      time.Now(),
+	}
+	docRef := s.convCol.Doc(conversationID).Collection("draws").NewDoc()
+	if _, err := docRef.Set(ctx, draw); err != nil {
+		return nil, err
+	}
+	draw.ID = docRef.ID
+	return &draw, nil
+}
+
+// GetDraw ดึงผลการเปิดไพ่ที่บันทึกไว้
+func (s *TarotService) GetDraw(ctx context.Context, conversationID, drawID string) (*TarotDraw, error) {
+	snap, err := s.convCol.Doc(conversationID).Collection("draws").Doc(drawID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrDrawNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var draw TarotDraw
+	if err := snap.DataTo(&draw); err != nil {
+		return nil, err
+	}
+	draw.ID = snap.Ref.ID
+	return &draw, nil
+}
+
+func (s *TarotService) loadDeck(ctx context.Context, deckID string) ([]TarotCard, error) {
+	docs, err := s.deckCol.Doc(deckID).Collection("cards").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	cards := make([]TarotCard, 0, len(docs))
+	for _, doc := range docs {
+		data := doc.Data()
+		cards = append(cards, TarotCard{
+			ID:       doc.Ref.ID,
+			Name:     stringField(data, "name", doc.Ref.ID),
+			ImageURL: stringField(data, "imageUrl", ""),
+		})
+	}
+	return cards, nil
+}
+
+// drawCards สุ่มไพ่แบบไม่คืนใบ (partial Fisher–Yates) ตามจำนวนตำแหน่งของ spread
+func drawCards(cards []TarotCard, spread Spread, allowReversed bool, intn func(int) (int, error)) ([]DrawnCard, error) {
+	if len(cards) < len(spread.Positions) {
+		return nil, ErrNotEnoughCards
+	}
+	pool := make([]TarotCard, len(cards))
+	copy(pool, cards)
+
+	drawn := make([]DrawnCard, 0, len(spread.Positions))
+	for i, pos := range spread.Positions {
+		j, err := intn(len(pool) - i)
+		if err != nil {
+			return nil, err
+		}
+		pool[i], pool[i+j] = pool[i+j], pool[i]
+
+		reversed := false
+		if allowReversed {
+			flip, err := intn(2)
+			if err != nil {
+				return nil, err
+			}
+			reversed = flip == 1
+		}
+		drawn = append(drawn, DrawnCard{
+			Position:     pos.Key,
+			PositionName: pos.Name,
+			CardID:       pool[i].ID,
+			CardName:     pool[i].Name,
+			ImageURL:     pool[i].ImageURL,
+			Reversed:     reversed,
+		})
+	}
+	return drawn, nil
+}
+
+// cryptoIntn สุ่มเลข [0, n) ด้วย crypto/rand
+func cryptoIntn(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("crypto rand: %w", err)
+	}
+	return int(v.Int64()), nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDeck(n int) []TarotCard {
+	cards := make([]TarotCard, n)
+	for i := range cards {
+		cards[i] = TarotCard{ID: fmt.Sprintf("card%02d", i), Name: fmt.Sprintf("Card %d", i)}
+	}
+	return cards
+}
+
+func TestDrawCardsWithoutReplacement(t *testing.T) {
+	spread := Spreads["celtic_cross"]
+	drawn, err := drawCards(testDeck(78), spread, true, cryptoIntn)
+	assert.NoError(t, err)
+	assert.Len(t, drawn, len(spread.Positions))
+
+	seen := map[string]bool{}
+	for i, card := range drawn {
+		assert.Equal(t, spread.Positions[i].Key, card.Position)
+		assert.False(t, seen[card.CardID], "card %s drawn twice", card.CardID)
+		seen[card.CardID] = true
+	}
+}
+
+func TestDrawCardsIsReproducibleWithFixedSource(t *testing.T) {
+	// แหล่งสุ่มคงที่: เลือก index สุดท้ายเสมอ และกลับหัวทุกใบ
+	last := func(n int) (int, error) { return n - 1, nil }
+	drawn, err := drawCards(testDeck(5), Spreads["three_card"], true, last)
+	assert.NoError(t, err)
+	assert.Equal(t, "card04", drawn[0].CardID)
+	assert.Equal(t, "card00", drawn[1].CardID)
+	assert.Equal(t, "card01", drawn[2].CardID)
+	for _, card := range drawn {
+		assert.True(t, card.Reversed)
+	}
+
+	drawn, _ = drawCards(testDeck(5), Spreads["three_card"], false, last)
+	for _, card := range drawn {
+		assert.False(t, card.Reversed)
+	}
+}
+
+func TestDrawCardsNotEnoughCards(t *testing.T) {
+	_, err := drawCards(testDeck(3), Spreads["celtic_cross"], false, cryptoIntn)
+	assert.ErrorIs(t, err, ErrNotEnoughCards)
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// parse_mode ที่ Telegram sendMessage รองรับ ("" = ข้อความธรรมดา)
+const (
+	TelegramHTML       = "HTML"
+	TelegramMarkdownV2 = "MarkdownV2"
+)
+
+const (
+	telegramAPIBaseURL = "https://api.telegram.org"
+	telegramMaxText    = 4096
+)
+
+var ErrTelegramNoRoute = errors.New("no telegram chat configured for alert type")
+
+// TelegramRoute ปลายทางของ alert หนึ่งประเภท (ThreadID = topic ในกลุ่มแบบ forum, 0 = ไม่ระบุ)
+type TelegramRoute struct {
+	ChatID    string `json:"chatId"`
+	ThreadID  int64  `json:"threadId,omitempty"`
+	ParseMode string `json:"parseMode,omitempty"` // ว่าง = ใช้ค่าของ bot
+}
+
+// TelegramBot ส่ง alert ของทีมงานผ่าน Telegram Bot API โดยเลือกห้องตามประเภท alert
+type TelegramBot struct {
+	token        string
+	apiBase      string
+	parseMode    string
+	defaultRoute TelegramRoute
+	routes       map[string]TelegramRoute
+	httpClient   *http.Client
+}
+
+// NewTelegramBot routes = alert type → ห้อง ประเภทที่ไม่มีใน routes ไปที่ defaultRoute
+func NewTelegramBot(token, parseMode string, defaultRoute TelegramRoute, routes map[string]TelegramRoute) *TelegramBot {
+	if parseMode == "" {
+		parseMode = TelegramHTML
+	}
+	return &TelegramBot{
+		token:        token,
+		apiBase:      telegramAPIBaseURL,
+		parseMode:    parseMode,
+		defaultRoute: defaultRoute,
+		routes:       routes,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Route ห้องปลายทางของ alert type (parse mode ว่างจะเติมจากค่าของ bot)
+func (b *TelegramBot) Route(alertType string) (TelegramRoute, error) {
+	route, ok := b.routes[alertType]
+	if !ok || route.ChatID == "" {
+		route = b.defaultRoute
+	}
+	if route.ChatID == "" {
+		return TelegramRoute{}, fmt.Errorf("%w: %s", ErrTelegramNoRoute, alertType)
+	}
+	if route.ParseMode == "" {
+		route.ParseMode = b.parseMode
+	}
+	return route, nil
+}
+
+// SendAlert render template ของ alert type แล้วส่งไปห้องที่กำหนดไว้
+func (b *TelegramBot) SendAlert(ctx context.Context, alertType string, data map[string]interface{}) error {
+	route, err := b.Route(alertType)
+	if err != nil {
+		return &DeliveryError{StatusCode: http.StatusNotFound, Body: err.Error()}
+	}
+	text, err := RenderTelegramAlert(alertType, route.ParseMode, data)
+	if err != nil {
+		return &DeliveryError{StatusCode: http.StatusBadRequest, Body: err.Error()}
+	}
+	return b.SendMessage(ctx, route, text)
+}
+
+// SendMessage เรียก sendMessage (ข้อความต้องจัดรูปแบบตาม route.ParseMode มาแล้ว)
+func (b *TelegramBot) SendMessage(ctx context.Context, route TelegramRoute, text string) error {
+	body := map[string]interface{}{
+		"chat_id":                  route.ChatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}
+	if route.ThreadID != 0 {
+		body["message_thread_id"] = route.ThreadID
+	}
+	if route.ParseMode != "" {
+		body["parse_mode"] = route.ParseMode
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.apiBase+"/bot"+b.token+"/sendMessage", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		// *url.Error มี URL ที่มี token ของ bot อยู่ ห้ามหลุดไปใน log หรือ outbox.lastError
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return fmt.Errorf("telegram sendMessage: %w", uerr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return newTelegramDeliveryError(resp, respBody)
+}
+
+// newTelegramDeliveryError Telegram บอกเวลารอของ 429 ใน parameters.retry_after แทน header Retry-After
+func newTelegramDeliveryError(resp *http.Response, body []byte) *DeliveryError {
+	derr := newDeliveryError(resp, body)
+	var parsed struct {
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		if parsed.Description != "" {
+			derr.Body = parsed.Description
+		}
+		if parsed.Parameters.RetryAfter > 0 {
+			derr.RetryAfter = time.Duration(parsed.Parameters.RetryAfter) * time.Second
+		}
+	}
+	return derr
+}
+
+//go:embed telegram_templates/*.tmpl
+var telegramTemplateFS embed.FS
+
+// telegramTemplates template ของ alert แต่ละประเภท (ชื่อไฟล์ = alert type) ประเภทที่ไม่มีไฟล์ใช้ "default"
+var telegramTemplates = mustParseTelegramTemplates()
+
+func mustParseTelegramTemplates() *template.Template {
+	root := template.New("").Funcs(telegramFuncs(""))
+	files, err := fs.Glob(telegramTemplateFS, "telegram_templates/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		src, err := telegramTemplateFS.ReadFile(f)
+		if err != nil {
+			panic(err)
+		}
+		name := strings.TrimSuffix(path.Base(f), ".tmpl")
+		template.Must(root.New(name).Parse(strings.TrimSpace(string(src))))
+	}
+	return root
+}
+
+// RenderTelegramAlert สร้างข้อความของ alert ตาม parse mode (escape ค่าจาก data ให้แล้ว)
+func RenderTelegramAlert(alertType, parseMode string, data map[string]interface{}) (string, error) {
+	tpl, err := telegramTemplates.Clone()
+	if err != nil {
+		return "", err
+	}
+	tpl.Funcs(telegramFuncs(parseMode))
+	name := alertType
+	if tpl.Lookup(name) == nil {
+		name = "default"
+	}
+	var buf bytes.Buffer
+	err = tpl.ExecuteTemplate(&buf, name, telegramAlertData{Type: alertType, Data: data})
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(buf.String())
+	if r := []rune(text); len(r) > telegramMaxText {
+		text = string(r[:telegramMaxText])
+	}
+	return text, nil
+}
+
+// telegramAlertData template เข้าถึงค่าด้วย {{.Data.userId}} และ {{.Type}}
+type telegramAlertData struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// Fields คู่ key/value ของ Data เรียงตาม key (ใช้ใน template default)
+func (d telegramAlertData) Fields() [][2]interface{} {
+	keys := make([]string, 0, len(d.Data))
+	for k := range d.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([][2]interface{}, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, [2]interface{}{k, d.Data[k]})
+	}
+	return out
+}
+
+func telegramFuncs(parseMode string) template.FuncMap {
+	esc := func(v interface{}) string { return escapeTelegram(parseMode, telegramString(v)) }
+	return template.FuncMap{
+		"esc": esc,
+		"bold": func(v interface{}) string {
+			switch parseMode {
+			case TelegramHTML:
+				return "<b>" + esc(v) + "</b>"
+			case TelegramMarkdownV2:
+				return "*" + esc(v) + "*"
+			}
+			return telegramString(v)
+		},
+		"code": func(v interface{}) string {
+			s := telegramString(v)
+			switch parseMode {
+			case TelegramHTML:
+				return "<code>" + html.EscapeString(s) + "</code>"
+			case TelegramMarkdownV2:
+				return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(s) + "`"
+			}
+			return s
+		},
+		// paren ค่าในวงเล็บ ข้อความคงที่ใน template ต้อง escape ตาม parse mode เหมือนค่าจาก data
+		"paren": func(v interface{}) string {
+			return escapeTelegram(parseMode, "(") + esc(v) + escapeTelegram(parseMode, ")")
+		},
+		"number": telegramNumber,
+		"date":   telegramDate,
+		"trunc": func(n int, v interface{}) string {
+			s := telegramString(v)
+			if r := []rune(s); len(r) > n {
+				return string(r[:n]) + "…"
+			}
+			return s
+		},
+	}
+}
+
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+	">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+func escapeTelegram(parseMode, s string) string {
+	switch parseMode {
+	case TelegramHTML:
+		return html.EscapeString(s)
+	case TelegramMarkdownV2:
+		return markdownV2Escaper.Replace(s)
+	}
+	return s
+}
+
+// telegramString ค่าจาก payload (ผ่าน JSON มาแล้ว ตัวเลขเป็น float64) → ข้อความ
+func telegramString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, p := range t {
+			parts[i] = telegramString(p)
+		}
+		return strings.Join(parts, ", ")
+	}
+	return fmt.Sprint(v)
+}
+
+// telegramNumber 12345 → "12,345" (ค่าที่ไม่ใช่ตัวเลขคืนตามเดิม)
+func telegramNumber(v interface{}) string {
+	switch t := v.(type) {
+	case float64:
+		if t == float64(int64(t)) {
+			return formatThousands(int64(t))
+		}
+	case int64:
+		return formatThousands(t)
+	case int:
+		return formatThousands(int64(t))
+	}
+	return telegramString(v)
+}
+
+// telegramDate เวลา RFC3339 → เวลาไทย (ค่าที่ parse ไม่ได้คืนตามเดิม)
+func telegramDate(v interface{}) string {
+	s := telegramString(v)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.In(lineDisplayZone).Format("02/01/2006 15:04:05")
+}
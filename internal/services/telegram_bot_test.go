@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelegramBotRoute(t *testing.T) {
+	bot := NewTelegramBot("token", "", TelegramRoute{ChatID: "-100"}, map[string]TelegramRoute{
+		"payment_failed": {ChatID: "-200", ThreadID: 7, ParseMode: TelegramMarkdownV2},
+	})
+
+	r, err := bot.Route("payment_failed")
+	assert.NoError(t, err)
+	assert.Equal(t, TelegramRoute{ChatID: "-200", ThreadID: 7, ParseMode: TelegramMarkdownV2}, r)
+
+	r, err = bot.Route("coin_topup")
+	assert.NoError(t, err)
+	assert.Equal(t, TelegramRoute{ChatID: "-100", ParseMode: TelegramHTML}, r)
+
+	_, err = NewTelegramBot("token", "", TelegramRoute{}, nil).Route("coin_topup")
+	assert.ErrorIs(t, err, ErrTelegramNoRoute)
+}
+
+func TestRenderTelegramAlert(t *testing.T) {
+	data := map[string]interface{}{
+		"userId": "u_1<x>",
+		"amount": float64(12000),
+		"time":   time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC).Format(time.RFC3339),
+	}
+
+	text, err := RenderTelegramAlert("coin_topup", TelegramHTML, data)
+	assert.NoError(t, err)
+	assert.Contains(t, text, "<b>💰 เติมเหรียญ</b>")
+	assert.Contains(t, text, "<code>u_1&lt;x&gt;</code>")
+	assert.Contains(t, text, "12,000 เหรียญ")
+	assert.Contains(t, text, "01/03/2026 13:00:00")
+
+	text, err = RenderTelegramAlert("coin_topup", TelegramMarkdownV2, data)
+	assert.NoError(t, err)
+	assert.Contains(t, text, "*💰 เติมเหรียญ*")
+	assert.Contains(t, text, `01/03/2026 13:00:00`)
+
+	text, err = RenderTelegramAlert("something_new", TelegramMarkdownV2, map[string]interface{}{"b": "1.5!", "a": "x_y"})
+	assert.NoError(t, err)
+	assert.Equal(t, "*🔔 something\\_new*\na: x\\_y\nb: 1\\.5\\!", text)
+
+	text, err = RenderTelegramAlert("outbox_dead", "", map[string]interface{}{"channel": "line", "recipient": "U1", "attempts": float64(3), "error": "boom"})
+	assert.NoError(t, err)
+	assert.NotContains(t, text, "ผู้ใช้")
+	assert.Contains(t, text, "ครั้งที่: 3")
+}
+
+func TestNewTelegramDeliveryError(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	derr := newTelegramDeliveryError(resp, []byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 12","parameters":{"retry_after":12}}`))
+	assert.True(t, derr.Retryable())
+	assert.Equal(t, 12*time.Second, derr.RetryAfter)
+	assert.Equal(t, "Too Many Requests: retry after 12", derr.Body)
+
+	resp = &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}}
+	derr = newTelegramDeliveryError(resp, []byte(`{"ok":false,"description":"Bad Request: message thread not found"}`))
+	assert.False(t, derr.Retryable())
+}
+
+// markdownV2Unescaped อักขระสงวนของ MarkdownV2 ที่ไม่ได้ escape (นอก code span และเครื่องหมาย bold)
+func markdownV2Unescaped(text string) []string {
+	var out []string
+	inCode := false
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\':
+			i++
+		case r == '`':
+			inCode = !inCode
+		case inCode || r == '*':
+		case strings.ContainsRune("_[]()~>#+-=|{}.!", r):
+			out = append(out, string(r))
+		}
+	}
+	return out
+}
+
+func TestRenderTelegramAlertMarkdownV2AllTemplates(t *testing.T) {
+	data := map[string]interface{}{
+		"userId": "u_1.x", "conversationId": "c-1", "reviewId": "r(1)", "paymentId": "p#1", "flagId": "f!1",
+		"jobId": "j1", "name": "summarize_conversation", "error": "boom (code=1) `x`", "direction": "input",
+		"categories": []interface{}{"self-harm", "pii"}, "channel": "line", "recipient": "U1", "campaignId": "cp_1",
+		"attempts": float64(3), "provider": "promptpay", "amount": float64(1234.5),
+		"time": time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC).Format(time.RFC3339),
+	}
+	for _, tpl := range telegramTemplates.Templates() {
+		if tpl.Name() == "" {
+			continue
+		}
+		text, err := RenderTelegramAlert(tpl.Name(), TelegramMarkdownV2, data)
+		assert.NoError(t, err, tpl.Name())
+		assert.Empty(t, markdownV2Unescaped(text), "%s: %s", tpl.Name(), text)
+	}
+	text, _ := RenderTelegramAlert("job_failed", TelegramMarkdownV2, data)
+	assert.Contains(t, text, "\\(summarize\\_conversation\\)")
+}
+
+func TestTelegramTransportErrorHidesToken(t *testing.T) {
+	bot := NewTelegramBot("123:SECRET", "", TelegramRoute{ChatID: "-100"}, nil)
+	bot.apiBase = "http://127.0.0.1:1"
+	err := bot.SendMessage(context.Background(), TelegramRoute{ChatID: "-100"}, "hi")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "SECRET")
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/poomiiz/go-backend/internal/utils"
+)
+
+// ประเภทของรายการใน timeline
+const (
+	TimelineConversation = "conversation"
+	TimelineTarotDraw    = "tarot_draw"
+	TimelinePackage      = "package"
+	TimelineBooking      = "booking"
+)
+
+const (
+	defaultTimelinePageSize = 20
+	maxTimelinePageSize     = 100
+)
+
+// TimelineItem เหตุการณ์หนึ่งรายการของผู้ใช้ (field ที่ไม่เกี่ยวกับ Type จะว่าง)
+type TimelineItem struct {
+	Type           string    `json:"type"`
+	ID             string    `json:"id"`
+	Timestamp      time.Time `json:"timestamp"`
+	ConversationID string    `json:"conversationId,omitempty"`
+
+	// conversation
+	Summary   string   `json:"summary,omitempty"`
+	Intent    string   `json:"intent,omitempty"`
+	Emotion   string   `json:"emotion,omitempty"`
+	Sentiment float64  `json:"sentiment,omitempty"`
+	Topics    []string `json:"topics,omitempty"`
+	Pinned    bool     `json:"pinned,omitempty"`
+
+	// tarot_draw
+	Spread   string   `json:"spread,omitempty"`
+	Question string   `json:"question,omitempty"`
+	Cards    []string `json:"cards,omitempty"`
+
+	// package
+	PackageID string     `json:"packageId,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// booking
+	Status string `json:"status,omitempty"`
+
+	path string // path ของ document (ใต้ /documents/) ใช้ตัดสินลำดับเมื่อ timestamp เท่ากัน
+}
+
+// ErrInvalidTimelineCursor cursor ที่ client ส่งมาอ่านไม่ได้
+var ErrInvalidTimelineCursor = errors.New("invalid timeline cursor")
+
+// TimelineCursor ตำแหน่งของรายการสุดท้ายในหน้าก่อน (zero = หน้าแรก)
+// ลำดับของ timeline คือ Timestamp ใหม่ → เก่า แล้ว Type ตามตัวอักษร แล้ว Path มาก → น้อย
+// จึงไม่มีรายการที่ timestamp ซ้ำกันหล่นหายระหว่างหน้า
+type TimelineCursor struct {
+	Timestamp time.Time
+	Type      string
+	Path      string
+}
+
+func (c TimelineCursor) IsZero() bool { return c.Timestamp.IsZero() }
+
+// String เข้ารหัส cursor เป็น string ที่ใส่ใน query string ได้ตรง ๆ
+func (c TimelineCursor) String() string {
+	raw := c.Timestamp.UTC().Format(time.RFC3339Nano) + "|" + c.Type + "|" + c.Path
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseTimelineCursor อ่าน cursor ที่ได้จาก TimelineCursor.String
+func ParseTimelineCursor(v string) (TimelineCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return TimelineCursor{}, ErrInvalidTimelineCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return TimelineCursor{}, ErrInvalidTimelineCursor
+	}
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return TimelineCursor{}, ErrInvalidTimelineCursor
+	}
+	switch parts[1] {
+	case TimelineConversation, TimelineTarotDraw, TimelinePackage, TimelineBooking:
+	default:
+		return TimelineCursor{}, ErrInvalidTimelineCursor
+	}
+	return TimelineCursor{Timestamp: ts, Type: parts[1], Path: parts[2]}, nil
+}
+
+// TimelineQuery เงื่อนไขของ timeline
+// Before = cursor ของหน้าก่อน, Types ว่าง = ทุกประเภท
+// Intent/Emotion กรองได้เฉพาะ conversation จึงคืนแต่ conversation เมื่อระบุ
+type TimelineQuery struct {
+	UserID  string
+	Before  TimelineCursor
+	Limit   int
+	Types   []string
+	Intent  string
+	Emotion string
+}
+
+type TimelineService struct {
+	client *firestore.Client
+}
+
+func NewTimelineService() *TimelineService {
+	return &TimelineService{client: utils.Client}
+}
+
+// Timeline รวมเหตุการณ์จากทุกแหล่ง เรียงใหม่ → เก่า และคืน cursor ของหน้าถัดไป (zero = หมดแล้ว)
+func (s *TimelineService) Timeline(ctx context.Context, q TimelineQuery) ([]TimelineItem, TimelineCursor, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultTimelinePageSize
+	}
+	if q.Limit > maxTimelinePageSize {
+		q.Limit = maxTimelinePageSize
+	}
+	if q.Intent != "" || q.Emotion != "" {
+		q.Types = []string{TimelineConversation}
+	}
+	want := func(t string) bool {
+		if len(q.Types) == 0 {
+			return true
+		}
+		for _, x := range q.Types {
+			if x == t {
+				return true
+			}
+		}
+		return false
+	}
+
+	var items []TimelineItem
+	sources := []struct {
+		typ  string
+		load func(context.Context, TimelineQuery) ([]TimelineItem, error)
+	}{
+		{TimelineConversation, s.conversations},
+		{TimelineTarotDraw, s.draws},
+		{TimelinePackage, s.packages},
+		{TimelineBooking, s.bookings},
+	}
+	for _, src := range sources {
+		if !want(src.typ) {
+			continue
+		}
+		list, err := src.load(ctx, q)
+		if err != nil {
+			return nil, TimelineCursor{}, err
+		}
+		items = append(items, list...)
+	}
+	page, next := pageTimeline(items, q.Before, q.Limit)
+	return page, next, nil
+}
+
+// pageTimeline เรียงตามลำดับของ timeline ตัดรายการที่ไม่อยู่หลัง before แล้วคืนไม่เกิน limit รายการ
+func pageTimeline(items []TimelineItem, before TimelineCursor, limit int) ([]TimelineItem, TimelineCursor) {
+	filtered := items[:0]
+	for _, it := range items {
+		if it.Timestamp.IsZero() {
+			continue
+		}
+		if !before.IsZero() && !timelineBefore(before, it.cursor()) {
+			continue
+		}
+		filtered = append(filtered, it)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return timelineBefore(filtered[i].cursor(), filtered[j].cursor())
+	})
+	if len(filtered) <= limit {
+		return filtered, TimelineCursor{}
+	}
+	page := filtered[:limit]
+	return page, page[limit-1].cursor()
+}
+
+func (it TimelineItem) cursor() TimelineCursor {
+	return TimelineCursor{Timestamp: it.Timestamp, Type: it.Type, Path: it.path}
+}
+
+// timelineBefore คืน true ถ้า a อยู่ก่อน b ใน timeline
+func timelineBefore(a, b TimelineCursor) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return compareDocPath(a.Path, b.Path) > 0
+}
+
+// compareDocPath เทียบ path ทีละ segment แบบเดียวกับที่ Firestore เรียง DocumentID
+func compareDocPath(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// docPath ตัด prefix projects/.../documents/ ออกจาก path ของ document
+func docPath(ref *firestore.DocumentRef) string {
+	if i := strings.Index(ref.Path, "/documents/"); i >= 0 {
+		return ref.Path[i+len("/documents/"):]
+	}
+	return ref.Path
+}
+
+func (s *TimelineService) conversations(ctx context.Context, q TimelineQuery) ([]TimelineItem, error) {
+	query := s.client.Collection("conversations").Where("userId", "==", q.UserID)
+	if q.Intent != "" {
+		query = query.Where("intent", "==", q.Intent)
+	}
+	if q.Emotion != "" {
+		query = query.Where("emotion", "==", q.Emotion)
+	}
+	docs, err := s.pageQuery(query, "startedAt", TimelineConversation, q).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]TimelineItem, 0, len(docs))
+	for _, doc := range docs {
+		var rec ConversationRecord
+		if err := doc.DataTo(&rec); err != nil {
+			continue
+		}
+		pinned, _ := doc.Data()["pinned"].(bool)
+		items = append(items, TimelineItem{
+			Type:           TimelineConversation,
+			ID:             doc.Ref.ID,
+			Timestamp:      rec.StartedAt,
+			ConversationID: doc.Ref.ID,
+			Summary:        rec.Summary,
+			Intent:         rec.Intent,
+			Emotion:        rec.Emotion,
+			Sentiment:      rec.Sentiment,
+			Topics:         rec.Topics,
+			Pinned:         pinned,
+			path:           docPath(doc.Ref),
+		})
+	}
+	return items, nil
+}
+
+func (s *TimelineService) draws(ctx context.Context, q TimelineQuery) ([]TimelineItem, error) {
+	docs, err := s.pageQuery(s.client.CollectionGroup("draws").Where("userId", "==", q.UserID), "createdAt", TimelineTarotDraw, q).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]TimelineItem, 0, len(docs))
+	for _, doc := range docs {
+		var d TarotDraw
+		if err := doc.DataTo(&d); err != nil {
+			continue
+		}
+		cards := make([]string, 0, len(d.Cards))
+		for _, c := range d.Cards {
+			name := c.CardName
+			if c.Reversed {
+				name += " (กลับหัว)"
+			}
+			cards = append(cards, name)
+		}
+		items = append(items, TimelineItem{
+			Type:           TimelineTarotDraw,
+			ID:             doc.Ref.ID,
+			Timestamp:      d.CreatedAt,
+			ConversationID: d.ConversationID,
+			Spread:         d.Spread,
+			Question:       d.Question,
+			Cards:          cards,
+			path:           docPath(doc.Ref),
+		})
+	}
+	return items, nil
+}
+
+func (s *TimelineService) packages(ctx context.Context, q TimelineQuery) ([]TimelineItem, error) {
+	docs, err := s.pageQuery(s.client.Collection("user_packages").Where("userId", "==", q.UserID), "startedAt", TimelinePackage, q).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]TimelineItem, 0, len(docs))
+	for _, doc := range docs {
+		var up UserPackage
+		if err := doc.DataTo(&up); err != nil {
+			continue
+		}
+		expires := up.ExpiresAt
+		items = append(items, TimelineItem{
+			Type:      TimelinePackage,
+			ID:        doc.Ref.ID,
+			Timestamp: up.StartedAt,
+			PackageID: up.PackageID,
+			ExpiresAt: &expires,
+			path:      docPath(doc.Ref),
+		})
+	}
+	return items, nil
+}
+
+// bookings อ่าน collection "bookings" (userId, status, createdAt)
+func (s *TimelineService) bookings(ctx context.Context, q TimelineQuery) ([]TimelineItem, error) {
+	docs, err := s.pageQuery(s.client.Collection("bookings").Where("userId", "==", q.UserID), "createdAt", TimelineBooking, q).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]TimelineItem, 0, len(docs))
+	for _, doc := range docs {
+		data := doc.Data()
+		ts, _ := data["createdAt"].(time.Time)
+		st, _ := data["status"].(string)
+		items = append(items, TimelineItem{
+			Type:      TimelineBooking,
+			ID:        doc.Ref.ID,
+			Timestamp: ts,
+			Status:    st,
+			path:      docPath(doc.Ref),
+		})
+	}
+	return items, nil
+}
+
+// pageQuery จำกัดแต่ละแหล่งให้ไม่เกิน limit รายการที่อยู่หลัง cursor (พอสำหรับประกอบหนึ่งหน้า)
+// เรียง field ใหม่ → เก่า แล้ว DocumentID มาก → น้อย ตรงกับ timelineBefore
+func (s *TimelineService) pageQuery(q firestore.Query, field, typ string, tq TimelineQuery) firestore.Query {
+	q = q.OrderBy(field, firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	if c := tq.Before; !c.IsZero() {
+		switch {
+		case typ < c.Type:
+			// รายการประเภทนี้ที่เวลาเท่า cursor แสดงไปแล้วทั้งหมด
+			q = q.Where(field, "<", c.Timestamp)
+		case typ > c.Type:
+			q = q.Where(field, "<=", c.Timestamp)
+		default:
+			if ref := s.client.Doc(c.Path); ref != nil {
+				q = q.StartAfter(c.Timestamp, ref)
+			} else {
+				q = q.Where(field, "<", c.Timestamp)
+			}
+		}
+	}
+	return q.Limit(tq.Limit)
+}
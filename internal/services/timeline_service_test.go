@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageTimeline(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	items := []TimelineItem{
+		{ID: "old", Type: TimelineBooking, Timestamp: base, path: "bookings/old"},
+		{ID: "missing", Type: TimelineBooking, path: "bookings/missing"}, // ไม่มีเวลา ข้าม
+		{ID: "new", Type: TimelineBooking, Timestamp: base.Add(2 * time.Hour), path: "bookings/new"},
+		{ID: "mid", Type: TimelineBooking, Timestamp: base.Add(time.Hour), path: "bookings/mid"},
+	}
+
+	page, next := pageTimeline(append([]TimelineItem(nil), items...), TimelineCursor{}, 2)
+	assert.Equal(t, []string{"new", "mid"}, timelineIDs(page))
+	assert.Equal(t, base.Add(time.Hour), next.Timestamp)
+
+	page, next = pageTimeline(append([]TimelineItem(nil), items...), next, 2)
+	assert.Equal(t, []string{"old"}, timelineIDs(page))
+	assert.True(t, next.IsZero())
+}
+
+func TestPageTimelineSameTimestamp(t *testing.T) {
+	ts := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	items := []TimelineItem{
+		{ID: "b1", Type: TimelineBooking, Timestamp: ts, path: "bookings/b1"},
+		{ID: "b2", Type: TimelineBooking, Timestamp: ts, path: "bookings/b2"},
+		{ID: "c1", Type: TimelineConversation, Timestamp: ts, path: "conversations/c1"},
+		{ID: "p1", Type: TimelinePackage, Timestamp: ts, path: "user_packages/p1"},
+	}
+
+	var seen []string
+	var cursor TimelineCursor
+	for i := 0; i < 4; i++ {
+		page, next := pageTimeline(append([]TimelineItem(nil), items...), cursor, 1)
+		seen = append(seen, timelineIDs(page)...)
+		if next.IsZero() {
+			break
+		}
+		cursor = next
+	}
+	// ไม่มีรายการที่เวลาเท่ากันหล่นหายหรือซ้ำ
+	assert.Equal(t, []string{"b2", "b1", "c1", "p1"}, seen)
+}
+
+func TestTimelineCursorRoundTrip(t *testing.T) {
+	c := TimelineCursor{
+		Timestamp: time.Date(2025, 6, 1, 12, 0, 0, 123, time.UTC),
+		Type:      TimelineTarotDraw,
+		Path:      "conversations/c1/draws/d1",
+	}
+	got, err := ParseTimelineCursor(c.String())
+	assert.NoError(t, err)
+	assert.True(t, got.Timestamp.Equal(c.Timestamp))
+	assert.Equal(t, c.Type, got.Type)
+	assert.Equal(t, c.Path, got.Path)
+
+	_, err = ParseTimelineCursor("2025-06-01T12:00:00Z")
+	assert.ErrorIs(t, err, ErrInvalidTimelineCursor)
+}
+
+func TestCompareDocPath(t *testing.T) {
+	assert.Less(t, compareDocPath("conversations/a/draws/z", "conversations/b/draws/a"), 0)
+	assert.Greater(t, compareDocPath("bookings/b2", "bookings/b1"), 0)
+	assert.Equal(t, 0, compareDocPath("bookings/b1", "bookings/b1"))
+}
+
+func timelineIDs(items []TimelineItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	return ids
+}
@@ -2,12 +2,24 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/poomiiz/go-backend/internal/utils"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PasswordResetTTL อายุของลิงก์ตั้งรหัสผ่านใหม่
+const PasswordResetTTL = time.Hour
+
+var (
+	ErrInvalidResetToken  = errors.New("invalid or expired reset token")
+	ErrInvalidCredentials = errors.New("invalid credentials")
 )
 
 type User struct {
@@ -16,17 +28,22 @@ type User struct {
 	Role         string    `firestore:"role"`
 	TwoFASecret  string    `firestore:"twoFASecret"`
 	Enabled2FA   bool      `firestore:"enabled2FA"`
+	Source       string    `firestore:"source,omitempty"` // "email" หรือ "line" (สร้างอัตโนมัติเมื่อเพิ่มเพื่อน OA)
+	LineUserID   string    `firestore:"lineUserId,omitempty"`
+	DisplayName  string    `firestore:"displayName,omitempty"`
 	CreatedAt    time.Time `firestore:"createdAt"`
 	UpdatedAt    time.Time `firestore:"updatedAt"`
 }
 
 type UserService struct {
-	col *firestore.CollectionRef
+	col      *firestore.CollectionRef
+	resetCol *firestore.CollectionRef
 }
 
 func NewUserService() *UserService {
 	return &UserService{
-		col: utils.Client.Collection("users"),
+		col:      utils.Client.Collection("users"),
+		resetCol: utils.Client.Collection("password_resets"),
 	}
 }
 
@@ -54,6 +71,7 @@ func (s *UserService) Register(ctx context.Context, email, plainPassword, role s
 		Role:         role,
 		TwoFASecret:  "",
 		Enabled2FA:   false,
+		Source:       "email",
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
@@ -71,14 +89,14 @@ func (s *UserService) Login(ctx context.Context, email, plainPassword string) (s
 		return "", nil, err
 	}
 	if len(docs) == 0 {
-		return "", nil, errors.New("invalid credentials")
+		return "", nil, ErrInvalidCredentials
 	}
 	var u User
 	if err := docs[0].DataTo(&u); err != nil {
 		return "", nil, err
 	}
 	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(plainPassword)) != nil {
-		return "", nil, errors.New("invalid credentials")
+		return "", nil, ErrInvalidCredentials
 	}
 	return docs[0].Ref.ID, &u, nil
 }
@@ -121,3 +139,68 @@ func (s *UserService) UpdateProfile(ctx context.Context, userID, newEmail, newRo
 	})
 	return err
 }
+
+// RequestPasswordReset ออก token ตั้งรหัสผ่านใหม่ (เก็บเฉพาะ hash ใน "password_resets")
+// คืน ErrUserNotFound ถ้าไม่มีอีเมลนี้ ผู้เรียกไม่ควรบอกผลนี้กับผู้ขอ
+func (s *UserService) RequestPasswordReset(ctx context.Context, email string) (string, string, error) {
+	docs, err := s.col.Where("email", "==", email).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return "", "", err
+	}
+	if len(docs) == 0 {
+		return "", "", ErrUserNotFound
+	}
+	token := randomHex(32)
+	now := time.Now()
+	_, err = s.resetCol.Doc(hashResetToken(token)).Set(ctx, map[string]interface{}{
+		"userId":    docs[0].Ref.ID,
+		"used":      false,
+		"createdAt": now,
+		"expireAt":  now.Add(PasswordResetTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return docs[0].Ref.ID, token, nil
+}
+
+// ResetPassword ตั้งรหัสผ่านใหม่ด้วย token (ใช้ได้ครั้งเดียว)
+func (s *UserService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	ref := s.resetCol.Doc(hashResetToken(token))
+	return utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		used, _ := data["used"].(bool)
+		expireAt, _ := data["expireAt"].(time.Time)
+		userID, _ := data["userId"].(string)
+		if used || userID == "" || time.Now().After(expireAt) {
+			return ErrInvalidResetToken
+		}
+		now := time.Now()
+		if err := tx.Update(s.col.Doc(userID), []firestore.Update{
+			{Path: "passwordHash", Value: string(hash)},
+			{Path: "updatedAt", Value: now},
+		}); err != nil {
+			return err
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "used", Value: true},
+			{Path: "usedAt", Value: now},
+		})
+	})
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,10 +2,15 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/poomiiz/go-backend/internal/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Job โครงสร้างข้อมูลงานใน Firestore
@@ -18,13 +23,33 @@ type Job struct {
 	UpdatedAt   time.Time `firestore:"updatedAt"`
 }
 
+// JobHandler ทำงานตาม payload ของ job (คืน error = job failed)
+type JobHandler func(ctx context.Context, payload string) error
+
+var (
+	jobHandlersMu sync.RWMutex
+	jobHandlers   = map[string]JobHandler{}
+)
+
+// RegisterJobHandler ผูกชื่อ job กับ handler (service ที่ต้องการงาน background ลงทะเบียนตอนสร้าง)
+func RegisterJobHandler(name string, h JobHandler) {
+	jobHandlersMu.Lock()
+	jobHandlers[name] = h
+	jobHandlersMu.Unlock()
+}
+
+// jobLease เวลาที่ job ค้าง "processing" ได้ก่อนถูกหยิบไปทำใหม่
+const jobLease = 30 * time.Minute
+
 type WorkpoolService struct {
-	col *firestore.CollectionRef
+	col    *firestore.CollectionRef
+	outbox *OutboxService
 }
 
 func NewWorkpoolService() *WorkpoolService {
 	return &WorkpoolService{
-		col: utils.Client.Collection("jobs"),
+		col:    utils.Client.Collection("jobs"),
+		outbox: NewOutboxService(),
 	}
 }
 
@@ -46,7 +71,78 @@ func (s *WorkpoolService) ScheduleJob(ctx context.Context, name, payload string,
 	return docRef.ID, nil
 }
 
+// ScheduleUniqueJob: ใส่ job ด้วย id ที่กำหนด ถ้ามีอยู่แล้วจะเลื่อนเวลาไปที่ runAt (ใช้ทำ debounce)
+func (s *WorkpoolService) ScheduleUniqueJob(ctx context.Context, jobID, name, payload string, runAt time.Time) error {
+	now := time.Now()
+	_, err := s.col.Doc(jobID).Set(ctx, map[string]interface{}{
+		"name":        name,
+		"payload":     payload,
+		"scheduledAt": runAt,
+		"status":      "pending",
+		"createdAt":   now,
+		"updatedAt":   now,
+	})
+	return err
+}
+
+// EnsureJob ใส่ job ด้วย id ที่กำหนดถ้ายังไม่มี (ใช้ตั้งรอบแรกของงานที่รันซ้ำ restart ไม่เลื่อนเวลาของรอบที่ตั้งไว้แล้ว)
+// job ที่จบไปแล้ว (failed หรือ done เพราะตั้งรอบถัดไปไม่สำเร็จ) ถูกตั้งใหม่ที่ runAt เพื่อให้งานที่รันซ้ำไม่หยุดไปถาวร
+func (s *WorkpoolService) EnsureJob(ctx context.Context, jobID, name, payload string, runAt time.Time) error {
+	ref := s.col.Doc(jobID)
+	return utils.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		now := time.Now()
+		if err == nil {
+			var job Job
+			if err := snap.DataTo(&job); err != nil {
+				return err
+			}
+			if !jobFinished(job.Status) {
+				return nil
+			}
+			return tx.Update(ref, []firestore.Update{
+				{Path: "status", Value: "pending"},
+				{Path: "scheduledAt", Value: runAt},
+				{Path: "updatedAt", Value: now},
+			})
+		}
+		return tx.Create(ref, Job{
+			Name:        name,
+			Payload:     payload,
+			ScheduledAt: runAt,
+			Status:      "pending",
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+	})
+}
+
+// jobFinished job ที่จะไม่ถูกหยิบมาทำอีก (pending/processing ยังรอทำหรือกำลังทำอยู่)
+func jobFinished(status string) bool {
+	return status == "done" || status == "failed"
+}
+
+// Run เรียก ProcessDueJobs ทุก interval จนกว่า ctx จะถูกยกเลิก
+func (s *WorkpoolService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.ProcessDueJobs(ctx); err != nil {
+			log.Println("Error processing jobs:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // ProcessDueJobs: ดึง job ที่ scheduledAt <= now และ status=="pending" มา execute
+// รวมถึง job ที่ค้าง "processing" เกิน jobLease (instance ที่หยิบไปตายระหว่างทำ) ให้ทำใหม่
 func (s *WorkpoolService) ProcessDueJobs(ctx context.Context) error {
 	now := time.Now()
 	q := s.col.Where("scheduledAt", "<=", now).Where("status", "==", "pending")
@@ -54,6 +150,11 @@ func (s *WorkpoolService) ProcessDueJobs(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	stale, err := s.col.Where("status", "==", "processing").Where("updatedAt", "<=", now.Add(-jobLease)).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	docs = append(docs, stale...)
 
 	for _, doc := range docs {
 		var job Job
@@ -61,35 +162,56 @@ func (s *WorkpoolService) ProcessDueJobs(ctx context.Context) error {
 			continue // อ่านไม่ออกข้ามไป
 		}
 
-		// mark ว่ากำลังประมวลผล
-		_, _ = doc.Ref.Update(ctx, []firestore.Update{
+		// mark ว่ากำลังประมวลผล (precondition กันไม่ให้สอง instance หยิบ job เดียวกัน)
+		claimed, err := doc.Ref.Update(ctx, []firestore.Update{
 			{Path: "status", Value: "processing"},
 			{Path: "updatedAt", Value: time.Now()},
-		})
+		}, firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			continue
+		}
 
 		// เรียกใช้ executeJob เพื่อ dispatch จริง
 		errExec := s.executeJob(ctx, doc.Ref.ID, job.Name, job.Payload)
 		newStatus := "done"
 		if errExec != nil {
 			newStatus = "failed"
+			s.alertFailed(ctx, doc.Ref.ID, job.Name, errExec)
 		}
 
-		// อัปเดตสถานะสุดท้าย
+		// อัปเดตสถานะสุดท้าย ถ้า job ถูก schedule ใหม่ระหว่างทำ (ScheduleUniqueJob) ให้คง pending ไว้
 		_, _ = doc.Ref.Update(ctx, []firestore.Update{
 			{Path: "status", Value: newStatus},
 			{Path: "updatedAt", Value: time.Now()},
-		})
+		}, firestore.LastUpdateTime(claimed.UpdateTime))
 	}
 
 	return nil
 }
 
-// executeJob: ใส่ logic เรียก Service อื่นตามชื่อ job.Name
+// alertFailed แจ้ง Telegram เมื่อ job ล้มเหลว (workpool ไม่ retry เอง job ที่ failed ต้องให้ทีมงานดู)
+func (s *WorkpoolService) alertFailed(ctx context.Context, jobID, name string, jobErr error) {
+	err := enqueueTelegramAlert(ctx, s.outbox, "job_failed", map[string]interface{}{
+		"jobId": jobID,
+		"name":  name,
+		"error": jobErr.Error(),
+	})
+	if err != nil {
+		log.Println("Error enqueueing job failed alert:", err)
+	}
+}
+
+// executeJob: เรียก handler ที่ลงทะเบียนไว้ตามชื่อ job.Name
 func (s *WorkpoolService) executeJob(ctx context.Context, jobID, name, payload string) error {
-	// TODO: หมายเหตุ: ต้องเขียน dispatch logic ตามชื่อ job เช่น
-	// if name == "send_reminder" {
-	//   // แปลง payload เป็น struct ที่ต้องการ แล้วเรียก NotificationService.SendLineMessage/SendTelegramAlert
-	// }
-	// สำหรับตอนนี้ return nil (dummy)
+	jobHandlersMu.RLock()
+	h, ok := jobHandlers[name]
+	jobHandlersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no handler for job %q", name)
+	}
+	if err := h(ctx, payload); err != nil {
+		log.Printf("Job %s (%s) failed: %v", jobID, name, err)
+		return err
+	}
 	return nil
 }
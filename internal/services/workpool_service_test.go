@@ -0,0 +1,16 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobFinished(t *testing.T) {
+	// EnsureJob ตั้งงานที่รันซ้ำใหม่เมื่อ job จบไปแล้ว ทั้ง done (ตั้งรอบถัดไปไม่สำเร็จ) และ failed
+	assert.True(t, jobFinished("done"))
+	assert.True(t, jobFinished("failed"))
+	// รอบที่ตั้งไว้แล้วหรือกำลังทำอยู่ไม่ถูกเลื่อน
+	assert.False(t, jobFinished("pending"))
+	assert.False(t, jobFinished("processing"))
+}
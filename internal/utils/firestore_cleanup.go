@@ -6,6 +6,8 @@ import (
 	"context"
 	"log"
 	"time"
+
+	"cloud.google.com/go/firestore"
 )
 
 func DeleteCollection(collection string, batchSize int) error {
@@ -38,3 +40,44 @@ func DeleteCollection(collection string, batchSize int) error {
 	}
 	return nil
 }
+
+// DeleteQuery ลบทุก document ที่ตรงกับ query ทีละ batchSize (คืนจำนวนที่ลบ)
+func DeleteQuery(ctx context.Context, q firestore.Query, batchSize int) (int, error) {
+	total := 0
+	for {
+		docs, err := q.Limit(batchSize).Documents(ctx).GetAll()
+		if err != nil {
+			return total, err
+		}
+		if len(docs) == 0 {
+			return total, nil
+		}
+		batch := Client.Batch()
+		for _, doc := range docs {
+			batch.Delete(doc.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return total, err
+		}
+		total += len(docs)
+		if len(docs) < batchSize {
+			return total, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// DeleteDocumentTree ลบทุก subcollection (ชั้นเดียว) ของ document แล้วลบตัว document
+func DeleteDocumentTree(ctx context.Context, ref *firestore.DocumentRef, batchSize int) error {
+	cols, err := ref.Collections(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, col := range cols {
+		if _, err := DeleteQuery(ctx, col.Query, batchSize); err != nil {
+			return err
+		}
+	}
+	_, err = ref.Delete(ctx)
+	return err
+}
@@ -6,16 +6,34 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Client ถูก initialize ไว้ใน InitFirestore()
 var Client *firestore.Client
 
+// MessagePartition คืนชื่อ subcollection ของเดือนนั้น เช่น "messages_2025_06"
+func MessagePartition(t time.Time) string {
+	year, month, _ := t.Date()
+	return fmt.Sprintf("messages_%04d_%02d", year, int(month))
+}
+
+// PartitionEnd คืนเวลาเริ่มเดือนถัดไปของ partition เช่น "messages_2025_06" → 2025-07-01 (ข้อความทั้งหมดเก่ากว่าเวลานี้)
+func PartitionEnd(name string) (time.Time, bool) {
+	var year, month int
+	if _, err := fmt.Sscanf(name, "messages_%04d_%02d", &year, &month); err != nil || month < 1 || month > 12 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local).AddDate(0, 1, 0), true
+}
+
 // ----------------------------------------------------------------------------
 // SaveUserMessage – บันทึกข้อความของผู้ใช้ ลง subcollection "messages_YYYY_MM"
 func SaveUserMessage(sessionId, userId, text string) {
@@ -24,19 +42,15 @@ func SaveUserMessage(sessionId, userId, text string) {
 	// 1. ตั้งชื่อ document ชั้นบนตาม sessionId
 	docRef := Client.Collection("conversations").Doc(sessionId)
 
-	// 2. สร้างหรืออัปเดต field "userId" ใน session document (ใช้ MergeAll เพื่อไม่ลบ field อื่น)
-	_, err := docRef.Set(ctx, map[string]interface{}{
-		"userId": userId,
-	}, firestore.MergeAll)
-	if err != nil {
+	// 2. สร้าง session document (พร้อม startedAt) หรืออัปเดต field "userId" ถ้ามีอยู่แล้ว
+	now := time.Now()
+	if err := ensureConversation(ctx, docRef, userId, now); err != nil {
 		log.Println("Error setting conversation doc:", err)
 		return
 	}
 
 	// 3. หาเดือน/ปีปัจจุบัน เพื่อจัด subcollection partition
-	now := time.Now()
-	year, month, _ := now.Date()
-	subcol := fmt.Sprintf("messages_%04d_%02d", year, int(month))
+	subcol := MessagePartition(now)
 	//    → example: "messages_2025_06"
 
 	// 4. สร้าง document ใน subcollection partition นั้น
@@ -46,8 +60,7 @@ func SaveUserMessage(sessionId, userId, text string) {
 		"text":      text,
 		"modelUsed": "",
 		"timestamp": now,
-		// ใส่ expireAt สำหรับ TTL (ถ้าใช้ TTL)
-		"expireAt": now.Add(24 * time.Hour),
+		// ไม่ตั้ง expireAt แล้ว การลบตาม retention ของแต่ละ tier ทำโดย RetentionService.Purge
 	}
 	if _, err := msgRef.Set(ctx, payload); err != nil {
 		log.Println("Error saving user message:", err)
@@ -58,20 +71,21 @@ func SaveUserMessage(sessionId, userId, text string) {
 // ----------------------------------------------------------------------------
 // SaveBotMessage – บันทึกข้อความของ bot ลง subcollection "messages_YYYY_MM"
 func SaveBotMessage(sessionId, userId, text, modelUsed string) {
+	SaveBotMessageWithMeta(sessionId, userId, text, modelUsed, nil)
+}
+
+// SaveBotMessageWithMeta เหมือน SaveBotMessage แต่เก็บ field เพิ่ม (เช่น experimentId/variant) และคืน message ID
+func SaveBotMessageWithMeta(sessionId, userId, text, modelUsed string, meta map[string]interface{}) string {
 	ctx := context.Background()
 
 	// Document ชั้นบน
 	docRef := Client.Collection("conversations").Doc(sessionId)
 
-	// (ไม่จำเป็นต้องตั้ง userId ซ้ำ เพราะ SaveUserMessage หมายถึง session จะถูกสร้างครั้งแรกที่ user ส่งข้อความ)
-	// แต่หากต้องการก็ทำ MergeAll ได้ไม่เสียหาย:
-	_, _ = docRef.Set(ctx, map[string]interface{}{
-		"userId": userId,
-	}, firestore.MergeAll)
-
+	// (ปกติ SaveUserMessage สร้าง session ไว้แล้ว แต่ถ้ายังไม่มีก็สร้างพร้อม startedAt ไม่เสียหาย)
 	now := time.Now()
-	year, month, _ := now.Date()
-	subcol := fmt.Sprintf("messages_%04d_%02d", year, int(month)) // "messages_2025_06"
+	_ = ensureConversation(ctx, docRef, userId, now)
+
+	subcol := MessagePartition(now) // "messages_2025_06"
 
 	msgRef := docRef.Collection(subcol).NewDoc()
 	payload := map[string]interface{}{
@@ -79,12 +93,33 @@ func SaveBotMessage(sessionId, userId, text, modelUsed string) {
 		"text":      text,
 		"modelUsed": modelUsed,
 		"timestamp": now,
-		"expireAt":  now.Add(24 * time.Hour),
+	}
+	for k, v := range meta {
+		payload[k] = v
 	}
 	if _, err := msgRef.Set(ctx, payload); err != nil {
 		log.Println("Error saving bot message:", err)
-		return
+		return ""
 	}
+	return msgRef.ID
+}
+
+// ensureConversation สร้าง conversation document พร้อม startedAt ถ้ายังไม่มี ถ้ามีแล้วอัปเดตแค่ userId
+// (ทุก document ต้องมี startedAt ไม่งั้น query ที่ orderBy startedAt จะข้ามไป)
+// oldestMessageAt = เวลาของข้อความเก่าสุดที่ยังเหลือ ใช้ให้ RetentionService.Purge query เฉพาะ conversation ที่มีข้อความเกิน retention
+func ensureConversation(ctx context.Context, docRef *firestore.DocumentRef, userID string, now time.Time) error {
+	_, err := docRef.Create(ctx, map[string]interface{}{
+		"userId":          userID,
+		"startedAt":       now,
+		"oldestMessageAt": now,
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	_, err = docRef.Set(ctx, map[string]interface{}{
+		"userId": userID,
+	}, firestore.MergeAll)
+	return err
 }
 
 // GetSessionMessages ดึงข้อความ user ทั้งหมดใน session
@@ -92,9 +127,7 @@ func GetSessionMessages(sessionID string) ([]string, error) {
 	ctx := context.Background()
 	docRef := Client.Collection("conversations").Doc(sessionID)
 
-	now := time.Now()
-	year, month, _ := now.Date()
-	subcol := fmt.Sprintf("messages_%04d_%02d", year, int(month))
+	subcol := MessagePartition(time.Now())
 
 	iter := docRef.Collection(subcol).Where("sender", "==", "user").Documents(ctx)
 	defer iter.Stop()
@@ -111,6 +144,122 @@ func GetSessionMessages(sessionID string) ([]string, error) {
 	return messages, nil
 }
 
+// StoredMessage ข้อความหนึ่งรายการใน partition "messages_YYYY_MM"
+type StoredMessage struct {
+	ID        string    `firestore:"-" json:"id"`
+	Sender    string    `firestore:"sender" json:"sender"` // "user" หรือ "bot"
+	Text      string    `firestore:"text" json:"text"`
+	ModelUsed string    `firestore:"modelUsed" json:"modelUsed"`
+	Timestamp time.Time `firestore:"timestamp" json:"timestamp"`
+
+	// มีเฉพาะข้อความ bot ที่ตอบด้วย prompt experiment
+	ExperimentID string `firestore:"experimentId,omitempty" json:"experimentId,omitempty"`
+	Variant      string `firestore:"variant,omitempty" json:"variant,omitempty"`
+}
+
+// MessagePartitions คืนชื่อ partition ทั้งหมดของ conversation เรียงจากเก่า → ใหม่
+func MessagePartitions(ctx context.Context, sessionID string) ([]string, error) {
+	iter := Client.Collection("conversations").Doc(sessionID).Collections(ctx)
+	var names []string
+	for {
+		col, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(col.ID, "messages_") {
+			names = append(names, col.ID)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetRecentMessages ดึงข้อความล่าสุดไม่เกิน limit รายการ (ข้ามทุก partition) เรียงจากเก่า → ใหม่
+func GetRecentMessages(ctx context.Context, sessionID string, limit int) ([]StoredMessage, error) {
+	partitions, err := MessagePartitions(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	docRef := Client.Collection("conversations").Doc(sessionID)
+
+	// ไล่จาก partition ใหม่สุดย้อนกลับไปจนครบ limit
+	var newestFirst []StoredMessage
+	for i := len(partitions) - 1; i >= 0 && len(newestFirst) < limit; i-- {
+		docs, err := docRef.Collection(partitions[i]).
+			OrderBy("timestamp", firestore.Desc).
+			Limit(limit - len(newestFirst)).
+			Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var m StoredMessage
+			if err := doc.DataTo(&m); err != nil {
+				continue
+			}
+			m.ID = doc.Ref.ID
+			newestFirst = append(newestFirst, m)
+		}
+	}
+
+	messages := make([]StoredMessage, len(newestFirst))
+	for i, m := range newestFirst {
+		messages[len(newestFirst)-1-i] = m
+	}
+	return messages, nil
+}
+
+// GetAllMessages ดึงทุกข้อความของ session จากทุก partition เรียงจากเก่า → ใหม่
+func GetAllMessages(ctx context.Context, sessionID string) ([]StoredMessage, error) {
+	partitions, err := MessagePartitions(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	docRef := Client.Collection("conversations").Doc(sessionID)
+
+	var messages []StoredMessage
+	for _, p := range partitions {
+		docs, err := docRef.Collection(p).OrderBy("timestamp", firestore.Asc).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var m StoredMessage
+			if err := doc.DataTo(&m); err != nil {
+				continue
+			}
+			m.ID = doc.Ref.ID
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// GetMessage หาข้อความตาม ID จากทุก partition ของ session (คืน nil ถ้าไม่พบ)
+func GetMessage(ctx context.Context, sessionID, messageID string) (*StoredMessage, error) {
+	partitions, err := MessagePartitions(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	docRef := Client.Collection("conversations").Doc(sessionID)
+	for i := len(partitions) - 1; i >= 0; i-- {
+		snap, err := docRef.Collection(partitions[i]).Doc(messageID).Get(ctx)
+		if err != nil || !snap.Exists() {
+			continue
+		}
+		var m StoredMessage
+		if err := snap.DataTo(&m); err != nil {
+			return nil, err
+		}
+		m.ID = snap.Ref.ID
+		return &m, nil
+	}
+	return nil, nil
+}
+
 // SaveInterpretResult บันทึก intent/emotion analysis
 func SaveInterpretResult(userID, convID, intent string, confidence float64) {
 	ctx := context.Background()
@@ -165,47 +314,6 @@ func InitFirestore() error {
 	log.Println("✅ Firestore client initialized")
 	return nil
 }
-func SaveSummary(sessionId, summary, intent, emotion string) {
-	ctx := context.Background()
-	_, err := Client.Collection("conversations").Doc(sessionId).Set(ctx, map[string]interface{}{
-		"summary":   summary,
-		"intent":    intent,
-		"emotion":   emotion,
-		"summaryAt": time.Now(),
-	}, firestore.MergeAll)
-	if err != nil {
-		log.Println("Error saving summary:", err)
-	}
-}
-
-type Conversation struct {
-	ID   string
-	Data map[string]interface{}
-}
-
-func JoinText(lines []string) string {
-	return strings.Join(lines, "\n")
-}
-func QueryUserConversations(userID string) []Conversation {
-	ctx := context.Background()
-	iter := Client.Collection("conversations").Where("userId", "==", userID).Documents(ctx)
-
-	result := []Conversation{}
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			continue
-		}
-		result = append(result, Conversation{
-			ID:   doc.Ref.ID,
-			Data: doc.Data(),
-		})
-	}
-	return result
-}
 
 func CloseFirestore() {
 	if Client != nil {